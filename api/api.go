@@ -8,37 +8,57 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
-	"github.com/plutov/paypal/v4"
 	"github.com/polldo/govod/api/background"
 	"github.com/polldo/govod/api/middleware"
 	"github.com/polldo/govod/api/web"
 	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/affiliate"
 	"github.com/polldo/govod/core/auth"
+	"github.com/polldo/govod/core/bundle"
 	"github.com/polldo/govod/core/cart"
+	"github.com/polldo/govod/core/coupon"
 	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/gift"
+	"github.com/polldo/govod/core/idempotency"
 	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/core/org"
+	"github.com/polldo/govod/core/report"
+	"github.com/polldo/govod/core/subscription"
 	"github.com/polldo/govod/core/token"
+	"github.com/polldo/govod/core/upload"
 	"github.com/polldo/govod/core/user"
 	"github.com/polldo/govod/core/video"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
 	"github.com/sirupsen/logrus"
-	stripecl "github.com/stripe/stripe-go/v74/client"
 )
 
+// Mailer sends the emails required by handlers.
+type Mailer interface {
+	token.Mailer
+	org.Mailer
+}
+
 // APIConfig contains all the mandatory dependencies required by handlers.
 type APIConfig struct {
 	CorsOrigin         string
 	Log                logrus.FieldLogger
 	DB                 *sqlx.DB
 	Session            *scs.SessionManager
-	Mailer             token.Mailer
+	Mailer             Mailer
 	TokenTimeout       time.Duration
 	Background         *background.Background
-	Paypal             *paypal.Client
-	Stripe             *stripecl.API
-	StripeCfg          config.Stripe
+	Payments           map[string]order.PaymentProvider
+	Invoice            config.Invoice
+	Billing            subscription.Billing
+	Subscriptions      config.Subscriptions
 	Providers          map[string]auth.Provider
 	LoginRedirectURL   string
 	ActivationRequired bool
+	Storage            storage.Store
+	PresignTTL         time.Duration
+	Uploads            config.Uploads
+	Signer             *signer.Signer
 }
 
 // api represents our server api.
@@ -77,6 +97,18 @@ func APIMux(cfg APIConfig) http.Handler {
 	authen := auth.Authenticate(cfg.Session)
 	admin := auth.Admin(cfg.Session)
 
+	// Routes open to everyone can still recognize authenticated users.
+	identify := auth.Identify(cfg.Session)
+
+	// Media can be fetched by signed links, without the session.
+	signed := auth.Signed(cfg.Signer)
+
+	// Requests carrying an idempotency key can be safely retried.
+	idem := idempotency.Middleware(cfg.DB)
+
+	// Orders are attributed to the affiliate who referred the user.
+	referral := affiliate.Track(cfg.Session)
+
 	// Setup the handlers.
 	a.Handle(http.MethodPost, "/auth/signup", auth.HandleSignup(cfg.DB, cfg.Session, cfg.ActivationRequired))
 	a.Handle(http.MethodPost, "/auth/login", auth.HandleLogin(cfg.DB, cfg.Session))
@@ -89,34 +121,113 @@ func APIMux(cfg APIConfig) http.Handler {
 	a.Handle(http.MethodPost, "/tokens/recover", token.HandleRecovery(cfg.DB))
 
 	a.Handle(http.MethodGet, "/users/current", user.HandleShowCurrent(cfg.DB), authen)
+	a.Handle(http.MethodPut, "/users/current/prefs", user.HandleUpdatePrefs(cfg.DB), authen)
 	a.Handle(http.MethodGet, "/users/{id}", user.HandleShow(cfg.DB), authen)
-	a.Handle(http.MethodPost, "/users", user.HandleCreate(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/users", user.HandleCreate(cfg.DB), authen, idem)
 
 	a.Handle(http.MethodGet, "/courses/owned", course.HandleListOwned(cfg.DB), authen)
 	a.Handle(http.MethodGet, "/courses/{course_id}/videos", video.HandleListByCourse(cfg.DB))
 	a.Handle(http.MethodGet, "/courses/{course_id}/progress", video.HandleListProgressByCourse(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/courses/{id}/enroll", order.HandleEnroll(cfg.DB), authen, idem)
+	a.Handle(http.MethodGet, "/courses/{id}/image", course.HandleShowImage(cfg.DB, cfg.Storage, cfg.PresignTTL))
 	a.Handle(http.MethodGet, "/courses/{id}", course.HandleShow(cfg.DB))
 	a.Handle(http.MethodGet, "/courses", course.HandleList(cfg.DB))
-	a.Handle(http.MethodPost, "/courses", course.HandleCreate(cfg.DB), admin)
-	a.Handle(http.MethodPut, "/courses/{id}", course.HandleUpdate(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/courses", course.HandleCreate(cfg.DB, cfg.Storage), admin, idem)
+	a.Handle(http.MethodPut, "/courses/{id}", course.HandleUpdate(cfg.DB, cfg.Storage), admin)
+
+	a.Handle(http.MethodPost, "/gifts/redeem", gift.HandleRedeem(cfg.DB), authen)
+
+	a.Handle(http.MethodGet, "/bundles/{id}", bundle.HandleShow(cfg.DB))
+	a.Handle(http.MethodGet, "/bundles", bundle.HandleList(cfg.DB))
+	a.Handle(http.MethodPost, "/bundles", bundle.HandleCreate(cfg.DB), admin, idem)
+	a.Handle(http.MethodPut, "/bundles/{id}", bundle.HandleUpdate(cfg.DB), admin)
+	a.Handle(http.MethodDelete, "/bundles/{id}", bundle.HandleDelete(cfg.DB), admin)
 
-	a.Handle(http.MethodGet, "/videos/{id}/full", video.HandleShowFull(cfg.DB), authen)
-	a.Handle(http.MethodGet, "/videos/{id}/free", video.HandleShowFree(cfg.DB))
+	a.Handle(http.MethodPost, "/uploads", upload.HandleCreate(cfg.DB, cfg.Uploads), admin, idem)
+	a.Handle(http.MethodGet, "/uploads/{id}", upload.HandleShow(cfg.DB), admin)
+	a.Handle(http.MethodPatch, "/uploads/{id}", upload.HandleWrite(cfg.DB, cfg.Storage, cfg.Uploads), admin)
+	a.Handle(http.MethodDelete, "/uploads/{id}", upload.HandleCancel(cfg.DB, cfg.Storage), admin)
+
+	a.Handle(http.MethodGet, "/videos/{id}/stream", video.HandleStream(cfg.DB, cfg.Storage), identify, signed)
+	a.Handle(http.MethodGet, "/videos/{id}/media/{path:.+}", video.HandleMedia(cfg.DB, cfg.Storage, cfg.Signer), identify, signed)
+	a.Handle(http.MethodGet, "/videos/{id}/full", video.HandleShowFull(cfg.DB, cfg.Signer), authen)
+	a.Handle(http.MethodGet, "/videos/{id}/free", video.HandleShowFree(cfg.DB, cfg.Signer))
 	a.Handle(http.MethodGet, "/videos/{id}", video.HandleShow(cfg.DB))
 	a.Handle(http.MethodGet, "/videos", video.HandleList(cfg.DB))
-	a.Handle(http.MethodPost, "/videos", video.HandleCreate(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/videos", video.HandleCreate(cfg.DB, cfg.Storage), admin, idem)
 	a.Handle(http.MethodPut, "/videos/{id}/progress", video.HandleUpdateProgress(cfg.DB), authen)
-	a.Handle(http.MethodPut, "/videos/{id}", video.HandleUpdate(cfg.DB), admin)
+	a.Handle(http.MethodPut, "/videos/{id}", video.HandleUpdate(cfg.DB, cfg.Storage), admin)
 
 	a.Handle(http.MethodGet, "/cart", cart.HandleShow(cfg.DB), authen)
-	a.Handle(http.MethodDelete, "/cart", cart.HandleDelete(cfg.DB), authen)
-	a.Handle(http.MethodPut, "/cart/items", cart.HandleCreateItem(cfg.DB), authen)
-	a.Handle(http.MethodDelete, "/cart/items/{course_id}", cart.HandleDeleteItem(cfg.DB), authen)
-
-	a.Handle(http.MethodPost, "/orders/paypal", order.HandlePaypalCheckout(cfg.DB, cfg.Paypal), authen)
-	a.Handle(http.MethodPost, "/orders/paypal/{id}/capture", order.HandlePaypalCapture(cfg.DB, cfg.Paypal), authen)
-	a.Handle(http.MethodPost, "/orders/stripe", order.HandleStripeCheckout(cfg.DB, cfg.Stripe, cfg.StripeCfg), authen)
-	a.Handle(http.MethodPost, "/orders/stripe/capture", order.HandleStripeCapture(cfg.DB, cfg.StripeCfg))
+	a.Handle(http.MethodDelete, "/cart", cart.HandleDelete(cfg.DB), authen, idem)
+	a.Handle(http.MethodPut, "/cart/items", cart.HandleCreateItem(cfg.DB), authen, idem)
+	a.Handle(http.MethodDelete, "/cart/items/{course_id}", cart.HandleDeleteItem(cfg.DB), authen, idem)
+	a.Handle(http.MethodPut, "/cart/bundles", cart.HandleCreateBundle(cfg.DB), authen, idem)
+	a.Handle(http.MethodDelete, "/cart/bundles/{bundle_id}", cart.HandleDeleteBundle(cfg.DB), authen, idem)
+	a.Handle(http.MethodPut, "/cart/coupon", cart.HandleApplyCoupon(cfg.DB), authen, idem)
+	a.Handle(http.MethodDelete, "/cart/coupon", cart.HandleDeleteCoupon(cfg.DB), authen, idem)
+
+	a.Handle(http.MethodGet, "/coupons", coupon.HandleList(cfg.DB), admin)
+	a.Handle(http.MethodGet, "/coupons/{id}", coupon.HandleShow(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/coupons", coupon.HandleCreate(cfg.DB), admin, idem)
+	a.Handle(http.MethodDelete, "/coupons/{id}", coupon.HandleDelete(cfg.DB), admin)
+
+	a.Handle(http.MethodGet, "/orders", order.HandleList(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/orders/{id}", order.HandleShow(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/admin/orders", order.HandleListAll(cfg.DB), admin)
+	a.Handle(http.MethodGet, "/admin/orders/{id}", order.HandleShowAny(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/orders/{provider}", order.HandleCheckout(cfg.DB, cfg.Payments), authen, referral, idem)
+	a.Handle(http.MethodPost, "/orders/{provider}/webhook", order.HandleWebhook(cfg.DB, cfg.Payments))
+	a.Handle(http.MethodPost, "/orders/{provider}/{id}/capture", order.HandleCapture(cfg.DB, cfg.Payments), authen)
+	a.Handle(http.MethodPost, "/orders/{id}/refund", order.HandleRefund(cfg.DB, cfg.Payments), admin)
+	a.Handle(http.MethodGet, "/orders/{id}/invoice", order.HandleShowInvoice(cfg.DB, cfg.Invoice), authen)
+	a.Handle(http.MethodPost, "/orders/{id}/invoice/regenerate", order.HandleRegenerateInvoice(cfg.DB, cfg.Invoice), admin)
+	a.Handle(http.MethodPost, "/orders/{id}/invoice/void", order.HandleVoidInvoice(cfg.DB), admin)
+
+	// Stripe webhooks were historically configured on this path.
+	a.Handle(http.MethodPost, "/orders/{provider:stripe}/capture", order.HandleWebhook(cfg.DB, cfg.Payments))
+
+	a.Handle(http.MethodGet, "/plans/{id}", subscription.HandleShowPlan(cfg.DB))
+	a.Handle(http.MethodGet, "/plans", subscription.HandleListPlans(cfg.DB))
+	a.Handle(http.MethodPost, "/plans", subscription.HandleCreatePlan(cfg.DB), admin, idem)
+	a.Handle(http.MethodPut, "/plans/{id}", subscription.HandleUpdatePlan(cfg.DB), admin)
+	a.Handle(http.MethodDelete, "/plans/{id}", subscription.HandleDeletePlan(cfg.DB), admin)
+
+	a.Handle(http.MethodGet, "/subscriptions", subscription.HandleList(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/subscriptions", subscription.HandleCheckout(cfg.DB, cfg.Billing), authen, idem)
+	a.Handle(http.MethodDelete, "/subscriptions/{id}", subscription.HandleCancel(cfg.DB, cfg.Billing), authen)
+	a.Handle(http.MethodPost, "/subscriptions/webhook", subscription.HandleWebhook(cfg.DB, cfg.Billing, cfg.Subscriptions.GracePeriod))
+
+	a.Handle(http.MethodGet, "/orgs", org.HandleList(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/orgs", org.HandleCreate(cfg.DB), authen, idem)
+	a.Handle(http.MethodPost, "/orgs/invitations/accept", org.HandleAccept(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/orgs/{id}", org.HandleShow(cfg.DB), authen)
+	a.Handle(http.MethodPut, "/orgs/{id}/members/{user_id}", org.HandleUpdateMember(cfg.DB), authen)
+	a.Handle(http.MethodDelete, "/orgs/{id}/members/{user_id}", org.HandleDeleteMember(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/orgs/{id}/invitations", org.HandleInvite(cfg.DB, cfg.Mailer, cfg.Background), authen, idem)
+	a.Handle(http.MethodPut, "/orgs/{id}/seats", org.HandleAssign(cfg.DB), authen, idem)
+	a.Handle(http.MethodDelete, "/orgs/{id}/seats/{course_id}/{user_id}", org.HandleReclaim(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/orgs/{id}/progress", org.HandleListProgress(cfg.DB), authen)
+	a.Handle(http.MethodPost, "/orgs/{id}/orders/{provider}", order.HandleCheckoutSeats(cfg.DB, cfg.Payments), authen, referral, idem)
+
+	a.Handle(http.MethodPost, "/referrals", affiliate.HandleCapture(cfg.DB, cfg.Session))
+	a.Handle(http.MethodGet, "/affiliates/me", affiliate.HandleShowStats(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/affiliates/me/commissions", affiliate.HandleListCommissions(cfg.DB), authen)
+	a.Handle(http.MethodGet, "/affiliates/payouts", affiliate.HandleExportPayouts(cfg.DB), admin)
+	a.Handle(http.MethodGet, "/affiliates", affiliate.HandleList(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/affiliates", affiliate.HandleCreate(cfg.DB), admin, idem)
+	a.Handle(http.MethodPut, "/affiliates/rates", affiliate.HandleSetRate(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/affiliates/{id}/payouts", affiliate.HandleSettle(cfg.DB), admin)
+
+	a.Handle(http.MethodGet, "/fulfillments", order.HandleListFulfillments(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/fulfillments/{id}/retry", order.HandleRetryFulfillment(cfg.DB), admin)
+	a.Handle(http.MethodPost, "/fulfillments/{id}/resolve", order.HandleResolveFulfillment(cfg.DB), admin)
+
+	a.Handle(http.MethodGet, "/admin/reports/revenue", report.HandleRevenue(cfg.DB), admin)
+	a.Handle(http.MethodGet, "/admin/reports/revenue/export", report.HandleExportRevenue(cfg.DB), admin)
+
+	a.Handle(http.MethodGet, "/admin/reconciliations", order.HandleListReconciliations(cfg.DB), admin)
+	a.Handle(http.MethodGet, "/admin/reconciliations/{id}", order.HandleShowReconciliation(cfg.DB), admin)
 
 	return a.Router
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -13,14 +14,19 @@ import (
 // It handles tasks's errors by logging them.
 // It also recovers in case of panics.
 type Background struct {
-	wg  sync.WaitGroup
-	log logrus.FieldLogger
+	wg     sync.WaitGroup
+	log    logrus.FieldLogger
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // New constructs and returns a new Background.
 func New(log logrus.FieldLogger) *Background {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Background{
-		log: log,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
@@ -28,27 +34,56 @@ func New(log logrus.FieldLogger) *Background {
 func (bg *Background) Add(f func() error) {
 	bg.wg.Add(1)
 
+	go func() {
+		defer bg.wg.Done()
+		bg.run(f)
+	}()
+}
+
+// Schedule inserts a new task that will be periodically executed in
+// background, until shutdown. The context passed to the task is
+// cancelled as soon as the shutdown begins.
+func (bg *Background) Schedule(interval time.Duration, f func(ctx context.Context) error) {
+	bg.wg.Add(1)
+
 	go func() {
 		defer bg.wg.Done()
 
-		defer func() {
-			if rec := recover(); rec != nil {
-				trace := debug.Stack()
-				err := fmt.Errorf("PANIC [%v] TRACE[%s]", rec, string(trace))
-				bg.log.WithField("message", err).Error("PANIC")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bg.ctx.Done():
+				return
+			case <-ticker.C:
+				bg.run(func() error { return f(bg.ctx) })
 			}
-		}()
+		}
+	}()
+}
 
-		if err := f(); err != nil {
-			bg.log.WithField("message", err).Error("ERROR")
+// run executes the task, logging its error and recovering from panics.
+func (bg *Background) run(f func() error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			trace := debug.Stack()
+			err := fmt.Errorf("PANIC [%v] TRACE[%s]", rec, string(trace))
+			bg.log.WithField("message", err).Error("PANIC")
 		}
 	}()
+
+	if err := f(); err != nil {
+		bg.log.WithField("message", err).Error("ERROR")
+	}
 }
 
-// Shutdown waits for tasks to complete. If the passed context
-// expires then it returns an error indicating that some task
-// didn't terminate in time.
+// Shutdown stops scheduled tasks and waits for all tasks to complete.
+// If the passed context expires then it returns an error indicating
+// that some task didn't terminate in time.
 func (bg *Background) Shutdown(ctx context.Context) error {
+	bg.cancel()
+
 	quit := make(chan struct{})
 	go func() {
 		bg.wg.Wait()
@@ -2,6 +2,7 @@ package background
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -112,3 +113,43 @@ func TestBackgroundPanic(t *testing.T) {
 		t.Fatalf("panic should not result in an error: %v", err)
 	}
 }
+
+func TestBackgroundSchedule(t *testing.T) {
+	log := logrus.New()
+	bg := New(log)
+
+	var mu sync.Mutex
+	var cnt int
+	bg.Schedule(time.Millisecond, func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		cnt++
+		return nil
+	})
+
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := bg.Shutdown(ctx); err != nil {
+		t.Fatalf("scheduled tasks should stop on shutdown: %v", err)
+	}
+
+	mu.Lock()
+	got := cnt
+	mu.Unlock()
+
+	if got == 0 {
+		t.Fatal("scheduled task should have been executed at least once")
+	}
+
+	// No more executions are expected after the shutdown.
+	time.Sleep(5 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cnt != got {
+		t.Fatalf("scheduled task executed after shutdown: %d executions, expected %d", cnt, got)
+	}
+}
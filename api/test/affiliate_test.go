@@ -0,0 +1,282 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/core/affiliate"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+)
+
+type affiliateTest struct {
+	*TestEnv
+}
+
+func TestAffiliate(t *testing.T) {
+	env, err := NewTestEnv(t, "affiliate_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	at := &affiliateTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+	c3 := ct.createCourseOK(t)
+
+	// Only administrators can enroll affiliates.
+	aff := affiliate.AffiliateNew{UserID: adminID, Code: "ADMIN01", Rate: 1000}
+	at.createStatus(t, at.UserEmail, at.UserPass, aff, http.StatusUnauthorized)
+	at.createStatus(t, at.AdminEmail, at.AdminPass, affiliate.AffiliateNew{UserID: adminID, Code: "!"}, http.StatusUnprocessableEntity)
+	at.createStatus(t, at.AdminEmail, at.AdminPass, aff, http.StatusCreated)
+	at.createStatus(t, at.AdminEmail, at.AdminPass, aff, http.StatusUnprocessableEntity)
+
+	// Rates of courses override the default rate of the affiliate.
+	at.setRateStatus(t, affiliate.RateNew{CourseID: c2.ID, Rate: 2000}, http.StatusOK)
+	at.setRateStatus(t, affiliate.RateNew{CourseID: c2.ID, Rate: 20000}, http.StatusUnprocessableEntity)
+
+	// Unknown referral codes are refused.
+	at.referralStatus(t, "UNKNOWN", http.StatusNotFound)
+	at.statsStatus(t, at.UserEmail, at.UserPass, http.StatusNotFound)
+
+	// Orders placed after landing with a referral code earn commissions.
+	rt.createItemOK(t, c1.ID)
+	rt.createItemOK(t, c2.ID)
+	at.Stripe.expectedCart = []course.Course{c1, c2}
+	id := at.referredCheckout(t, aff.Code)
+	ot.stripeWebhook(t, "checkout.session.completed", id)
+	ot.statusOK(t, id, order.Success)
+
+	owed := at.commission(t, id, c1.ID, 1000) + at.commission(t, id, c2.ID, 2000)
+	stats := at.statsOK(t)
+	if stats.Clicks != 1 || stats.Conversions != 1 {
+		t.Fatalf("expected 1 click and 1 conversion, got %d and %d", stats.Clicks, stats.Conversions)
+	}
+	at.owedOK(t, stats, owed)
+	at.payoutsOK(t, aff.Code, true)
+
+	// Refunds reverse the commissions of the refunded items.
+	ot.refundOK(t, id, []string{c1.ID}, order.PartiallyRefunded)
+	owed -= at.commission(t, id, c1.ID, 1000)
+	at.owedOK(t, at.statsOK(t), owed)
+
+	// Commissions accrued after the export are left for the next payout.
+	cutoff := at.payoutsOK(t, aff.Code, true)
+	rt.createItemOK(t, c3.ID)
+	at.Stripe.expectedCart = []course.Course{c3}
+	late := at.referredCheckout(t, aff.Code)
+	ot.stripeWebhook(t, "checkout.session.completed", late)
+	ot.statusOK(t, late, order.Success)
+
+	at.settleOK(t, cutoff)
+	at.owedOK(t, at.statsOK(t), at.commission(t, late, c3.ID, 1000))
+
+	// Settled commissions are clawed back when refunded afterwards.
+	at.settleOK(t, at.payoutsOK(t, aff.Code, true))
+	at.owedOK(t, at.statsOK(t), 0)
+	at.payoutsOK(t, aff.Code, false)
+
+	ot.refundOK(t, id, []string{c2.ID}, order.Refunded)
+	at.owedOK(t, at.statsOK(t), -owed)
+	at.payoutsOK(t, aff.Code, false)
+}
+
+// commission computes the commission expected on the item of the order.
+func (at *affiliateTest) commission(t *testing.T, providerID string, courseID string, rate int64) int64 {
+	ord, err := order.FetchByProviderID(context.Background(), at.DB, providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := order.FetchItems(context.Background(), at.DB, ord.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, it := range items {
+		if it.CourseID == courseID {
+			return (it.Price - it.Tax) * rate / 10000
+		}
+	}
+
+	t.Fatalf("course %s not found in order %s", courseID, ord.ID)
+	return 0
+}
+
+// do performs the request as the passed user.
+func (at *affiliateTest) do(t *testing.T, email string, pass string, method string, url string, payload any) *http.Response {
+	og := &orgTest{at.TestEnv}
+	return og.do(t, email, pass, method, url, payload)
+}
+
+func (at *affiliateTest) createStatus(t *testing.T, email string, pass string, aff affiliate.AffiliateNew, status int) {
+	w := at.do(t, email, pass, http.MethodPost, "/affiliates", aff)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("creating affiliate: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (at *affiliateTest) setRateStatus(t *testing.T, rate affiliate.RateNew, status int) {
+	w := at.do(t, at.AdminEmail, at.AdminPass, http.MethodPut, "/affiliates/rates", rate)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("setting rate: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (at *affiliateTest) referralStatus(t *testing.T, code string, status int) {
+	w := at.do(t, at.UserEmail, at.UserPass, http.MethodPost, "/referrals", affiliate.Referral{Code: code})
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("capturing referral: expected status %d, got %s", status, w.Status)
+	}
+}
+
+// referredCheckout lands with the referral code and starts a stripe
+// checkout in the same session, returning the id of the stripe session.
+func (at *affiliateTest) referredCheckout(t *testing.T, code string) string {
+	if err := Login(at.Server, at.UserEmail, at.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(at.Server)
+
+	body, err := json.Marshal(affiliate.Referral{Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := at.Client().Post(at.URL+"/referrals", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't capture referral: status code %s", w.Status)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, at.URL+"/orders/stripe", billing(t, order.CheckoutNew{Country: "US"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = at.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't create stripe order: status code %s", w.Status)
+	}
+
+	var url string
+	if err := json.NewDecoder(w.Body).Decode(&url); err != nil {
+		t.Fatal(err)
+	}
+
+	return path.Base(url)
+}
+
+func (at *affiliateTest) statsStatus(t *testing.T, email string, pass string, status int) {
+	w := at.do(t, email, pass, http.MethodGet, "/affiliates/me", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("showing stats: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (at *affiliateTest) statsOK(t *testing.T) affiliate.Stats {
+	w := at.do(t, at.AdminEmail, at.AdminPass, http.MethodGet, "/affiliates/me", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show stats: status code %s", w.Status)
+	}
+
+	var stats affiliate.Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	return stats
+}
+
+// owedOK checks the amount owed to the affiliate, in the default currency.
+func (at *affiliateTest) owedOK(t *testing.T, stats affiliate.Stats, exp int64) {
+	var owed int64
+	for _, m := range stats.Owed {
+		owed += m.Amount
+	}
+
+	if owed != exp {
+		t.Fatalf("expected owed amount %d, got %d", exp, owed)
+	}
+}
+
+// payoutsOK checks whether the exported payouts include the affiliate.
+// It returns the cutoff of the payout of the affiliate, if any.
+func (at *affiliateTest) payoutsOK(t *testing.T, code string, exp bool) string {
+	w := at.do(t, at.AdminEmail, at.AdminPass, http.MethodGet, "/affiliates/payouts", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't export payouts: status code %s", w.Status)
+	}
+
+	if ct := w.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("expected csv payouts, got %s", ct)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	var cutoff string
+	for _, rec := range records[1:] {
+		if rec[1] == code {
+			found = true
+			cutoff = rec[5]
+		}
+	}
+
+	if found != exp {
+		t.Fatalf("expected affiliate %s in payouts: %t, got %t", code, exp, found)
+	}
+
+	return cutoff
+}
+
+// settleOK settles the payout of the affiliate exported with the passed cutoff.
+func (at *affiliateTest) settleOK(t *testing.T, cutoff string) {
+	stats := at.statsOK(t)
+
+	c, err := time.Parse(time.RFC3339Nano, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := at.do(t, at.AdminEmail, at.AdminPass, http.MethodPost, "/affiliates/"+stats.Affiliate.ID+"/payouts", affiliate.SettleNew{Cutoff: c})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't settle payouts: status code %s", w.Status)
+	}
+}
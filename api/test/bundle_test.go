@@ -0,0 +1,311 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/polldo/govod/core/bundle"
+	"github.com/polldo/govod/core/cart"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+)
+
+type bundleTest struct {
+	*TestEnv
+}
+
+func TestBundle(t *testing.T) {
+	env, err := NewTestEnv(t, "bundle_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	bt := &bundleTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+	c3 := ct.createCourseOK(t)
+	c4 := ct.createCourseOK(t)
+	c5 := ct.createCourseOK(t)
+
+	// Only administrators can manage bundles, while everyone can see them.
+	bt.createBundleUnauth(t, []string{c1.ID, c2.ID})
+	b1 := bt.createBundleOK(t, []string{c1.ID, c2.ID, c3.ID}, 5000)
+	b2 := bt.createBundleOK(t, []string{c4.ID, c5.ID}, 3000)
+	b3 := bt.createBundleOK(t, []string{c1.ID, c5.ID}, 1000)
+	bt.showBundleOK(t, b1)
+	bt.listBundlesOK(t, 3)
+	bt.deleteBundleOK(t, b3.ID)
+	bt.showBundleNotFound(t, b3.ID)
+	bt.listBundlesOK(t, 2)
+
+	// The user already owns a course of the bundle.
+	rt.createItemOK(t, c1.ID)
+	ot.Stripe.expectedCart = []course.Course{c1}
+	ot.testStripe(t)
+
+	// Courses in the cart that are part of the bundle are bought within it,
+	// while owned courses are credited on the price of the bundle.
+	rt.createItemOK(t, c2.ID)
+	bt.createCartBundleOK(t, b1.ID)
+	credited := (b1.Price*(c2.Price+c3.Price) + (c1.Price+c2.Price+c3.Price)/2) / (c1.Price + c2.Price + c3.Price)
+	ot.Stripe.expectedCart = []course.Course{{Price: credited}}
+	strpID := ot.testStripe(t)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c3})
+
+	// Courses bought within bundles keep track of them and sum up to the price charged.
+	items := ot.showOrderItemsOK(t, ot.orderID(t, strpID))
+	var tot int64
+	for _, it := range items {
+		if it.BundleID != b1.ID {
+			t.Fatalf("expected item of bundle %s, got %q", b1.ID, it.BundleID)
+		}
+		tot += it.Price
+	}
+	if len(items) != 2 || tot != credited {
+		t.Fatalf("expected 2 items totaling %d, got %d totaling %d", credited, len(items), tot)
+	}
+
+	// Bundles whose courses are all owned cannot be bought again.
+	bt.createCartBundleOwned(t, b1.ID)
+
+	// Bundles are paid at full price when no course is owned.
+	bt.createCartBundleOK(t, b2.ID)
+	ot.Paypal.expectedCart = []course.Course{{Price: b2.Price}}
+	ot.testPaypal(t)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c3, c4, c5})
+}
+
+// showOrderItemsOK returns the items of the passed order.
+func (ot *orderTest) showOrderItemsOK(t *testing.T, orderID string) []order.Item {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/orders/"+orderID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show order: status code %s", w.Status)
+	}
+
+	var rc order.Receipt
+	if err := json.NewDecoder(w.Body).Decode(&rc); err != nil {
+		t.Fatalf("cannot unmarshal order: %v", err)
+	}
+
+	return rc.Items
+}
+
+func (bt *bundleTest) createBundle(t *testing.T, email string, pass string, courseIDs []string, price int64) *http.Response {
+	if err := Login(bt.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(bt.Server)
+
+	b := bundle.BundleNew{
+		Name:        "Test bundle",
+		Description: "This is a test bundle",
+		Price:       price,
+		ImageURL:    "/images/test.png",
+		CourseIDs:   courseIDs,
+	}
+
+	body, err := json.Marshal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, bt.URL+"/bundles", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := bt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (bt *bundleTest) createBundleOK(t *testing.T, courseIDs []string, price int64) bundle.Bundle {
+	w := bt.createBundle(t, bt.AdminEmail, bt.AdminPass, courseIDs, price)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create bundle: status code %s", w.Status)
+	}
+
+	var got bundle.Bundle
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal created bundle: %v", err)
+	}
+
+	if got.Price != price || len(got.CourseIDs) != len(courseIDs) {
+		t.Fatalf("wrong bundle payload: %+v", got)
+	}
+
+	return got
+}
+
+func (bt *bundleTest) createBundleUnauth(t *testing.T, courseIDs []string) {
+	w := bt.createBundle(t, bt.UserEmail, bt.UserPass, courseIDs, 100)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to create bundles: status code %s", w.Status)
+	}
+}
+
+func (bt *bundleTest) showBundle(t *testing.T, id string) *http.Response {
+	r, err := http.NewRequest(http.MethodGet, bt.URL+"/bundles/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := bt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (bt *bundleTest) showBundleOK(t *testing.T, exp bundle.Bundle) {
+	w := bt.showBundle(t, exp.ID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show bundle: status code %s", w.Status)
+	}
+
+	var got bundle.Bundle
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal bundle: %v", err)
+	}
+
+	// Don't care about dates and the order of courses.
+	exp.CreatedAt = got.CreatedAt
+	exp.UpdatedAt = got.UpdatedAt
+
+	less := func(a, b string) bool { return a < b }
+	if diff := cmp.Diff(got, exp, cmpopts.SortSlices(less)); diff != "" {
+		t.Fatalf("wrong bundle payload. Diff: \n%s", diff)
+	}
+}
+
+func (bt *bundleTest) showBundleNotFound(t *testing.T, id string) {
+	w := bt.showBundle(t, id)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleted bundles should not be found: status code %s", w.Status)
+	}
+}
+
+func (bt *bundleTest) listBundlesOK(t *testing.T, exp int) {
+	r, err := http.NewRequest(http.MethodGet, bt.URL+"/bundles", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := bt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list bundles: status code %s", w.Status)
+	}
+
+	var got []bundle.Bundle
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal bundles: %v", err)
+	}
+
+	if len(got) != exp {
+		t.Fatalf("expected %d bundles, got %d", exp, len(got))
+	}
+}
+
+func (bt *bundleTest) deleteBundleOK(t *testing.T, id string) {
+	if err := Login(bt.Server, bt.AdminEmail, bt.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(bt.Server)
+
+	r, err := http.NewRequest(http.MethodDelete, bt.URL+"/bundles/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := bt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't delete bundle: status code %s", w.Status)
+	}
+}
+
+func (bt *bundleTest) createCartBundle(t *testing.T, bundleID string) *http.Response {
+	if err := Login(bt.Server, bt.UserEmail, bt.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(bt.Server)
+
+	body, err := json.Marshal(cart.BundleNew{BundleID: bundleID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPut, bt.URL+"/cart/bundles", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := bt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (bt *bundleTest) createCartBundleOK(t *testing.T, bundleID string) {
+	w := bt.createCartBundle(t, bundleID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't add bundle to cart: status code %s", w.Status)
+	}
+}
+
+func (bt *bundleTest) createCartBundleOwned(t *testing.T, bundleID string) {
+	w := bt.createCartBundle(t, bundleID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("owned bundles should not be added to cart: status code %s", w.Status)
+	}
+}
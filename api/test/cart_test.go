@@ -31,18 +31,19 @@ func TestCart(t *testing.T) {
 	ct := &cartTest{env}
 
 	// The cart should be initially empty.
-	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}})
+	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}, Bundles: []cart.Bundle{}})
 
 	// Add two items and check if they're added.
 	item1 := ct.createItemOK(t, course1.ID)
 	item2 := ct.createItemOK(t, course2.ID)
 	ct.showCartOK(t, cart.Cart{
-		Items: []cart.Item{item1, item2},
+		Items:   []cart.Item{item1, item2},
+		Bundles: []cart.Bundle{},
 	})
 
 	// Flush the cart and check that it's empty.
 	ct.deleteCartOK(t)
-	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}})
+	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}, Bundles: []cart.Bundle{}})
 
 	// Deletion should be idempotent.
 	ct.deleteCartOK(t)
@@ -52,7 +53,7 @@ func TestCart(t *testing.T) {
 	ct.createItemOK(t, course2.ID)
 	ct.deleteItemOK(t, item1.CourseID)
 	ct.deleteItemOK(t, item2.CourseID)
-	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}})
+	ct.showCartOK(t, cart.Cart{Items: []cart.Item{}, Bundles: []cart.Bundle{}})
 }
 
 func (ct *cartTest) createItemOK(t *testing.T, courseID string) cart.Item {
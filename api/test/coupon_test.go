@@ -0,0 +1,231 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/polldo/govod/core/coupon"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/money"
+	"github.com/stripe/stripe-go/v74"
+)
+
+type couponTest struct {
+	*TestEnv
+}
+
+func TestCoupon(t *testing.T) {
+	env, err := NewTestEnv(t, "coupon_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+	cpt := &couponTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+	c3 := ct.createCourseOK(t)
+
+	// Only admins can manage coupons.
+	cpt.createCouponUnauth(t)
+
+	half := cpt.createCouponOK(t, coupon.CouponNew{
+		Code:       "half50",
+		Kind:       coupon.Percentage,
+		Value:      50,
+		MaxPerUser: 1,
+		CourseIDs:  []string{c1.ID},
+	})
+
+	big := cpt.createCouponOK(t, coupon.CouponNew{
+		Code:     "BIG10",
+		Kind:     coupon.Fixed,
+		Value:    1000,
+		MinTotal: c1.Price + c2.Price + c3.Price + 1,
+		Currency: money.Default,
+	})
+
+	// Codes are unique.
+	cpt.createCouponInvalid(t, coupon.CouponNew{Code: "HALF50", Kind: coupon.Fixed, Value: 100, Currency: money.Default})
+
+	// Fixed values need a currency.
+	cpt.createCouponInvalid(t, coupon.CouponNew{Code: "FIXED10", Kind: coupon.Fixed, Value: 1000})
+
+	// Percentages cannot exceed 100.
+	cpt.createCouponInvalid(t, coupon.CouponNew{Code: "ALL200", Kind: coupon.Percentage, Value: 200})
+
+	rt.createItemOK(t, c1.ID)
+	rt.createItemOK(t, c2.ID)
+
+	// Unknown coupons and coupons whose conditions are not met are rejected.
+	cpt.applyCouponInvalid(t, "UNKNOWN")
+	cpt.applyCouponInvalid(t, big.Code)
+
+	// The percentage only applies to the course in the scope of the coupon.
+	q := cpt.applyCouponOK(t, half.Code)
+	disc := c1.Price * 50 / 100
+	if q.Discount != disc || q.Total != c1.Price+c2.Price-disc {
+		t.Fatalf("wrong quote: expected discount %d, got %+v", disc, q)
+	}
+
+	// The order records the discounted prices.
+	ot.testFake(t, order.CheckoutNew{Country: "US"})
+	ords := ot.listOrdersOK(t, 1)
+	if ords[0].Total != q.Total {
+		t.Fatalf("expected order total %d, got %d", q.Total, ords[0].Total)
+	}
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2})
+
+	// The coupon can be used only once per user.
+	rt.createItemOK(t, c3.ID)
+	cpt.applyCouponInvalid(t, half.Code)
+
+	// Failed orders give their redemptions back.
+	once := cpt.createCouponOK(t, coupon.CouponNew{
+		Code:       "ONCE50",
+		Kind:       coupon.Percentage,
+		Value:      50,
+		MaxPerUser: 1,
+		CourseIDs:  []string{c3.ID},
+	})
+
+	q = cpt.applyCouponOK(t, once.Code)
+	discounted := c3
+	discounted.Price = q.Total
+	ot.Stripe.expectedCart = []course.Course{discounted}
+	strpID := ot.stripeCheckout(t)
+	ot.stripeEventOK(t, "checkout.session.completed", "evt_coupon_1", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusUnpaid))
+	ot.stripeEventOK(t, "checkout.session.async_payment_failed", "evt_coupon_2", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusUnpaid))
+	ot.statusOK(t, strpID, order.Failed)
+
+	rt.createItemOK(t, c3.ID)
+	cpt.applyCouponOK(t, once.Code)
+}
+
+func (cpt *couponTest) createCouponOK(t *testing.T, cn coupon.CouponNew) coupon.Coupon {
+	if err := Login(cpt.Server, cpt.AdminEmail, cpt.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(cpt.Server)
+
+	w := cpt.createCoupon(t, cn)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create coupon: status code %s", w.Status)
+	}
+
+	var got coupon.Coupon
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal created coupon: %v", err)
+	}
+
+	return got
+}
+
+func (cpt *couponTest) createCouponInvalid(t *testing.T, cn coupon.CouponNew) {
+	if err := Login(cpt.Server, cpt.AdminEmail, cpt.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(cpt.Server)
+
+	w := cpt.createCoupon(t, cn)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("coupon should be invalid: status code %s", w.Status)
+	}
+}
+
+func (cpt *couponTest) createCouponUnauth(t *testing.T) {
+	if err := Login(cpt.Server, cpt.UserEmail, cpt.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(cpt.Server)
+
+	w := cpt.createCoupon(t, coupon.CouponNew{Code: "USER10", Kind: coupon.Fixed, Value: 10})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to create coupons: status code %s", w.Status)
+	}
+}
+
+func (cpt *couponTest) createCoupon(t *testing.T, cn coupon.CouponNew) *http.Response {
+	body, err := json.Marshal(&cn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, cpt.URL+"/coupons", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := cpt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (cpt *couponTest) applyCouponOK(t *testing.T, code string) coupon.Quote {
+	if err := Login(cpt.Server, cpt.UserEmail, cpt.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(cpt.Server)
+
+	w := cpt.applyCoupon(t, code)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't apply coupon: status code %s", w.Status)
+	}
+
+	var q coupon.Quote
+	if err := json.NewDecoder(w.Body).Decode(&q); err != nil {
+		t.Fatalf("cannot unmarshal quote: %v", err)
+	}
+
+	return q
+}
+
+func (cpt *couponTest) applyCouponInvalid(t *testing.T, code string) {
+	if err := Login(cpt.Server, cpt.UserEmail, cpt.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(cpt.Server)
+
+	w := cpt.applyCoupon(t, code)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("coupon %s should not be applicable: status code %s", code, w.Status)
+	}
+}
+
+func (cpt *couponTest) applyCoupon(t *testing.T, code string) *http.Response {
+	body, err := json.Marshal(coupon.Code{Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPut, cpt.URL+"/cart/coupon", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := cpt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/money"
 	"github.com/polldo/govod/validate"
 )
 
@@ -52,7 +53,7 @@ func (ct *courseTest) createCourseOK(t *testing.T) course.Course {
 	c := course.CourseNew{
 		Name:        "Test" + strconv.Itoa(rand.Intn(1000)),
 		Description: "This is a test course",
-		Price:       rand.Intn(1000),
+		Price:       1 + rand.Int63n(100000),
 		ImageURL:    "/images/test.png",
 	}
 
@@ -141,7 +142,8 @@ func (ct *courseTest) updateCourseOK(t *testing.T, crs course.Course) course.Cou
 	c := course.CourseUp{
 		Name:        ptr("Updated Test"),
 		Description: ptr("This is an updated test course"),
-		Price:       ptr(500),
+		Price:       ptr[int64](500),
+		Prices:      ptr([]money.Money{money.New(450, "EUR")}),
 		ImageURL:    ptr("/images/updated.png"),
 	}
 
@@ -174,6 +176,7 @@ func (ct *courseTest) updateCourseOK(t *testing.T, crs course.Course) course.Cou
 	exp.Name = *c.Name
 	exp.Description = *c.Description
 	exp.Price = *c.Price
+	exp.Prices = *c.Prices
 	exp.ImageURL = *c.ImageURL
 
 	if diff := cmp.Diff(got, exp); diff != "" {
@@ -199,7 +202,7 @@ func (ct *courseTest) updateCourseConcurrent(t *testing.T, crs course.Course) {
 		c := course.CourseUp{
 			Name:        ptr("Updated Test"),
 			Description: ptr("This is an updated test course"),
-			Price:       ptr(500),
+			Price:       ptr[int64](500),
 			ImageURL:    ptr("/images/updated.png"),
 		}
 
@@ -249,7 +252,7 @@ func (ct *courseTest) updateCourseInexistent(t *testing.T, crs course.Course) {
 	c := course.CourseUp{
 		Name:        ptr("Updated Test Course Not Existent"),
 		Description: ptr("This is an updated test course - not exist"),
-		Price:       ptr(300),
+		Price:       ptr[int64](300),
 		ImageURL:    ptr("/images/updated.png"),
 	}
 
@@ -283,7 +286,7 @@ func (ct *courseTest) updateCourseUnauth(t *testing.T, crs course.Course) {
 	c := course.CourseUp{
 		Name:        ptr("Updated Test Unauth"),
 		Description: ptr("This is an updated test course - unauth"),
-		Price:       ptr(300),
+		Price:       ptr[int64](300),
 		ImageURL:    ptr("/images/updated.png"),
 	}
 
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+)
+
+type enrollTest struct {
+	*TestEnv
+}
+
+func TestEnroll(t *testing.T) {
+	env, err := NewTestEnv(t, "enroll_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	et := &enrollTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+	og := &orgTest{env}
+
+	free := et.createFreeCourseOK(t)
+	paid := ct.createCourseOK(t)
+
+	// Only free courses can be enrolled without paying.
+	et.enrollStatus(t, "", "", free.ID, http.StatusUnauthorized)
+	et.enrollStatus(t, et.UserEmail, et.UserPass, paid.ID, http.StatusUnprocessableEntity)
+	et.enrollStatus(t, et.UserEmail, et.UserPass, "70a61a6c-2b69-4a6b-a49f-5aeb3a8e3d88", http.StatusNotFound)
+
+	rc := et.enrollOK(t, free.ID)
+	ct.listCoursesOwnedOK(t, []course.Course{free})
+
+	// Enrollments are not sales, so they are never invoiced.
+	ot.showInvoiceNotFound(t, rc.ID)
+	w := og.do(t, et.AdminEmail, et.AdminPass, http.MethodPost, "/orders/"+rc.ID+"/invoice/regenerate", nil)
+	w.Body.Close()
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected free order not to be invoiced, got %s", w.Status)
+	}
+	et.enrollStatus(t, et.UserEmail, et.UserPass, free.ID, http.StatusUnprocessableEntity)
+
+	// Carts with nothing to be paid skip the payment provider.
+	free2 := et.createFreeCourseOK(t)
+	rt.createItemOK(t, free2.ID)
+	w = og.do(t, et.UserEmail, et.UserPass, http.MethodPost, "/orders/stripe", order.CheckoutNew{Country: "US"})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't checkout free cart: status code %s", w.Status)
+	}
+
+	rc = et.receiptOK(t, w)
+	ct.listCoursesOwnedOK(t, []course.Course{free, free2})
+
+	// Free orders can be revoked as the paid ones.
+	ot.refundOK(t, rc.ProviderID, nil, order.Refunded)
+	ct.listCoursesOwnedOK(t, []course.Course{free})
+}
+
+// createFreeCourseOK creates a course with no price.
+func (et *enrollTest) createFreeCourseOK(t *testing.T) course.Course {
+	og := &orgTest{et.TestEnv}
+
+	c := course.CourseNew{
+		Name:        "Free course",
+		Description: "This is a free course",
+		ImageURL:    "/images/test.png",
+	}
+
+	w := og.do(t, et.AdminEmail, et.AdminPass, http.MethodPost, "/courses", c)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create free course: status code %s", w.Status)
+	}
+
+	var got course.Course
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal created course: %v", err)
+	}
+
+	if !got.Free() {
+		t.Fatalf("expected free course, got price %d", got.Price)
+	}
+
+	return got
+}
+
+func (et *enrollTest) enroll(t *testing.T, email string, pass string, courseID string) *http.Response {
+	if email != "" {
+		if err := Login(et.Server, email, pass); err != nil {
+			t.Fatal(err)
+		}
+		defer Logout(et.Server)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, et.URL+"/courses/"+courseID+"/enroll", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := et.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (et *enrollTest) enrollStatus(t *testing.T, email string, pass string, courseID string, status int) {
+	w := et.enroll(t, email, pass, courseID)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("enrolling: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (et *enrollTest) enrollOK(t *testing.T, courseID string) order.Receipt {
+	w := et.enroll(t, et.UserEmail, et.UserPass, courseID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't enroll: status code %s", w.Status)
+	}
+
+	return et.receiptOK(t, w)
+}
+
+// receiptOK checks that the free order returned has been fulfilled.
+func (et *enrollTest) receiptOK(t *testing.T, w *http.Response) order.Receipt {
+	var rc order.Receipt
+	if err := json.NewDecoder(w.Body).Decode(&rc); err != nil {
+		t.Fatal(err)
+	}
+
+	if rc.Status != order.Success || rc.Provider != order.ProviderFree || rc.Total != 0 {
+		t.Fatalf("expected free order fulfilled, got %s order of %d with status %s", rc.Provider, rc.Total, rc.Status)
+	}
+
+	return rc
+}
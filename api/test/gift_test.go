@@ -0,0 +1,200 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/gift"
+	"github.com/polldo/govod/core/order"
+)
+
+type giftTest struct {
+	*TestEnv
+}
+
+func TestGift(t *testing.T) {
+	env, err := NewTestEnv(t, "gift_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	gt := &giftTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+
+	// The user buys the cart for the admin.
+	rt.createItemOK(t, c1.ID)
+	rt.createItemOK(t, c2.ID)
+	ot.Stripe.expectedCart = []course.Course{c1, c2}
+	gn := &gift.GiftNew{Email: gt.AdminEmail, Message: "Enjoy!"}
+	id := gt.giftCheckout(t, order.CheckoutNew{Country: "US", Gift: gn})
+
+	// Codes are not sent until the order is fulfilled.
+	gt.deliverOK(t, "")
+	ot.stripeWebhook(t, "checkout.session.completed", id)
+	ot.statusOK(t, id, order.Success)
+
+	// The buyer doesn't own the courses of the gift.
+	ct.listCoursesOwnedOK(t, []course.Course{})
+
+	gt.deliverOK(t, gt.AdminEmail)
+	code := gt.Mailer.token
+
+	// Codes are sent only once.
+	gt.deliverOK(t, "")
+
+	gt.redeemInvalid(t, gt.AdminEmail, gt.AdminPass, "invalid-code", http.StatusBadRequest)
+	gt.redeemOK(t, gt.AdminEmail, gt.AdminPass, code)
+	gt.redeemInvalid(t, gt.UserEmail, gt.UserPass, code, http.StatusUnprocessableEntity)
+
+	// Courses are owned by the recipient, not by the buyer.
+	gt.listOwnedOK(t, gt.AdminEmail, gt.AdminPass, 2)
+	gt.listOwnedOK(t, gt.UserEmail, gt.UserPass, 0)
+}
+
+// giftCheckout starts a stripe checkout of the cart as a gift and returns
+// the id of the created stripe session.
+func (gt *giftTest) giftCheckout(t *testing.T, bill order.CheckoutNew) string {
+	if err := Login(gt.Server, gt.UserEmail, gt.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(gt.Server)
+
+	r, err := http.NewRequest(http.MethodPost, gt.URL+"/orders/stripe", billing(t, bill))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := gt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't create stripe gift order: status code %s", w.Status)
+	}
+
+	urlBytes, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var url string
+	if err := json.Unmarshal(urlBytes, &url); err != nil {
+		t.Fatal(err)
+	}
+
+	return path.Base(url)
+}
+
+// deliverOK sends the codes of the fulfilled gifts and checks that
+// a code has been sent to the expected recipient, if any.
+func (gt *giftTest) deliverOK(t *testing.T, to string) {
+	gt.Mailer.token = ""
+
+	if err := gift.Deliver(context.Background(), gt.DB, gt.Mailer); err != nil {
+		t.Fatal(err)
+	}
+
+	if to == "" && gt.Mailer.token != "" {
+		t.Fatal("no gift code should be sent")
+	}
+
+	if to != "" && gt.Mailer.token == "" {
+		t.Fatalf("gift code should be sent to %s", to)
+	}
+}
+
+func (gt *giftTest) redeem(t *testing.T, email string, pass string, code string) *http.Response {
+	if err := Login(gt.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(gt.Server)
+
+	body, err := json.Marshal(gift.Code{Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, gt.URL+"/gifts/redeem", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := gt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (gt *giftTest) redeemOK(t *testing.T, email string, pass string, code string) {
+	w := gt.redeem(t, email, pass, code)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't redeem gift: status code %s", w.Status)
+	}
+
+	var g gift.Gift
+	if err := json.NewDecoder(w.Body).Decode(&g); err != nil {
+		t.Fatalf("cannot unmarshal redeemed gift: %v", err)
+	}
+
+	if g.RedeemedAt == nil {
+		t.Fatal("gift should be redeemed")
+	}
+}
+
+func (gt *giftTest) redeemInvalid(t *testing.T, email string, pass string, code string, status int) {
+	w := gt.redeem(t, email, pass, code)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d redeeming gift, got %s", status, w.Status)
+	}
+}
+
+// listOwnedOK checks the number of courses owned by the passed user.
+func (gt *giftTest) listOwnedOK(t *testing.T, email string, pass string, exp int) {
+	if err := Login(gt.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(gt.Server)
+
+	r, err := http.NewRequest(http.MethodGet, gt.URL+"/courses/owned", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := gt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list owned courses: status code %s", w.Status)
+	}
+
+	var got []course.Course
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal owned courses: %v", err)
+	}
+
+	if len(got) != exp {
+		t.Fatalf("expected %d owned courses, got %d", exp, len(got))
+	}
+}
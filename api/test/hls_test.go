@@ -0,0 +1,190 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/polldo/govod/core/video"
+)
+
+// uriAttr matches the URI attributes of HLS tags.
+var uriAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// mediaAttr matches the media templates of DASH manifests.
+var mediaAttr = regexp.MustCompile(`media="([^"]*)"`)
+
+type hlsTest struct {
+	*TestEnv
+}
+
+func TestHLS(t *testing.T) {
+	env, err := NewTestEnv(t, "hls_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	ht := &hlsTest{env}
+	st := &streamTest{env}
+	et := &enrollTest{env}
+	vt := &videoTest{env}
+	og := &orgTest{env}
+
+	segments := media([]byte{0x47}, 3000)
+	key := media(nil, 16)
+
+	ht.upload(t, "videos/hls/master.m3u8", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720\n720p/index.m3u8\n")
+	ht.upload(t, "videos/hls/720p/index.m3u8", "#EXTM3U\n"+
+		"#EXT-X-TARGETDURATION:6\n"+
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"../key.bin\"\n"+
+		"#EXTINF:6.0,\n#EXT-X-BYTERANGE:1000@0\nsegments.ts\n"+
+		"#EXTINF:6.0,\n#EXT-X-BYTERANGE:2000@1000\nsegments.ts\n"+
+		"#EXT-X-ENDLIST\n")
+	ht.upload(t, "videos/hls/720p/segments.ts", string(segments))
+	ht.upload(t, "videos/hls/key.bin", string(key))
+
+	c := et.createFreeCourseOK(t)
+	v := vt.createVideoOK(t, c.ID, 1)
+
+	w := og.do(t, ht.AdminEmail, ht.AdminPass, http.MethodPut, "/videos/"+v.ID, video.VideoUp{Key: ptr("videos/hls/master.m3u8"), Free: ptr(false)})
+	w.Body.Close()
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't update video: status code %s", w.Status)
+	}
+
+	// Every file of the playlist is checked against the ownership of the course.
+	master := "/videos/" + v.ID + "/media/master.m3u8"
+	ht.fetchStatus(t, "", "", master, http.StatusUnauthorized)
+	ht.fetchStatus(t, ht.UserEmail, ht.UserPass, master, http.StatusForbidden)
+
+	et.enrollOK(t, c.ID)
+	ht.fetchStatus(t, ht.UserEmail, ht.UserPass, "/videos/"+v.ID+"/media/720p/segments.ts", http.StatusOK)
+	ht.fetchStatus(t, ht.UserEmail, ht.UserPass, "/videos/"+v.ID+"/stream", http.StatusNotFound)
+	ht.fetchStatus(t, ht.UserEmail, ht.UserPass, "/videos/"+v.ID+"/media/missing.ts", http.StatusNotFound)
+
+	// Owners are given a signed link of the master playlist, whose URIs are signed too.
+	link := st.showFullURL(t, v.ID)
+	if !strings.HasPrefix(link, master+"?") {
+		t.Fatalf("expected signed link of the master playlist, got %s", link)
+	}
+
+	body := ht.playlistOK(t, link, "application/vnd.apple.mpegurl")
+	variant := ht.uris(body)[0]
+	if !strings.HasPrefix(variant, "/videos/"+v.ID+"/media/720p/index.m3u8?") {
+		t.Fatalf("expected signed link of the variant playlist, got %s", variant)
+	}
+
+	body = ht.playlistOK(t, variant, "application/vnd.apple.mpegurl")
+	uris := ht.uris(body)
+	if len(uris) != 2 || uris[0] != uris[1] || !strings.Contains(body, "#EXT-X-BYTERANGE:2000@1000") {
+		t.Fatalf("expected byte ranges of the same segment, got:\n%s", body)
+	}
+
+	keys := uriAttr.FindStringSubmatch(body)
+	if keys == nil || !strings.HasPrefix(keys[1], "/videos/"+v.ID+"/media/key.bin?") {
+		t.Fatalf("expected signed link of the key, got:\n%s", body)
+	}
+
+	// Segments are fetched by their byte ranges.
+	w = st.fetch(t, "", "", uris[1], http.Header{"Range": {"bytes=1000-2999"}})
+	st.bodyOK(t, w, http.StatusPartialContent, segments[1000:3000])
+
+	w = st.fetch(t, "", "", keys[1], nil)
+	st.bodyOK(t, w, http.StatusOK, key)
+
+	// Links are valid only for the file they were signed for.
+	_, query, _ := strings.Cut(uris[0], "?")
+	ht.fetchStatus(t, "", "", "/videos/"+v.ID+"/media/key.bin?"+query, http.StatusForbidden)
+
+	// Users not owning the course can't fetch files without links.
+	ht.fetchStatus(t, ht.AdminEmail, ht.AdminPass, "/videos/"+v.ID+"/media/key.bin", http.StatusForbidden)
+
+	// DASH manifests sign the segments built by players by prefix.
+	ht.upload(t, "videos/dash/manifest.mpd", `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="$RepresentationID$/seg-$Number$.m4s" startNumber="1"/>
+      <Representation id="720p" bandwidth="2000000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+	ht.upload(t, "videos/dash/720p/seg-1.m4s", string(segments))
+
+	w = og.do(t, ht.AdminEmail, ht.AdminPass, http.MethodPut, "/videos/"+v.ID, video.VideoUp{Key: ptr("videos/dash/manifest.mpd")})
+	w.Body.Close()
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't update video: status code %s", w.Status)
+	}
+
+	body = ht.playlistOK(t, st.showFullURL(t, v.ID), "application/dash+xml")
+	tmpl := mediaAttr.FindStringSubmatch(body)
+	if tmpl == nil {
+		t.Fatalf("expected segment template, got:\n%s", body)
+	}
+
+	_, query, _ = strings.Cut(html.UnescapeString(tmpl[1]), "?")
+	w = st.fetch(t, "", "", "/videos/"+v.ID+"/media/720p/seg-1.m4s?"+query, nil)
+	st.bodyOK(t, w, http.StatusOK, segments)
+
+	ht.fetchStatus(t, "", "", "/videos/"+v.ID+"/stream?"+query, http.StatusForbidden)
+}
+
+// upload stores the file of the playlist directly.
+func (ht *hlsTest) upload(t *testing.T, key string, data string) {
+	if _, err := ht.Storage.Put(context.Background(), key, strings.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatalf("uploading %s: %v", key, err)
+	}
+}
+
+func (ht *hlsTest) fetchStatus(t *testing.T, email string, pass string, link string, status int) {
+	st := &streamTest{ht.TestEnv}
+	w := st.fetch(t, email, pass, link, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("fetching %s: expected status %d, got %s", link, status, w.Status)
+	}
+}
+
+// playlistOK fetches the playlist by its link, without the session.
+func (ht *hlsTest) playlistOK(t *testing.T, link string, contentType string) string {
+	st := &streamTest{ht.TestEnv}
+	w := st.fetch(t, "", "", link, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't fetch playlist %s: status code %s", link, w.Status)
+	}
+
+	if ct := w.Header.Get("Content-Type"); ct != contentType {
+		t.Fatalf("expected playlist of type %s, got %s", contentType, ct)
+	}
+
+	b, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(b, []byte("videos/hls")) || bytes.Contains(b, []byte("videos/dash")) {
+		t.Fatalf("expected playlist not to expose the storage, got:\n%s", b)
+	}
+
+	return string(b)
+}
+
+// uris returns the URIs of the variants or segments of the HLS playlist.
+func (ht *hlsTest) uris(body string) []string {
+	var uris []string
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	return uris
+}
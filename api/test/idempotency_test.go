@@ -0,0 +1,127 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/polldo/govod/core/cart"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/idempotency"
+	"github.com/polldo/govod/core/order"
+)
+
+type idempotencyTest struct {
+	*TestEnv
+}
+
+func TestIdempotency(t *testing.T) {
+	env, err := NewTestEnv(t, "idempotency_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	it := &idempotencyTest{env}
+	ot := &orderTest{env}
+	ct := &courseTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+
+	// Retries of the same request replay the first response.
+	item := cart.ItemNew{CourseID: c1.ID}
+	first := it.sendOK(t, it.UserEmail, it.UserPass, http.MethodPut, "/cart/items", "cart-key", item, http.StatusCreated)
+	it.replayOK(t, it.UserEmail, it.UserPass, http.MethodPut, "/cart/items", "cart-key", item, http.StatusCreated, first)
+
+	// Keys cannot be reused for different requests.
+	other := cart.ItemNew{CourseID: c2.ID}
+	it.sendOK(t, it.UserEmail, it.UserPass, http.MethodPut, "/cart/items", "cart-key", other, http.StatusUnprocessableEntity)
+
+	// Repeated checkouts create a single order.
+	bill := order.CheckoutNew{Country: "US"}
+	ot.Stripe.expectedCart = []course.Course{c1}
+	first = it.sendOK(t, it.UserEmail, it.UserPass, http.MethodPost, "/orders/stripe", "checkout-key", bill, http.StatusOK)
+	it.replayOK(t, it.UserEmail, it.UserPass, http.MethodPost, "/orders/stripe", "checkout-key", bill, http.StatusOK, first)
+	ot.listOrdersOK(t, 1)
+
+	// The query is part of the request, as it selects the currency of checkouts.
+	got := it.sendOK(t, it.UserEmail, it.UserPass, http.MethodPost, "/orders/stripe?currency=EUR", "checkout-key", bill, http.StatusUnprocessableEntity)
+	if !bytes.Contains(got, []byte("different request")) {
+		t.Fatalf("expected key reused with a different query to be rejected, got %s", got)
+	}
+	ot.listOrdersOK(t, 1)
+
+	// Keys are scoped to users.
+	cn := course.CourseNew{Name: "Idempotent", Description: "Created once", Price: 100, ImageURL: "/images/test.png"}
+	first = it.sendOK(t, it.AdminEmail, it.AdminPass, http.MethodPost, "/courses", "cart-key", cn, http.StatusCreated)
+	it.replayOK(t, it.AdminEmail, it.AdminPass, http.MethodPost, "/courses", "cart-key", cn, http.StatusCreated, first)
+}
+
+// send performs a request with the passed idempotency key, as the passed user.
+func (it *idempotencyTest) send(t *testing.T, email string, pass string, method string, path string, key string, body any) *http.Response {
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(method, it.URL+path, bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(idempotency.Header, key)
+
+	if err := Login(it.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(it.Server)
+
+	w, err := it.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// sendOK performs the request and checks its status code.
+// It returns the body of the response.
+func (it *idempotencyTest) sendOK(t *testing.T, email string, pass string, method string, path string, key string, body any, status int) []byte {
+	w := it.send(t, email, pass, method, path, key, body)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("%s %s: expected status code %d, got %s", method, path, status, w.Status)
+	}
+
+	got, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+// replayOK retries the request and checks that the expected response is replayed.
+func (it *idempotencyTest) replayOK(t *testing.T, email string, pass string, method string, path string, key string, body any, status int, exp []byte) {
+	w := it.send(t, email, pass, method, path, key, body)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("%s %s: expected replayed status code %d, got %s", method, path, status, w.Status)
+	}
+
+	if w.Header.Get(idempotency.ReplayedHeader) != "true" {
+		t.Fatalf("%s %s: response not replayed", method, path)
+	}
+
+	got, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, exp) {
+		t.Fatalf("%s %s: expected replayed body %s, got %s", method, path, exp, got)
+	}
+}
@@ -21,7 +21,11 @@ import (
 	"github.com/polldo/govod/api"
 	"github.com/polldo/govod/api/background"
 	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/core/subscription"
 	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/stripe-go/v74"
 	stripecl "github.com/stripe/stripe-go/v74/client"
@@ -30,6 +34,12 @@ import (
 
 var dbTest config.DB
 
+// mediaURL is the base URL the media files of tests are presigned with.
+const mediaURL = "https://media.govod.test"
+
+// linkSecret is the secret the media links of tests are signed with.
+const linkSecret = "0123456789abcdefghijklmnopqrstuv"
+
 func TestMain(m *testing.M) {
 	dbTest = config.DB{
 		User:       "test_user",
@@ -126,6 +136,16 @@ func (m *mockMailer) SendRecoveryToken(token string, dst string) error {
 	return nil
 }
 
+func (m *mockMailer) SendGiftCode(code string, dst string, sender string, message string) error {
+	m.token = code
+	return nil
+}
+
+func (m *mockMailer) SendInvitation(token string, dst string, org string) error {
+	m.token = token
+	return nil
+}
+
 const seedTest = `
 INSERT INTO users (user_id, name, email, role, active, password_hash, created_at, updated_at) VALUES
 	('ae127240-ce13-4789-aafd-d2f31e7ee487', 'Admin', '{{ .AdminEmail}}', 'ADMIN', TRUE, '{{ .AdminPassHash}}', '2022-09-16 00:00:00', '2022-09-16 00:00:00'),
@@ -144,12 +164,24 @@ type TestEnv struct {
 	UserEmail string
 	UserPass  string
 
+	// DB allows tests to inspect the state of the database.
+	DB *sqlx.DB
+
 	// Collect mocked dependencies here to make them
 	// available to all tests.
 	Mailer        *mockMailer
 	Paypal        *mockPaypal
 	Stripe        *mockStripe
 	WebhookSecret string
+
+	// BillingWebhookSecret signs the stripe events of subscriptions.
+	BillingWebhookSecret string
+
+	// Payments contains the payment providers, pointing to mocked servers.
+	Payments map[string]order.PaymentProvider
+
+	// Storage keeps the media files in a temporary directory.
+	Storage storage.Store
 }
 
 func (te *TestEnv) parseSeed() (string, error) {
@@ -214,6 +246,7 @@ func NewTestEnv(t *testing.T, dbname string) (*TestEnv, error) {
 
 	// Setup the test environment with some users.
 	te := &TestEnv{
+		DB:         dbEnv,
 		AdminEmail: "admin@govod.com",
 		AdminPass:  "admin-password123",
 		UserEmail:  "user@govod.com",
@@ -262,12 +295,14 @@ func NewTestEnv(t *testing.T, dbname string) (*TestEnv, error) {
 
 	// Build the stripe client to allow payments.
 	strpcfg := config.Stripe{
-		APISecret:     "random-key",
-		WebhookSecret: "random-test-secret",
-		SuccessURL:    "/success.html",
-		CancelURL:     "/cart.html",
+		APISecret:            "random-key",
+		WebhookSecret:        "random-test-secret",
+		BillingWebhookSecret: "random-billing-secret",
+		SuccessURL:           "/success.html",
+		CancelURL:            "/cart.html",
 	}
 	te.WebhookSecret = strpcfg.WebhookSecret
+	te.BillingWebhookSecret = strpcfg.BillingWebhookSecret
 	strp := &stripecl.API{}
 
 	// Point to the mocked stripe server.
@@ -277,6 +312,23 @@ func NewTestEnv(t *testing.T, dbname string) (*TestEnv, error) {
 		Uploads: stripe.GetBackend(stripe.UploadsBackend),
 	})
 
+	te.Payments = map[string]order.PaymentProvider{
+		order.ProviderPaypal: order.NewPaypal(pp, config.Paypal{WebhookID: paypalWebhookID}),
+		order.ProviderStripe: order.NewStripe(strp, strpcfg),
+		order.ProviderFake:   order.NewFake(),
+	}
+
+	// Media files are presigned as if the directory was publicly served.
+	te.Storage, err = storage.NewLocal(t.TempDir(), mediaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sg, err := signer.New(time.Hour, signer.Key{ID: "test", Secret: []byte(linkSecret)})
+	if err != nil {
+		return nil, err
+	}
+
 	api := api.APIMux(api.APIConfig{
 		CorsOrigin:         "",
 		Log:                log,
@@ -285,10 +337,15 @@ func NewTestEnv(t *testing.T, dbname string) (*TestEnv, error) {
 		Mailer:             mail,
 		TokenTimeout:       time.Nanosecond,
 		Background:         bg,
-		Paypal:             pp,
-		Stripe:             strp,
-		StripeCfg:          strpcfg,
+		Payments:           te.Payments,
+		Invoice:            config.Invoice{SellerName: "Govod"},
+		Billing:            subscription.NewStripe(strp, strpcfg),
+		Subscriptions:      config.Subscriptions{GracePeriod: 72 * time.Hour},
 		ActivationRequired: true,
+		Storage:            te.Storage,
+		PresignTTL:         time.Hour,
+		Uploads:            config.Uploads{MaxVideoSize: 1 << 20, MaxImageSize: 1 << 16, MaxChunkSize: 1 << 14},
+		Signer:             sg,
 	})
 
 	jar, err := cookiejar.New(nil)
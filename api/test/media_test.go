@@ -0,0 +1,162 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/video"
+)
+
+type mediaTest struct {
+	*TestEnv
+}
+
+func TestMedia(t *testing.T) {
+	env, err := NewTestEnv(t, "media_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	mt := &mediaTest{env}
+	ct := &courseTest{env}
+
+	mt.upload(t, "images/course.png", "image")
+	mt.upload(t, "videos/intro.mp4", "video")
+
+	// Courses can reference images uploaded to the storage.
+	mt.createCourseStatus(t, "images/missing.png", http.StatusUnprocessableEntity)
+	mt.createCourseStatus(t, "../course.png", http.StatusUnprocessableEntity)
+	c := mt.createCourseStatus(t, "images/course.png", http.StatusCreated)
+
+	if c.ImageURL != "/courses/"+c.ID+"/image" {
+		t.Fatalf("expected image served by the api, got %s", c.ImageURL)
+	}
+	mt.showImageOK(t, c.ID, "images/course.png")
+
+	// Images referenced by url are not served by the api.
+	c2 := ct.createCourseOK(t)
+	w := mt.get(t, "/courses/"+c2.ID+"/image")
+	w.Body.Close()
+	if w.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected course without stored image, got %s", w.Status)
+	}
+
+	// Videos can reference objects uploaded to the storage.
+	mt.createVideoStatus(t, c.ID, "videos/missing.mp4", http.StatusUnprocessableEntity)
+	v := mt.createVideoStatus(t, c.ID, "videos/intro.mp4", http.StatusCreated)
+	mt.showFreeURL(t, v.ID)
+}
+
+// upload stores the object directly, as the uploads of administrators do.
+func (mt *mediaTest) upload(t *testing.T, key string, data string) {
+	if _, err := mt.Storage.Put(context.Background(), key, strings.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatalf("uploading %s: %v", key, err)
+	}
+}
+
+func (mt *mediaTest) createCourseStatus(t *testing.T, key string, status int) course.Course {
+	og := &orgTest{mt.TestEnv}
+
+	c := course.CourseNew{
+		Name:        "Stored course",
+		Description: "This is a course with a stored image",
+		Price:       1000,
+		ImageKey:    key,
+	}
+
+	w := og.do(t, mt.AdminEmail, mt.AdminPass, http.MethodPost, "/courses", c)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("creating course with image %s: expected status %d, got %s", key, status, w.Status)
+	}
+
+	var got course.Course
+	if status == http.StatusCreated {
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("cannot unmarshal created course: %v", err)
+		}
+	}
+
+	return got
+}
+
+func (mt *mediaTest) createVideoStatus(t *testing.T, courseID string, key string, status int) video.Video {
+	og := &orgTest{mt.TestEnv}
+
+	v := video.VideoNew{
+		CourseID:    courseID,
+		Index:       1,
+		Name:        "Stored video",
+		Description: "This is a stored video",
+		Free:        true,
+		Key:         key,
+		ImageURL:    "/images/new.png",
+	}
+
+	w := og.do(t, mt.AdminEmail, mt.AdminPass, http.MethodPost, "/videos", v)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("creating video with key %s: expected status %d, got %s", key, status, w.Status)
+	}
+
+	var got video.Video
+	if status == http.StatusCreated {
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("cannot unmarshal created video: %v", err)
+		}
+	}
+
+	return got
+}
+
+func (mt *mediaTest) get(t *testing.T, path string) *http.Response {
+	w, err := mt.Client().Get(mt.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// showImageOK checks that clients are redirected to the presigned image.
+func (mt *mediaTest) showImageOK(t *testing.T, courseID string, key string) {
+	w := mt.get(t, "/courses/"+courseID+"/image")
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusFound {
+		t.Fatalf("can't show image: status code %s", w.Status)
+	}
+
+	if loc := w.Header.Get("Location"); loc != mediaURL+"/"+key {
+		t.Fatalf("expected redirect to %s, got %s", mediaURL+"/"+key, loc)
+	}
+}
+
+// showFreeURL checks that the video is located by a signed link of its
+// stream, never exposing where it is stored, and returns the link.
+func (mt *mediaTest) showFreeURL(t *testing.T, videoID string) string {
+	w := mt.get(t, "/videos/"+videoID+"/free")
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show free video: status code %s", w.Status)
+	}
+
+	var got struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "/videos/" + videoID + "/stream?"; !strings.HasPrefix(got.URL, exp) || !strings.Contains(got.URL, "sig=") {
+		t.Fatalf("expected video url signed link of %s, got %s", exp, got.URL)
+	}
+
+	return got.URL
+}
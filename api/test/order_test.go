@@ -2,6 +2,7 @@ package test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -11,6 +12,10 @@ import (
 
 	"github.com/plutov/paypal/v4"
 	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/tax"
+	"github.com/polldo/govod/validate"
 	"github.com/stripe/stripe-go/v74"
 	"github.com/stripe/stripe-go/v74/webhook"
 )
@@ -28,6 +33,7 @@ func TestOrder(t *testing.T) {
 	ot := &orderTest{env}
 	ct := &courseTest{env}
 	rt := &cartTest{env}
+	ut := &userTest{env}
 
 	// Prepping the env.
 	c1 := ct.createCourseOK(t)
@@ -36,6 +42,14 @@ func TestOrder(t *testing.T) {
 	_ = ct.createCourseOK(t)
 	c3 := ct.createCourseOK(t)
 	c4 := ct.createCourseOK(t)
+	c5 := ct.createCourseOK(t)
+	c6 := ct.createCourseOK(t)
+	c7 := ct.createCourseOK(t)
+	c8 := ct.createCourseOK(t)
+	c9 := ct.createCourseOK(t)
+	c10 := ct.createCourseOK(t)
+	c11 := ct.createCourseOK(t)
+	c12 := ct.createCourseOK(t)
 
 	// Initially the user doesn't own any course.
 	ct.listCoursesOwnedOK(t, []course.Course{})
@@ -46,7 +60,7 @@ func TestOrder(t *testing.T) {
 
 	// Perform a paypal payment.
 	ot.Paypal.expectedCart = []course.Course{c1, c2}
-	ot.testPaypal(t)
+	ppID := ot.testPaypal(t)
 
 	// Check if the paypal payment has been correctly fulfilled.
 	ct.listCoursesOwnedOK(t, []course.Course{c1, c2})
@@ -57,20 +71,256 @@ func TestOrder(t *testing.T) {
 
 	// Perform a stripe payment.
 	ot.Stripe.expectedCart = []course.Course{c3, c4}
-	ot.testStripe(t)
+	strpID := ot.testStripe(t)
 
 	// Check if the stripe payment has been correctly fulfilled.
 	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c3, c4})
+
+	// Users cannot refund their orders.
+	ot.refundUnauth(t, strpID)
+
+	// Refunds failed on the provider are left pending, and block other refunds of the order.
+	// Buyers keep access to the courses until they are repaid.
+	ot.Stripe.expectedRefund = c4.Price
+	ot.refundFailed(t, strpID, []string{c3.ID})
+	ot.statusOK(t, strpID, order.Success)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c3, c4})
+	ot.refundInvalid(t, strpID, []string{c4.ID})
+
+	// Partially refund the stripe order, retrying the pending refund,
+	// and check the course is not owned anymore.
+	ot.Stripe.expectedRefund = c3.Price
+	ot.refundOK(t, strpID, nil, order.PartiallyRefunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c4})
+
+	// The same item cannot be refunded twice.
+	ot.refundInvalid(t, strpID, []string{c3.ID})
+
+	// Refund the whole paypal order.
+	ot.Paypal.expectedRefund = c1.Price + c2.Price
+	ot.refundOK(t, ppID, nil, order.Refunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c4})
+
+	// Refund the remaining item of the stripe order.
+	ot.Stripe.expectedRefund = c4.Price
+	ot.refundOK(t, strpID, nil, order.Refunded)
+	ct.listCoursesOwnedOK(t, []course.Course{})
+
+	// Fully refunded orders cannot be refunded anymore.
+	ot.refundInvalid(t, ppID, nil)
+
+	// Abandon a stripe checkout and let it expire.
+	rt.createItemOK(t, c5.ID)
+	ot.Stripe.expectedCart = []course.Course{c5}
+	expID := ot.stripeCheckout(t)
+	ot.expireOK(t, expID)
+
+	// A late payment must not fulfill the expired order.
+	ot.stripeWebhook(t, "checkout.session.completed", expID)
+	ot.statusOK(t, expID, order.Expired)
+	ct.listCoursesOwnedOK(t, []course.Course{})
+
+	// Stripe notifies the expiration of sessions too.
+	ot.Stripe.expectedCart = []course.Course{c5}
+	expID = ot.stripeCheckout(t)
+	ot.stripeWebhook(t, "checkout.session.expired", expID)
+	ot.statusOK(t, expID, order.Expired)
+
+	// Unknown payment providers are rejected.
+	ot.checkoutNotFound(t, "unknown")
+
+	// Payments can be completed locally with the fake provider.
+	rt.createItemOK(t, c6.ID)
+	ot.testFake(t, order.CheckoutNew{Country: "US"})
+	ct.listCoursesOwnedOK(t, []course.Course{c6})
+
+	// Paid orders are tracked by fulfillments.
+	ot.listFulfillmentsUnauth(t)
+	ot.listFulfillmentsOK(t, order.FulfillmentDone, 3)
+	ot.listFulfillmentsOK(t, order.FulfillmentFailed, 0)
+
+	// Users can inspect the history of their orders.
+	ords := ot.listOrdersOK(t, 5)
+	ot.showOrderOK(t, ords[0].ID)
+	ot.listOrdersUnauth(t)
+
+	// Admins can filter all the orders.
+	ot.listAllOrdersOK(t, "", 5)
+	ot.listAllOrdersOK(t, "status=refunded", 2)
+	ot.listAllOrdersOK(t, "courseId="+c5.ID, 2)
+	ot.listAllOrdersOK(t, "status=success&courseId="+c6.ID, 1)
+	ot.listAllOrdersOK(t, "from="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), 0)
+
+	// Courses can only be bought in the currencies they are priced in.
+	c7 = ct.updateCourseOK(t, c7)
+	rt.createItemOK(t, c7.ID)
+	ot.checkoutInvalid(t, "fake", "currency=GBP", order.CheckoutNew{Country: "US"})
+
+	// The billing country is required to compute taxes.
+	ot.checkoutInvalid(t, "fake", "", order.CheckoutNew{})
+	ot.checkoutInvalid(t, "fake", "", order.CheckoutNew{Country: "XX"})
+
+	// Users pay in their preferred currency, taxes included.
+	ut.updatePrefsOK(t, "EUR")
+	ot.testFake(t, order.CheckoutNew{Country: "it"})
+	ords = ot.listOrdersOK(t, 6)
+	eur, err := c7.PriceIn("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ords[0].Currency != eur.Currency || ords[0].Total != eur.Amount {
+		t.Fatalf("expected order of %s, got %d %s", eur, ords[0].Total, ords[0].Currency)
+	}
+
+	vat := tax.Lookup("IT", "").Apply(eur.Amount)
+	if ords[0].Country != "IT" || !ords[0].TaxInclusive || ords[0].Tax != vat.Tax || ords[0].Items[0].TaxRate != 22000 {
+		t.Fatalf("expected italian VAT of %d, got %d in %q", vat.Tax, ords[0].Tax, ords[0].Country)
+	}
+
+	// Successful orders are invoiced.
+	ot.showInvoiceOK(t, ords[0].ID)
+	ot.showInvoiceNotFound(t, ords[2].ID) // expired
+	ot.invoiceUnauth(t, ords[0].ID, "void")
+
+	// Voided invoices are replaced by new ones, with a new number.
+	old := ot.invoiceOK(t, ords[0].ID, "void")
+	ot.showInvoiceNotFound(t, ords[0].ID)
+	inv := ot.invoiceOK(t, ords[0].ID, "regenerate")
+	if inv.Year != old.Year || inv.Seq != old.Seq+1 {
+		t.Fatalf("expected invoice number after %s, got %s", old.Number, inv.Number)
+	}
+	ot.showInvoiceOK(t, ords[0].ID)
+
+	// Paypal notifies captures through webhooks, even if the client never asks for them.
+	ut.updatePrefsOK(t, "USD")
+	rt.createItemOK(t, c8.ID)
+	rt.createItemOK(t, c9.ID)
+	rt.createItemOK(t, c10.ID)
+	ot.Paypal.expectedCart = []course.Course{c8, c9, c10}
+	ppID = ot.paypalCheckout(t)
+
+	ot.paypalWebhookInvalid(t, "PAYMENT.CAPTURE.COMPLETED", paypalCaptureEvent(ppID))
+	ot.statusOK(t, ppID, order.Pending)
+
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.COMPLETED", paypalCaptureEvent(ppID))
+	ot.statusOK(t, ppID, order.Success)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, c8, c9, c10})
+
+	// Late captures and notifications are ignored.
+	ot.paypalCapture(t, ppID)
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.COMPLETED", paypalCaptureEvent(ppID))
+	ot.statusOK(t, ppID, order.Success)
+
+	// Refunds requested by admins are notified back, but recorded only once.
+	ot.Paypal.expectedRefund = c8.Price
+	ot.refundOK(t, ppID, []string{c8.ID}, order.PartiallyRefunded)
+	refs := ot.refundsOK(t, ppID, 1)
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.REFUNDED", paypalRefundEvent(ppID, refs[0].ProviderRefundID, c8.Price))
+	ot.refundsOK(t, ppID, 1)
+
+	// Refunds issued on paypal revoke the items they cover, in order.
+	first, second := c9, c10
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.REFUNDED", paypalRefundEvent(ppID, "refund-external", 1))
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.REFUNDED", paypalRefundEvent(ppID, "refund-external", 1))
+	ot.refundsOK(t, ppID, 2)
+	ot.statusOK(t, ppID, order.PartiallyRefunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, second})
+
+	// Reversed payments revoke all the remaining items.
+	ot.paypalWebhookOK(t, "PAYMENT.CAPTURE.REVERSED", paypalRefundEvent(ppID, "reversal-external", second.Price))
+	ot.refundsOK(t, ppID, 3)
+	ot.statusOK(t, ppID, order.Refunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7})
+
+	// Delayed payments keep the order pending, without expiring, until they succeed.
+	rt.createItemOK(t, c11.ID)
+	rt.createItemOK(t, c12.ID)
+	ot.Stripe.expectedCart = []course.Course{c11, c12}
+	strpID = ot.stripeCheckout(t)
+	ot.stripeEventOK(t, "checkout.session.completed", "evt_async_1", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusUnpaid))
+	if err := order.ExpirePending(context.Background(), ot.DB, ot.Payments, 0); err != nil {
+		t.Fatal(err)
+	}
+	ot.statusOK(t, strpID, order.Pending)
+	ot.stripeEventOK(t, "checkout.session.async_payment_succeeded", "evt_async_2", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusPaid))
+	ot.statusOK(t, strpID, order.Success)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, c11, c12})
+
+	// Refunds issued on stripe revoke the items they cover, once.
+	first, second = c11, c12
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+	ot.stripeEventOK(t, "charge.refunded", "evt_refund_1", stripeCharge(strpID, 1))
+	ot.stripeEventOK(t, "charge.refunded", "evt_refund_1", stripeCharge(strpID, 1))
+	ot.stripeEventOK(t, "charge.refunded", "evt_refund_2", stripeCharge(strpID, 1))
+	ot.refundsOK(t, strpID, 1)
+	ot.statusOK(t, strpID, order.PartiallyRefunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, second})
+
+	// Disputes suspend the access to courses until they are won.
+	ot.stripeEventOK(t, "charge.dispute.created", "evt_dispute_1", stripeDispute(strpID, "dp_1", stripe.DisputeStatusNeedsResponse))
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7})
+	ot.stripeEventOK(t, "charge.dispute.closed", "evt_dispute_2", stripeDispute(strpID, "dp_1", stripe.DisputeStatusWon))
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, second})
+
+	// Events already processed are ignored.
+	ot.stripeEventOK(t, "charge.dispute.created", "evt_dispute_1", stripeDispute(strpID, "dp_2", stripe.DisputeStatusNeedsResponse))
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7, second})
+
+	// Lost disputes refund all the remaining items.
+	ot.stripeEventOK(t, "charge.dispute.created", "evt_dispute_3", stripeDispute(strpID, "dp_3", stripe.DisputeStatusNeedsResponse))
+	ot.stripeEventOK(t, "charge.dispute.closed", "evt_dispute_4", stripeDispute(strpID, "dp_3", stripe.DisputeStatusLost))
+	ot.refundsOK(t, strpID, 2)
+	ot.statusOK(t, strpID, order.Refunded)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7})
+
+	// Failed delayed payments fail their order.
+	ot.Stripe.expectedCart = []course.Course{c11, c12}
+	rt.createItemOK(t, c11.ID)
+	rt.createItemOK(t, c12.ID)
+	strpID = ot.stripeCheckout(t)
+	ot.stripeEventOK(t, "checkout.session.completed", "evt_async_3", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusUnpaid))
+	ot.stripeEventOK(t, "checkout.session.async_payment_failed", "evt_async_4", stripeSession(strpID, stripe.CheckoutSessionPaymentStatusUnpaid))
+	ot.statusOK(t, strpID, order.Failed)
+	ct.listCoursesOwnedOK(t, []course.Course{c6, c7})
+
+	// Stripe tax rates are created once, and the ones created before restarts are reused.
+	ot.Stripe.addTaxRate(map[string]any{"govod_rate": "US-CT-Sales tax-6350"})
+	ot.Stripe.expectedCart = []course.Course{c11}
+	for _, region := range []string{"NY", "NY", "CT"} {
+		rt.createItemOK(t, c11.ID)
+		ot.stripeCheckoutIn(t, order.CheckoutNew{Country: "US", Region: region})
+	}
+	if n := ot.Stripe.countTaxRates(); n != 2 {
+		t.Fatalf("expected 2 stripe tax rates, got %d", n)
+	}
 }
 
-func (ot *orderTest) testPaypal(t *testing.T) {
+func (ot *orderTest) testPaypal(t *testing.T) string {
+	id := ot.paypalCheckout(t)
+
+	// Capture the paypal order.
+	// We are using a mocked paypal server that returns OK on captures
+	// to simulate the user payment happy path.
+	ot.paypalCapture(t, id)
+
+	return id
+}
+
+// paypalCheckout starts a paypal checkout and returns the
+// id of the created paypal order.
+func (ot *orderTest) paypalCheckout(t *testing.T) string {
 	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
 		t.Fatal(err)
 	}
 	defer Logout(ot.Server)
 
 	// Checkout the order via paypal.
-	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/paypal", nil)
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/paypal", billing(t, order.CheckoutNew{Country: "US"}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,15 +340,21 @@ func (ot *orderTest) testPaypal(t *testing.T) {
 		t.Fatalf("cannot unmarshal paypal order: %v", err)
 	}
 
-	// Capture the paypal order.
-	// We are using a mocked paypal server that returns OK on captures
-	// to simulate the user payment happy path.
-	r, err = http.NewRequest(http.MethodPost, ot.URL+"/orders/paypal/"+ord.ID+"/capture", nil)
+	return ord.ID
+}
+
+func (ot *orderTest) paypalCapture(t *testing.T, id string) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/paypal/"+id+"/capture", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	w, err = ot.Client().Do(r)
+	w, err := ot.Client().Do(r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,14 +365,226 @@ func (ot *orderTest) testPaypal(t *testing.T) {
 	}
 }
 
-func (ot *orderTest) testStripe(t *testing.T) {
+// paypalCaptureEvent generates the resource of the paypal events
+// notifying the completion of the capture bound to the passed paypal order.
+func paypalCaptureEvent(id string) map[string]any {
+	return map[string]any{
+		"id":     "capture-" + id,
+		"status": "COMPLETED",
+		"supplementary_data": map[string]any{
+			"related_ids": map[string]any{"order_id": id},
+		},
+	}
+}
+
+// paypalRefundEvent generates the resource of the paypal events
+// notifying a refund of the capture bound to the passed paypal order.
+func paypalRefundEvent(id string, refundID string, amount int64) map[string]any {
+	m := money.New(amount, money.Default)
+	return map[string]any{
+		"id":     refundID,
+		"status": "COMPLETED",
+		"amount": paypal.Money{Currency: m.Currency, Value: m.Decimal()},
+		"links": []paypal.Link{
+			{Rel: "up", Href: "https://api.paypal.com/v2/payments/captures/capture-" + id},
+		},
+	}
+}
+
+// paypalWebhook notifies a paypal event of the passed type
+// signed with the passed signature.
+func (ot *orderTest) paypalWebhook(t *testing.T, typ string, resource map[string]any, sig string) *http.Response {
+	evt := map[string]any{
+		"id":            "WH-" + validate.GenerateID(),
+		"event_type":    typ,
+		"resource_type": "capture",
+		"resource":      resource,
+	}
+
+	b, err := json.Marshal(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/paypal/webhook", bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("PAYPAL-AUTH-ALGO", "SHA256withRSA")
+	r.Header.Set("PAYPAL-TRANSMISSION-ID", validate.GenerateID())
+	r.Header.Set("PAYPAL-TRANSMISSION-TIME", time.Now().UTC().Format(time.RFC3339))
+	r.Header.Set("PAYPAL-TRANSMISSION-SIG", sig)
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (ot *orderTest) paypalWebhookOK(t *testing.T, typ string, resource map[string]any) {
+	w := ot.paypalWebhook(t, typ, resource, paypalSignature)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't notify paypal event %s: status code %s", typ, w.Status)
+	}
+}
+
+func (ot *orderTest) paypalWebhookInvalid(t *testing.T, typ string, resource map[string]any) {
+	w := ot.paypalWebhook(t, typ, resource, "forged-signature")
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusBadRequest {
+		t.Fatalf("forged paypal events should be rejected: status code %s", w.Status)
+	}
+}
+
+// refundsOK checks the number of refunds recorded for the order
+// bound to the passed provider id.
+func (ot *orderTest) refundsOK(t *testing.T, providerID string, exp int) []order.Refund {
+	ord, err := order.FetchByProviderID(context.Background(), ot.DB, providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := order.FetchRefunds(context.Background(), ot.DB, ord.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(refs) != exp {
+		t.Fatalf("expected %d refunds, got %d", exp, len(refs))
+	}
+
+	return refs
+}
+
+func (ot *orderTest) testFake(t *testing.T, bill order.CheckoutNew) string {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/fake", billing(t, bill))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't create fake order: status code %s", w.Status)
+	}
+
+	var chk order.FakeCheckout
+	if err := json.NewDecoder(w.Body).Decode(&chk); err != nil {
+		t.Fatalf("cannot unmarshal fake checkout: %v", err)
+	}
+
+	// Fake payments are always captured.
+	r, err = http.NewRequest(http.MethodPost, ot.URL+"/orders/fake/"+chk.ID+"/capture", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't capture fake order: status code %s", w.Status)
+	}
+
+	return chk.ID
+}
+
+func (ot *orderTest) checkoutInvalid(t *testing.T, provider string, query string, bill order.CheckoutNew) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/"+provider+"?"+query, billing(t, bill))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("checkout should be invalid: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) checkoutNotFound(t *testing.T, provider string) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/"+provider, billing(t, order.CheckoutNew{Country: "US"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNotFound {
+		t.Fatalf("provider %s should not exist: status code %s", provider, w.Status)
+	}
+}
+
+// billing encodes the billing information sent on checkouts.
+func billing(t *testing.T, bill order.CheckoutNew) io.Reader {
+	b, err := json.Marshal(bill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewBuffer(b)
+}
+
+func (ot *orderTest) testStripe(t *testing.T) string {
+	id := ot.stripeCheckout(t)
+
+	// Now simulate the payment by triggering a stripe webhook.
+	ot.stripeWebhook(t, "checkout.session.completed", id)
+
+	return id
+}
+
+// stripeCheckout starts a stripe checkout and returns the
+// id of the created stripe session.
+func (ot *orderTest) stripeCheckout(t *testing.T) string {
+	return ot.stripeCheckoutIn(t, order.CheckoutNew{Country: "US"})
+}
+
+// stripeCheckoutIn starts a stripe checkout billed to the passed
+// address and returns the id of the created stripe session.
+func (ot *orderTest) stripeCheckoutIn(t *testing.T, bill order.CheckoutNew) string {
 	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
 		t.Fatal(err)
 	}
 	defer Logout(ot.Server)
 
 	// Checkout the order via stripe.
-	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/stripe", nil)
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/stripe", billing(t, bill))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -131,8 +599,6 @@ func (ot *orderTest) testStripe(t *testing.T) {
 		t.Fatalf("can't create stripe order: status code %s", w.Status)
 	}
 
-	// Now simulate the payment by triggering a stripe webhook.
-	//
 	// Extract the checkout session id from the location returned by the mock.
 	urlBytes, err := io.ReadAll(w.Body)
 	if err != nil {
@@ -144,15 +610,57 @@ func (ot *orderTest) testStripe(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Generate the webhook payload.
-	//
+	// Mocked stripe returns the id in the URL.
+	return path.Base(url)
+}
+
+// stripeWebhook triggers a stripe webhook of the passed type
+// for the passed checkout session.
+func (ot *orderTest) stripeWebhook(t *testing.T, typ string, id string) {
 	// Set the same checkout id previously obtained.
 	obj := map[string]any{
-		// Mocked stripe returns the id in the URL.
-		"id":   path.Base(url),
-		"mode": stripe.CheckoutSessionModePayment,
+		"id":             id,
+		"mode":           stripe.CheckoutSessionModePayment,
+		"payment_intent": "pi_" + id,
+	}
+
+	ot.stripeEventOK(t, typ, "", obj)
+}
+
+// stripeEvent triggers a stripe webhook of the passed type, with
+// the passed event id, about the passed stripe object.
+func (ot *orderTest) stripeEvent(t *testing.T, typ string, id string, obj map[string]any) *http.Response {
+	b, sig := signStripeEvent(t, ot.WebhookSecret, typ, id, obj)
+
+	// Finally trigger the webhook.
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/stripe/webhook", bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Stripe-Signature", sig)
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (ot *orderTest) stripeEventOK(t *testing.T, typ string, id string, obj map[string]any) {
+	w := ot.stripeEvent(t, typ, id, obj)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't trigger stripe webhook: status code %s", w.Status)
 	}
+}
 
+// signStripeEvent generates the payload of a stripe event of the passed type,
+// with the passed event id, about the passed stripe object. It returns the
+// payload together with its signature made with the passed secret.
+func signStripeEvent(t *testing.T, secret string, typ string, id string, obj map[string]any) ([]byte, string) {
+	// Generate the webhook payload.
 	raw, err := json.Marshal(obj)
 	if err != nil {
 		t.Fatal(err)
@@ -160,9 +668,11 @@ func (ot *orderTest) testStripe(t *testing.T) {
 
 	// evt is the complete payload for the webhook.
 	evt := stripe.Event{
+		ID: id,
 		// Required by stripe-go 74.2.0 .
 		APIVersion: "2022-11-15",
-		Type:       "checkout.session.completed",
+		Type:       typ,
+		Created:    time.Now().Unix(),
 		Data: &stripe.EventData{
 			Raw: json.RawMessage(raw),
 		},
@@ -176,24 +686,425 @@ func (ot *orderTest) testStripe(t *testing.T) {
 	// Sign the payload with the appropriate secret.
 	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
 		Payload:   b,
-		Secret:    ot.WebhookSecret,
+		Secret:    secret,
 		Timestamp: time.Now(),
 	})
 
-	// Finally trigger the webhook.
-	r, err = http.NewRequest(http.MethodPost, ot.URL+"/orders/stripe/capture", bytes.NewBuffer(b))
+	return b, signed.Header
+}
+
+// stripeSession generates a stripe checkout session bound to the passed id.
+func stripeSession(id string, status stripe.CheckoutSessionPaymentStatus) map[string]any {
+	return map[string]any{
+		"id":             id,
+		"mode":           stripe.CheckoutSessionModePayment,
+		"payment_intent": "pi_" + id,
+		"payment_status": status,
+	}
+}
+
+// stripeCharge generates the stripe charge of the session bound to the passed id.
+func stripeCharge(id string, refunded int64) map[string]any {
+	return map[string]any{
+		"id":              "ch_" + id,
+		"payment_intent":  "pi_" + id,
+		"currency":        "usd",
+		"amount_refunded": refunded,
+		"refunded":        true,
+	}
+}
+
+// stripeDispute generates a dispute of the charge of the session bound to the passed id.
+func stripeDispute(id string, disputeID string, status stripe.DisputeStatus) map[string]any {
+	return map[string]any{
+		"id":             disputeID,
+		"charge":         "ch_" + id,
+		"payment_intent": "pi_" + id,
+		"currency":       "usd",
+		"amount":         100,
+		"reason":         stripe.DisputeReasonFraudulent,
+		"status":         status,
+	}
+}
+
+// expireOK expires all pending orders and checks that the order
+// bound to the passed provider id is expired.
+func (ot *orderTest) expireOK(t *testing.T, providerID string) {
+	if err := order.ExpirePending(context.Background(), ot.DB, ot.Payments, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ot.statusOK(t, providerID, order.Expired)
+}
+
+// statusOK checks the status of the order bound to the passed provider id.
+func (ot *orderTest) statusOK(t *testing.T, providerID string, exp order.Status) {
+	ord, err := order.FetchByProviderID(context.Background(), ot.DB, providerID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	r.Header.Set("Stripe-Signature", signed.Header)
 
-	w, err = ot.Client().Do(r)
+	if ord.Status != exp {
+		t.Fatalf("expected order status %s, got %s", exp, ord.Status)
+	}
+}
+
+func (ot *orderTest) refund(t *testing.T, providerID string, courseIDs []string) *http.Response {
+	ord, err := order.FetchByProviderID(context.Background(), ot.DB, providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(order.RefundNew{CourseIDs: courseIDs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/"+ord.ID+"/refund", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	return w
+}
+
+func (ot *orderTest) refundOK(t *testing.T, providerID string, courseIDs []string, exp order.Status) {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	w := ot.refund(t, providerID, courseIDs)
 	defer w.Body.Close()
 
-	if w.StatusCode != http.StatusNoContent {
-		t.Fatalf("can't trigger stripe webhook: status code %s", w.Status)
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't refund order: status code %s", w.Status)
+	}
+
+	ot.statusOK(t, providerID, exp)
+}
+
+func (ot *orderTest) refundFailed(t *testing.T, providerID string, courseIDs []string) {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	w := ot.refund(t, providerID, courseIDs)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("refund should fail: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) refundInvalid(t *testing.T, providerID string, courseIDs []string) {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	w := ot.refund(t, providerID, courseIDs)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("refund should not be allowed: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) refundUnauth(t *testing.T, providerID string) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	w := ot.refund(t, providerID, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to refund orders: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) listFulfillmentsOK(t *testing.T, status order.FulfillmentStatus, exp int) {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/fulfillments?status="+string(status), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list fulfillments: status code %s", w.Status)
+	}
+
+	var fs []order.Fulfillment
+	if err := json.NewDecoder(w.Body).Decode(&fs); err != nil {
+		t.Fatalf("cannot unmarshal fulfillments: %v", err)
+	}
+
+	if len(fs) != exp {
+		t.Fatalf("expected %d fulfillments with status %s, got %d", exp, status, len(fs))
+	}
+
+	for _, f := range fs {
+		if f.Status != status {
+			t.Fatalf("expected fulfillment status %s, got %s", status, f.Status)
+		}
+	}
+}
+
+func (ot *orderTest) listFulfillmentsUnauth(t *testing.T) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/fulfillments", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to list fulfillments: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) listOrdersOK(t *testing.T, exp int) []order.Receipt {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list orders: status code %s", w.Status)
+	}
+
+	var rcs []order.Receipt
+	if err := json.NewDecoder(w.Body).Decode(&rcs); err != nil {
+		t.Fatalf("cannot unmarshal orders: %v", err)
+	}
+
+	if len(rcs) != exp {
+		t.Fatalf("expected %d orders, got %d", exp, len(rcs))
+	}
+
+	for _, rc := range rcs {
+		if len(rc.Items) == 0 {
+			t.Fatalf("order %s has no items", rc.ID)
+		}
+	}
+
+	return rcs
+}
+
+func (ot *orderTest) showOrderOK(t *testing.T, id string) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/orders/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show order: status code %s", w.Status)
+	}
+
+	var rc order.Receipt
+	if err := json.NewDecoder(w.Body).Decode(&rc); err != nil {
+		t.Fatalf("cannot unmarshal order: %v", err)
+	}
+
+	if rc.ID != id {
+		t.Fatalf("expected order %s, got %s", id, rc.ID)
+	}
+}
+
+func (ot *orderTest) listOrdersUnauth(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("anonymous users should not list orders: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) listAllOrdersOK(t *testing.T, query string, exp int) {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/admin/orders?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list all orders: status code %s", w.Status)
+	}
+
+	var rcs []order.Receipt
+	if err := json.NewDecoder(w.Body).Decode(&rcs); err != nil {
+		t.Fatalf("cannot unmarshal orders: %v", err)
+	}
+
+	if len(rcs) != exp {
+		t.Fatalf("expected %d orders filtered by [%s], got %d", exp, query, len(rcs))
+	}
+}
+
+func (ot *orderTest) showInvoice(t *testing.T, orderID string) *http.Response {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodGet, ot.URL+"/orders/"+orderID+"/invoice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (ot *orderTest) showInvoiceOK(t *testing.T, orderID string) {
+	w := ot.showInvoice(t, orderID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show invoice: status code %s", w.Status)
+	}
+
+	if ct := w.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("expected a pdf invoice, got %s", ct)
+	}
+
+	b, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("%PDF-")) {
+		t.Fatal("invoice is not a valid pdf")
+	}
+}
+
+func (ot *orderTest) showInvoiceNotFound(t *testing.T, orderID string) {
+	w := ot.showInvoice(t, orderID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNotFound {
+		t.Fatalf("invoice should not be found: status code %s", w.Status)
+	}
+}
+
+func (ot *orderTest) invoiceOK(t *testing.T, orderID string, action string) order.Invoice {
+	if err := Login(ot.Server, ot.AdminEmail, ot.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/"+orderID+"/invoice/"+action, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't %s invoice: status code %s", action, w.Status)
+	}
+
+	var inv order.Invoice
+	if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+		t.Fatalf("cannot unmarshal invoice: %v", err)
+	}
+
+	return inv
+}
+
+func (ot *orderTest) invoiceUnauth(t *testing.T, orderID string, action string) {
+	if err := Login(ot.Server, ot.UserEmail, ot.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ot.Server)
+
+	r, err := http.NewRequest(http.MethodPost, ot.URL+"/orders/"+orderID+"/invoice/"+action, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ot.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to %s invoices: status code %s", action, w.Status)
 	}
 }
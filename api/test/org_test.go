@@ -0,0 +1,263 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/core/org"
+	"github.com/polldo/govod/core/video"
+)
+
+const (
+	adminID = "ae127240-ce13-4789-aafd-d2f31e7ee487"
+	userID  = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+)
+
+type orgTest struct {
+	*TestEnv
+}
+
+func TestOrg(t *testing.T) {
+	env, err := NewTestEnv(t, "org_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	og := &orgTest{env}
+	ct := &courseTest{env}
+	vt := &videoTest{env}
+	ot := &orderTest{env}
+
+	c1 := ct.createCourseOK(t)
+	v1 := vt.createVideoOK(t, c1.ID, 0)
+	og.lockVideoOK(t, v1.ID)
+
+	o := og.createOrgOK(t)
+
+	// Only owners and managers can buy seats.
+	seats := order.SeatsCheckoutNew{Country: "US", Seats: []order.SeatsNew{{CourseID: c1.ID, Seats: 2}}}
+	og.checkoutSeatsStatus(t, og.AdminEmail, og.AdminPass, o.ID, seats, http.StatusForbidden)
+
+	// Courses are listed once, before any checkout is started.
+	dup := order.SeatsCheckoutNew{Country: "US", Seats: []order.SeatsNew{{CourseID: c1.ID, Seats: 2}, {CourseID: c1.ID, Seats: 1}}}
+	og.checkoutSeatsStatus(t, og.UserEmail, og.UserPass, o.ID, dup, http.StatusUnprocessableEntity)
+
+	ot.Stripe.expectedCart = []course.Course{{Price: c1.Price * 2}}
+	id := og.checkoutSeatsOK(t, o.ID, seats)
+	ot.stripeWebhook(t, "checkout.session.completed", id)
+	ot.statusOK(t, id, order.Success)
+
+	// Seats are not owned by the buyer.
+	ct.listCoursesOwnedOK(t, []course.Course{})
+	og.showVideoStatus(t, og.AdminEmail, og.AdminPass, v1.ID, http.StatusForbidden)
+
+	// Invited users take a seat once they accept the invitation.
+	tok := og.inviteOK(t, o.ID, c1.ID, og.AdminEmail)
+	og.acceptStatus(t, "invalid-token", http.StatusBadRequest)
+	og.acceptStatus(t, tok, http.StatusOK)
+	og.acceptStatus(t, tok, http.StatusBadRequest)
+	og.showVideoStatus(t, og.AdminEmail, og.AdminPass, v1.ID, http.StatusOK)
+
+	// Members cannot manage seats nor see the progress of others.
+	og.progressStatus(t, og.AdminEmail, og.AdminPass, o.ID, http.StatusForbidden)
+	og.assignStatus(t, og.AdminEmail, og.AdminPass, o.ID, org.SeatNew{CourseID: c1.ID, UserID: adminID}, http.StatusForbidden)
+	og.progressOK(t, o.ID, []string{adminID})
+
+	// Seats cannot exceed the purchased ones.
+	og.assignStatus(t, og.UserEmail, og.UserPass, o.ID, org.SeatNew{CourseID: c1.ID, UserID: userID}, http.StatusCreated)
+	og.inviteStatus(t, o.ID, c1.ID, "someone@example.com", http.StatusUnprocessableEntity)
+
+	// Reclaimed seats don't grant access anymore.
+	og.reclaimOK(t, o.ID, c1.ID, adminID)
+	og.showVideoStatus(t, og.AdminEmail, og.AdminPass, v1.ID, http.StatusForbidden)
+	og.inviteOK(t, o.ID, c1.ID, "someone@example.com")
+}
+
+// do performs the passed request logged in as the passed user.
+func (og *orgTest) do(t *testing.T, email string, pass string, method string, url string, payload any) *http.Response {
+	if err := Login(og.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(og.Server)
+
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = bytes.NewBuffer(b)
+	}
+
+	r, err := http.NewRequest(method, og.URL+url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := og.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// lockVideoOK makes the passed video visible only to owners.
+func (og *orgTest) lockVideoOK(t *testing.T, videoID string) {
+	w := og.do(t, og.AdminEmail, og.AdminPass, http.MethodPut, "/videos/"+videoID, video.VideoUp{Free: ptr(false)})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't update video: status code %s", w.Status)
+	}
+}
+
+func (og *orgTest) createOrgOK(t *testing.T) org.Org {
+	w := og.do(t, og.UserEmail, og.UserPass, http.MethodPost, "/orgs", org.OrgNew{Name: "Test Org"})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create organization: status code %s", w.Status)
+	}
+
+	var got org.Org
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal created organization: %v", err)
+	}
+
+	return got
+}
+
+// checkoutSeatsOK starts a stripe checkout of seats for the organization
+// and returns the id of the created stripe session.
+func (og *orgTest) checkoutSeatsOK(t *testing.T, orgID string, seats order.SeatsCheckoutNew) string {
+	w := og.do(t, og.UserEmail, og.UserPass, http.MethodPost, "/orgs/"+orgID+"/orders/stripe", seats)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't create stripe order of seats: status code %s", w.Status)
+	}
+
+	var url string
+	if err := json.NewDecoder(w.Body).Decode(&url); err != nil {
+		t.Fatal(err)
+	}
+
+	return path.Base(url)
+}
+
+func (og *orgTest) checkoutSeatsStatus(t *testing.T, email string, pass string, orgID string, seats order.SeatsCheckoutNew, status int) {
+	w := og.do(t, email, pass, http.MethodPost, "/orgs/"+orgID+"/orders/stripe", seats)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d buying seats, got %s", status, w.Status)
+	}
+}
+
+func (og *orgTest) showVideoStatus(t *testing.T, email string, pass string, videoID string, status int) {
+	w := og.do(t, email, pass, http.MethodGet, "/videos/"+videoID+"/full", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d showing full video, got %s", status, w.Status)
+	}
+}
+
+func (og *orgTest) inviteStatus(t *testing.T, orgID string, courseID string, email string, status int) {
+	in := org.InvitationNew{CourseID: courseID, Email: email}
+	w := og.do(t, og.UserEmail, og.UserPass, http.MethodPost, "/orgs/"+orgID+"/invitations", in)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d inviting %s, got %s", status, email, w.Status)
+	}
+}
+
+// inviteOK invites the user to take a seat of the course and returns
+// the token sent to them.
+func (og *orgTest) inviteOK(t *testing.T, orgID string, courseID string, email string) string {
+	og.Mailer.token = ""
+	og.inviteStatus(t, orgID, courseID, email, http.StatusCreated)
+
+	// Invitations are sent in background.
+	for i := 0; i < 50 && og.Mailer.token == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if og.Mailer.token == "" {
+		t.Fatalf("invitation should be sent to %s", email)
+	}
+
+	return og.Mailer.token
+}
+
+func (og *orgTest) acceptStatus(t *testing.T, token string, status int) {
+	w := og.do(t, og.AdminEmail, og.AdminPass, http.MethodPost, "/orgs/invitations/accept", org.Token{Token: token})
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d accepting invitation, got %s", status, w.Status)
+	}
+}
+
+func (og *orgTest) assignStatus(t *testing.T, email string, pass string, orgID string, seat org.SeatNew, status int) {
+	w := og.do(t, email, pass, http.MethodPut, "/orgs/"+orgID+"/seats", seat)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d assigning seat, got %s", status, w.Status)
+	}
+}
+
+func (og *orgTest) reclaimOK(t *testing.T, orgID string, courseID string, userID string) {
+	url := fmt.Sprintf("/orgs/%s/seats/%s/%s", orgID, courseID, userID)
+	w := og.do(t, og.UserEmail, og.UserPass, http.MethodDelete, url, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't reclaim seat: status code %s", w.Status)
+	}
+}
+
+func (og *orgTest) progressStatus(t *testing.T, email string, pass string, orgID string, status int) {
+	w := og.do(t, email, pass, http.MethodGet, "/orgs/"+orgID+"/progress", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d listing progress, got %s", status, w.Status)
+	}
+}
+
+// progressOK checks that the progress is listed for the passed members.
+func (og *orgTest) progressOK(t *testing.T, orgID string, userIDs []string) {
+	w := og.do(t, og.UserEmail, og.UserPass, http.MethodGet, "/orgs/"+orgID+"/progress", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list progress: status code %s", w.Status)
+	}
+
+	var got []org.Progress
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal progress: %v", err)
+	}
+
+	if len(got) != len(userIDs) {
+		t.Fatalf("expected progress of %d members, got %d", len(userIDs), len(got))
+	}
+
+	for i, p := range got {
+		if p.UserID != userIDs[i] || p.Videos != 1 {
+			t.Fatalf("wrong progress payload: %+v", p)
+		}
+	}
+}
@@ -7,16 +7,67 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/plutov/paypal/v4"
 	"github.com/polldo/govod/api/web"
 	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/money"
 	mock "github.com/stripe/stripe-mock/param"
 )
 
+// Paypal events are verified by the mock only if they are
+// notified to this webhook with this signature.
+const (
+	paypalWebhookID = "paypal-test-webhook"
+	paypalSignature = "paypal-test-signature"
+)
+
+// payments records the amounts charged by mocked providers for each
+// checkout, so that they can be reconciled with orders. Tests can alter
+// the amounts charged or mark checkouts as not paid.
+type payments struct {
+	mu      sync.Mutex
+	charged map[string]int64
+	unpaid  map[string]bool
+}
+
+// charge sets the amount charged for the checkout bound to the passed id.
+func (ps *payments) charge(id string, amount int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.charged == nil {
+		ps.charged = make(map[string]int64)
+	}
+	ps.charged[id] = amount
+}
+
+// unpay marks the checkout bound to the passed id as not paid.
+func (ps *payments) unpay(id string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.unpaid == nil {
+		ps.unpaid = make(map[string]bool)
+	}
+	ps.unpaid[id] = true
+}
+
+// payment returns the amount charged for the checkout bound to the
+// passed id and whether it has been paid.
+func (ps *payments) payment(id string) (int64, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.charged[id], !ps.unpaid[id]
+}
+
 type mockPaypal struct {
-	expectedCart []course.Course
+	payments
+	expectedCart   []course.Course
+	expectedRefund int64
 }
 
 func (m *mockPaypal) handle() http.Handler {
@@ -42,37 +93,168 @@ func (m *mockPaypal) handle() http.Handler {
 			return
 		}
 
-		var tot int
+		tot := money.New(0, money.Default)
 		for _, c := range m.expectedCart {
-			tot += c.Price
+			tot.Amount += c.Price
 		}
 
 		// Check the paypal amount against the total of the cart.
-		if pu.Units[0].Amount.Value != strconv.Itoa(tot) {
+		// Paypal amounts are expressed as decimals.
+		if pu.Units[0].Amount.Currency != tot.Currency || pu.Units[0].Amount.Value != tot.Decimal() {
 			web.Respond(context.Background(), w, nil, 400)
 			return
 		}
 
 		// Generate a random provider-id that will be used to capture this order.
 		randID := fmt.Sprintf("paypal-%d", rand.Intn(300))
+		m.charge(randID, tot.Amount)
 		ord := paypal.Order{ID: randID}
 		web.Respond(context.Background(), w, ord, 200)
 	})
 
 	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Happy path: the paypal payment was completed.
-		ord := paypal.Order{Status: "COMPLETED"}
+		id := mux.Vars(r)["id"]
+		ord := paypal.CaptureOrderResponse{
+			ID:     id,
+			Status: "COMPLETED",
+			PurchaseUnits: []paypal.CapturedPurchaseUnit{{
+				Payments: &paypal.CapturedPayments{
+					Captures: []paypal.CaptureAmount{{ID: "capture-" + id, Status: "COMPLETED"}},
+				},
+			}},
+		}
 		web.Respond(context.Background(), w, ord, 200)
 	})
 
+	show := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		amount, paid := m.payment(id)
+
+		// Orders not paid are only approved by the user.
+		if !paid {
+			web.Respond(context.Background(), w, paypal.Order{ID: id, Status: "APPROVED"}, 200)
+			return
+		}
+
+		// Return a completed order with a single capture bound to it.
+		charged := money.New(amount, money.Default)
+		ord := paypal.Order{
+			ID:     id,
+			Status: "COMPLETED",
+			PurchaseUnits: []paypal.PurchaseUnit{{
+				Payments: &paypal.CapturedPayments{
+					Captures: []paypal.CaptureAmount{{
+						ID:     "capture-" + id,
+						Status: "COMPLETED",
+						Amount: &paypal.PurchaseUnitAmount{Currency: charged.Currency, Value: charged.Decimal()},
+					}},
+				},
+			}},
+		}
+		web.Respond(context.Background(), w, ord, 200)
+	})
+
+	refund := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req paypal.RefundCaptureRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		// Check the refunded amount against the expected one.
+		exp := money.New(m.expectedRefund, money.Default)
+		if req.Amount == nil || req.Amount.Value != exp.Decimal() {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		ref := paypal.RefundResponse{ID: fmt.Sprintf("refund-%d", rand.Intn(300)), Status: "COMPLETED"}
+		web.Respond(context.Background(), w, ref, 201)
+	})
+
+	verify := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			TransmissionSig string          `json:"transmission_sig"`
+			WebhookID       string          `json:"webhook_id"`
+			Event           json.RawMessage `json:"webhook_event"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Event) == 0 {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		res := paypal.VerifyWebhookResponse{VerificationStatus: "FAILURE"}
+		if req.WebhookID == paypalWebhookID && req.TransmissionSig == paypalSignature {
+			res.VerificationStatus = "SUCCESS"
+		}
+		web.Respond(context.Background(), w, res, 200)
+	})
+
 	r := mux.NewRouter()
+	r.Handle("/v1/notifications/verify-webhook-signature", verify).Methods("POST")
 	r.Handle("/v2/checkout/orders", checkout).Methods("POST")
+	r.Handle("/v2/checkout/orders/{id}", show).Methods("GET")
 	r.Handle("/v2/checkout/orders/{id}/capture", capture).Methods("POST")
+	r.Handle("/v2/payments/captures/{id}/refund", refund).Methods("POST")
 	return r
 }
 
 type mockStripe struct {
-	expectedCart []course.Course
+	payments
+	expectedCart   []course.Course
+	expectedRefund int64
+
+	// taxRates are the tax rates created on stripe, by id.
+	ratesMu  sync.Mutex
+	taxRates map[string]map[string]any
+}
+
+// addTaxRate records a tax rate with the passed metadata and returns its id.
+func (m *mockStripe) addTaxRate(metadata map[string]any) string {
+	m.ratesMu.Lock()
+	defer m.ratesMu.Unlock()
+
+	if m.taxRates == nil {
+		m.taxRates = make(map[string]map[string]any)
+	}
+
+	id := fmt.Sprintf("txr_%d", len(m.taxRates)+1)
+	m.taxRates[id] = map[string]any{"id": id, "object": "tax_rate", "active": true, "inclusive": true, "metadata": metadata}
+	return id
+}
+
+// countTaxRates returns the number of tax rates created on stripe.
+func (m *mockStripe) countTaxRates() int {
+	m.ratesMu.Lock()
+	defer m.ratesMu.Unlock()
+	return len(m.taxRates)
+}
+
+// hasTaxRates reports whether all the tax rates of a line exist.
+func (m *mockStripe) hasTaxRates(rates any) bool {
+	m.ratesMu.Lock()
+	defer m.ratesMu.Unlock()
+
+	var ids []any
+	switch rs := rates.(type) {
+	case nil:
+	case []any:
+		ids = rs
+	case map[string]any:
+		for _, id := range rs {
+			ids = append(ids, id)
+		}
+	default:
+		return false
+	}
+
+	for _, id := range ids {
+		if s, ok := id.(string); !ok || m.taxRates[s] == nil {
+			return false
+		}
+	}
+	return true
 }
 
 func (m *mockStripe) handle() http.Handler {
@@ -83,7 +265,7 @@ func (m *mockStripe) handle() http.Handler {
 		lines := params["line_items"].(map[string]any)
 
 		n := 0
-		tot := 0
+		var tot int64
 		for _, li := range lines {
 			it := li.(map[string]any)
 
@@ -92,16 +274,26 @@ func (m *mockStripe) handle() http.Handler {
 				return
 			}
 
+			if !m.hasTaxRates(it["tax_rates"]) {
+				web.Respond(context.Background(), w, nil, 400)
+				return
+			}
+
 			pd := it["price_data"].(map[string]any)
+			if pd["currency"] != "usd" {
+				web.Respond(context.Background(), w, nil, 400)
+				return
+			}
+
 			s := pd["unit_amount"].(string)
-			amount, err := strconv.ParseInt(s, 10, 0)
+			amount, err := strconv.ParseInt(s, 10, 64)
 			if err != nil {
 				web.Respond(context.Background(), w, err, 400)
 				return
 			}
 
-			// Stripe prices are expressed in cents.
-			tot += int(amount / 100)
+			// Stripe prices are expressed in the minor unit of the currency.
+			tot += amount
 			n += 1
 		}
 
@@ -111,7 +303,7 @@ func (m *mockStripe) handle() http.Handler {
 			return
 		}
 
-		exp := 0
+		var exp int64
 		for _, c := range m.expectedCart {
 			exp += c.Price
 		}
@@ -124,11 +316,113 @@ func (m *mockStripe) handle() http.Handler {
 
 		// Generate a random provider-id that will be used to capture this order.
 		randID := fmt.Sprintf("stripe-%d", rand.Intn(300))
+		m.charge(randID, tot)
 		ord := map[string]any{"ID": randID, "URL": randID}
 		web.Respond(context.Background(), w, ord, 201)
 	})
 
+	show := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Return a completed session bound to a payment intent.
+		id := mux.Vars(r)["id"]
+		amount, paid := m.payment(id)
+		s := map[string]any{
+			"id":             id,
+			"payment_intent": "pi_" + id,
+			"status":         "complete",
+			"payment_status": "paid",
+			"amount_total":   amount,
+			"currency":       "usd",
+		}
+
+		if !paid {
+			s["status"] = "open"
+			s["payment_status"] = "unpaid"
+		}
+		web.Respond(context.Background(), w, s, 200)
+	})
+
+	refund := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, _ := mock.ParseParams(r)
+
+		if pi, ok := params["payment_intent"].(string); !ok || pi == "" {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		// Retries of refunds must be idempotent.
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		// Check the refunded amount against the expected one, if any.
+		// Stripe amounts are expressed in the minor unit of the currency.
+		if s, ok := params["amount"].(string); ok {
+			amount, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || amount != m.expectedRefund {
+				web.Respond(context.Background(), w, nil, 400)
+				return
+			}
+		}
+
+		ref := map[string]any{"id": "re_" + key, "status": "succeeded"}
+		web.Respond(context.Background(), w, ref, 200)
+	})
+
+	expire := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		s := map[string]any{"id": id, "status": "expired"}
+		web.Respond(context.Background(), w, s, 200)
+	})
+
+	cancel := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, _ := mock.ParseParams(r)
+
+		// Subscriptions are only canceled at the end of the period.
+		if params["cancel_at_period_end"] != "true" {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		s := map[string]any{"id": id, "status": "active", "cancel_at_period_end": true}
+		web.Respond(context.Background(), w, s, 200)
+	})
+
+	listRates := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.ratesMu.Lock()
+		data := make([]any, 0, len(m.taxRates))
+		for _, tr := range m.taxRates {
+			data = append(data, tr)
+		}
+		m.ratesMu.Unlock()
+
+		l := map[string]any{"object": "list", "url": "/v1/tax_rates", "has_more": false, "data": data}
+		web.Respond(context.Background(), w, l, 200)
+	})
+
+	createRate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, _ := mock.ParseParams(r)
+
+		// Rates must be identifiable to be reused.
+		md, ok := params["metadata"].(map[string]any)
+		if !ok || params["inclusive"] != "true" {
+			web.Respond(context.Background(), w, nil, 400)
+			return
+		}
+
+		id := m.addTaxRate(md)
+		web.Respond(context.Background(), w, map[string]any{"id": id, "object": "tax_rate", "metadata": md}, 200)
+	})
+
 	r := mux.NewRouter()
+	r.Handle("/v1/tax_rates", listRates).Methods("GET")
+	r.Handle("/v1/tax_rates", createRate).Methods("POST")
 	r.Handle("/v1/checkout/sessions", checkout).Methods("POST")
+	r.Handle("/v1/checkout/sessions/{id}", show).Methods("GET")
+	r.Handle("/v1/checkout/sessions/{id}/expire", expire).Methods("POST")
+	r.Handle("/v1/refunds", refund).Methods("POST")
+	r.Handle("/v1/subscriptions/{id}", cancel).Methods("POST")
 	return r
 }
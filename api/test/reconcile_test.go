@@ -0,0 +1,198 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+)
+
+type reconcileTest struct {
+	*TestEnv
+}
+
+func TestReconcile(t *testing.T) {
+	env, err := NewTestEnv(t, "reconcile_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	rct := &reconcileTest{env}
+	ot := &orderTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+
+	from := time.Now().UTC().Add(-time.Minute)
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+	c3 := ct.createCourseOK(t)
+
+	// Paypal reports a fulfilled order as not paid.
+	rt.createItemOK(t, c1.ID)
+	ot.Paypal.expectedCart = []course.Course{c1}
+	ppID := ot.testPaypal(t)
+	ot.Paypal.unpay(ppID)
+
+	// Stripe charged a different amount for a fulfilled order.
+	rt.createItemOK(t, c2.ID)
+	ot.Stripe.expectedCart = []course.Course{c2}
+	strpID := ot.testStripe(t)
+	ot.Stripe.charge(strpID, c2.Price+1)
+
+	// Stripe completed a payment whose webhook never arrived.
+	rt.createItemOK(t, c3.ID)
+	ot.Stripe.expectedCart = []course.Course{c3}
+	pendingID := ot.stripeCheckout(t)
+	ot.statusOK(t, pendingID, order.Pending)
+
+	rep, err := order.Reconcile(context.Background(), rct.DB, rct.Payments, from, time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Checked != 3 || rep.Failed != 0 {
+		t.Fatalf("expected 3 orders checked and none failed, got %d and %d", rep.Checked, rep.Failed)
+	}
+
+	// The paid pending order has been fulfilled.
+	ot.statusOK(t, pendingID, order.Success)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2, c3})
+
+	rct.listReconciliationsUnauth(t)
+	recs := rct.listReconciliationsOK(t, 1)
+	if recs[0].ID != rep.ID {
+		t.Fatalf("expected reconciliation %s, got %s", rep.ID, recs[0].ID)
+	}
+
+	got := rct.showReconciliationOK(t, rep.ID)
+	exp := map[string]order.Discrepancy{
+		ot.orderID(t, ppID):      {Kind: order.DiscrepancyUnpaidSuccess, Expected: c1.Price, Actual: 0},
+		ot.orderID(t, strpID):    {Kind: order.DiscrepancyAmountMismatch, Expected: c2.Price, Actual: c2.Price + 1},
+		ot.orderID(t, pendingID): {Kind: order.DiscrepancyPaidPending, Expected: c3.Price, Actual: c3.Price, Fixed: true},
+	}
+
+	if len(got.Discrepancies) != len(exp) {
+		t.Fatalf("expected %d discrepancies, got %d", len(exp), len(got.Discrepancies))
+	}
+
+	for _, d := range got.Discrepancies {
+		e, ok := exp[d.OrderID]
+		if !ok {
+			t.Fatalf("unexpected discrepancy for order %s", d.OrderID)
+		}
+
+		if d.Kind != e.Kind || d.Expected != e.Expected || d.Actual != e.Actual || d.Fixed != e.Fixed {
+			t.Fatalf("expected discrepancy %+v, got %+v", e, d)
+		}
+	}
+
+	// Orders fixed are not reported anymore.
+	rep, err = order.Reconcile(context.Background(), rct.DB, rct.Payments, from, time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Discrepancies) != 2 {
+		t.Fatalf("expected 2 discrepancies, got %d", len(rep.Discrepancies))
+	}
+	rct.listReconciliationsOK(t, 2)
+}
+
+// orderID returns the id of the order bound to the passed provider id.
+func (ot *orderTest) orderID(t *testing.T, providerID string) string {
+	ord, err := order.FetchByProviderID(context.Background(), ot.DB, providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ord.ID
+}
+
+func (rct *reconcileTest) listReconciliationsOK(t *testing.T, exp int) []order.Reconciliation {
+	if err := Login(rct.Server, rct.AdminEmail, rct.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(rct.Server)
+
+	r, err := http.NewRequest(http.MethodGet, rct.URL+"/admin/reconciliations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := rct.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list reconciliations: status code %s", w.Status)
+	}
+
+	var recs []order.Reconciliation
+	if err := json.NewDecoder(w.Body).Decode(&recs); err != nil {
+		t.Fatalf("cannot unmarshal reconciliations: %v", err)
+	}
+
+	if len(recs) != exp {
+		t.Fatalf("expected %d reconciliations, got %d", exp, len(recs))
+	}
+
+	return recs
+}
+
+func (rct *reconcileTest) listReconciliationsUnauth(t *testing.T) {
+	if err := Login(rct.Server, rct.UserEmail, rct.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(rct.Server)
+
+	r, err := http.NewRequest(http.MethodGet, rct.URL+"/admin/reconciliations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := rct.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("users should not be able to list reconciliations: status code %s", w.Status)
+	}
+}
+
+func (rct *reconcileTest) showReconciliationOK(t *testing.T, id string) order.Report {
+	if err := Login(rct.Server, rct.AdminEmail, rct.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(rct.Server)
+
+	r, err := http.NewRequest(http.MethodGet, rct.URL+"/admin/reconciliations/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := rct.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show reconciliation: status code %s", w.Status)
+	}
+
+	var rep order.Report
+	if err := json.NewDecoder(w.Body).Decode(&rep); err != nil {
+		t.Fatalf("cannot unmarshal reconciliation: %v", err)
+	}
+
+	return rep
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/core/report"
+	"github.com/polldo/govod/money"
+)
+
+type reportTest struct {
+	*TestEnv
+}
+
+func TestReport(t *testing.T) {
+	env, err := NewTestEnv(t, "report_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	rpt := &reportTest{env}
+	ct := &courseTest{env}
+	rt := &cartTest{env}
+	ot := &orderTest{env}
+
+	// Only administrators can see the reports.
+	rpt.revenueStatus(t, rpt.UserEmail, rpt.UserPass, "", http.StatusUnauthorized)
+	rpt.revenueStatus(t, rpt.AdminEmail, rpt.AdminPass, "?group=year", http.StatusUnprocessableEntity)
+	rpt.revenueStatus(t, rpt.AdminEmail, rpt.AdminPass, "?from=yesterday", http.StatusUnprocessableEntity)
+	if rows := rpt.revenueOK(t, ""); len(rows) != 0 {
+		t.Fatalf("expected no revenue, got %d rows", len(rows))
+	}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+
+	rt.createItemOK(t, c1.ID)
+	rt.createItemOK(t, c2.ID)
+	ot.Stripe.expectedCart = []course.Course{c1, c2}
+	id := ot.testStripe(t)
+	ot.refundOK(t, id, []string{c1.ID}, order.PartiallyRefunded)
+
+	// Pending orders are not part of the revenue.
+	rt.createItemOK(t, c1.ID)
+	ot.Stripe.expectedCart = []course.Course{c1}
+	ot.stripeCheckout(t)
+
+	// Enrollments to free courses are not part of the revenue.
+	et := &enrollTest{env}
+	et.enrollOK(t, et.createFreeCourseOK(t).ID)
+
+	gross := c1.Price + c2.Price
+	for _, group := range []report.Group{report.Day, report.Week, report.Month, report.Provider, report.Currency} {
+		rows := rpt.revenueOK(t, "?group="+string(group))
+		if len(rows) != 1 {
+			t.Fatalf("expected 1 row grouped by %s, got %d", group, len(rows))
+		}
+
+		exp := report.Row{
+			Key:          rows[0].Key,
+			Currency:     money.Default,
+			Orders:       1,
+			Gross:        gross,
+			Tax:          rows[0].Tax,
+			Refunds:      c1.Price,
+			Net:          c2.Price,
+			AverageOrder: gross,
+		}
+		if rows[0] != exp {
+			t.Fatalf("unexpected revenue grouped by %s: %+v", group, rows[0])
+		}
+	}
+
+	rows := rpt.revenueOK(t, "?group=course")
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows grouped by course, got %d", len(rows))
+	}
+	for _, r := range rows {
+		c := c1
+		if r.Key == c2.ID {
+			c = c2
+		}
+		if r.Name != c.Name || r.Gross != c.Price || r.Orders != 1 {
+			t.Fatalf("unexpected revenue of course %s: %+v", c.ID, r)
+		}
+	}
+
+	if rows := rpt.revenueOK(t, "?currency=eur"); len(rows) != 0 {
+		t.Fatalf("expected no revenue in EUR, got %d rows", len(rows))
+	}
+
+	rpt.exportOK(t, 2)
+}
+
+func (rpt *reportTest) revenue(t *testing.T, email string, pass string, url string) *http.Response {
+	if err := Login(rpt.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(rpt.Server)
+
+	r, err := http.NewRequest(http.MethodGet, rpt.URL+url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := rpt.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (rpt *reportTest) revenueStatus(t *testing.T, email string, pass string, query string, status int) {
+	w := rpt.revenue(t, email, pass, "/admin/reports/revenue"+query)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("fetching revenue: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (rpt *reportTest) revenueOK(t *testing.T, query string) []report.Row {
+	w := rpt.revenue(t, rpt.AdminEmail, rpt.AdminPass, "/admin/reports/revenue"+query)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't fetch revenue: status code %s", w.Status)
+	}
+
+	var rows []report.Row
+	if err := json.NewDecoder(w.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+// exportOK exports the revenue grouped by course and checks
+// the number of exported rows, header excluded.
+func (rpt *reportTest) exportOK(t *testing.T, exp int) {
+	w := rpt.revenue(t, rpt.AdminEmail, rpt.AdminPass, "/admin/reports/revenue/export?group=course")
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't export revenue: status code %s", w.Status)
+	}
+
+	if ct := w.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("expected csv revenue, got %s", ct)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != exp+1 {
+		t.Fatalf("expected %d exported rows, got %d", exp, len(records)-1)
+	}
+}
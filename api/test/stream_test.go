@@ -0,0 +1,203 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/core/video"
+	"github.com/polldo/govod/signer"
+)
+
+type streamTest struct {
+	*TestEnv
+}
+
+func TestStream(t *testing.T) {
+	env, err := NewTestEnv(t, "stream_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	st := &streamTest{env}
+	mt := &mediaTest{env}
+	et := &enrollTest{env}
+	vt := &videoTest{env}
+	og := &orgTest{env}
+
+	data := media(mp4Header, 5000)
+	if _, err := st.Storage.Put(context.Background(), "videos/stream.mp4", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	c := et.createFreeCourseOK(t)
+	v := vt.createVideoOK(t, c.ID, 1)
+	st.streamStatus(t, "", "", v.ID, http.StatusNotFound)
+
+	w := og.do(t, st.AdminEmail, st.AdminPass, http.MethodPut, "/videos/"+v.ID, video.VideoUp{Key: ptr("videos/stream.mp4"), Free: ptr(false)})
+	w.Body.Close()
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't update video: status code %s", w.Status)
+	}
+
+	// Only owners can stream videos that are not free.
+	st.streamStatus(t, "", "", v.ID, http.StatusUnauthorized)
+	st.streamStatus(t, st.UserEmail, st.UserPass, v.ID, http.StatusForbidden)
+
+	et.enrollOK(t, c.ID)
+
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, nil)
+	body := st.bodyOK(t, w, http.StatusOK, data)
+	if w.Header.Get("Content-Type") != "video/mp4" || w.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("unexpected headers of stream: %v", w.Header)
+	}
+	etag := w.Header.Get("ETag")
+	if etag == "" || len(body) != len(data) {
+		t.Fatalf("expected stream with etag, got %q", etag)
+	}
+
+	// Players seek videos by ranges.
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, http.Header{"Range": {"bytes=100-199"}})
+	st.bodyOK(t, w, http.StatusPartialContent, data[100:200])
+	if cr := w.Header.Get("Content-Range"); cr != "bytes 100-199/5000" {
+		t.Fatalf("unexpected content range %s", cr)
+	}
+
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, http.Header{"Range": {"bytes=4900-"}})
+	st.bodyOK(t, w, http.StatusPartialContent, data[4900:])
+
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, http.Header{"Range": {"bytes=6000-7000"}})
+	w.Body.Close()
+	if w.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected range not satisfiable, got %s", w.Status)
+	}
+
+	// Cached videos are revalidated.
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, http.Header{"If-None-Match": {etag}})
+	st.bodyOK(t, w, http.StatusNotModified, nil)
+
+	w = st.stream(t, st.UserEmail, st.UserPass, v.ID, http.Header{"Range": {"bytes=0-9"}, "If-Range": {`"stale"`}})
+	st.bodyOK(t, w, http.StatusOK, data)
+
+	// Owners are given signed links, which work without the session.
+	link := st.showFullURL(t, v.ID)
+	w = st.fetch(t, "", "", link, http.Header{"Range": {"bytes=0-99"}})
+	st.bodyOK(t, w, http.StatusPartialContent, data[:100])
+
+	// Tampered links are rejected.
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set(signer.UserParam, "00000000-0000-0000-0000-000000000000")
+	st.fetchStatus(t, u.Path+"?"+q.Encode(), http.StatusForbidden)
+
+	q = u.Query()
+	q.Set(signer.ExpiresParam, strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+	st.fetchStatus(t, u.Path+"?"+q.Encode(), http.StatusForbidden)
+
+	other := vt.createVideoOK(t, c.ID, 2)
+	st.fetchStatus(t, "/videos/"+other.ID+"/stream?"+u.RawQuery, http.StatusForbidden)
+
+	// Free videos can be streamed by anyone.
+	og.do(t, st.AdminEmail, st.AdminPass, http.MethodPut, "/videos/"+v.ID, video.VideoUp{Free: ptr(true)}).Body.Close()
+	w = st.stream(t, "", "", v.ID, nil)
+	st.bodyOK(t, w, http.StatusOK, data)
+
+	w = st.fetch(t, "", "", mt.showFreeURL(t, v.ID), nil)
+	st.bodyOK(t, w, http.StatusOK, data)
+}
+
+func (st *streamTest) stream(t *testing.T, email string, pass string, videoID string, h http.Header) *http.Response {
+	return st.fetch(t, email, pass, "/videos/"+videoID+"/stream", h)
+}
+
+func (st *streamTest) fetch(t *testing.T, email string, pass string, link string, h http.Header) *http.Response {
+	if email != "" {
+		if err := Login(st.Server, email, pass); err != nil {
+			t.Fatal(err)
+		}
+		defer Logout(st.Server)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, st.URL+link, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, vs := range h {
+		r.Header[k] = vs
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (st *streamTest) streamStatus(t *testing.T, email string, pass string, videoID string, status int) {
+	w := st.stream(t, email, pass, videoID, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("streaming video: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (st *streamTest) fetchStatus(t *testing.T, link string, status int) {
+	w := st.fetch(t, "", "", link, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("fetching link %s: expected status %d, got %s", link, status, w.Status)
+	}
+}
+
+// showFullURL returns the link of the video given to its owner.
+func (st *streamTest) showFullURL(t *testing.T, videoID string) string {
+	og := &orgTest{st.TestEnv}
+	w := og.do(t, st.UserEmail, st.UserPass, http.MethodGet, "/videos/"+videoID+"/full", nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show full video: status code %s", w.Status)
+	}
+
+	var got struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	return got.URL
+}
+
+// bodyOK checks the status and the body of the stream.
+func (st *streamTest) bodyOK(t *testing.T, w *http.Response, status int, exp []byte) []byte {
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("streaming video: expected status %d, got %s", status, w.Status)
+	}
+
+	got, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, exp) {
+		t.Fatalf("expected stream of %d bytes, got %d bytes", len(exp), len(got))
+	}
+
+	return got
+}
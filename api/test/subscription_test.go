@@ -0,0 +1,367 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/subscription"
+	"github.com/stripe/stripe-go/v74"
+)
+
+type subscriptionTest struct {
+	*TestEnv
+}
+
+func TestSubscription(t *testing.T) {
+	env, err := NewTestEnv(t, "subscription_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	st := &subscriptionTest{env}
+	ct := &courseTest{env}
+	vt := &videoTest{env}
+	og := &orgTest{env}
+	rt := &cartTest{env}
+
+	c1 := ct.createCourseOK(t)
+	c2 := ct.createCourseOK(t)
+	ct.createCourseOK(t)
+	v1 := vt.createVideoOK(t, c1.ID, 0)
+	og.lockVideoOK(t, v1.ID)
+
+	// Only administrators can manage plans, while everyone can see them.
+	st.createPlanStatus(t, st.UserEmail, st.UserPass, subscription.PlanNew{}, http.StatusUnauthorized)
+	st.createPlanStatus(t, st.AdminEmail, st.AdminPass, st.planNew(subscription.Monthly, nil), http.StatusUnprocessableEntity)
+	p1 := st.createPlanOK(t, st.planNew(subscription.Monthly, []string{c1.ID, c2.ID}))
+	p2 := st.createPlanOK(t, subscription.PlanNew{Name: "All", Description: "All courses", Interval: subscription.Yearly, Price: 10000, AllCourses: true})
+	st.listPlansOK(t, 2)
+	st.deletePlanOK(t, p2.ID)
+	st.listPlansOK(t, 1)
+	st.subscribeStatus(t, p2.ID, http.StatusUnprocessableEntity)
+
+	// Subscriptions grant access once their first invoice is paid.
+	st.Stripe.expectedCart = []course.Course{{Price: p1.Price}}
+	sessID := st.subscribeOK(t, p1.ID)
+	og.showVideoStatus(t, st.UserEmail, st.UserPass, v1.ID, http.StatusForbidden)
+
+	billingID := "sub_" + sessID
+	end := time.Now().Add(30 * 24 * time.Hour)
+	st.billingEventOK(t, "checkout.session.completed", stripeSubscriptionSession(sessID, billingID))
+	st.billingEventOK(t, "invoice.paid", stripeInvoice(billingID, end))
+
+	sub := st.listSubscriptionsOK(t, subscription.Active)
+	og.showVideoStatus(t, st.UserEmail, st.UserPass, v1.ID, http.StatusOK)
+	ct.listCoursesOwnedOK(t, []course.Course{c1, c2})
+	st.subscribeStatus(t, p1.ID, http.StatusUnprocessableEntity)
+
+	// Courses of the plan are not owned: they can still be bought.
+	rt.createItemOK(t, c1.ID)
+
+	// Failed renewals keep the access during the grace period.
+	st.billingEventOK(t, "invoice.payment_failed", stripeInvoice(billingID, end))
+	st.listSubscriptionsOK(t, subscription.PastDue)
+	og.showVideoStatus(t, st.UserEmail, st.UserPass, v1.ID, http.StatusOK)
+
+	// Events of unknown subscriptions are refused, to be notified again.
+	st.billingEventStatus(t, "invoice.paid", stripeInvoice("sub_unknown", end), http.StatusInternalServerError)
+
+	// Canceled subscriptions revoke the access once deleted.
+	st.cancelOK(t, sub.ID)
+	st.billingEventOK(t, "customer.subscription.deleted", stripeSubscription(billingID, sub.ID, stripe.SubscriptionStatusCanceled))
+	st.listSubscriptionsOK(t, subscription.Canceled)
+	og.showVideoStatus(t, st.UserEmail, st.UserPass, v1.ID, http.StatusForbidden)
+	ct.listCoursesOwnedOK(t, []course.Course{})
+}
+
+// stripeSubscriptionSession generates a completed stripe checkout session
+// that started the passed stripe subscription.
+func stripeSubscriptionSession(id string, billingID string) map[string]any {
+	return map[string]any{
+		"id":           id,
+		"mode":         stripe.CheckoutSessionModeSubscription,
+		"subscription": billingID,
+	}
+}
+
+// stripeInvoice generates an invoice of the passed stripe subscription
+// for the period ending at the passed time.
+func stripeInvoice(billingID string, end time.Time) map[string]any {
+	return map[string]any{
+		"id":           "in_" + billingID,
+		"subscription": billingID,
+		"period_end":   time.Now().Unix(),
+		"lines": map[string]any{
+			"data": []map[string]any{{"period": map[string]any{"end": end.Unix()}}},
+		},
+	}
+}
+
+// stripeSubscription generates a stripe subscription bound to the passed subscription.
+func stripeSubscription(billingID string, subID string, status stripe.SubscriptionStatus) map[string]any {
+	return map[string]any{
+		"id":       billingID,
+		"status":   status,
+		"metadata": map[string]string{"subscription_id": subID},
+	}
+}
+
+func (st *subscriptionTest) planNew(interval subscription.Interval, courseIDs []string) subscription.PlanNew {
+	return subscription.PlanNew{
+		Name:        "Test plan",
+		Description: "This is a test plan",
+		Interval:    interval,
+		Price:       1500,
+		CourseIDs:   courseIDs,
+	}
+}
+
+func (st *subscriptionTest) createPlan(t *testing.T, email string, pass string, p subscription.PlanNew) *http.Response {
+	if err := Login(st.Server, email, pass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(st.Server)
+
+	body, err := json.Marshal(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, st.URL+"/plans", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (st *subscriptionTest) createPlanOK(t *testing.T, p subscription.PlanNew) subscription.Plan {
+	w := st.createPlan(t, st.AdminEmail, st.AdminPass, p)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create plan: status code %s", w.Status)
+	}
+
+	var got subscription.Plan
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal created plan: %v", err)
+	}
+
+	if got.Price != p.Price || got.Interval != p.Interval || len(got.CourseIDs) != len(p.CourseIDs) {
+		t.Fatalf("wrong plan payload: %+v", got)
+	}
+
+	return got
+}
+
+func (st *subscriptionTest) createPlanStatus(t *testing.T, email string, pass string, p subscription.PlanNew, status int) {
+	w := st.createPlan(t, email, pass, p)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d creating plan, got %s", status, w.Status)
+	}
+}
+
+func (st *subscriptionTest) listPlansOK(t *testing.T, exp int) {
+	r, err := http.NewRequest(http.MethodGet, st.URL+"/plans", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list plans: status code %s", w.Status)
+	}
+
+	var got []subscription.Plan
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal plans: %v", err)
+	}
+
+	if len(got) != exp {
+		t.Fatalf("expected %d plans, got %d", exp, len(got))
+	}
+}
+
+func (st *subscriptionTest) deletePlanOK(t *testing.T, id string) {
+	if err := Login(st.Server, st.AdminEmail, st.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(st.Server)
+
+	r, err := http.NewRequest(http.MethodDelete, st.URL+"/plans/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusNoContent {
+		t.Fatalf("can't delete plan: status code %s", w.Status)
+	}
+}
+
+func (st *subscriptionTest) subscribe(t *testing.T, planID string) *http.Response {
+	if err := Login(st.Server, st.UserEmail, st.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(st.Server)
+
+	body, err := json.Marshal(subscription.SubscriptionNew{PlanID: planID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, st.URL+"/subscriptions", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// subscribeOK starts a stripe checkout subscribing the plan and returns
+// the id of the created stripe session.
+func (st *subscriptionTest) subscribeOK(t *testing.T, planID string) string {
+	w := st.subscribe(t, planID)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't subscribe plan: status code %s", w.Status)
+	}
+
+	var url string
+	if err := json.NewDecoder(w.Body).Decode(&url); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mocked stripe returns the id in the URL.
+	return path.Base(url)
+}
+
+func (st *subscriptionTest) subscribeStatus(t *testing.T, planID string, status int) {
+	w := st.subscribe(t, planID)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d subscribing plan, got %s", status, w.Status)
+	}
+}
+
+// listSubscriptionsOK checks the status of the only subscription
+// of the user and returns it.
+func (st *subscriptionTest) listSubscriptionsOK(t *testing.T, exp subscription.Status) subscription.Subscription {
+	if err := Login(st.Server, st.UserEmail, st.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(st.Server)
+
+	r, err := http.NewRequest(http.MethodGet, st.URL+"/subscriptions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't list subscriptions: status code %s", w.Status)
+	}
+
+	var got []subscription.Subscription
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal subscriptions: %v", err)
+	}
+
+	if len(got) != 1 || got[0].Status != exp {
+		t.Fatalf("expected a single subscription with status %s, got %+v", exp, got)
+	}
+
+	return got[0]
+}
+
+func (st *subscriptionTest) cancelOK(t *testing.T, id string) {
+	if err := Login(st.Server, st.UserEmail, st.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(st.Server)
+
+	r, err := http.NewRequest(http.MethodDelete, st.URL+"/subscriptions/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't cancel subscription: status code %s", w.Status)
+	}
+
+	var got subscription.Subscription
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal canceled subscription: %v", err)
+	}
+
+	if !got.CancelAtPeriodEnd {
+		t.Fatal("subscription should be canceled at the end of the period")
+	}
+}
+
+// billingEventStatus triggers the stripe webhook of subscriptions
+// with an event of the passed type about the passed stripe object.
+func (st *subscriptionTest) billingEventStatus(t *testing.T, typ string, obj map[string]any, status int) {
+	b, sig := signStripeEvent(t, st.BillingWebhookSecret, typ, "", obj)
+
+	r, err := http.NewRequest(http.MethodPost, st.URL+"/subscriptions/webhook", bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Stripe-Signature", sig)
+
+	w, err := st.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("expected status code %d triggering billing webhook, got %s", status, w.Status)
+	}
+}
+
+func (st *subscriptionTest) billingEventOK(t *testing.T, typ string, obj map[string]any) {
+	st.billingEventStatus(t, typ, obj, http.StatusNoContent)
+}
@@ -0,0 +1,238 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/upload"
+	"github.com/polldo/govod/core/video"
+)
+
+// mp4Header is the beginning of a file sniffed as video/mp4.
+var mp4Header = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+
+// pngHeader is the beginning of a file sniffed as image/png.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+type uploadTest struct {
+	*TestEnv
+}
+
+func TestUpload(t *testing.T) {
+	env, err := NewTestEnv(t, "upload_test")
+	if err != nil {
+		t.Fatalf("initializing test env: %v", err)
+	}
+
+	ut := &uploadTest{env}
+	ct := &courseTest{env}
+	vt := &videoTest{env}
+	mt := &mediaTest{env}
+	og := &orgTest{env}
+
+	// Only accepted formats within the limits can be uploaded.
+	data := media(mp4Header, 40000)
+	ut.createStatus(t, upload.UploadNew{Kind: upload.Video, Filename: "intro.avi", Size: 10, Checksum: checksum(data)}, http.StatusUnsupportedMediaType)
+	ut.createStatus(t, upload.UploadNew{Kind: upload.Image, Filename: "cover.png", Size: 1 << 17, Checksum: checksum(data)}, http.StatusRequestEntityTooLarge)
+
+	up := ut.createOK(t, upload.Video, "intro.mp4", data)
+
+	// Chunks must follow the offset of the upload.
+	ut.writeStatus(t, up.ID, 100, data[:100], http.StatusConflict)
+	ut.writeStatus(t, up.ID, 0, data[:1<<15], http.StatusRequestEntityTooLarge)
+	ut.writeStatus(t, up.ID, 0, media(pngHeader, 1000), http.StatusUnsupportedMediaType)
+
+	up = ut.writeOK(t, up.ID, 0, data[:10000])
+	ut.writeStatus(t, up.ID, 0, data[:10000], http.StatusConflict)
+
+	// Interrupted uploads are resumed from their offset.
+	up = ut.showOK(t, up.ID)
+	if up.Offset != 10000 {
+		t.Fatalf("expected upload at offset 10000, got %d", up.Offset)
+	}
+	up = ut.writeOK(t, up.ID, up.Offset, data[10000:25000])
+	up = ut.writeOK(t, up.ID, up.Offset, data[25000:])
+
+	if up.Status != upload.Completed || up.Offset != up.Size {
+		t.Fatalf("expected upload completed, got %s at offset %d", up.Status, up.Offset)
+	}
+	ut.storedOK(t, up.Key, data)
+	ut.writeStatus(t, up.ID, up.Offset, nil, http.StatusUnprocessableEntity)
+
+	// Completed uploads are attached to videos by their key.
+	c := ct.createCourseOK(t)
+	v := vt.createVideoOK(t, c.ID, 1)
+
+	w := og.do(t, ut.AdminEmail, ut.AdminPass, http.MethodPut, "/videos/"+v.ID, video.VideoUp{Key: ptr(up.Key)})
+	w.Body.Close()
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't attach upload to video: status code %s", w.Status)
+	}
+	mt.showFreeURL(t, v.ID)
+
+	// Images are attached to courses.
+	img := media(pngHeader, 3000)
+	iup := ut.createOK(t, upload.Image, "cover.PNG", img)
+	iup = ut.writeOK(t, iup.ID, 0, img)
+
+	w = og.do(t, ut.AdminEmail, ut.AdminPass, http.MethodPut, "/courses/"+c.ID, course.CourseUp{ImageKey: ptr(iup.Key)})
+	w.Body.Close()
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't attach upload to course: status code %s", w.Status)
+	}
+	mt.showImageOK(t, c.ID, iup.Key)
+
+	// Files not matching their checksum are rejected.
+	bad := ut.createOK(t, upload.Image, "bad.png", media(pngHeader, 100))
+	ut.writeStatus(t, bad.ID, 0, media(pngHeader, 100), http.StatusUnprocessableEntity)
+	if bad = ut.showOK(t, bad.ID); bad.Status != upload.Failed {
+		t.Fatalf("expected upload failed, got %s", bad.Status)
+	}
+
+	// Uploads can be aborted.
+	ab := ut.createOK(t, upload.Video, "abort.webm", data)
+	ut.cancelStatus(t, ab.ID, http.StatusNoContent)
+	ut.cancelStatus(t, ab.ID, http.StatusNotFound)
+	ut.cancelStatus(t, up.ID, http.StatusUnprocessableEntity)
+}
+
+// media returns a random file of the passed size, starting with header.
+func media(header []byte, size int) []byte {
+	b := make([]byte, size)
+	rand.Read(b)
+	copy(b, header)
+	return b
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (ut *uploadTest) create(t *testing.T, un upload.UploadNew) *http.Response {
+	og := &orgTest{ut.TestEnv}
+	return og.do(t, ut.AdminEmail, ut.AdminPass, http.MethodPost, "/uploads", un)
+}
+
+func (ut *uploadTest) createStatus(t *testing.T, un upload.UploadNew, status int) {
+	w := ut.create(t, un)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("creating upload: expected status %d, got %s", status, w.Status)
+	}
+}
+
+func (ut *uploadTest) createOK(t *testing.T, kind upload.Kind, filename string, data []byte) upload.Upload {
+	w := ut.create(t, upload.UploadNew{Kind: kind, Filename: filename, Size: int64(len(data)), Checksum: checksum(data)})
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusCreated {
+		t.Fatalf("can't create upload: status code %s", w.Status)
+	}
+
+	return ut.decode(t, w)
+}
+
+func (ut *uploadTest) write(t *testing.T, id string, offset int64, chunk []byte) *http.Response {
+	if err := Login(ut.Server, ut.AdminEmail, ut.AdminPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ut.Server)
+
+	r, err := http.NewRequest(http.MethodPatch, ut.URL+"/uploads/"+id, bytes.NewReader(chunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set(upload.OffsetHeader, strconv.FormatInt(offset, 10))
+
+	w, err := ut.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (ut *uploadTest) writeStatus(t *testing.T, id string, offset int64, chunk []byte, status int) {
+	w := ut.write(t, id, offset, chunk)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("writing chunk at offset %d: expected status %d, got %s", offset, status, w.Status)
+	}
+}
+
+func (ut *uploadTest) writeOK(t *testing.T, id string, offset int64, chunk []byte) upload.Upload {
+	w := ut.write(t, id, offset, chunk)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't write chunk at offset %d: status code %s", offset, w.Status)
+	}
+
+	up := ut.decode(t, w)
+	if exp := strconv.FormatInt(offset+int64(len(chunk)), 10); w.Header.Get(upload.OffsetHeader) != exp {
+		t.Fatalf("expected offset %s, got %s", exp, w.Header.Get(upload.OffsetHeader))
+	}
+
+	return up
+}
+
+func (ut *uploadTest) showOK(t *testing.T, id string) upload.Upload {
+	og := &orgTest{ut.TestEnv}
+	w := og.do(t, ut.AdminEmail, ut.AdminPass, http.MethodGet, "/uploads/"+id, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't show upload: status code %s", w.Status)
+	}
+
+	return ut.decode(t, w)
+}
+
+func (ut *uploadTest) cancelStatus(t *testing.T, id string, status int) {
+	og := &orgTest{ut.TestEnv}
+	w := og.do(t, ut.AdminEmail, ut.AdminPass, http.MethodDelete, "/uploads/"+id, nil)
+	defer w.Body.Close()
+
+	if w.StatusCode != status {
+		t.Fatalf("canceling upload: expected status %d, got %s", status, w.Status)
+	}
+}
+
+// storedOK checks that the file has been stored under the key of the upload.
+func (ut *uploadTest) storedOK(t *testing.T, key string, data []byte) {
+	rc, _, err := ut.Storage.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatalf("stored file of %d bytes differs from the uploaded one of %d bytes", len(got), len(data))
+	}
+}
+
+func (ut *uploadTest) decode(t *testing.T, w *http.Response) upload.Upload {
+	var up upload.Upload
+	if err := json.NewDecoder(w.Body).Decode(&up); err != nil {
+		t.Fatal(err)
+	}
+	return up
+}
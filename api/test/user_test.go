@@ -31,6 +31,9 @@ func TestUser(t *testing.T) {
 	ut.createUserOK(t)
 	ut.createUserUnauth(t)
 	ut.createUserExistent(t)
+
+	ut.updatePrefsOK(t, "EUR")
+	ut.updatePrefsInvalid(t, "XYZ")
 }
 
 func (ut *userTest) getUserOK(t *testing.T) user.User {
@@ -337,3 +340,54 @@ func (ut *userTest) createUserOK(t *testing.T) {
 		t.Fatalf("wrong user payload. Diff: \n%s", diff)
 	}
 }
+
+func (ut *userTest) updatePrefs(t *testing.T, currency string) *http.Response {
+	if err := Login(ut.Server, ut.UserEmail, ut.UserPass); err != nil {
+		t.Fatal(err)
+	}
+	defer Logout(ut.Server)
+
+	body, err := json.Marshal(user.UserPrefs{Currency: currency})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPut, ut.Server.URL+"/users/current/prefs", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ut.Server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func (ut *userTest) updatePrefsOK(t *testing.T, currency string) {
+	w := ut.updatePrefs(t, currency)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("can't update preferences: status code %s", w.Status)
+	}
+
+	var got user.User
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("cannot unmarshal updated user: %v", err)
+	}
+
+	if got.Currency != currency {
+		t.Fatalf("expected currency %s, got %s", currency, got.Currency)
+	}
+}
+
+func (ut *userTest) updatePrefsInvalid(t *testing.T, currency string) {
+	w := ut.updatePrefs(t, currency)
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("currency %s should be invalid: status code %s", currency, w.Status)
+	}
+}
@@ -17,8 +17,16 @@ import (
 	"github.com/polldo/govod/api/background"
 	"github.com/polldo/govod/config"
 	"github.com/polldo/govod/core/auth"
+	"github.com/polldo/govod/core/gift"
+	"github.com/polldo/govod/core/idempotency"
+	"github.com/polldo/govod/core/order"
+	"github.com/polldo/govod/core/subscription"
+	"github.com/polldo/govod/core/upload"
 	"github.com/polldo/govod/database"
 	"github.com/polldo/govod/email"
+	"github.com/polldo/govod/random"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
 	"github.com/sirupsen/logrus"
 	stripecl "github.com/stripe/stripe-go/v74/client"
 )
@@ -56,6 +64,21 @@ func Run(logger *logrus.Logger) error {
 		return fmt.Errorf("failed to open db connection: %w", err)
 	}
 
+	// Build the storage of media files.
+	store, err := newStorage(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to build the media storage: %w", err)
+	}
+
+	// Build the signer of media links.
+	if len(cfg.Links.Keys) == 0 {
+		logger.Warn("no keys configured to sign media links: links will not survive restarts")
+	}
+	sg, err := newSigner(cfg.Links)
+	if err != nil {
+		return fmt.Errorf("failed to build the signer of media links: %w", err)
+	}
+
 	// Init the session manager.
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 24 * time.Hour
@@ -64,30 +87,87 @@ func Run(logger *logrus.Logger) error {
 	links := email.Links{
 		ActivationURL: cfg.Email.ActivationURL,
 		RecoveryURL:   cfg.Email.RecoveryURL,
+		GiftURL:       cfg.Email.GiftURL,
+		InvitationURL: cfg.Email.InvitationURL,
 	}
 	mail := email.New(cfg.Email.Address, cfg.Email.Password, cfg.Email.Host, cfg.Email.Port, links)
 
 	// Init a background manager to safely spawn go-routines.
 	bg := background.New(logger)
 
-	// Build the paypal client to allow payments.
-	pp, err := paypal.NewClient(
-		cfg.Paypal.ClientID,
-		cfg.Paypal.Secret,
-		cfg.Paypal.URL,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to build the paypal client: %w", err)
+	// Build the payment providers that have been configured.
+	payments := make(map[string]order.PaymentProvider)
+
+	// Subscriptions are available only if stripe is configured.
+	var billing subscription.Billing
+
+	if cfg.Paypal.ClientID != "" {
+		pp, err := paypal.NewClient(
+			cfg.Paypal.ClientID,
+			cfg.Paypal.Secret,
+			cfg.Paypal.URL,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to build the paypal client: %w", err)
+		}
+
+		// The paypal token must be retrieved manually only the first time.
+		if _, err = pp.GetAccessToken(context.TODO()); err != nil {
+			return fmt.Errorf("failed to get the first paypal access token: %w", err)
+		}
+
+		payments[order.ProviderPaypal] = order.NewPaypal(pp, cfg.Paypal)
+	}
+
+	if cfg.Stripe.APISecret != "" {
+		strp := &stripecl.API{}
+		strp.Init(cfg.Stripe.APISecret, nil)
+
+		payments[order.ProviderStripe] = order.NewStripe(strp, cfg.Stripe)
+		billing = subscription.NewStripe(strp, cfg.Stripe)
 	}
 
-	// The paypal token must be retrieved manually only the first time.
-	if _, err = pp.GetAccessToken(context.TODO()); err != nil {
-		return fmt.Errorf("failed to get the first paypal access token: %w", err)
+	if cfg.Orders.FakePayments {
+		logger.Warn("fake payments enabled: courses can be bought for free")
+		payments[order.ProviderFake] = order.NewFake()
 	}
 
-	// Build the stripe client to allow payments.
-	strp := &stripecl.API{}
-	strp.Init(cfg.Stripe.APISecret, nil)
+	// Periodically expire the orders abandoned during checkout.
+	bg.Schedule(cfg.Orders.ExpireInterval, func(ctx context.Context) error {
+		return order.ExpirePending(ctx, db, payments, cfg.Orders.ExpireAfter)
+	})
+
+	// Periodically retry the fulfillments of paid orders that failed.
+	bg.Schedule(cfg.Orders.FulfillInterval, func(ctx context.Context) error {
+		return order.RetryFulfillments(ctx, db)
+	})
+
+	// Periodically send the codes of the gifts whose order has been fulfilled.
+	bg.Schedule(cfg.Orders.GiftInterval, func(ctx context.Context) error {
+		return gift.Deliver(ctx, db, mail)
+	})
+
+	// Periodically reconcile recent orders with the payments recorded by providers.
+	bg.Schedule(cfg.Orders.ReconcileInterval, func(ctx context.Context) error {
+		to := time.Now().UTC()
+		_, err := order.Reconcile(ctx, db, payments, to.Add(-cfg.Orders.ReconcileWindow), to)
+		return err
+	})
+
+	// Periodically render the invoices of successful orders.
+	bg.Schedule(cfg.Invoice.RenderInterval, func(ctx context.Context) error {
+		return order.RenderInvoices(ctx, db, cfg.Invoice)
+	})
+
+	// Periodically purge the idempotency keys that cannot be retried anymore.
+	bg.Schedule(cfg.Idempotency.PurgeInterval, func(ctx context.Context) error {
+		return idempotency.Purge(ctx, db, cfg.Idempotency.KeyTTL)
+	})
+
+	// Periodically purge the uploads of media files abandoned before completion.
+	bg.Schedule(cfg.Uploads.PurgeInterval, func(ctx context.Context) error {
+		return upload.Purge(ctx, db, store, cfg.Uploads.ExpireAfter)
+	})
 
 	// Instantiate known oauth providers.
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Oauth.DiscoveryTimeout)
@@ -109,12 +189,17 @@ func Run(logger *logrus.Logger) error {
 		Mailer:             mail,
 		TokenTimeout:       cfg.Email.TokenTimeout,
 		Background:         bg,
-		Paypal:             pp,
-		Stripe:             strp,
-		StripeCfg:          cfg.Stripe,
+		Payments:           payments,
+		Invoice:            cfg.Invoice,
+		Billing:            billing,
+		Subscriptions:      cfg.Subscriptions,
 		Providers:          oauthProvs,
 		LoginRedirectURL:   cfg.Oauth.LoginRedirectURL,
 		ActivationRequired: cfg.Auth.ActivationRequired,
+		Storage:            store,
+		PresignTTL:         cfg.Storage.PresignTTL,
+		Uploads:            cfg.Uploads,
+		Signer:             sg,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -161,3 +246,41 @@ func Run(logger *logrus.Logger) error {
 	}
 	return nil
 }
+
+// newStorage builds the media storage of the configured driver.
+func newStorage(cfg config.Storage) (storage.Store, error) {
+	switch cfg.Driver {
+	case "local":
+		return storage.NewLocal(cfg.LocalDir, cfg.LocalURL)
+	case "s3":
+		return storage.NewS3(&http.Client{}, storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver[%s]", cfg.Driver)
+	}
+}
+
+// newSigner builds the signer of media links with the configured keys,
+// or with a random key if none is configured.
+func newSigner(cfg config.Links) (*signer.Signer, error) {
+	if len(cfg.Keys) == 0 {
+		secret, err := random.StringSecure(64)
+		if err != nil {
+			return nil, fmt.Errorf("generating key: %w", err)
+		}
+		return signer.New(cfg.TTL, signer.Key{ID: "default", Secret: []byte(secret)})
+	}
+
+	keys, err := signer.ParseKeys(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.New(cfg.TTL, keys...)
+}
@@ -7,14 +7,21 @@ import (
 // Config contains all the config parameters useful
 // to setup the whole server components.
 type Config struct {
-	Cors   Cors
-	Web    Web
-	DB     DB
-	Email  Email
-	Paypal Paypal
-	Stripe Stripe
-	Oauth  Oauth
-	Auth   Auth
+	Cors          Cors
+	Web           Web
+	Idempotency   Idempotency
+	DB            DB
+	Email         Email
+	Paypal        Paypal
+	Stripe        Stripe
+	Orders        Orders
+	Subscriptions Subscriptions
+	Invoice       Invoice
+	Oauth         Oauth
+	Auth          Auth
+	Storage       Storage
+	Uploads       Uploads
+	Links         Links
 }
 
 // Cors includes parameters for CORS setup.
@@ -31,6 +38,13 @@ type Web struct {
 	ShutdownTimeout time.Duration `conf:"default:120s"`
 }
 
+// Idempotency contains parameters to manage idempotency keys.
+// Requests can be retried with the same key until KeyTTL elapses.
+type Idempotency struct {
+	KeyTTL        time.Duration `conf:"default:24h"`
+	PurgeInterval time.Duration `conf:"default:1h"`
+}
+
 // DB contains the details of the PostgreSQL to use.
 type DB struct {
 	User         string `conf:"default:postgres"`
@@ -51,22 +65,61 @@ type Email struct {
 	Password      string
 	RecoveryURL   string        `conf:"default:http://mylocal.com:3000/password/confirm?token="`
 	ActivationURL string        `conf:"default:http://mylocal.com:3000/activate/confirm?token="`
+	GiftURL       string        `conf:"default:http://mylocal.com:3000/gifts/redeem?code="`
+	InvitationURL string        `conf:"default:http://mylocal.com:3000/orgs/join?token="`
 	TokenTimeout  time.Duration `conf:"default:10s"`
 }
 
 // Stripe contains parameters to setup the Stripe dependency.
+// Events of subscriptions are notified to a dedicated webhook,
+// signed with BillingWebhookSecret.
 type Stripe struct {
-	APISecret     string
-	WebhookSecret string
-	SuccessURL    string `conf:"default:http://mylocal.com:3000/dashboard"`
-	CancelURL     string `conf:"default:http://mylocal.com:3000/cart"`
+	APISecret            string
+	WebhookSecret        string
+	BillingWebhookSecret string
+	SuccessURL           string `conf:"default:http://mylocal.com:3000/dashboard"`
+	CancelURL            string `conf:"default:http://mylocal.com:3000/cart"`
 }
 
 // Paypal contains parameters to setup the Paypal dependency.
+// WebhookID identifies the webhook registered on paypal, which is
+// needed to verify the signature of the events it notifies.
 type Paypal struct {
-	ClientID string
-	Secret   string
-	URL      string `conf:"default:https://api.sandbox.paypal.com"`
+	ClientID  string
+	Secret    string
+	WebhookID string
+	URL       string `conf:"default:https://api.sandbox.paypal.com"`
+}
+
+// Orders contains parameters to manage the lifecycle of orders.
+// Codes of gifts whose order has been fulfilled are sent every GiftInterval.
+// Orders created within the last ReconcileWindow are periodically
+// reconciled with the payments recorded by providers.
+// FakePayments enables a payment provider that completes payments
+// locally: never enable it in production.
+type Orders struct {
+	ExpireAfter       time.Duration `conf:"default:6h"`
+	ExpireInterval    time.Duration `conf:"default:10m"`
+	FulfillInterval   time.Duration `conf:"default:1m"`
+	GiftInterval      time.Duration `conf:"default:1m"`
+	ReconcileInterval time.Duration `conf:"default:24h"`
+	ReconcileWindow   time.Duration `conf:"default:48h"`
+	FakePayments      bool          `conf:"default:false"`
+}
+
+// Subscriptions contains parameters to manage subscriptions.
+// Subscribers keep their access for GracePeriod after the end of the
+// paid period, while the provider retries failed renewals.
+type Subscriptions struct {
+	GracePeriod time.Duration `conf:"default:72h"`
+}
+
+// Invoice contains the details of the seller shown on invoices.
+type Invoice struct {
+	SellerName     string `conf:"default:Govod"`
+	SellerAddress  string
+	SellerTaxID    string
+	RenderInterval time.Duration `conf:"default:1m"`
 }
 
 // Oauth includes all details needed to setup Oauth authentication.
@@ -85,3 +138,43 @@ type Oauth struct {
 type Auth struct {
 	ActivationRequired bool `conf:"default:false"`
 }
+
+// Storage configures the backend media files are stored on.
+// Driver is either local, which stores files under LocalDir, or s3.
+// Files of the local driver can be presigned only if LocalDir is publicly
+// served under LocalURL. Presigned URLs are valid for PresignTTL.
+type Storage struct {
+	Driver      string `conf:"default:local"`
+	LocalDir    string `conf:"default:media"`
+	LocalURL    string
+	S3Endpoint  string        `conf:"default:https://s3.amazonaws.com,env:STORAGE_S3_ENDPOINT"`
+	S3Region    string        `conf:"default:us-east-1,env:STORAGE_S3_REGION"`
+	S3Bucket    string        `conf:"env:STORAGE_S3_BUCKET"`
+	S3AccessKey string        `conf:"env:STORAGE_S3_ACCESS_KEY"`
+	S3SecretKey string        `conf:"mask,env:STORAGE_S3_SECRET_KEY"`
+	S3PathStyle bool          `conf:"default:false,env:STORAGE_S3_PATH_STYLE"`
+	PresignTTL  time.Duration `conf:"default:1h"`
+}
+
+// Uploads contains the limits of the uploads of media files, in bytes.
+// Chunks must be received within the read timeout of the web server.
+// Uploads not completed within ExpireAfter are periodically purged.
+type Uploads struct {
+	MaxVideoSize  int64         `conf:"default:5368709120"`
+	MaxImageSize  int64         `conf:"default:10485760"`
+	MaxChunkSize  int64         `conf:"default:8388608"`
+	ExpireAfter   time.Duration `conf:"default:24h"`
+	PurgeInterval time.Duration `conf:"default:1h"`
+}
+
+// Links configures the signed links of media files, valid for TTL.
+// Keys are formatted as id:secret, of at least 32 bytes, and separated by
+// semicolons. Links are signed with the first key and verified with any of
+// them, so that keys are rotated by placing the new one first, and removing
+// the old one once its links have expired.
+// A random key is generated when none is configured, so that links are
+// invalidated by restarts.
+type Links struct {
+	Keys []string      `conf:"mask"`
+	TTL  time.Duration `conf:"default:4h"`
+}
@@ -0,0 +1,144 @@
+package affiliate
+
+import (
+	"context"
+	"time"
+
+	"github.com/polldo/govod/money"
+)
+
+// CommissionStatus models the status of a commission.
+type CommissionStatus string
+
+const (
+	// Owed commissions will be paid with the next payout.
+	Owed CommissionStatus = "owed"
+	// Paid commissions have been paid to the affiliate.
+	Paid CommissionStatus = "paid"
+	// Clawback commissions have been reversed after being paid:
+	// they are deducted from the next payout.
+	Clawback CommissionStatus = "clawback"
+	// Reversed commissions are not due anymore.
+	Reversed CommissionStatus = "reversed"
+)
+
+// maxRate is the maximum commission rate, in basis points.
+const maxRate = 10000
+
+// Affiliate models partners promoting courses with their referral code.
+// Rate is the default commission rate of the affiliate, in basis points
+// of the price of the items they referred, taxes excluded.
+type Affiliate struct {
+	ID        string    `json:"id" db:"affiliate_id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	Code      string    `json:"code" db:"code"`
+	Rate      int       `json:"rate" db:"rate"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// AffiliateNew contains the information needed to enroll
+// a user in the affiliate program.
+type AffiliateNew struct {
+	UserID string `json:"userId" validate:"required,uuid"`
+	Code   string `json:"code" validate:"required,alphanum,min=4,max=32"`
+	Rate   int    `json:"rate" validate:"gte=0,lte=10000"`
+}
+
+// Rate is the commission rate of a course. Rates without an affiliate
+// apply to all affiliates, while rates of an affiliate override them.
+type Rate struct {
+	AffiliateID string `json:"affiliateId" db:"affiliate_id"`
+	CourseID    string `json:"courseId" db:"course_id"`
+	Rate        int    `json:"rate" db:"rate"`
+}
+
+// RateNew contains the information needed to set the commission rate of a course.
+type RateNew struct {
+	AffiliateID string `json:"affiliateId" validate:"omitempty,uuid"`
+	CourseID    string `json:"courseId" validate:"required,uuid"`
+	Rate        int    `json:"rate" validate:"gte=0,lte=10000"`
+}
+
+// Referral contains the referral code captured on landing.
+type Referral struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// Commission is the commission owed to an affiliate for an item of an order.
+type Commission struct {
+	ID          string           `json:"id" db:"commission_id"`
+	AffiliateID string           `json:"affiliateId" db:"affiliate_id"`
+	OrderID     string           `json:"orderId" db:"order_id"`
+	CourseID    string           `json:"courseId" db:"course_id"`
+	Amount      int64            `json:"amount" db:"amount"`
+	Currency    string           `json:"currency" db:"currency"`
+	Rate        int              `json:"rate" db:"rate"`
+	Status      CommissionStatus `json:"status" db:"status"`
+	PaidAt      *time.Time       `json:"paidAt" db:"paid_at"`
+	CreatedAt   time.Time        `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time        `json:"updatedAt" db:"updated_at"`
+}
+
+// Sale is an item sold to a referred user. Price is the amount
+// charged for the item, taxes included.
+type Sale struct {
+	CourseID string
+	Price    int64
+	Tax      int64
+	Currency string
+}
+
+// Stats summarizes the performance of an affiliate. Conversions are the
+// orders referred by the affiliate, while Owed contains the amounts to be
+// paid with the next payout, for each currency.
+type Stats struct {
+	Affiliate   Affiliate     `json:"affiliate"`
+	Clicks      int           `json:"clicks"`
+	Conversions int           `json:"conversions"`
+	Owed        []money.Money `json:"owed"`
+	Paid        []money.Money `json:"paid"`
+}
+
+// Payout is the amount owed to an affiliate in a currency.
+type Payout struct {
+	AffiliateID string `json:"affiliateId" db:"affiliate_id"`
+	Code        string `json:"code" db:"code"`
+	Email       string `json:"email" db:"email"`
+	Currency    string `json:"currency" db:"currency"`
+	Amount      int64  `json:"amount" db:"amount"`
+}
+
+// SettleNew contains the cutoff of the payouts exported, see HandleExportPayouts.
+// Only the commissions accrued up to the cutoff are settled.
+type SettleNew struct {
+	Cutoff time.Time `json:"cutoff" validate:"required"`
+}
+
+// commission computes the commission on the sale at the passed rate.
+// Taxes are collected on behalf of authorities, so they are excluded.
+func commission(s Sale, rate int) int64 {
+	net := s.Price - s.Tax
+	if net <= 0 || rate <= 0 {
+		return 0
+	}
+	return net * int64(min(rate, maxRate)) / maxRate
+}
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// referralKey is used to store/retrieve the referring affiliate from a context.Context.
+const referralKey ctxKey = 1
+
+// setReferral stores the affiliate who referred the user in the context.
+func setReferral(ctx context.Context, affiliateID string) context.Context {
+	return context.WithValue(ctx, referralKey, affiliateID)
+}
+
+// Referrer returns the affiliate who referred the user, if any.
+// It's available to the handlers wrapped by the Track middleware.
+func Referrer(ctx context.Context) string {
+	id, _ := ctx.Value(referralKey).(string)
+	return id
+}
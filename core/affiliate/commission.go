@@ -0,0 +1,66 @@
+package affiliate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/validate"
+)
+
+// Accrue records the commissions earned by the affiliate on the sales of an
+// order bought by the passed user. Affiliates do not earn commissions on
+// their own orders, nor on items whose commission is zero.
+// It's meant to be run in the same transaction completing the order.
+func Accrue(ctx context.Context, tx sqlx.ExtContext, affiliateID string, orderID string, userID string, sales []Sale, now time.Time) error {
+	if affiliateID == "" {
+		return nil
+	}
+
+	aff, err := Fetch(ctx, tx, affiliateID)
+	if err != nil {
+		// The referral may outlive the affiliate.
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if aff.UserID == userID {
+		return nil
+	}
+
+	for _, s := range sales {
+		rate, err := FetchRate(ctx, tx, aff.ID, s.CourseID)
+		if err != nil {
+			return err
+		}
+
+		amount := commission(s, rate)
+		if amount == 0 {
+			continue
+		}
+
+		c := Commission{
+			ID:          validate.GenerateID(),
+			AffiliateID: aff.ID,
+			OrderID:     orderID,
+			CourseID:    s.CourseID,
+			Amount:      amount,
+			Currency:    s.Currency,
+			Rate:        rate,
+			Status:      Owed,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if err := CreateCommission(ctx, tx, c); err != nil {
+			return fmt.Errorf("accruing commission of course[%s]: %w", s.CourseID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package affiliate
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/user"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleCapture captures the referral code the user landed with.
+// The affiliate is stored in the session of the user, so that the
+// orders they place afterwards are attributed to the affiliate.
+func HandleCapture(db *sqlx.DB, session *scs.SessionManager) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var ref Referral
+		if err := web.Decode(w, r, &ref); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(ref); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		aff, err := FetchByCode(ctx, db, ref.Code)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return fmt.Errorf("fetching affiliate with code[%s]: %w", ref.Code, err)
+		}
+
+		if err := CreateClick(ctx, db, validate.GenerateID(), aff.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		session.Put(ctx, referralSessionKey, aff.ID)
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleCreate allows administrators to enroll users in the affiliate program.
+func HandleCreate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var a AffiliateNew
+		if err := web.Decode(w, r, &a); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(a); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if _, err := user.Fetch(ctx, db, a.UserID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				err := fmt.Errorf("user[%s] not found", a.UserID)
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching user[%s]: %w", a.UserID, err)
+		}
+
+		now := time.Now().UTC()
+
+		aff := Affiliate{
+			ID:        validate.GenerateID(),
+			UserID:    a.UserID,
+			Code:      a.Code,
+			Rate:      a.Rate,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		if err := Create(ctx, db, aff); err != nil {
+			if errors.Is(err, database.ErrDBDuplicatedEntry) {
+				return weberr.NewError(err, "passed user or code is already an affiliate", http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, aff, http.StatusCreated)
+	}
+}
+
+// HandleList allows administrators to list all the affiliates.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		affs, err := FetchAll(ctx, db)
+		if err != nil {
+			return fmt.Errorf("fetching all affiliates: %w", err)
+		}
+
+		return web.Respond(ctx, w, affs, http.StatusOK)
+	}
+}
+
+// HandleSetRate allows administrators to set the commission rate of a
+// course, either for all the affiliates or for a specific one.
+func HandleSetRate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var rn RateNew
+		if err := web.Decode(w, r, &rn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(rn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if _, err := course.Fetch(ctx, db, rn.CourseID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				err := fmt.Errorf("course[%s] not found", rn.CourseID)
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching course[%s]: %w", rn.CourseID, err)
+		}
+
+		if rn.AffiliateID != "" {
+			if _, err := Fetch(ctx, db, rn.AffiliateID); err != nil {
+				if errors.Is(err, database.ErrDBNotFound) {
+					err := fmt.Errorf("affiliate[%s] not found", rn.AffiliateID)
+					return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+				}
+				return fmt.Errorf("fetching affiliate[%s]: %w", rn.AffiliateID, err)
+			}
+		}
+
+		rate := Rate{
+			AffiliateID: rn.AffiliateID,
+			CourseID:    rn.CourseID,
+			Rate:        rn.Rate,
+		}
+
+		if err := SetRate(ctx, db, rate); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, rate, http.StatusOK)
+	}
+}
+
+// HandleShowStats returns the dashboard of the current user's affiliate
+// account, with its clicks, conversions and commissions.
+func HandleShowStats(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		aff, err := FetchByUser(ctx, db, clm.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return fmt.Errorf("fetching affiliate of user[%s]: %w", clm.UserID, err)
+		}
+
+		stats, err := FetchStats(ctx, db, aff)
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, stats, http.StatusOK)
+	}
+}
+
+// HandleListCommissions returns the commissions earned by
+// the current user's affiliate account.
+func HandleListCommissions(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		aff, err := FetchByUser(ctx, db, clm.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return fmt.Errorf("fetching affiliate of user[%s]: %w", clm.UserID, err)
+		}
+
+		cs, err := FetchCommissions(ctx, db, aff.ID)
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, cs, http.StatusOK)
+	}
+}
+
+// HandleExportPayouts allows administrators to export the payouts
+// owed to the affiliates as a CSV file. Each payout carries the cutoff
+// of the export, to be passed to HandleSettle once paid, so that the
+// commissions accrued meanwhile are left for the next payout.
+func HandleExportPayouts(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		// Timestamps are stored with microsecond precision.
+		cutoff := time.Now().UTC().Truncate(time.Microsecond)
+
+		ps, err := FetchPayouts(ctx, db, cutoff)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"payouts.csv\"")
+		w.WriteHeader(http.StatusOK)
+
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"affiliate_id", "code", "email", "currency", "amount", "cutoff"})
+		for _, p := range ps {
+			cw.Write([]string{
+				p.AffiliateID,
+				p.Code,
+				p.Email,
+				p.Currency,
+				money.New(p.Amount, p.Currency).Decimal(),
+				cutoff.Format(time.RFC3339Nano),
+			})
+		}
+		cw.Flush()
+
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("writing payouts: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// HandleSettle allows administrators to mark the payout owed to an
+// affiliate as paid, up to the cutoff of the export it has been paid from.
+func HandleSettle(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		affID := web.Param(r, "id")
+
+		if err := validate.CheckID(affID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		var sn SettleNew
+		if err := web.Decode(w, r, &sn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(sn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+		if sn.Cutoff.After(now) {
+			err := fmt.Errorf("cutoff[%s] in the future", sn.Cutoff)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if _, err := Fetch(ctx, db, affID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return fmt.Errorf("fetching affiliate[%s]: %w", affID, err)
+		}
+
+		if err := Settle(ctx, db, affID, sn.Cutoff.UTC(), now); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
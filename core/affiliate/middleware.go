@@ -0,0 +1,28 @@
+package affiliate
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/polldo/govod/api/web"
+)
+
+// referralSessionKey is the session key of the affiliate who referred the user.
+const referralSessionKey = "referral"
+
+// Track returns a middleware that makes the affiliate who referred
+// the user, if any, available to the handler through Referrer.
+func Track(s *scs.SessionManager) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if id := s.GetString(ctx, referralSessionKey); id != "" {
+				ctx = setReferral(ctx, id)
+			}
+
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
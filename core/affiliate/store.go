@@ -0,0 +1,427 @@
+package affiliate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+)
+
+// Create inserts a new affiliate.
+func Create(ctx context.Context, db sqlx.ExtContext, aff Affiliate) error {
+	const q = `
+	INSERT INTO affiliates
+		(affiliate_id, user_id, code, rate, created_at, updated_at)
+	VALUES
+		(:affiliate_id, :user_id, :code, :rate, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, aff); err != nil {
+		return fmt.Errorf("inserting affiliate: %w", err)
+	}
+
+	return nil
+}
+
+// Fetch returns a specific affiliate.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Affiliate, error) {
+	in := struct {
+		ID string `db:"affiliate_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		affiliates
+	WHERE
+		affiliate_id = :affiliate_id`
+
+	var aff Affiliate
+	if err := database.NamedQueryStruct(ctx, db, q, in, &aff); err != nil {
+		return Affiliate{}, fmt.Errorf("selecting affiliate[%s]: %w", id, err)
+	}
+
+	return aff, nil
+}
+
+// FetchByCode returns the affiliate owning the passed referral code.
+func FetchByCode(ctx context.Context, db sqlx.ExtContext, code string) (Affiliate, error) {
+	in := struct {
+		Code string `db:"code"`
+	}{
+		Code: code,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		affiliates
+	WHERE
+		code = :code`
+
+	var aff Affiliate
+	if err := database.NamedQueryStruct(ctx, db, q, in, &aff); err != nil {
+		return Affiliate{}, fmt.Errorf("selecting affiliate with code[%s]: %w", code, err)
+	}
+
+	return aff, nil
+}
+
+// FetchByUser returns the affiliate account of the passed user.
+func FetchByUser(ctx context.Context, db sqlx.ExtContext, userID string) (Affiliate, error) {
+	in := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		affiliates
+	WHERE
+		user_id = :user_id`
+
+	var aff Affiliate
+	if err := database.NamedQueryStruct(ctx, db, q, in, &aff); err != nil {
+		return Affiliate{}, fmt.Errorf("selecting affiliate of user[%s]: %w", userID, err)
+	}
+
+	return aff, nil
+}
+
+// FetchAll returns all the affiliates.
+func FetchAll(ctx context.Context, db sqlx.ExtContext) ([]Affiliate, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		affiliates
+	ORDER BY
+		code`
+
+	affs := []Affiliate{}
+	if err := database.NamedQuerySlice(ctx, db, q, struct{}{}, &affs); err != nil {
+		return nil, fmt.Errorf("selecting all affiliates: %w", err)
+	}
+
+	return affs, nil
+}
+
+// CreateClick records a visit landed with the referral code of the affiliate.
+func CreateClick(ctx context.Context, db sqlx.ExtContext, id string, affiliateID string, now time.Time) error {
+	in := struct {
+		ID          string    `db:"click_id"`
+		AffiliateID string    `db:"affiliate_id"`
+		CreatedAt   time.Time `db:"created_at"`
+	}{
+		ID:          id,
+		AffiliateID: affiliateID,
+		CreatedAt:   now,
+	}
+
+	const q = `
+	INSERT INTO affiliate_clicks
+		(click_id, affiliate_id, created_at)
+	VALUES
+		(:click_id, :affiliate_id, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("inserting click of affiliate[%s]: %w", affiliateID, err)
+	}
+
+	return nil
+}
+
+// SetRate sets the commission rate of a course, replacing the previous one.
+func SetRate(ctx context.Context, db sqlx.ExtContext, rate Rate) error {
+	const q = `
+	INSERT INTO commission_rates
+		(affiliate_id, course_id, rate)
+	VALUES
+		(:affiliate_id, :course_id, :rate)
+	ON CONFLICT (affiliate_id, course_id) DO UPDATE SET
+		rate = EXCLUDED.rate`
+
+	if err := database.NamedExecContext(ctx, db, q, rate); err != nil {
+		return fmt.Errorf("setting rate of course[%s]: %w", rate.CourseID, err)
+	}
+
+	return nil
+}
+
+// FetchRate returns the commission rate of the affiliate for the passed course.
+// The rate set for the affiliate takes precedence over the one set for
+// all affiliates, which takes precedence over the default rate of the affiliate.
+func FetchRate(ctx context.Context, db sqlx.ExtContext, affiliateID string, courseID string) (int, error) {
+	in := struct {
+		AffiliateID string `db:"affiliate_id"`
+		CourseID    string `db:"course_id"`
+	}{
+		AffiliateID: affiliateID,
+		CourseID:    courseID,
+	}
+
+	const q = `
+	SELECT
+		COALESCE(
+			(SELECT rate FROM commission_rates WHERE affiliate_id = :affiliate_id AND course_id = :course_id),
+			(SELECT rate FROM commission_rates WHERE affiliate_id = '' AND course_id = :course_id),
+			a.rate
+		) AS rate
+	FROM
+		affiliates AS a
+	WHERE
+		a.affiliate_id = :affiliate_id`
+
+	var r struct {
+		Rate int `db:"rate"`
+	}
+	if err := database.NamedQueryStruct(ctx, db, q, in, &r); err != nil {
+		return 0, fmt.Errorf("selecting rate of affiliate[%s] for course[%s]: %w", affiliateID, courseID, err)
+	}
+
+	return r.Rate, nil
+}
+
+// CreateCommission inserts a new commission.
+// Commissions already recorded for the same item are left untouched.
+func CreateCommission(ctx context.Context, db sqlx.ExtContext, c Commission) error {
+	const q = `
+	INSERT INTO commissions
+		(commission_id, affiliate_id, order_id, course_id, amount, currency, rate, status, created_at, updated_at)
+	VALUES
+		(:commission_id, :affiliate_id, :order_id, :course_id, :amount, :currency, :rate, :status, :created_at, :updated_at)
+	ON CONFLICT (order_id, course_id) DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, db, q, c); err != nil {
+		return fmt.Errorf("inserting commission: %w", err)
+	}
+
+	return nil
+}
+
+// ReverseCommission reverses the commission earned on the item of an order.
+// Commissions already paid are clawed back from the next payout.
+func ReverseCommission(ctx context.Context, db sqlx.ExtContext, orderID string, courseID string, now time.Time) error {
+	in := struct {
+		OrderID   string    `db:"order_id"`
+		CourseID  string    `db:"course_id"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}{
+		OrderID:   orderID,
+		CourseID:  courseID,
+		UpdatedAt: now,
+	}
+
+	const q = `
+	UPDATE commissions
+	SET
+		status = CASE WHEN status = 'paid' THEN 'clawback' ELSE 'reversed' END,
+		updated_at = :updated_at
+	WHERE
+		order_id = :order_id AND
+		course_id = :course_id AND
+		status IN ('owed', 'paid')`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("reversing commission of course[%s] of order[%s]: %w", courseID, orderID, err)
+	}
+
+	return nil
+}
+
+// FetchCommissions returns the commissions earned by the passed affiliate.
+func FetchCommissions(ctx context.Context, db sqlx.ExtContext, affiliateID string) ([]Commission, error) {
+	in := struct {
+		AffiliateID string `db:"affiliate_id"`
+	}{
+		AffiliateID: affiliateID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		commissions
+	WHERE
+		affiliate_id = :affiliate_id
+	ORDER BY
+		created_at DESC, course_id`
+
+	cs := []Commission{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &cs); err != nil {
+		return nil, fmt.Errorf("selecting commissions of affiliate[%s]: %w", affiliateID, err)
+	}
+
+	return cs, nil
+}
+
+// FetchStats returns the performance of the passed affiliate.
+// Only orders which reached success are counted as conversions.
+func FetchStats(ctx context.Context, db sqlx.ExtContext, aff Affiliate) (Stats, error) {
+	in := struct {
+		AffiliateID string `db:"affiliate_id"`
+	}{
+		AffiliateID: aff.ID,
+	}
+
+	const q = `
+	SELECT
+		(SELECT COUNT(*) FROM affiliate_clicks WHERE affiliate_id = :affiliate_id) AS clicks,
+		(
+			SELECT
+				COUNT(*)
+			FROM
+				orders
+			WHERE
+				affiliate_id = :affiliate_id AND
+				status IN ('success', 'partially_refunded', 'refunded')
+		) AS conversions`
+
+	var c struct {
+		Clicks      int `db:"clicks"`
+		Conversions int `db:"conversions"`
+	}
+	if err := database.NamedQueryStruct(ctx, db, q, in, &c); err != nil {
+		return Stats{}, fmt.Errorf("selecting stats of affiliate[%s]: %w", aff.ID, err)
+	}
+
+	const qo = `
+	SELECT
+		currency,
+		SUM(CASE WHEN status = 'owed' THEN amount ELSE -amount END) AS amount
+	FROM
+		commissions
+	WHERE
+		affiliate_id = :affiliate_id AND
+		status IN ('owed', 'clawback')
+	GROUP BY
+		currency
+	ORDER BY
+		currency`
+
+	owed := []money.Money{}
+	if err := database.NamedQuerySlice(ctx, db, qo, in, &owed); err != nil {
+		return Stats{}, fmt.Errorf("selecting owed commissions of affiliate[%s]: %w", aff.ID, err)
+	}
+
+	const qp = `
+	SELECT
+		currency,
+		SUM(amount) AS amount
+	FROM
+		commissions
+	WHERE
+		affiliate_id = :affiliate_id AND
+		status = 'paid'
+	GROUP BY
+		currency
+	ORDER BY
+		currency`
+
+	paid := []money.Money{}
+	if err := database.NamedQuerySlice(ctx, db, qp, in, &paid); err != nil {
+		return Stats{}, fmt.Errorf("selecting paid commissions of affiliate[%s]: %w", aff.ID, err)
+	}
+
+	return Stats{
+		Affiliate:   aff,
+		Clicks:      c.Clicks,
+		Conversions: c.Conversions,
+		Owed:        owed,
+		Paid:        paid,
+	}, nil
+}
+
+// FetchPayouts returns the amounts to be paid to the affiliates, for each currency,
+// for the commissions owed or clawed back up to the cutoff.
+// Owed commissions are paid net of the ones clawed back.
+func FetchPayouts(ctx context.Context, db sqlx.ExtContext, cutoff time.Time) ([]Payout, error) {
+	in := struct {
+		Cutoff time.Time `db:"cutoff"`
+	}{
+		Cutoff: cutoff,
+	}
+
+	const q = `
+	SELECT
+		a.affiliate_id,
+		a.code,
+		u.email,
+		c.currency,
+		SUM(CASE WHEN c.status = 'owed' THEN c.amount ELSE -c.amount END) AS amount
+	FROM
+		commissions AS c
+	JOIN
+		affiliates AS a ON a.affiliate_id = c.affiliate_id
+	JOIN
+		users AS u ON u.user_id = a.user_id
+	WHERE
+		((c.status = 'owed' AND c.created_at <= :cutoff) OR (c.status = 'clawback' AND c.updated_at <= :cutoff))
+	GROUP BY
+		a.affiliate_id, a.code, u.email, c.currency
+	HAVING
+		SUM(CASE WHEN c.status = 'owed' THEN c.amount ELSE -c.amount END) > 0
+	ORDER BY
+		a.code, c.currency`
+
+	ps := []Payout{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ps); err != nil {
+		return nil, fmt.Errorf("selecting payouts: %w", err)
+	}
+
+	return ps, nil
+}
+
+// Settle marks the commissions of the affiliate accrued up to the cutoff as
+// paid, together with the clawbacks deducted from them, as exported by
+// FetchPayouts. Currencies whose clawbacks exceed the owed commissions are
+// not paid out, and are left for the next payout.
+func Settle(ctx context.Context, db sqlx.ExtContext, affiliateID string, cutoff time.Time, now time.Time) error {
+	in := struct {
+		AffiliateID string    `db:"affiliate_id"`
+		Cutoff      time.Time `db:"cutoff"`
+		Now         time.Time `db:"now"`
+	}{
+		AffiliateID: affiliateID,
+		Cutoff:      cutoff,
+		Now:         now,
+	}
+
+	const q = `
+	UPDATE commissions
+	SET
+		status = CASE WHEN status = 'owed' THEN 'paid' ELSE 'reversed' END,
+		paid_at = CASE WHEN status = 'owed' THEN :now ELSE paid_at END,
+		updated_at = :now
+	WHERE
+		affiliate_id = :affiliate_id AND
+		((status = 'owed' AND created_at <= :cutoff) OR (status = 'clawback' AND updated_at <= :cutoff)) AND
+		currency IN (
+			SELECT
+				currency
+			FROM
+				commissions
+			WHERE
+				affiliate_id = :affiliate_id AND
+				((status = 'owed' AND created_at <= :cutoff) OR (status = 'clawback' AND updated_at <= :cutoff))
+			GROUP BY
+				currency
+			HAVING
+				SUM(CASE WHEN status = 'owed' THEN amount ELSE -amount END) > 0
+		)`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("settling commissions of affiliate[%s]: %w", affiliateID, err)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"time"
@@ -67,6 +68,32 @@ func Admin(s *scs.SessionManager) web.Middleware {
 				return weberr.NotAuthorized(fmt.Errorf("user role is not admin: %s", role))
 			}
 
+			uid, ok := s.Get(ctx, userKey).(string)
+			if !ok {
+				return weberr.NotAuthorized(errors.New("no userID in session"))
+			}
+
+			ctx = claims.Set(ctx, claims.Claims{UserID: uid, Role: role})
+
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// Identify returns a middleware that loads the claims of the
+// authenticated user, if any, on routes open to everyone.
+func Identify(s *scs.SessionManager) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			uid, uok := s.Get(ctx, userKey).(string)
+			role, rok := s.Get(ctx, roleKey).(string)
+
+			if uok && rok {
+				ctx = claims.Set(ctx, claims.Claims{UserID: uid, Role: role})
+			}
+
 			return handler(ctx, w, r)
 		}
 		return h
@@ -90,7 +117,12 @@ func LoadAndSave(s *scs.SessionManager) web.Middleware {
 				return err
 			}
 
-			bw := &bufferedResponseWriter{ResponseWriter: w}
+			bw := &bufferedResponseWriter{
+				ResponseWriter: w,
+				commit: func() error {
+					return commitSession(ctx, s, w)
+				},
+			}
 			if err := handler(ctx, bw, r); err != nil {
 				return err
 			}
@@ -99,40 +131,52 @@ func LoadAndSave(s *scs.SessionManager) web.Middleware {
 				r.MultipartForm.RemoveAll()
 			}
 
-			switch s.Status(ctx) {
-			case scs.Modified:
-				token, expiry, err := s.Commit(ctx)
-				if err != nil {
-					return err
-				}
-
-				s.WriteSessionCookie(ctx, w, token, expiry)
-			case scs.Destroyed:
-				s.WriteSessionCookie(ctx, w, "", time.Time{})
-			}
-
-			w.Header().Add("Vary", "Cookie")
-
-			if bw.code != 0 {
-				w.WriteHeader(bw.code)
+			if err := bw.send(); err != nil {
+				return err
 			}
-			w.Write(bw.buf.Bytes())
 
-			return nil
+			return bw.err
 		}
 		return h
 	}
 	return m
 }
 
+// commitSession writes the cookie of the session, if it has changed.
+func commitSession(ctx context.Context, s *scs.SessionManager, w http.ResponseWriter) error {
+	switch s.Status(ctx) {
+	case scs.Modified:
+		token, expiry, err := s.Commit(ctx)
+		if err != nil {
+			return err
+		}
+
+		s.WriteSessionCookie(ctx, w, token, expiry)
+	case scs.Destroyed:
+		s.WriteSessionCookie(ctx, w, "", time.Time{})
+	}
+
+	w.Header().Add("Vary", "Cookie")
+	return nil
+}
+
+// bufferedResponseWriter buffers responses until the session is committed,
+// since its cookie must be written before them. Responses too large to be
+// buffered are streamed once flushed.
 type bufferedResponseWriter struct {
 	http.ResponseWriter
 	buf         bytes.Buffer
 	code        int
 	wroteHeader bool
+	streaming   bool
+	commit      func() error
+	err         error
 }
 
 func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if bw.streaming {
+		return bw.ResponseWriter.Write(b)
+	}
 	return bw.buf.Write(b)
 }
 
@@ -140,7 +184,51 @@ func (bw *bufferedResponseWriter) WriteHeader(code int) {
 	if !bw.wroteHeader {
 		bw.code = code
 		bw.wroteHeader = true
+
+		if bw.streaming {
+			bw.ResponseWriter.WriteHeader(code)
+		}
+	}
+}
+
+func (bw *bufferedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if bw.streaming {
+		return io.Copy(bw.ResponseWriter, r)
+	}
+	return bw.buf.ReadFrom(r)
+}
+
+// Flush commits the session and sends the response written so far.
+// The rest of the response is then streamed without being buffered,
+// so the session cannot be modified after flushing.
+func (bw *bufferedResponseWriter) Flush() {
+	if err := bw.send(); err != nil && bw.err == nil {
+		bw.err = err
+	}
+	bw.streaming = true
+
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// send commits the session and writes the buffered response, if not streamed.
+func (bw *bufferedResponseWriter) send() error {
+	if bw.streaming {
+		return nil
 	}
+
+	if err := bw.commit(); err != nil {
+		return err
+	}
+
+	if bw.code != 0 {
+		bw.ResponseWriter.WriteHeader(bw.code)
+	}
+	bw.ResponseWriter.Write(bw.buf.Bytes())
+	bw.buf.Reset()
+
+	return nil
 }
 
 func (bw *bufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/signer"
+)
+
+// Signed returns a middleware that verifies the links signed by sg.
+// Requests of signed links are identified as the user the link has been
+// signed for, so that players can fetch media without the session cookie.
+// Tampered and expired links are rejected, while requests without a
+// signature are passed through.
+func Signed(sg *signer.Signer) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			q := r.URL.Query()
+			if !signer.IsSigned(q) {
+				return handler(ctx, w, r)
+			}
+
+			uid, err := sg.Verify(r.URL.EscapedPath(), q)
+			if err != nil {
+				if errors.Is(err, signer.ErrExpired) {
+					return weberr.NewError(err, "link expired", http.StatusForbidden)
+				}
+				return weberr.NewError(err, "invalid link", http.StatusForbidden)
+			}
+
+			// Links of free media are not signed for any user.
+			if uid != "" {
+				ctx = claims.Set(ctx, claims.Claims{UserID: uid, Role: claims.RoleUser})
+			}
+
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
@@ -0,0 +1,186 @@
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/money"
+)
+
+// ErrOwned is returned when all the courses of a bundle are already owned.
+var ErrOwned = errors.New("all the courses of the bundle are already owned")
+
+// Bundle models a set of courses sold together as a single product,
+// usually at a discounted price.
+//
+// Price is expressed in the minor unit of Currency.
+// Prices contains the prices of the bundle in other currencies.
+type Bundle struct {
+	ID          string        `json:"id" db:"bundle_id"`
+	Name        string        `json:"name" db:"name"`
+	Description string        `json:"description" db:"description"`
+	ImageURL    string        `json:"imageUrl" db:"image_url"`
+	Price       int64         `json:"price" db:"price"`
+	Currency    string        `json:"currency" db:"currency"`
+	Prices      []money.Money `json:"prices" db:"-"`
+	CourseIDs   []string      `json:"courseIds" db:"-"`
+	CreatedAt   time.Time     `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time     `json:"updatedAt" db:"updated_at"`
+	Version     int           `json:"-" db:"version"`
+}
+
+// BundleNew contains the information needed to
+// create a new bundle. A bundle is made of at least two courses.
+// Currency defaults to money.Default.
+type BundleNew struct {
+	Name        string        `json:"name" validate:"required"`
+	Description string        `json:"description" validate:"required"`
+	Price       int64         `json:"price" validate:"required,gte=0,lte=1000000"`
+	Currency    string        `json:"currency" validate:"omitempty,iso4217"`
+	Prices      []money.Money `json:"prices" validate:"omitempty,dive"`
+	ImageURL    string        `json:"imageUrl" validate:"required"`
+	CourseIDs   []string      `json:"courseIds" validate:"required,min=2,unique,dive,uuid"`
+}
+
+// BundleUp contains the information of a bundle
+// that can be updated. Passed prices and courses replace the existing ones.
+type BundleUp struct {
+	Name        *string        `json:"name"`
+	Description *string        `json:"description"`
+	Price       *int64         `json:"price" validate:"omitempty,gte=0,lte=1000000"`
+	Currency    *string        `json:"currency" validate:"omitempty,iso4217"`
+	Prices      *[]money.Money `json:"prices" validate:"omitempty,dive"`
+	ImageURL    *string        `json:"imageUrl"`
+	CourseIDs   *[]string      `json:"courseIds" validate:"omitempty,min=2,unique,dive,uuid"`
+}
+
+// PriceIn returns the price of the bundle in the passed currency.
+func (b Bundle) PriceIn(currency string) (money.Money, error) {
+	currency = strings.ToUpper(currency)
+
+	if b.Currency == currency {
+		return money.New(b.Price, b.Currency), nil
+	}
+
+	for _, p := range b.Prices {
+		if p.Currency == currency {
+			return p, nil
+		}
+	}
+
+	return money.Money{}, fmt.Errorf("bundle[%s] in %s: %w", b.ID, currency, course.ErrNoPrice)
+}
+
+// Quote is the price charged for a bundle to a user, together with
+// the courses the bundle grants to them.
+// Shares contains the part of Price charged for each of the Courses.
+type Quote struct {
+	Bundle  Bundle
+	Price   money.Money
+	Courses []course.Course
+	Shares  []int64
+}
+
+// Credit prices the bundle in the passed currency for a user already
+// owning some of its courses. Owned courses are not granted again and
+// their list price is credited pro-rata on the price of the bundle.
+// Courses are the ones of the bundle.
+// It returns ErrOwned if all the courses are already owned.
+func Credit(b Bundle, courses []course.Course, owned map[string]bool, currency string) (Quote, error) {
+	price, err := b.PriceIn(currency)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	var list, left []int64
+	var granted []course.Course
+	for _, c := range courses {
+		p, err := c.PriceIn(currency)
+		if err != nil {
+			return Quote{}, err
+		}
+
+		list = append(list, p.Amount)
+		if owned[c.ID] {
+			continue
+		}
+
+		c.Price = p.Amount
+		c.Currency = p.Currency
+		granted = append(granted, c)
+		left = append(left, p.Amount)
+	}
+
+	if len(granted) == 0 {
+		return Quote{}, fmt.Errorf("bundle[%s]: %w", b.ID, ErrOwned)
+	}
+
+	// The credit is the share of the price of the owned courses.
+	if len(granted) < len(courses) {
+		price.Amount = prorate(price.Amount, list, left)
+	}
+
+	q := Quote{
+		Bundle:  b,
+		Price:   price,
+		Courses: granted,
+		Shares:  Split(price.Amount, left),
+	}
+
+	return q, nil
+}
+
+// prorate returns the part of amount proportional to the weights of part
+// over the weights of all, rounded half up. Weights are split evenly
+// if they are all zero.
+func prorate(amount int64, all []int64, part []int64) int64 {
+	var tot, p int64
+	for _, w := range all {
+		tot += w
+	}
+	for _, w := range part {
+		p += w
+	}
+
+	if tot == 0 {
+		tot, p = int64(len(all)), int64(len(part))
+	}
+
+	return (amount*p + tot/2) / tot
+}
+
+// Split divides the amount proportionally to the passed weights.
+// What's left by the rounding is assigned to the first parts, so that
+// parts always sum up to the amount. Weights are split evenly if they
+// are all zero.
+func Split(amount int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+
+	var tot int64
+	for _, w := range weights {
+		tot += w
+	}
+
+	left := amount
+	for i, w := range weights {
+		if tot == 0 {
+			parts[i] = amount / int64(len(weights))
+		} else {
+			parts[i] = amount * w / tot
+		}
+		left -= parts[i]
+	}
+
+	for i := 0; left > 0; i = (i + 1) % len(parts) {
+		parts[i]++
+		left--
+	}
+
+	return parts
+}
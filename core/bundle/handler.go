@@ -0,0 +1,220 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleCreate allows administrators to add new bundles of existing courses.
+func HandleCreate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var b BundleNew
+		if err := web.Decode(w, r, &b); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(b); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+
+		bundle := Bundle{
+			ID:          validate.GenerateID(),
+			Name:        b.Name,
+			Description: b.Description,
+			Price:       b.Price,
+			Currency:    b.Currency,
+			Prices:      b.Prices,
+			ImageURL:    b.ImageURL,
+			CourseIDs:   b.CourseIDs,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if bundle.Currency == "" {
+			bundle.Currency = money.Default
+		}
+		if bundle.Prices == nil {
+			bundle.Prices = []money.Money{}
+		}
+
+		if err := check(ctx, db, bundle); err != nil {
+			return err
+		}
+
+		err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+			return Create(ctx, tx, bundle)
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrDBDuplicatedEntry) {
+				return weberr.NewError(err, "passed bundle already exists", http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, bundle, http.StatusCreated)
+	}
+}
+
+// HandleUpdate allows administrators to update existing bundles.
+func HandleUpdate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		bundleID := web.Param(r, "id")
+
+		if err := validate.CheckID(bundleID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		var bup BundleUp
+		if err := web.Decode(w, r, &bup); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(bup); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		bundle, err := Fetch(ctx, db, bundleID)
+		if err != nil {
+			err := fmt.Errorf("fetching passed bundle[%s]: %w", bundleID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if bup.Name != nil {
+			bundle.Name = *bup.Name
+		}
+		if bup.Description != nil {
+			bundle.Description = *bup.Description
+		}
+		if bup.Price != nil {
+			bundle.Price = *bup.Price
+		}
+		if bup.Currency != nil {
+			bundle.Currency = *bup.Currency
+		}
+		if bup.Prices != nil {
+			bundle.Prices = *bup.Prices
+		}
+		if bup.ImageURL != nil {
+			bundle.ImageURL = *bup.ImageURL
+		}
+		if bup.CourseIDs != nil {
+			bundle.CourseIDs = *bup.CourseIDs
+		}
+		bundle.UpdatedAt = time.Now().UTC()
+
+		if err := check(ctx, db, bundle); err != nil {
+			return err
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			bundle, err = Update(ctx, tx, bundle)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("updating bundle[%s]: %w", bundleID, err)
+		}
+
+		return web.Respond(ctx, w, bundle, http.StatusOK)
+	}
+}
+
+// HandleDelete allows administrators to remove bundles from sale.
+// Courses already bought through the bundle are kept by their owners.
+func HandleDelete(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		bundleID := web.Param(r, "id")
+
+		if err := validate.CheckID(bundleID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if _, err := Fetch(ctx, db, bundleID); err != nil {
+			err := fmt.Errorf("fetching passed bundle[%s]: %w", bundleID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if err := Delete(ctx, db, bundleID); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleList allows users to fetch all available bundles.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		bundles, err := FetchAll(ctx, db)
+		if err != nil {
+			return fmt.Errorf("fetching all bundles: %w", err)
+		}
+
+		return web.Respond(ctx, w, bundles, http.StatusOK)
+	}
+}
+
+// HandleShow allows users to fetch the information of a specific bundle.
+func HandleShow(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		bundleID := web.Param(r, "id")
+
+		if err := validate.CheckID(bundleID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		bundle, err := Fetch(ctx, db, bundleID)
+		if err != nil {
+			err := fmt.Errorf("fetching bundle[%s]: %w", bundleID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, bundle, http.StatusOK)
+	}
+}
+
+// check verifies that the bundle has at most one price per currency
+// and that all its courses exist.
+func check(ctx context.Context, db sqlx.ExtContext, b Bundle) error {
+	seen := map[string]bool{b.Currency: true}
+	for _, p := range b.Prices {
+		if seen[p.Currency] {
+			err := fmt.Errorf("bundle has more prices in %s", p.Currency)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+		seen[p.Currency] = true
+	}
+
+	for _, id := range b.CourseIDs {
+		if _, err := course.Fetch(ctx, db, id); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				err := fmt.Errorf("course[%s] of bundle not found", id)
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching course[%s] of bundle: %w", id, err)
+		}
+	}
+
+	return nil
+}
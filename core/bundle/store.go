@@ -0,0 +1,285 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+)
+
+// Create inserts a new bundle together with its prices and courses.
+func Create(ctx context.Context, db sqlx.ExtContext, bundle Bundle) error {
+	const q = `
+	INSERT INTO bundles
+		(bundle_id, name, description, price, currency, image_url, created_at, updated_at)
+	VALUES
+	(:bundle_id, :name, :description, :price, :currency, :image_url, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, bundle); err != nil {
+		return fmt.Errorf("inserting bundle: %w", err)
+	}
+
+	if err := createPrices(ctx, db, bundle); err != nil {
+		return err
+	}
+
+	return createCourses(ctx, db, bundle)
+}
+
+// Update updates the details of a specific bundle, replacing its prices
+// and courses. It relies on optimistic lock to deal with data races.
+func Update(ctx context.Context, db sqlx.ExtContext, bundle Bundle) (Bundle, error) {
+	const q = `
+	UPDATE bundles
+	SET
+		name = :name,
+		description = :description,
+		price = :price,
+		currency = :currency,
+		image_url = :image_url,
+		updated_at = :updated_at,
+		version = version + 1
+	WHERE
+		bundle_id = :bundle_id AND
+		version = :version
+	RETURNING version`
+
+	v := struct {
+		Version int `db:"version"`
+	}{}
+
+	if err := database.NamedQueryStruct(ctx, db, q, bundle, &v); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Bundle{}, fmt.Errorf("updating bundle[%s]: version conflict", bundle.ID)
+		}
+		return Bundle{}, fmt.Errorf("updating bundle[%s]: %w", bundle.ID, err)
+	}
+
+	bundle.Version = v.Version
+
+	in := struct {
+		ID string `db:"bundle_id"`
+	}{
+		ID: bundle.ID,
+	}
+
+	const qp = `
+	DELETE FROM
+		bundle_prices
+	WHERE
+		bundle_id = :bundle_id`
+
+	if err := database.NamedExecContext(ctx, db, qp, in); err != nil {
+		return Bundle{}, fmt.Errorf("deleting prices of bundle[%s]: %w", bundle.ID, err)
+	}
+
+	const qc = `
+	DELETE FROM
+		bundle_courses
+	WHERE
+		bundle_id = :bundle_id`
+
+	if err := database.NamedExecContext(ctx, db, qc, in); err != nil {
+		return Bundle{}, fmt.Errorf("deleting courses of bundle[%s]: %w", bundle.ID, err)
+	}
+
+	if err := createPrices(ctx, db, bundle); err != nil {
+		return Bundle{}, err
+	}
+
+	if err := createCourses(ctx, db, bundle); err != nil {
+		return Bundle{}, err
+	}
+
+	return bundle, nil
+}
+
+// Delete deletes a bundle. Orders of the bundle are left untouched.
+func Delete(ctx context.Context, db sqlx.ExtContext, id string) error {
+	in := struct {
+		ID string `db:"bundle_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	DELETE FROM
+		bundles
+	WHERE
+		bundle_id = :bundle_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting bundle[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// Fetch returns information of a specific bundle, with its prices and courses.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Bundle, error) {
+	in := struct {
+		ID string `db:"bundle_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		bundles
+	WHERE
+		bundle_id = :bundle_id`
+
+	var bundle Bundle
+	if err := database.NamedQueryStruct(ctx, db, q, in, &bundle); err != nil {
+		return Bundle{}, fmt.Errorf("selecting bundle[%s]: %w", id, err)
+	}
+
+	return withDetails(ctx, db, bundle)
+}
+
+// FetchAll returns all bundles, with their prices and courses.
+func FetchAll(ctx context.Context, db sqlx.ExtContext) ([]Bundle, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		bundles
+	ORDER BY
+		bundle_id`
+
+	bs := []Bundle{}
+	if err := database.NamedQuerySlice(ctx, db, q, struct{}{}, &bs); err != nil {
+		return nil, fmt.Errorf("selecting all bundles: %w", err)
+	}
+
+	for i := range bs {
+		b, err := withDetails(ctx, db, bs[i])
+		if err != nil {
+			return nil, err
+		}
+		bs[i] = b
+	}
+
+	return bs, nil
+}
+
+// FetchCourses returns the latest details of the courses of the bundle.
+func FetchCourses(ctx context.Context, db sqlx.ExtContext, bundle Bundle) ([]course.Course, error) {
+	courses := make([]course.Course, 0, len(bundle.CourseIDs))
+	for _, id := range bundle.CourseIDs {
+		c, err := course.Fetch(ctx, db, id)
+		if err != nil {
+			return nil, fmt.Errorf("fetching course[%s] of bundle[%s]: %w", id, bundle.ID, err)
+		}
+		courses = append(courses, c)
+	}
+
+	return courses, nil
+}
+
+// createPrices inserts the prices of the bundle in other currencies.
+func createPrices(ctx context.Context, db sqlx.ExtContext, bundle Bundle) error {
+	for _, p := range bundle.Prices {
+		in := struct {
+			BundleID string `db:"bundle_id"`
+			Currency string `db:"currency"`
+			Amount   int64  `db:"amount"`
+		}{
+			BundleID: bundle.ID,
+			Currency: p.Currency,
+			Amount:   p.Amount,
+		}
+
+		const q = `
+		INSERT INTO bundle_prices
+			(bundle_id, currency, amount)
+		VALUES
+			(:bundle_id, :currency, :amount)`
+
+		if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+			return fmt.Errorf("inserting price[%s] of bundle[%s]: %w", p.Currency, bundle.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// createCourses inserts the courses of the bundle.
+func createCourses(ctx context.Context, db sqlx.ExtContext, bundle Bundle) error {
+	for _, id := range bundle.CourseIDs {
+		in := struct {
+			BundleID string `db:"bundle_id"`
+			CourseID string `db:"course_id"`
+		}{
+			BundleID: bundle.ID,
+			CourseID: id,
+		}
+
+		const q = `
+		INSERT INTO bundle_courses
+			(bundle_id, course_id)
+		VALUES
+			(:bundle_id, :course_id)`
+
+		if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+			return fmt.Errorf("inserting course[%s] of bundle[%s]: %w", id, bundle.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// withDetails loads the prices of the passed bundle in other currencies
+// and the ids of its courses.
+func withDetails(ctx context.Context, db sqlx.ExtContext, b Bundle) (Bundle, error) {
+	in := struct {
+		ID string `db:"bundle_id"`
+	}{
+		ID: b.ID,
+	}
+
+	const qp = `
+	SELECT
+		amount, currency
+	FROM
+		bundle_prices
+	WHERE
+		bundle_id = :bundle_id
+	ORDER BY
+		currency`
+
+	b.Prices = []money.Money{}
+	if err := database.NamedQuerySlice(ctx, db, qp, in, &b.Prices); err != nil {
+		return Bundle{}, fmt.Errorf("selecting prices of bundle[%s]: %w", b.ID, err)
+	}
+
+	const qc = `
+	SELECT
+		course_id
+	FROM
+		bundle_courses
+	WHERE
+		bundle_id = :bundle_id
+	ORDER BY
+		course_id`
+
+	var cs []struct {
+		ID string `db:"course_id"`
+	}
+	if err := database.NamedQuerySlice(ctx, db, qc, in, &cs); err != nil {
+		return Bundle{}, fmt.Errorf("selecting courses of bundle[%s]: %w", b.ID, err)
+	}
+
+	b.CourseIDs = make([]string, 0, len(cs))
+	for _, c := range cs {
+		b.CourseIDs = append(b.CourseIDs, c.ID)
+	}
+
+	return b, nil
+}
@@ -7,11 +7,13 @@ import (
 // Cart models the users' carts.
 // Each user can have only a cart at a time.
 type Cart struct {
-	UserID    string    `json:"-" db:"user_id"`
-	CreatedAt time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
-	Version   int       `json:"-" db:"version"`
-	Items     []Item    `json:"items" db:"-"`
+	UserID     string    `json:"-" db:"user_id"`
+	CouponCode string    `json:"couponCode" db:"coupon_code"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
+	Version    int       `json:"-" db:"version"`
+	Items      []Item    `json:"items" db:"-"`
+	Bundles    []Bundle  `json:"bundles" db:"-"`
 }
 
 // Item models the item of a cart.
@@ -28,3 +30,18 @@ type Item struct {
 type ItemNew struct {
 	CourseID string `json:"courseId" db:"course_id"`
 }
+
+// Bundle models a bundle of courses in a cart.
+// A cart can have many bundles.
+type Bundle struct {
+	UserID    string    `json:"-" db:"user_id"`
+	BundleID  string    `json:"bundleId" db:"bundle_id"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// BundleNew models the data required to insert a
+// new bundle on the user's cart.
+type BundleNew struct {
+	BundleID string `json:"bundleId" db:"bundle_id" validate:"required,uuid"`
+}
@@ -0,0 +1,44 @@
+package cart
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/user"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// Currency returns the currency the user's cart is priced in.
+// The currency passed in the request query takes precedence over
+// the one preferred by the user. The default currency is used otherwise.
+func Currency(ctx context.Context, db sqlx.ExtContext, r *http.Request, userID string) (string, error) {
+	if cur := r.URL.Query().Get("currency"); cur != "" {
+		q := struct {
+			Currency string `validate:"iso4217"`
+		}{
+			Currency: strings.ToUpper(cur),
+		}
+
+		if err := validate.Check(q); err != nil {
+			return "", weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		return q.Currency, nil
+	}
+
+	usr, err := user.Fetch(ctx, db, userID)
+	if err != nil {
+		return "", fmt.Errorf("fetching user[%s]: %w", userID, err)
+	}
+
+	if usr.Currency != "" {
+		return usr.Currency, nil
+	}
+
+	return money.Default, nil
+}
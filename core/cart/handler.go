@@ -10,7 +10,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/polldo/govod/api/web"
 	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/bundle"
 	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/core/coupon"
 	"github.com/polldo/govod/core/course"
 	"github.com/polldo/govod/database"
 	"github.com/polldo/govod/validate"
@@ -29,7 +31,7 @@ func HandleShow(db *sqlx.DB) web.Handler {
 		cart, err := Fetch(ctx, db, clm.UserID)
 		if err != nil {
 			if errors.Is(err, database.ErrDBNotFound) {
-				return web.Respond(ctx, w, Cart{Items: []Item{}}, http.StatusOK)
+				return web.Respond(ctx, w, Cart{Items: []Item{}, Bundles: []Bundle{}}, http.StatusOK)
 			}
 			return fmt.Errorf("fetching user[%s] cart: %w", clm.UserID, err)
 		}
@@ -39,6 +41,11 @@ func HandleShow(db *sqlx.DB) web.Handler {
 			return fmt.Errorf("fetching user[%s] cart items: %w", clm.UserID, err)
 		}
 
+		cart.Bundles, err = FetchBundles(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("fetching user[%s] cart bundles: %w", clm.UserID, err)
+		}
+
 		return web.Respond(ctx, w, cart, http.StatusOK)
 	}
 }
@@ -135,3 +142,177 @@ func HandleDeleteItem(db *sqlx.DB) web.Handler {
 		return web.Respond(ctx, w, nil, http.StatusNoContent)
 	}
 }
+
+// HandleCreateBundle adds a bundle of courses in the user's cart.
+// Bundles whose courses are all already owned by the user are rejected.
+func HandleCreateBundle(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var bnew BundleNew
+		if err := web.Decode(w, r, &bnew); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(bnew); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		b, err := bundle.Fetch(ctx, db, bnew.BundleID)
+		if err != nil {
+			err := fmt.Errorf("fetching bundle[%s]: %w", bnew.BundleID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		owned, err := course.FetchByOwner(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("checking if bundle[%s] is already owned by user[%s]: %w",
+				bnew.BundleID,
+				clm.UserID,
+				err,
+			)
+		}
+
+		own := make(map[string]bool, len(owned))
+		for _, o := range owned {
+			own[o.ID] = true
+		}
+
+		left := 0
+		for _, id := range b.CourseIDs {
+			if !own[id] {
+				left++
+			}
+		}
+
+		if left == 0 {
+			err := fmt.Errorf("bundle[%s]: %w", b.ID, bundle.ErrOwned)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if _, err := Upsert(ctx, db, clm.UserID); err != nil {
+			return fmt.Errorf("upserting user[%s] cart: %w", clm.UserID, err)
+		}
+
+		now := time.Now().UTC()
+		item := Bundle{
+			UserID:    clm.UserID,
+			BundleID:  b.ID,
+			UpdatedAt: now,
+			CreatedAt: now,
+		}
+
+		if err := CreateBundle(ctx, db, item); err != nil {
+			return fmt.Errorf("creating cart bundle[%s] for user[%s]: %w", item.BundleID, clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, item, http.StatusCreated)
+	}
+}
+
+// HandleDeleteBundle deletes a bundle from the user's cart.
+func HandleDeleteBundle(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		bundleID := web.Param(r, "bundle_id")
+
+		if err := validate.CheckID(bundleID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Upsert(ctx, db, clm.UserID); err != nil {
+			return fmt.Errorf("upserting user[%s] cart: %w", clm.UserID, err)
+		}
+
+		if err := DeleteBundle(ctx, db, clm.UserID, bundleID); err != nil {
+			return fmt.Errorf("deleting user[%s] cart bundle: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleApplyCoupon applies a coupon to the user's cart.
+// It returns the cart totals discounted by the coupon, in the cart currency.
+func HandleApplyCoupon(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var code coupon.Code
+		if err := web.Decode(w, r, &code); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(code); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		currency, err := Currency(ctx, db, r, clm.UserID)
+		if err != nil {
+			return err
+		}
+
+		courses, err := FetchCourses(ctx, db, clm.UserID, currency)
+		if err != nil {
+			if errors.Is(err, course.ErrNoPrice) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching user[%s] cart courses: %w", clm.UserID, err)
+		}
+
+		cp, err := coupon.Resolve(ctx, db, code.Code, clm.UserID, courses)
+		if err != nil {
+			if errors.Is(err, coupon.ErrNotApplicable) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("resolving coupon for user[%s]: %w", clm.UserID, err)
+		}
+
+		cart, err := Upsert(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("upserting user[%s] cart: %w", clm.UserID, err)
+		}
+
+		cart.CouponCode = cp.Code
+		if err := UpdateCoupon(ctx, db, cart); err != nil {
+			return fmt.Errorf("applying coupon to user[%s] cart: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, coupon.Apply(cp, courses), http.StatusOK)
+	}
+}
+
+// HandleDeleteCoupon removes the coupon applied to the user's cart.
+func HandleDeleteCoupon(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		cart, err := Upsert(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("upserting user[%s] cart: %w", clm.UserID, err)
+		}
+
+		cart.CouponCode = ""
+		if err := UpdateCoupon(ctx, db, cart); err != nil {
+			return fmt.Errorf("removing coupon from user[%s] cart: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/course"
 	"github.com/polldo/govod/database"
 )
 
@@ -99,6 +100,24 @@ func Update(ctx context.Context, db sqlx.ExtContext, cart Cart) (Cart, error) {
 	return cart, nil
 }
 
+// UpdateCoupon sets the coupon code applied to the user's cart.
+// An empty code removes the coupon.
+func UpdateCoupon(ctx context.Context, db sqlx.ExtContext, cart Cart) error {
+	const q = `
+	UPDATE carts
+	SET
+		coupon_code = :coupon_code,
+		updated_at = :updated_at
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, db, q, cart); err != nil {
+		return fmt.Errorf("updating coupon of cart of user[%s]: %w", cart.UserID, err)
+	}
+
+	return nil
+}
+
 // Upsert updates a user's cart if it exists.
 // It creates it otherwise.
 func Upsert(ctx context.Context, db sqlx.ExtContext, userID string) (Cart, error) {
@@ -152,6 +171,31 @@ func FetchItems(ctx context.Context, db sqlx.ExtContext, userID string) ([]Item,
 	return ci, nil
 }
 
+// FetchCourses returns the latest details of the courses in the user's cart,
+// priced in the passed currency.
+func FetchCourses(ctx context.Context, db sqlx.ExtContext, userID string, currency string) ([]course.Course, error) {
+	items, err := FetchItems(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	courses := make([]course.Course, 0, len(items))
+	for _, it := range items {
+		c, err := course.Fetch(ctx, db, it.CourseID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching course[%s]: %w", it.CourseID, err)
+		}
+
+		if c, err = c.In(currency); err != nil {
+			return nil, err
+		}
+
+		courses = append(courses, c)
+	}
+
+	return courses, nil
+}
+
 // CreateItem inserts a new item in the user's cart.
 func CreateItem(ctx context.Context, db sqlx.ExtContext, item Item) error {
 	const q = `
@@ -189,3 +233,67 @@ func DeleteItem(ctx context.Context, db sqlx.ExtContext, userID string, courseID
 
 	return nil
 }
+
+// FetchBundles returns all the bundles in the user's cart.
+func FetchBundles(ctx context.Context, db sqlx.ExtContext, userID string) ([]Bundle, error) {
+	in := struct {
+		ID string `db:"user_id"`
+	}{
+		ID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		cart_bundles
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		bundle_id`
+
+	cb := []Bundle{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &cb); err != nil {
+		return nil, fmt.Errorf("selecting cart bundles of user[%s]: %w", userID, err)
+	}
+
+	return cb, nil
+}
+
+// CreateBundle inserts a new bundle in the user's cart.
+func CreateBundle(ctx context.Context, db sqlx.ExtContext, b Bundle) error {
+	const q = `
+	INSERT INTO cart_bundles
+		(user_id, bundle_id, created_at, updated_at)
+	VALUES
+	(:user_id, :bundle_id, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, b); err != nil {
+		return fmt.Errorf("inserting cart bundle: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBundle drops a bundle from the user's cart.
+func DeleteBundle(ctx context.Context, db sqlx.ExtContext, userID string, bundleID string) error {
+	in := struct {
+		UserID   string `db:"user_id"`
+		BundleID string `db:"bundle_id"`
+	}{
+		UserID:   userID,
+		BundleID: bundleID,
+	}
+
+	const q = `
+	DELETE FROM
+		cart_bundles
+	WHERE
+		user_id = :user_id AND bundle_id = :bundle_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting cart bundle: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package coupon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/money"
+)
+
+// ErrNotApplicable is returned when a coupon cannot be applied to a cart.
+var ErrNotApplicable = errors.New("coupon not applicable")
+
+// Kind models the supported types of discount.
+type Kind string
+
+const (
+	// Percentage coupons discount a percentage of the price
+	// of each course in their scope.
+	Percentage Kind = "percentage"
+
+	// Fixed coupons discount a fixed amount, spread across
+	// the courses in their scope.
+	Fixed Kind = "fixed"
+)
+
+// Coupon models discount codes.
+// Coupons without courses apply to all the courses.
+// Zero limits mean no limit.
+//
+// Fixed values and minimum totals are expressed in the minor unit of
+// Currency. Coupons with a currency only apply to carts priced in it.
+type Coupon struct {
+	ID             string     `json:"id" db:"coupon_id"`
+	Code           string     `json:"code" db:"code"`
+	Kind           Kind       `json:"kind" db:"kind"`
+	Value          int64      `json:"value" db:"value"`
+	MinTotal       int64      `json:"minTotal" db:"min_total"`
+	Currency       string     `json:"currency" db:"currency"`
+	MaxRedemptions int        `json:"maxRedemptions" db:"max_redemptions"`
+	MaxPerUser     int        `json:"maxPerUser" db:"max_per_user"`
+	StartsAt       *time.Time `json:"startsAt" db:"starts_at"`
+	EndsAt         *time.Time `json:"endsAt" db:"ends_at"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+	CourseIDs      []string   `json:"courseIds" db:"-"`
+}
+
+// CouponNew contains the information needed to create a new coupon.
+type CouponNew struct {
+	Code           string     `json:"code" validate:"required,alphanum,min=3,max=32"`
+	Kind           Kind       `json:"kind" validate:"required,oneof=percentage fixed"`
+	Value          int64      `json:"value" validate:"required,gt=0"`
+	MinTotal       int64      `json:"minTotal" validate:"gte=0"`
+	Currency       string     `json:"currency" validate:"omitempty,iso4217"`
+	MaxRedemptions int        `json:"maxRedemptions" validate:"gte=0"`
+	MaxPerUser     int        `json:"maxPerUser" validate:"gte=0"`
+	StartsAt       *time.Time `json:"startsAt"`
+	EndsAt         *time.Time `json:"endsAt"`
+	CourseIDs      []string   `json:"courseIds" validate:"omitempty,dive,uuid"`
+}
+
+// Usage counts the redemptions of a coupon.
+type Usage struct {
+	Total  int `db:"total"`
+	ByUser int `db:"by_user"`
+}
+
+// Redemption binds a coupon to the order in which it has been used.
+type Redemption struct {
+	CouponID  string    `json:"couponId" db:"coupon_id"`
+	OrderID   string    `json:"orderId" db:"order_id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	Discount  int64     `json:"discount" db:"discount"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Code models the request of applying a coupon to the cart.
+type Code struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// Line contains the price of a course before and after the discount.
+type Line struct {
+	CourseID string `json:"courseId"`
+	Price    int64  `json:"price"`
+	Discount int64  `json:"discount"`
+	Total    int64  `json:"total"`
+}
+
+// Quote contains the totals of a set of courses, discounted
+// by a coupon if any. Amounts are expressed in the minor unit of Currency.
+type Quote struct {
+	Code     string `json:"code"`
+	Currency string `json:"currency"`
+	Lines    []Line `json:"lines"`
+	Subtotal int64  `json:"subtotal"`
+	Discount int64  `json:"discount"`
+	Total    int64  `json:"total"`
+}
+
+// Covers returns whether the passed course is in the scope of the coupon.
+func (c Coupon) Covers(courseID string) bool {
+	if len(c.CourseIDs) == 0 {
+		return true
+	}
+	for _, id := range c.CourseIDs {
+		if id == courseID {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies that the coupon can be applied to the passed courses
+// at the passed time, given its current usage.
+// Courses must be priced in the same currency.
+func (c Coupon) Check(courses []course.Course, usage Usage, now time.Time) error {
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return fmt.Errorf("coupon %s not valid yet: %w", c.Code, ErrNotApplicable)
+	}
+
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return fmt.Errorf("coupon %s expired: %w", c.Code, ErrNotApplicable)
+	}
+
+	if c.MaxRedemptions > 0 && usage.Total >= c.MaxRedemptions {
+		return fmt.Errorf("coupon %s exhausted: %w", c.Code, ErrNotApplicable)
+	}
+
+	if c.MaxPerUser > 0 && usage.ByUser >= c.MaxPerUser {
+		return fmt.Errorf("coupon %s already used: %w", c.Code, ErrNotApplicable)
+	}
+
+	var tot int64
+	var covered bool
+	for _, crs := range courses {
+		if c.Currency != "" && crs.Currency != c.Currency {
+			return fmt.Errorf("coupon %s only applies to %s prices: %w", c.Code, c.Currency, ErrNotApplicable)
+		}
+		tot += crs.Price
+		covered = covered || c.Covers(crs.ID)
+	}
+
+	if tot < c.MinTotal {
+		least := money.New(c.MinTotal, c.Currency)
+		return fmt.Errorf("coupon %s requires a total of at least %s: %w", c.Code, least, ErrNotApplicable)
+	}
+
+	if !covered {
+		return fmt.Errorf("coupon %s does not apply to these courses: %w", c.Code, ErrNotApplicable)
+	}
+
+	return nil
+}
+
+// Apply computes the totals of the passed courses discounted by the
+// coupon, which can be nil. The coupon must have been checked before.
+// Courses must be priced in the same currency.
+//
+// Fixed discounts are spread across the covered courses proportionally
+// to their price, so that the price paid for each course is known.
+func Apply(c *Coupon, courses []course.Course) Quote {
+	q := Quote{Lines: make([]Line, 0, len(courses))}
+	for _, crs := range courses {
+		q.Currency = crs.Currency
+		q.Lines = append(q.Lines, Line{CourseID: crs.ID, Price: crs.Price})
+		q.Subtotal += crs.Price
+	}
+
+	if c != nil {
+		q.Code = c.Code
+
+		switch c.Kind {
+		case Percentage:
+			pct := min(c.Value, 100)
+			for i := range q.Lines {
+				if c.Covers(q.Lines[i].CourseID) {
+					q.Lines[i].Discount = q.Lines[i].Price * pct / 100
+				}
+			}
+
+		case Fixed:
+			var covered int64
+			for _, l := range q.Lines {
+				if c.Covers(l.CourseID) {
+					covered += l.Price
+				}
+			}
+
+			amount := min(c.Value, covered)
+			left := amount
+			for i := range q.Lines {
+				if !c.Covers(q.Lines[i].CourseID) || covered == 0 {
+					continue
+				}
+				q.Lines[i].Discount = amount * q.Lines[i].Price / covered
+				left -= q.Lines[i].Discount
+			}
+
+			// Assign what's left by the rounding to the first courses with room.
+			for i := range q.Lines {
+				if left == 0 {
+					break
+				}
+				if !c.Covers(q.Lines[i].CourseID) {
+					continue
+				}
+				d := min(left, q.Lines[i].Price-q.Lines[i].Discount)
+				q.Lines[i].Discount += d
+				left -= d
+			}
+		}
+	}
+
+	for i := range q.Lines {
+		q.Lines[i].Total = q.Lines[i].Price - q.Lines[i].Discount
+		q.Discount += q.Lines[i].Discount
+	}
+	q.Total = q.Subtotal - q.Discount
+
+	return q
+}
@@ -0,0 +1,140 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleCreate allows administrators to add new coupons.
+func HandleCreate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var cn CouponNew
+		if err := web.Decode(w, r, &cn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(cn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if cn.Kind == Percentage && cn.Value > 100 {
+			err := errors.New("percentage cannot be greater than 100")
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if (cn.Kind == Fixed || cn.MinTotal > 0) && cn.Currency == "" {
+			err := errors.New("fixed values and minimum totals require a currency")
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if cn.StartsAt != nil && cn.EndsAt != nil && !cn.EndsAt.After(*cn.StartsAt) {
+			err := errors.New("coupon must end after its start")
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+
+		coupon := Coupon{
+			ID:             validate.GenerateID(),
+			Code:           strings.ToUpper(cn.Code),
+			Kind:           cn.Kind,
+			Value:          cn.Value,
+			MinTotal:       cn.MinTotal,
+			Currency:       cn.Currency,
+			MaxRedemptions: cn.MaxRedemptions,
+			MaxPerUser:     cn.MaxPerUser,
+			StartsAt:       utc(cn.StartsAt),
+			EndsAt:         utc(cn.EndsAt),
+			CourseIDs:      cn.CourseIDs,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+
+		if coupon.CourseIDs == nil {
+			coupon.CourseIDs = []string{}
+		}
+
+		err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+			return Create(ctx, tx, coupon)
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrDBDuplicatedEntry) {
+				return weberr.NewError(err, "passed coupon already exists", http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, coupon, http.StatusCreated)
+	}
+}
+
+// HandleList allows administrators to fetch all the coupons.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		coupons, err := FetchAll(ctx, db)
+		if err != nil {
+			return fmt.Errorf("fetching all coupons: %w", err)
+		}
+
+		return web.Respond(ctx, w, coupons, http.StatusOK)
+	}
+}
+
+// HandleShow allows administrators to fetch a specific coupon.
+func HandleShow(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		couponID := web.Param(r, "id")
+
+		if err := validate.CheckID(couponID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		coupon, err := Fetch(ctx, db, couponID)
+		if err != nil {
+			err := fmt.Errorf("fetching coupon[%s]: %w", couponID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, coupon, http.StatusOK)
+	}
+}
+
+// HandleDelete allows administrators to delete coupons.
+// Orders that already used the coupon keep their discounted prices.
+func HandleDelete(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		couponID := web.Param(r, "id")
+
+		if err := validate.CheckID(couponID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if err := Delete(ctx, db, couponID); err != nil {
+			return fmt.Errorf("deleting coupon[%s]: %w", couponID, err)
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// utc converts an optional time to UTC.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
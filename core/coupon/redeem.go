@@ -0,0 +1,69 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/database"
+)
+
+// Resolve returns the coupon with the passed code, checking that the user
+// can apply it to the passed courses. It returns nil if the code is empty.
+func Resolve(ctx context.Context, db sqlx.ExtContext, code string, userID string, courses []course.Course) (*Coupon, error) {
+	if code == "" {
+		return nil, nil
+	}
+
+	c, err := FetchByCode(ctx, db, code)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil, fmt.Errorf("coupon %s not found: %w", code, ErrNotApplicable)
+		}
+		return nil, err
+	}
+
+	usage, err := FetchUsage(ctx, db, c.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Check(courses, usage, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Redeem records the usage of the coupon in the passed order.
+// Limits are checked again while holding a lock on the coupon,
+// so that concurrent checkouts cannot exceed them.
+// It must be called within a transaction.
+func Redeem(ctx context.Context, tx sqlx.ExtContext, c Coupon, userID string, orderID string, courses []course.Course, discount int64) error {
+	if err := Lock(ctx, tx, c.ID); err != nil {
+		return err
+	}
+
+	usage, err := FetchUsage(ctx, tx, c.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := c.Check(courses, usage, now); err != nil {
+		return err
+	}
+
+	r := Redemption{
+		CouponID:  c.ID,
+		OrderID:   orderID,
+		UserID:    userID,
+		Discount:  discount,
+		CreatedAt: now,
+	}
+
+	return CreateRedemption(ctx, tx, r)
+}
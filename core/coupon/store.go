@@ -0,0 +1,258 @@
+package coupon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// Create inserts a new coupon together with its courses.
+func Create(ctx context.Context, db sqlx.ExtContext, coupon Coupon) error {
+	const q = `
+	INSERT INTO coupons
+		(coupon_id, code, kind, value, min_total, currency, max_redemptions, max_per_user, starts_at, ends_at, created_at, updated_at)
+	VALUES
+		(:coupon_id, :code, :kind, :value, :min_total, :currency, :max_redemptions, :max_per_user, :starts_at, :ends_at, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, coupon); err != nil {
+		return fmt.Errorf("inserting coupon: %w", err)
+	}
+
+	for _, id := range coupon.CourseIDs {
+		in := struct {
+			CouponID string `db:"coupon_id"`
+			CourseID string `db:"course_id"`
+		}{
+			CouponID: coupon.ID,
+			CourseID: id,
+		}
+
+		const q = `
+		INSERT INTO coupon_courses
+			(coupon_id, course_id)
+		VALUES
+			(:coupon_id, :course_id)`
+
+		if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+			return fmt.Errorf("inserting course[%s] of coupon: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a coupon. Its redemptions are deleted in cascade.
+func Delete(ctx context.Context, db sqlx.ExtContext, id string) error {
+	in := struct {
+		ID string `db:"coupon_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	DELETE FROM
+		coupons
+	WHERE
+		coupon_id = :coupon_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting coupon[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// Fetch returns the specified coupon, with its courses.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Coupon, error) {
+	in := struct {
+		ID string `db:"coupon_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		coupons
+	WHERE
+		coupon_id = :coupon_id`
+
+	var c Coupon
+	if err := database.NamedQueryStruct(ctx, db, q, in, &c); err != nil {
+		return Coupon{}, fmt.Errorf("selecting coupon[%s]: %w", id, err)
+	}
+
+	return withCourses(ctx, db, c)
+}
+
+// FetchByCode returns the coupon with the passed code, with its courses.
+// Codes are case insensitive.
+func FetchByCode(ctx context.Context, db sqlx.ExtContext, code string) (Coupon, error) {
+	in := struct {
+		Code string `db:"code"`
+	}{
+		Code: code,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		coupons
+	WHERE
+		UPPER(code) = UPPER(:code)`
+
+	var c Coupon
+	if err := database.NamedQueryStruct(ctx, db, q, in, &c); err != nil {
+		return Coupon{}, fmt.Errorf("selecting coupon with code[%s]: %w", code, err)
+	}
+
+	return withCourses(ctx, db, c)
+}
+
+// FetchAll returns all the coupons, with their courses.
+func FetchAll(ctx context.Context, db sqlx.ExtContext) ([]Coupon, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		coupons
+	ORDER BY
+		created_at DESC`
+
+	cs := []Coupon{}
+	if err := database.NamedQuerySlice(ctx, db, q, struct{}{}, &cs); err != nil {
+		return nil, fmt.Errorf("selecting coupons: %w", err)
+	}
+
+	for i := range cs {
+		c, err := withCourses(ctx, db, cs[i])
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+
+	return cs, nil
+}
+
+// Lock selects the coupon for update, so that its redemptions
+// can be safely counted within the current transaction.
+func Lock(ctx context.Context, tx sqlx.ExtContext, id string) error {
+	in := struct {
+		ID string `db:"coupon_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		coupon_id
+	FROM
+		coupons
+	WHERE
+		coupon_id = :coupon_id
+	FOR UPDATE`
+
+	var out struct {
+		ID string `db:"coupon_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, tx, q, in, &out); err != nil {
+		return fmt.Errorf("locking coupon[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// FetchUsage counts the redemptions of a coupon, in total and by the passed user.
+// Only the redemptions of pending and paid orders are counted: orders that
+// expired or failed give their redemptions back.
+func FetchUsage(ctx context.Context, db sqlx.ExtContext, couponID string, userID string) (Usage, error) {
+	in := struct {
+		CouponID          string `db:"coupon_id"`
+		UserID            string `db:"user_id"`
+		Pending           string `db:"pending"`
+		Success           string `db:"success"`
+		PartiallyRefunded string `db:"partially_refunded"`
+		Refunded          string `db:"refunded"`
+	}{
+		CouponID: couponID,
+		UserID:   userID,
+
+		// Same as the order statuses, which cannot be imported here.
+		Pending:           "pending",
+		Success:           "success",
+		PartiallyRefunded: "partially_refunded",
+		Refunded:          "refunded",
+	}
+
+	const q = `
+	SELECT
+		COUNT(*) AS total,
+		COUNT(*) FILTER (WHERE r.user_id = :user_id) AS by_user
+	FROM
+		coupon_redemptions AS r
+	INNER JOIN
+		orders AS o ON o.order_id = r.order_id
+	WHERE
+		r.coupon_id = :coupon_id AND
+		o.status IN (:pending, :success, :partially_refunded, :refunded)`
+
+	var u Usage
+	if err := database.NamedQueryStruct(ctx, db, q, in, &u); err != nil {
+		return Usage{}, fmt.Errorf("counting redemptions of coupon[%s]: %w", couponID, err)
+	}
+
+	return u, nil
+}
+
+// CreateRedemption records that a coupon has been used in an order.
+func CreateRedemption(ctx context.Context, db sqlx.ExtContext, r Redemption) error {
+	const q = `
+	INSERT INTO coupon_redemptions
+		(coupon_id, order_id, user_id, discount, created_at)
+	VALUES
+		(:coupon_id, :order_id, :user_id, :discount, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, r); err != nil {
+		return fmt.Errorf("inserting redemption of coupon[%s]: %w", r.CouponID, err)
+	}
+
+	return nil
+}
+
+// withCourses loads the courses the passed coupon is scoped to.
+func withCourses(ctx context.Context, db sqlx.ExtContext, c Coupon) (Coupon, error) {
+	in := struct {
+		ID string `db:"coupon_id"`
+	}{
+		ID: c.ID,
+	}
+
+	const q = `
+	SELECT
+		course_id
+	FROM
+		coupon_courses
+	WHERE
+		coupon_id = :coupon_id
+	ORDER BY
+		course_id`
+
+	var rows []struct {
+		CourseID string `db:"course_id"`
+	}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &rows); err != nil {
+		return Coupon{}, fmt.Errorf("selecting courses of coupon[%s]: %w", c.ID, err)
+	}
+
+	c.CourseIDs = make([]string, 0, len(rows))
+	for _, r := range rows {
+		c.CourseIDs = append(c.CourseIDs, r.CourseID)
+	}
+
+	return c, nil
+}
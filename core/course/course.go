@@ -1,35 +1,106 @@
 package course
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/polldo/govod/money"
+)
+
+// ErrNoPrice is returned when a course is not sold in a currency.
+var ErrNoPrice = errors.New("course not available in currency")
 
 // Course models courses.
 // A user can own many courses and a course
 // can be owned by many users.
+//
+// Price is expressed in the minor unit of Currency.
+// Images uploaded to the media storage are referenced by ImageKey:
+// ImageURL then points to the endpoint serving them.
+// Prices contains the prices of the course in other currencies.
 type Course struct {
-	ID          string    `json:"id" db:"course_id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	ImageURL    string    `json:"imageUrl" db:"image_url"`
-	Price       int       `json:"price" db:"price"`
-	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
-	Version     int       `json:"-" db:"version"`
+	ID          string        `json:"id" db:"course_id"`
+	Name        string        `json:"name" db:"name"`
+	Description string        `json:"description" db:"description"`
+	ImageURL    string        `json:"imageUrl" db:"image_url"`
+	ImageKey    string        `json:"-" db:"image_key"`
+	Price       int64         `json:"price" db:"price"`
+	Currency    string        `json:"currency" db:"currency"`
+	Prices      []money.Money `json:"prices" db:"-"`
+	CreatedAt   time.Time     `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time     `json:"updatedAt" db:"updated_at"`
+	Version     int           `json:"-" db:"version"`
 }
 
 // CourseNew contains the information needed to
 // create a new course.
+// Currency defaults to money.Default.
+// Either the URL or the storage key of the image must be passed.
+// Courses with no price are free: users can enroll without paying.
 type CourseNew struct {
-	Name        string `json:"name" validate:"required"`
-	Description string `json:"description" validate:"required"`
-	Price       int    `json:"price" validate:"required,gte=0,lte=10000"`
-	ImageURL    string `json:"imageUrl" validate:"required"`
+	Name        string        `json:"name" validate:"required"`
+	Description string        `json:"description" validate:"required"`
+	Price       int64         `json:"price" validate:"gte=0,lte=1000000"`
+	Currency    string        `json:"currency" validate:"omitempty,iso4217"`
+	Prices      []money.Money `json:"prices" validate:"omitempty,dive"`
+	ImageURL    string        `json:"imageUrl" validate:"required_without=ImageKey"`
+	ImageKey    string        `json:"imageKey"`
 }
 
 // CourseUp contains the information of a course
-// that can be updated.
+// that can be updated. Passed prices replace the existing ones.
 type CourseUp struct {
-	Name        *string `json:"name"`
-	Description *string `json:"description"`
-	Price       *int    `json:"price" validate:"omitempty,gte=0,lte=10000"`
-	ImageURL    *string `json:"imageUrl"`
+	Name        *string        `json:"name"`
+	Description *string        `json:"description"`
+	Price       *int64         `json:"price" validate:"omitempty,gte=0,lte=1000000"`
+	Currency    *string        `json:"currency" validate:"omitempty,iso4217"`
+	Prices      *[]money.Money `json:"prices" validate:"omitempty,dive"`
+	ImageURL    *string        `json:"imageUrl"`
+	ImageKey    *string        `json:"imageKey"`
+}
+
+// Free reports whether the course is free in all its currencies.
+func (c Course) Free() bool {
+	if c.Price != 0 {
+		return false
+	}
+
+	for _, p := range c.Prices {
+		if p.Amount != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PriceIn returns the price of the course in the passed currency.
+func (c Course) PriceIn(currency string) (money.Money, error) {
+	currency = strings.ToUpper(currency)
+
+	if c.Currency == currency {
+		return money.New(c.Price, c.Currency), nil
+	}
+
+	for _, p := range c.Prices {
+		if p.Currency == currency {
+			return p, nil
+		}
+	}
+
+	return money.Money{}, fmt.Errorf("course[%s] in %s: %w", c.ID, currency, ErrNoPrice)
+}
+
+// In returns the course priced in the passed currency.
+func (c Course) In(currency string) (Course, error) {
+	p, err := c.PriceIn(currency)
+	if err != nil {
+		return Course{}, err
+	}
+
+	c.Price = p.Amount
+	c.Currency = p.Currency
+	return c, nil
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,11 +13,14 @@ import (
 	"github.com/polldo/govod/api/weberr"
 	"github.com/polldo/govod/core/claims"
 	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/storage"
 	"github.com/polldo/govod/validate"
 )
 
 // HandleCreate allows administrators to add new courses.
-func HandleCreate(db *sqlx.DB) web.Handler {
+// Images referenced by key must have been uploaded to the media storage.
+func HandleCreate(db *sqlx.DB, store storage.Store) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		var c CourseNew
 		if err := web.Decode(w, r, &c); err != nil {
@@ -34,12 +38,33 @@ func HandleCreate(db *sqlx.DB) web.Handler {
 			Name:        c.Name,
 			Description: c.Description,
 			Price:       c.Price,
+			Currency:    c.Currency,
+			Prices:      c.Prices,
 			ImageURL:    c.ImageURL,
+			ImageKey:    c.ImageKey,
 			CreatedAt:   now,
 			UpdatedAt:   now,
 		}
 
-		if err := Create(ctx, db, course); err != nil {
+		if course.Currency == "" {
+			course.Currency = money.Default
+		}
+		if course.Prices == nil {
+			course.Prices = []money.Money{}
+		}
+
+		if err := checkPrices(course); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if err := setImage(ctx, store, &course); err != nil {
+			return err
+		}
+
+		err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+			return Create(ctx, tx, course)
+		})
+		if err != nil {
 			if errors.Is(err, database.ErrDBDuplicatedEntry) {
 				return weberr.NewError(err, "passed course already exists", http.StatusUnprocessableEntity)
 			}
@@ -51,7 +76,8 @@ func HandleCreate(db *sqlx.DB) web.Handler {
 }
 
 // HandleUpdate allows administrators to update existing courses.
-func HandleUpdate(db *sqlx.DB) web.Handler {
+// Passing an image URL removes the image previously uploaded, if any.
+func HandleUpdate(db *sqlx.DB, store storage.Store) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		courseID := web.Param(r, "id")
 
@@ -86,13 +112,40 @@ func HandleUpdate(db *sqlx.DB) web.Handler {
 		if cup.Price != nil {
 			course.Price = *cup.Price
 		}
+		if cup.Currency != nil {
+			course.Currency = *cup.Currency
+		}
+		if cup.Prices != nil {
+			course.Prices = *cup.Prices
+		}
 		if cup.ImageURL != nil {
 			course.ImageURL = *cup.ImageURL
+			course.ImageKey = ""
+		}
+		if cup.ImageKey != nil {
+			course.ImageKey = *cup.ImageKey
 		}
 		course.UpdatedAt = time.Now().UTC()
 
-		if course, err = Update(ctx, db, course); err != nil {
-			return fmt.Errorf("updating course[%s]: %w", course.ID, err)
+		if err := checkPrices(course); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if course.ImageKey == "" && course.ImageURL == "" {
+			err := errors.New("course without image")
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if err := setImage(ctx, store, &course); err != nil {
+			return err
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			course, err = Update(ctx, tx, course)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("updating course[%s]: %w", courseID, err)
 		}
 
 		return web.Respond(ctx, w, course, http.StatusOK)
@@ -112,6 +165,7 @@ func HandleList(db *sqlx.DB) web.Handler {
 }
 
 // HandleList allows users to fetch courses they own.
+// Courses accessible through their subscription are listed as well.
 func HandleListOwned(db *sqlx.DB) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		clm, err := claims.Get(ctx)
@@ -124,6 +178,23 @@ func HandleListOwned(db *sqlx.DB) web.Handler {
 			return fmt.Errorf("fetching courses of user[%s]: %w", clm.UserID, err)
 		}
 
+		subscribed, err := FetchSubscribed(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("fetching subscribed courses of user[%s]: %w", clm.UserID, err)
+		}
+
+		owned := make(map[string]bool, len(courses))
+		for _, c := range courses {
+			owned[c.ID] = true
+		}
+
+		for _, c := range subscribed {
+			if !owned[c.ID] {
+				courses = append(courses, c)
+			}
+		}
+		sort.Slice(courses, func(i, j int) bool { return courses[i].ID < courses[j].ID })
+
 		return web.Respond(ctx, w, courses, http.StatusOK)
 	}
 }
@@ -149,3 +220,15 @@ func HandleShow(db *sqlx.DB) web.Handler {
 		return web.Respond(ctx, w, course, http.StatusOK)
 	}
 }
+
+// checkPrices verifies that the course has at most one price per currency.
+func checkPrices(c Course) error {
+	seen := map[string]bool{c.Currency: true}
+	for _, p := range c.Prices {
+		if seen[p.Currency] {
+			return fmt.Errorf("course has more prices in %s", p.Currency)
+		}
+		seen[p.Currency] = true
+	}
+	return nil
+}
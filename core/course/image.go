@@ -0,0 +1,102 @@
+package course
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/storage"
+	"github.com/polldo/govod/validate"
+)
+
+// imageURL returns the URL of the endpoint serving the stored image of a course.
+func imageURL(courseID string) string {
+	return "/courses/" + courseID + "/image"
+}
+
+// setImage checks that the image referenced by the key of the course
+// has been uploaded, pointing the image URL of the course to it.
+func setImage(ctx context.Context, store storage.Store, course *Course) error {
+	if course.ImageKey == "" {
+		return nil
+	}
+
+	if err := storage.CheckKey(course.ImageKey); err != nil {
+		return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	if _, err := store.Stat(ctx, course.ImageKey); err != nil {
+		err := fmt.Errorf("checking image[%s] of course[%s]: %w", course.ImageKey, course.ID, err)
+		if errors.Is(err, storage.ErrNotFound) {
+			return weberr.NewError(err, "image not uploaded", http.StatusUnprocessableEntity)
+		}
+		return err
+	}
+
+	course.ImageURL = imageURL(course.ID)
+	return nil
+}
+
+// HandleShowImage serves the image of a course uploaded to the media storage.
+// Clients are redirected to a presigned URL of the image, if the storage
+// supports them, otherwise the image is served directly.
+func HandleShowImage(db *sqlx.DB, store storage.Store, expiry time.Duration) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		courseID := web.Param(r, "id")
+
+		if err := validate.CheckID(courseID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		course, err := Fetch(ctx, db, courseID)
+		if err != nil {
+			err := fmt.Errorf("fetching course[%s]: %w", courseID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if course.ImageKey == "" {
+			return weberr.NotFound(fmt.Errorf("course[%s] has no image uploaded", courseID))
+		}
+
+		url, err := store.Presign(ctx, course.ImageKey, expiry)
+		if err == nil {
+			http.Redirect(w, r, url, http.StatusFound)
+			return nil
+		}
+		if !errors.Is(err, storage.ErrNotSupported) {
+			return fmt.Errorf("presigning image of course[%s]: %w", courseID, err)
+		}
+
+		rc, obj, err := store.Get(ctx, course.ImageKey)
+		if err != nil {
+			err := fmt.Errorf("reading image of course[%s]: %w", courseID, err)
+			if errors.Is(err, storage.ErrNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", obj.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		w.Header().Set("ETag", obj.ETag)
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := io.Copy(w, rc); err != nil {
+			return fmt.Errorf("sending image of course[%s]: %w", courseID, err)
+		}
+
+		return nil
+	}
+}
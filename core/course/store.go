@@ -4,27 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
 )
 
-// Create inserts a new course.
+// Create inserts a new course together with its prices.
 func Create(ctx context.Context, db sqlx.ExtContext, course Course) error {
 	const q = `
 	INSERT INTO courses
-		(course_id, name, description, price, image_url, created_at, updated_at)
+		(course_id, name, description, price, currency, image_url, image_key, created_at, updated_at)
 	VALUES
-	(:course_id, :name, :description, :price, :image_url, :created_at, :updated_at)`
+	(:course_id, :name, :description, :price, :currency, :image_url, :image_key, :created_at, :updated_at)`
 
 	if err := database.NamedExecContext(ctx, db, q, course); err != nil {
 		return fmt.Errorf("inserting course: %w", err)
 	}
 
-	return nil
+	return createPrices(ctx, db, course)
 }
 
-// Update updates the details of a specific course.
+// Update updates the details of a specific course, replacing its prices.
 // It relies on optimistic lock to deal with data races.
 func Update(ctx context.Context, db sqlx.ExtContext, course Course) (Course, error) {
 	const q = `
@@ -33,7 +35,9 @@ func Update(ctx context.Context, db sqlx.ExtContext, course Course) (Course, err
 		name = :name,
 		description = :description,
 		price = :price,
+		currency = :currency,
 		image_url = :image_url,
+		image_key = :image_key,
 		updated_at = :updated_at,
 		version = version + 1
 	WHERE
@@ -54,10 +58,18 @@ func Update(ctx context.Context, db sqlx.ExtContext, course Course) (Course, err
 
 	course.Version = v.Version
 
+	if err := deletePrices(ctx, db, course.ID); err != nil {
+		return Course{}, err
+	}
+
+	if err := createPrices(ctx, db, course); err != nil {
+		return Course{}, err
+	}
+
 	return course, nil
 }
 
-// Fetch returns information of a specific course.
+// Fetch returns information of a specific course, with its prices.
 func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Course, error) {
 	in := struct {
 		ID string `db:"course_id"`
@@ -78,10 +90,10 @@ func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Course, error) {
 		return Course{}, fmt.Errorf("selecting course[%s]: %w", id, err)
 	}
 
-	return course, nil
+	return withPrices(ctx, db, course)
 }
 
-// FetchAll returns all courses.
+// FetchAll returns all courses, with their prices.
 func FetchAll(ctx context.Context, db sqlx.ExtContext) ([]Course, error) {
 	const q = `
 	SELECT
@@ -96,25 +108,42 @@ func FetchAll(ctx context.Context, db sqlx.ExtContext) ([]Course, error) {
 		return nil, fmt.Errorf("selecting all courses: %w", err)
 	}
 
+	for i := range cs {
+		c, err := withPrices(ctx, db, cs[i])
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+
 	return cs, nil
 }
 
-// FetchByOwner returns all the courses owned by the passed user.
+// FetchByOwner returns all the courses owned by the passed user, with their prices.
+// Courses whose order item has been refunded are not owned anymore, while
+// courses whose order payment is disputed are suspended until the dispute is won.
+// Courses bought as a gift are owned by the user who redeemed it, not by the buyer.
+// Seats bought for organizations are not owned by anyone, and courses
+// accessible through subscriptions are not owned either: they can still be bought.
 func FetchByOwner(ctx context.Context, db sqlx.ExtContext, userID string) ([]Course, error) {
 	in := struct {
-		ID     string `db:"user_id"`
-		Status string `db:"status"`
+		ID              string `db:"user_id"`
+		Status          string `db:"status"`
+		StatusRefunding string `db:"status_refunding"`
+		DisputeOpen     string `db:"dispute_open"`
 	}{
 		ID: userID,
 
 		// TODO: Use a const instead of this magic value.
 		// This seems a good reason to move all the handlers in the api package.
 		// Or just create a models package with all the struct and const.
-		Status: "success",
+		Status:          "success",
+		StatusRefunding: "partially_refunded",
+		DisputeOpen:     "open",
 	}
 
 	const q = `
-	SELECT
+	SELECT DISTINCT
 		c.*
 	FROM
 		orders AS o
@@ -122,9 +151,20 @@ func FetchByOwner(ctx context.Context, db sqlx.ExtContext, userID string) ([]Cou
 		order_items AS i ON i.order_id = o.order_id
 	INNER JOIN
 		courses AS c ON i.course_id = c.course_id
+	LEFT JOIN
+		gifts AS g ON g.order_id = o.order_id
 	WHERE
-		o.status = :status AND
-		o.user_id = :user_id
+		o.status IN (:status, :status_refunding) AND
+		((g.order_id IS NULL AND o.user_id = :user_id) OR g.redeemed_by = :user_id) AND
+		o.org_id = '' AND
+		NOT EXISTS (
+			SELECT 1 FROM order_refunds AS r
+			WHERE r.order_id = i.order_id AND r.course_id = i.course_id AND r.status = 'completed'
+		) AND
+		NOT EXISTS (
+			SELECT 1 FROM order_disputes AS d
+			WHERE d.order_id = o.order_id AND d.status = :dispute_open
+		)
 	ORDER BY
 		c.course_id`
 
@@ -133,23 +173,42 @@ func FetchByOwner(ctx context.Context, db sqlx.ExtContext, userID string) ([]Cou
 		return nil, fmt.Errorf("selecting all courses: %w", err)
 	}
 
+	for i := range cs {
+		c, err := withPrices(ctx, db, cs[i])
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+
 	return cs, nil
 }
 
 // FetchOwned returns the specified course if the passed user owns it.
+// Courses whose order item has been refunded are not owned anymore, while
+// courses whose order payment is disputed are suspended until the dispute is won.
+// Gifts grant the course to their recipient.
+// Subscribers can access the courses of their plan as long as their
+// subscription grants access.
 func FetchOwned(ctx context.Context, db sqlx.ExtContext, courseID string, userID string) (Course, error) {
 	in := struct {
-		UserID   string `db:"user_id"`
-		CourseID string `db:"course_id"`
-		Status   string `db:"status"`
+		UserID          string    `db:"user_id"`
+		CourseID        string    `db:"course_id"`
+		Status          string    `db:"status"`
+		StatusRefunding string    `db:"status_refunding"`
+		DisputeOpen     string    `db:"dispute_open"`
+		Now             time.Time `db:"now"`
 	}{
-		UserID:   userID,
-		CourseID: courseID,
-		Status:   "success",
+		UserID:          userID,
+		CourseID:        courseID,
+		Status:          "success",
+		StatusRefunding: "partially_refunded",
+		DisputeOpen:     "open",
+		Now:             time.Now().UTC(),
 	}
 
 	const q = `
-	SELECT
+	(SELECT
 		c.*
 	FROM
 		orders AS o
@@ -157,15 +216,158 @@ func FetchOwned(ctx context.Context, db sqlx.ExtContext, courseID string, userID
 		order_items AS i ON i.order_id = o.order_id
 	INNER JOIN
 		courses AS c ON i.course_id = c.course_id
+	LEFT JOIN
+		gifts AS g ON g.order_id = o.order_id
+	WHERE
+		o.status IN (:status, :status_refunding) AND
+		((g.order_id IS NULL AND o.user_id = :user_id) OR g.redeemed_by = :user_id) AND
+		o.org_id = '' AND
+		c.course_id = :course_id AND
+		NOT EXISTS (
+			SELECT 1 FROM order_refunds AS r
+			WHERE r.order_id = i.order_id AND r.course_id = i.course_id AND r.status = 'completed'
+		) AND
+		NOT EXISTS (
+			SELECT 1 FROM order_disputes AS d
+			WHERE d.order_id = o.order_id AND d.status = :dispute_open
+		))
+	UNION
+	(SELECT
+		c.*
+	FROM
+		courses AS c
 	WHERE
-		o.status = :status AND
-		o.user_id = :user_id AND
-		c.course_id = :course_id`
+		c.course_id = :course_id AND
+		EXISTS (
+			SELECT 1 FROM subscriptions AS s
+			INNER JOIN plans AS p ON p.plan_id = s.plan_id
+			LEFT JOIN plan_courses AS pc ON pc.plan_id = p.plan_id AND pc.course_id = c.course_id
+			WHERE s.user_id = :user_id AND s.access_until > :now AND (p.all_courses OR pc.course_id IS NOT NULL)
+		))
+	LIMIT 1`
 
 	var cs Course
 	if err := database.NamedQueryStruct(ctx, db, q, in, &cs); err != nil {
 		return Course{}, fmt.Errorf("selecting owned course: %w", err)
 	}
 
+	return withPrices(ctx, db, cs)
+}
+
+// FetchSubscribed returns all the courses the passed user can access
+// through their subscription, with their prices.
+func FetchSubscribed(ctx context.Context, db sqlx.ExtContext, userID string) ([]Course, error) {
+	in := struct {
+		UserID string    `db:"user_id"`
+		Now    time.Time `db:"now"`
+	}{
+		UserID: userID,
+		Now:    time.Now().UTC(),
+	}
+
+	const q = `
+	SELECT DISTINCT
+		c.*
+	FROM
+		subscriptions AS s
+	INNER JOIN
+		plans AS p ON p.plan_id = s.plan_id
+	LEFT JOIN
+		plan_courses AS pc ON pc.plan_id = p.plan_id
+	INNER JOIN
+		courses AS c ON p.all_courses OR c.course_id = pc.course_id
+	WHERE
+		s.user_id = :user_id AND
+		s.access_until > :now
+	ORDER BY
+		c.course_id`
+
+	cs := []Course{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &cs); err != nil {
+		return nil, fmt.Errorf("selecting subscribed courses: %w", err)
+	}
+
+	for i := range cs {
+		c, err := withPrices(ctx, db, cs[i])
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+
 	return cs, nil
 }
+
+// createPrices inserts the prices of the course in other currencies.
+func createPrices(ctx context.Context, db sqlx.ExtContext, course Course) error {
+	for _, p := range course.Prices {
+		in := struct {
+			CourseID string `db:"course_id"`
+			Currency string `db:"currency"`
+			Amount   int64  `db:"amount"`
+		}{
+			CourseID: course.ID,
+			Currency: p.Currency,
+			Amount:   p.Amount,
+		}
+
+		const q = `
+		INSERT INTO course_prices
+			(course_id, currency, amount)
+		VALUES
+			(:course_id, :currency, :amount)`
+
+		if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+			return fmt.Errorf("inserting price[%s] of course[%s]: %w", p.Currency, course.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// deletePrices deletes all the prices of the course in other currencies.
+func deletePrices(ctx context.Context, db sqlx.ExtContext, courseID string) error {
+	in := struct {
+		ID string `db:"course_id"`
+	}{
+		ID: courseID,
+	}
+
+	const q = `
+	DELETE FROM
+		course_prices
+	WHERE
+		course_id = :course_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting prices of course[%s]: %w", courseID, err)
+	}
+
+	return nil
+}
+
+// withPrices loads the prices of the passed course in other currencies.
+func withPrices(ctx context.Context, db sqlx.ExtContext, c Course) (Course, error) {
+	in := struct {
+		ID string `db:"course_id"`
+	}{
+		ID: c.ID,
+	}
+
+	const q = `
+	SELECT
+		amount, currency
+	FROM
+		course_prices
+	WHERE
+		course_id = :course_id
+	ORDER BY
+		currency`
+
+	c.Prices = []money.Money{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &c.Prices); err != nil {
+		return Course{}, fmt.Errorf("selecting prices of course[%s]: %w", c.ID, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,62 @@
+package gift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/user"
+	"github.com/polldo/govod/database"
+)
+
+// Deliver generates and sends the codes of the gifts whose order has been
+// fulfilled. A new code is generated if sending the previous one failed.
+// It's meant to be periodically run in background.
+func Deliver(ctx context.Context, db *sqlx.DB, mailer Mailer) error {
+	gs, err := FetchUndelivered(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, g := range gs {
+		if err := deliver(ctx, db, mailer, g); err != nil {
+			errs = append(errs, fmt.Errorf("delivering gift[%s]: %w", g.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver sends a new code to the recipient of the gift.
+// The code is stored only if the email is sent.
+func deliver(ctx context.Context, db *sqlx.DB, mailer Mailer, g Gift) error {
+	buyer, err := user.Fetch(ctx, db, g.UserID)
+	if err != nil {
+		return fmt.Errorf("fetching buyer[%s]: %w", g.UserID, err)
+	}
+
+	code, h, err := genCode()
+	if err != nil {
+		return fmt.Errorf("generating code: %w", err)
+	}
+
+	now := time.Now().UTC()
+	g.CodeHash = h
+	g.SentAt = &now
+	g.UpdatedAt = now
+
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		if err := UpdateCode(ctx, tx, g); err != nil {
+			return err
+		}
+
+		if err := mailer.SendGiftCode(code, g.Email, buyer.Name, g.Message); err != nil {
+			return fmt.Errorf("sending code to %s: %w", g.Email, err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package gift
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// ErrRedeemed is returned when a gift has already been redeemed.
+var ErrRedeemed = errors.New("gift already redeemed")
+
+// Mailer should be able to send the codes of gifts to their recipients.
+type Mailer interface {
+	SendGiftCode(code string, to string, sender string, message string) error
+}
+
+// Gift models the courses of an order bought by a user for someone else.
+// Courses are owned by the user redeeming the gift, instead of the buyer.
+// The code is generated and sent to the recipient once the order is
+// fulfilled: only its hash is stored.
+type Gift struct {
+	ID         string     `json:"id" db:"gift_id"`
+	OrderID    string     `json:"orderId" db:"order_id"`
+	UserID     string     `json:"userId" db:"user_id"`
+	Email      string     `json:"email" db:"recipient_email"`
+	Message    string     `json:"message" db:"message"`
+	CodeHash   []byte     `json:"-" db:"code_hash"`
+	SentAt     *time.Time `json:"sentAt" db:"sent_at"`
+	RedeemedBy string     `json:"-" db:"redeemed_by"`
+	RedeemedAt *time.Time `json:"redeemedAt" db:"redeemed_at"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// GiftNew contains the information needed to buy the cart as a gift.
+type GiftNew struct {
+	Email   string `json:"email" validate:"required,email"`
+	Message string `json:"message" validate:"omitempty,max=500"`
+}
+
+// Code contains the code of a gift to be redeemed.
+type Code struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// genCode generates a new random code for a gift, returning it
+// together with its hash.
+func genCode() (string, []byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	return code, hash(code), nil
+}
+
+// hash returns the hash of the passed code, as stored.
+func hash(code string) []byte {
+	h := sha256.Sum256([]byte(code))
+	return h[:]
+}
@@ -0,0 +1,58 @@
+package gift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleRedeem allows users to claim a gift into their own account,
+// becoming the owners of the courses bought with it.
+// Codes can be redeemed only once.
+func HandleRedeem(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var in Code
+		if err := web.Decode(w, r, &in); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(in); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		h := hash(in.Code)
+
+		g, err := Redeem(ctx, db, h, clm.UserID, time.Now().UTC())
+		if err == nil {
+			return web.Respond(ctx, w, g, http.StatusOK)
+		}
+
+		if !errors.Is(err, database.ErrDBNotFound) {
+			return err
+		}
+
+		// Tell apart codes already redeemed from the invalid ones.
+		if _, err := FetchByCode(ctx, db, h); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.BadRequest(errors.New("gift code is not valid"))
+			}
+			return err
+		}
+
+		return weberr.NewError(ErrRedeemed, ErrRedeemed.Error(), http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,156 @@
+package gift
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// Create inserts a new gift.
+func Create(ctx context.Context, db sqlx.ExtContext, gift Gift) error {
+	const q = `
+	INSERT INTO gifts
+		(gift_id, order_id, user_id, recipient_email, message, created_at, updated_at)
+	VALUES
+	(:gift_id, :order_id, :user_id, :recipient_email, :message, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, gift); err != nil {
+		return fmt.Errorf("inserting gift: %w", err)
+	}
+
+	return nil
+}
+
+// FetchByOrder returns the gift bought with the passed order.
+func FetchByOrder(ctx context.Context, db sqlx.ExtContext, orderID string) (Gift, error) {
+	in := struct {
+		OrderID string `db:"order_id"`
+	}{
+		OrderID: orderID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		gifts
+	WHERE
+		order_id = :order_id`
+
+	var g Gift
+	if err := database.NamedQueryStruct(ctx, db, q, in, &g); err != nil {
+		return Gift{}, fmt.Errorf("selecting gift of order[%s]: %w", orderID, err)
+	}
+
+	return g, nil
+}
+
+// FetchByCode returns the gift whose code has the passed hash.
+func FetchByCode(ctx context.Context, db sqlx.ExtContext, codeHash []byte) (Gift, error) {
+	in := struct {
+		CodeHash []byte `db:"code_hash"`
+	}{
+		CodeHash: codeHash,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		gifts
+	WHERE
+		code_hash = :code_hash`
+
+	var g Gift
+	if err := database.NamedQueryStruct(ctx, db, q, in, &g); err != nil {
+		return Gift{}, fmt.Errorf("selecting gift by code: %w", err)
+	}
+
+	return g, nil
+}
+
+// FetchUndelivered returns the gifts whose order has been fulfilled,
+// but whose code has not been sent yet.
+func FetchUndelivered(ctx context.Context, db sqlx.ExtContext) ([]Gift, error) {
+	in := struct {
+		Status          string `db:"status"`
+		StatusRefunding string `db:"status_refunding"`
+	}{
+		Status:          "success",
+		StatusRefunding: "partially_refunded",
+	}
+
+	const q = `
+	SELECT
+		g.*
+	FROM
+		gifts AS g
+	INNER JOIN
+		orders AS o ON o.order_id = g.order_id
+	WHERE
+		o.status IN (:status, :status_refunding) AND
+		g.sent_at IS NULL
+	ORDER BY
+		g.created_at`
+
+	gs := []Gift{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &gs); err != nil {
+		return nil, fmt.Errorf("selecting undelivered gifts: %w", err)
+	}
+
+	return gs, nil
+}
+
+// UpdateCode records the hash of the code sent to the recipient of the gift.
+func UpdateCode(ctx context.Context, db sqlx.ExtContext, gift Gift) error {
+	const q = `
+	UPDATE gifts
+	SET
+		code_hash = :code_hash,
+		sent_at = :sent_at,
+		updated_at = :updated_at
+	WHERE
+		gift_id = :gift_id`
+
+	if err := database.NamedExecContext(ctx, db, q, gift); err != nil {
+		return fmt.Errorf("updating code of gift[%s]: %w", gift.ID, err)
+	}
+
+	return nil
+}
+
+// Redeem assigns the gift with the passed code hash to the passed user,
+// if the gift has not been redeemed yet.
+// It returns database.ErrDBNotFound otherwise.
+func Redeem(ctx context.Context, db sqlx.ExtContext, codeHash []byte, userID string, now time.Time) (Gift, error) {
+	in := struct {
+		CodeHash   []byte    `db:"code_hash"`
+		RedeemedBy string    `db:"redeemed_by"`
+		RedeemedAt time.Time `db:"redeemed_at"`
+	}{
+		CodeHash:   codeHash,
+		RedeemedBy: userID,
+		RedeemedAt: now,
+	}
+
+	const q = `
+	UPDATE gifts
+	SET
+		redeemed_by = :redeemed_by,
+		redeemed_at = :redeemed_at,
+		updated_at = :redeemed_at
+	WHERE
+		code_hash = :code_hash AND
+		redeemed_by = ''
+	RETURNING *`
+
+	var g Gift
+	if err := database.NamedQueryStruct(ctx, db, q, in, &g); err != nil {
+		return Gift{}, fmt.Errorf("redeeming gift by user[%s]: %w", userID, err)
+	}
+
+	return g, nil
+}
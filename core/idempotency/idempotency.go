@@ -0,0 +1,26 @@
+package idempotency
+
+import (
+	"time"
+)
+
+// Header is the request header carrying the idempotency key.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on the responses replayed for retries.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// Key models the idempotency key sent by a user to safely retry a request.
+// Keys are scoped to the user and bound to the first request they came with,
+// identified by its Fingerprint. The response of that request is recorded
+// once completed: Status is zero while the request is still in progress.
+type Key struct {
+	UserID      string    `db:"user_id"`
+	Key         string    `db:"idempotency_key"`
+	Fingerprint string    `db:"fingerprint"`
+	Status      int       `db:"status"`
+	ContentType string    `db:"content_type"`
+	Body        []byte    `db:"body"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
@@ -0,0 +1,172 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/database"
+)
+
+// maxKeyLen is the maximum length accepted for idempotency keys.
+const maxKeyLen = 255
+
+// maxBodyBytes is the maximum size of the bodies of idempotent requests.
+const maxBodyBytes = 1048576
+
+// Middleware returns a middleware that makes requests carrying an
+// idempotency key safe to retry. The first response sent for a key is
+// recorded and replayed for all the retries of the same request, while
+// reusing the key for a different request is rejected.
+// Requests failed with an error are not recorded, so that they can be retried.
+//
+// Keys are scoped to the authenticated user, so the middleware must
+// run after authentication. Requests without a key are passed through.
+func Middleware(db *sqlx.DB) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(Header)
+			if key == "" {
+				return handler(ctx, w, r)
+			}
+
+			if len(key) > maxKeyLen {
+				err := fmt.Errorf("idempotency key longer than %d characters", maxKeyLen)
+				return weberr.BadRequest(err)
+			}
+
+			clm, err := claims.Get(ctx)
+			if err != nil {
+				return weberr.NotAuthorized(err)
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err != nil {
+				return weberr.BadRequest(fmt.Errorf("reading request body: %w", err))
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			now := time.Now().UTC()
+			k := Key{
+				UserID:      clm.UserID,
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+
+			if err := Create(ctx, db, k); err != nil {
+				if !errors.Is(err, database.ErrDBNotFound) {
+					return err
+				}
+				return replay(ctx, db, w, k)
+			}
+
+			rw := &recorder{ResponseWriter: w}
+			if err := handler(ctx, rw, r); err != nil {
+				if derr := Delete(ctx, db, k.UserID, k.Key); derr != nil {
+					return fmt.Errorf("releasing idempotency key: %v: %w", derr, err)
+				}
+				return err
+			}
+
+			k.Status = rw.code
+			if k.Status == 0 {
+				k.Status = http.StatusOK
+			}
+			k.ContentType = w.Header().Get("Content-Type")
+			k.Body = rw.buf.Bytes()
+			k.UpdatedAt = time.Now().UTC()
+
+			// The response has already been sent: the key stays
+			// in progress if it cannot be completed.
+			if err := Complete(ctx, db, k); err != nil {
+				return fmt.Errorf("completing idempotency key: %w", err)
+			}
+
+			return nil
+		}
+		return h
+	}
+	return m
+}
+
+// replay sends again the response recorded for the idempotency key,
+// if the request matches the one the key has been first sent with.
+func replay(ctx context.Context, db *sqlx.DB, w http.ResponseWriter, k Key) error {
+	rec, err := Fetch(ctx, db, k.UserID, k.Key)
+	if err != nil {
+		return err
+	}
+
+	if rec.Fingerprint != k.Fingerprint {
+		err := fmt.Errorf("idempotency key[%s] already used for a different request", k.Key)
+		return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	if rec.Status == 0 {
+		err := fmt.Errorf("request with idempotency key[%s] still in progress", k.Key)
+		return weberr.NewError(err, err.Error(), http.StatusConflict)
+	}
+
+	if rec.ContentType != "" {
+		w.Header().Set("Content-Type", rec.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+
+	if _, err := w.Write(rec.Body); err != nil {
+		return fmt.Errorf("cannot write replayed response: %w", err)
+	}
+
+	return nil
+}
+
+// fingerprint identifies a request by its method, path, query and body.
+// The query is part of the request, since it can affect handlers, such as
+// the currency of checkouts.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder writes the response while recording it.
+type recorder struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+	rw.buf.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if rw.code == 0 {
+		rw.code = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Purge deletes the idempotency keys older than ttl, after which
+// requests cannot be retried anymore.
+// It's meant to be periodically run in background.
+func Purge(ctx context.Context, db *sqlx.DB, ttl time.Duration) error {
+	return DeleteBefore(ctx, db, time.Now().UTC().Add(-ttl))
+}
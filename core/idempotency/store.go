@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// Create inserts a new idempotency key.
+// It returns database.ErrDBNotFound if the user already sent the key.
+func Create(ctx context.Context, db sqlx.ExtContext, key Key) error {
+	const q = `
+	INSERT INTO idempotency_keys
+		(user_id, idempotency_key, fingerprint, status, content_type, body, created_at, updated_at)
+	VALUES
+	(:user_id, :idempotency_key, :fingerprint, :status, :content_type, :body, :created_at, :updated_at)
+	ON CONFLICT DO NOTHING
+	RETURNING
+		idempotency_key`
+
+	var out struct {
+		Key string `db:"idempotency_key"`
+	}
+	if err := database.NamedQueryStruct(ctx, db, q, key, &out); err != nil {
+		return fmt.Errorf("inserting idempotency key[%s]: %w", key.Key, err)
+	}
+
+	return nil
+}
+
+// Fetch returns the idempotency key sent by the passed user.
+func Fetch(ctx context.Context, db sqlx.ExtContext, userID string, key string) (Key, error) {
+	in := struct {
+		UserID string `db:"user_id"`
+		Key    string `db:"idempotency_key"`
+	}{
+		UserID: userID,
+		Key:    key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		idempotency_keys
+	WHERE
+		user_id = :user_id AND
+		idempotency_key = :idempotency_key`
+
+	var k Key
+	if err := database.NamedQueryStruct(ctx, db, q, in, &k); err != nil {
+		return Key{}, fmt.Errorf("selecting idempotency key[%s] of user[%s]: %w", key, userID, err)
+	}
+
+	return k, nil
+}
+
+// Complete records the response of the request bound to the idempotency key.
+func Complete(ctx context.Context, db sqlx.ExtContext, key Key) error {
+	const q = `
+	UPDATE
+		idempotency_keys
+	SET
+		status = :status,
+		content_type = :content_type,
+		body = :body,
+		updated_at = :updated_at
+	WHERE
+		user_id = :user_id AND
+		idempotency_key = :idempotency_key`
+
+	if err := database.NamedExecContext(ctx, db, q, key); err != nil {
+		return fmt.Errorf("updating idempotency key[%s]: %w", key.Key, err)
+	}
+
+	return nil
+}
+
+// Delete removes the idempotency key sent by the passed user.
+func Delete(ctx context.Context, db sqlx.ExtContext, userID string, key string) error {
+	in := struct {
+		UserID string `db:"user_id"`
+		Key    string `db:"idempotency_key"`
+	}{
+		UserID: userID,
+		Key:    key,
+	}
+
+	const q = `
+	DELETE FROM
+		idempotency_keys
+	WHERE
+		user_id = :user_id AND
+		idempotency_key = :idempotency_key`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting idempotency key[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// DeleteBefore removes all the idempotency keys created before the passed time.
+func DeleteBefore(ctx context.Context, db sqlx.ExtContext, before time.Time) error {
+	in := struct {
+		Before time.Time `db:"before"`
+	}{
+		Before: before,
+	}
+
+	const q = `
+	DELETE FROM
+		idempotency_keys
+	WHERE
+		created_at < :before`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting idempotency keys: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/validate"
+)
+
+// suspend records a dispute opened on the payment of an order.
+// The order doesn't grant access to its courses until the dispute is won.
+// Disputes already recorded and payments not bound to any order are ignored.
+func suspend(ctx context.Context, db *sqlx.DB, ev Event) error {
+	if ev.PaymentID == "" || ev.DisputeID == "" {
+		return errors.New("dispute not bound to any payment")
+	}
+
+	ord, err := FetchByPaymentID(ctx, db, ev.PaymentID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	d := Dispute{
+		ID:                validate.GenerateID(),
+		OrderID:           ord.ID,
+		ProviderDisputeID: ev.DisputeID,
+		Status:            DisputeOpen,
+		Reason:            ev.Reason,
+		Amount:            ev.Amount.Amount,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	if err := CreateDispute(ctx, db, d); err != nil {
+		return fmt.Errorf("creating dispute for order[%s]: %w", ord.ID, err)
+	}
+
+	return nil
+}
+
+// settle closes the dispute opened on the payment of an order.
+// Won disputes restore the access to the courses, while lost disputes
+// refund all the items of the order, since the money has been given back.
+func settle(ctx context.Context, db *sqlx.DB, ev Event, won bool) error {
+	// Disputes may be closed before their opening is processed.
+	if err := suspend(ctx, db, ev); err != nil {
+		return err
+	}
+
+	status := DisputeWon
+	if !won {
+		status = DisputeLost
+
+		rev := ev
+		rev.RefundID = ev.DisputeID
+		if err := revoke(ctx, db, rev, true); err != nil {
+			return fmt.Errorf("refunding lost dispute: %w", err)
+		}
+	}
+
+	if err := UpdateDisputeStatus(ctx, db, ev.DisputeID, status, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// ExpirePending marks as expired all the orders that have been pending
+// for longer than ttl. It's meant to be periodically run in background.
+//
+// Checkouts still open on the provider side are cancelled, where supported,
+// so that they cannot be paid anymore.
+func ExpirePending(ctx context.Context, db *sqlx.DB, providers map[string]PaymentProvider, ttl time.Duration) error {
+	ords, err := FetchPendingBefore(ctx, db, time.Now().UTC().Add(-ttl))
+	if err != nil {
+		return fmt.Errorf("fetching pending orders: %w", err)
+	}
+
+	cancel := func(ord Order) error {
+		p, ok := lookup(providers, ord.Provider)
+		if !ok {
+			return fmt.Errorf("payment provider[%s] not available", ord.Provider)
+		}
+		return p.Cancel(ctx, ord)
+	}
+
+	var errs []error
+	for _, o := range ords {
+		if err := expire(ctx, db, o.ID, cancel); err != nil {
+			errs = append(errs, fmt.Errorf("expiring order[%s]: %w", o.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// expire marks the order as expired, only if it's still pending.
+// The cancel function, if not nil, is called before updating the order
+// to close the checkout on the provider side. If it fails the order
+// is left untouched.
+func expire(ctx context.Context, db *sqlx.DB, orderID string, cancel func(Order) error) error {
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		// The order may have been paid in the meanwhile.
+		if ord.Status != Pending || ord.PaymentID != "" {
+			return nil
+		}
+
+		if cancel != nil {
+			if err := cancel(ord); err != nil {
+				return fmt.Errorf("cancelling checkout[%s]: %w", ord.ProviderID, err)
+			}
+		}
+
+		up := StatusUp{
+			ID:        ord.ID,
+			Status:    Expired,
+			UpdatedAt: time.Now().UTC(),
+		}
+
+		if err := UpdateStatus(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating status: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// fail marks the order as failed, only if it's still pending.
+// It's used when a delayed payment, already bound to the order, is declined.
+func fail(ctx context.Context, db *sqlx.DB, providerID string) error {
+	ord, err := FetchByProviderID(ctx, db, providerID)
+	if err != nil {
+		return fmt.Errorf("fetching the order bound to payment[%s]: %w", providerID, err)
+	}
+
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		if ord.Status != Pending {
+			return nil
+		}
+
+		up := StatusUp{
+			ID:        ord.ID,
+			Status:    Failed,
+			UpdatedAt: time.Now().UTC(),
+		}
+
+		if err := UpdateStatus(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating status: %w", err)
+		}
+
+		return nil
+	})
+}
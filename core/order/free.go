@@ -0,0 +1,112 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/coupon"
+	"github.com/polldo/govod/core/gift"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// free is the provider bound to the orders that have nothing to be paid.
+var free = &Free{}
+
+// Free is the payment provider of orders whose total is zero, like
+// the enrollments to free courses. Nothing is charged, so these orders
+// are completed without contacting any external gateway.
+// It's not registered among the providers users can checkout with.
+type Free struct{}
+
+// Name implements the PaymentProvider interface.
+func (f *Free) Name() string {
+	return ProviderFree
+}
+
+// Checkout generates a new checkout id. Only lines with no price are accepted.
+func (f *Free) Checkout(ctx context.Context, lines []Line) (Checkout, error) {
+	if total(lines) != 0 {
+		return Checkout{}, errors.New("free checkout of lines to be paid")
+	}
+
+	return Checkout{ProviderID: ProviderFree + "-" + validate.GenerateID()}, nil
+}
+
+// Capture always succeeds, since there is nothing to be paid.
+func (f *Free) Capture(ctx context.Context, providerID string) (Payment, error) {
+	return Payment{ProviderID: providerID}, nil
+}
+
+// ParseWebhook is not supported: nothing is notified about free orders.
+func (f *Free) ParseWebhook(r *http.Request) (Event, error) {
+	return Event{}, ErrNotSupported
+}
+
+// Refund always succeeds, since there is nothing to give back.
+func (f *Free) Refund(ctx context.Context, ord Order, amount money.Money, key string) (string, error) {
+	return ProviderFree + "-refund-" + validate.GenerateID(), nil
+}
+
+// Cancel always succeeds.
+func (f *Free) Cancel(ctx context.Context, ord Order) error {
+	return nil
+}
+
+// Inspect is not supported: free orders are not recorded anywhere else.
+func (f *Free) Inspect(ctx context.Context, ord Order) (Record, error) {
+	return Record{}, ErrNotSupported
+}
+
+// total returns the amount to be paid for the lines.
+func total(lines []Line) int64 {
+	var tot int64
+	for _, l := range lines {
+		tot += l.Price.Amount
+	}
+	return tot
+}
+
+// lookup returns the provider bound to the orders with the passed name.
+// Free orders are bound to a provider that is always available.
+func lookup(providers map[string]PaymentProvider, name string) (PaymentProvider, bool) {
+	if name == ProviderFree {
+		return free, true
+	}
+
+	p, ok := providers[name]
+	return p, ok
+}
+
+// enroll creates the order of lines that have nothing to be paid and
+// fulfills it right away, returning its receipt. Like paid orders, the
+// fulfillment is retried in background if it fails.
+func enroll(ctx context.Context, db *sqlx.DB, userID string, lines []Line, cp *coupon.Coupon, gf *gift.GiftNew) (Receipt, error) {
+	chk, err := free.Checkout(ctx, lines)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	if err := prepare(ctx, db, userID, "", free.Name(), chk.ProviderID, lines, cp, gf); err != nil {
+		return Receipt{}, err
+	}
+
+	pay, err := free.Capture(ctx, chk.ProviderID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	if _, err := complete(ctx, db, free, pay); err != nil {
+		return Receipt{}, fmt.Errorf("completing free order[%s]: %w", chk.ProviderID, err)
+	}
+
+	ord, err := FetchByProviderID(ctx, db, chk.ProviderID)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("fetching free order[%s]: %w", chk.ProviderID, err)
+	}
+
+	return receipt(ctx, db, ord)
+}
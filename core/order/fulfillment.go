@@ -0,0 +1,302 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/affiliate"
+	"github.com/polldo/govod/core/cart"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+const (
+	// fulfillMaxAttempts is the number of attempts after which
+	// a fulfillment is considered failed and requires a manual action.
+	fulfillMaxAttempts = 10
+
+	// fulfillBackoff is the delay before the first retry of a fulfillment.
+	// It doubles at each attempt, up to fulfillMaxBackoff.
+	fulfillBackoff    = time.Minute
+	fulfillMaxBackoff = 2 * time.Hour
+)
+
+// capture records that the payment of the order bound to providerID
+// has been captured. In the same transaction the fulfillment of the order
+// is enqueued in the outbox, so that it's never lost even if it fails.
+// It returns the fulfillment of the order.
+//
+// Orders already fulfilled, even if refunded afterwards, are ignored,
+// while expired orders cannot be captured and ErrNotPending is returned.
+func capture(ctx context.Context, db *sqlx.DB, providerID string, paymentID string) (Fulfillment, error) {
+	ord, err := FetchByProviderID(ctx, db, providerID)
+	if err != nil {
+		return Fulfillment{}, fmt.Errorf("fetching the order bound to payment[%s]: %w", providerID, err)
+	}
+
+	var f Fulfillment
+	err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		switch ord.Status {
+		case Success, PartiallyRefunded, Refunded:
+			// Nothing left to do: the capture has been notified again.
+			f = Fulfillment{OrderID: ord.ID, Status: FulfillmentDone}
+			return nil
+		case Pending:
+		default:
+			return fmt.Errorf("status[%s]: %w", ord.Status, ErrNotPending)
+		}
+
+		now := time.Now().UTC()
+
+		if paymentID != "" {
+			up := PaymentUp{
+				ID:        ord.ID,
+				PaymentID: paymentID,
+				UpdatedAt: now,
+			}
+
+			if err := UpdatePayment(ctx, tx, up); err != nil {
+				return fmt.Errorf("updating payment: %w", err)
+			}
+		}
+
+		nf := Fulfillment{
+			ID:            validate.GenerateID(),
+			OrderID:       ord.ID,
+			Status:        FulfillmentPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+
+		// Captures can be notified multiple times: keep the first fulfillment.
+		if err := CreateFulfillment(ctx, tx, nf); err != nil {
+			return fmt.Errorf("creating fulfillment: %w", err)
+		}
+
+		if f, err = FetchFulfillmentByOrder(ctx, tx, ord.ID); err != nil {
+			return fmt.Errorf("fetching fulfillment: %w", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return Fulfillment{}, fmt.Errorf("capturing the order[%s] bound to payment[%s]: %w", ord.ID, providerID, err)
+	}
+	return f, nil
+}
+
+// await binds a payment that is still being processed to its order,
+// as happens with delayed payment methods. Orders bound to a payment
+// are never expired: they wait for the payment to succeed or fail.
+func await(ctx context.Context, db *sqlx.DB, pay Payment) error {
+	if pay.PaymentID == "" {
+		return nil
+	}
+
+	ord, err := FetchByProviderID(ctx, db, pay.ProviderID)
+	if err != nil {
+		return fmt.Errorf("fetching the order bound to payment[%s]: %w", pay.ProviderID, err)
+	}
+
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		if ord.Status != Pending || ord.PaymentID != "" {
+			return nil
+		}
+
+		up := PaymentUp{
+			ID:        ord.ID,
+			PaymentID: pay.PaymentID,
+			UpdatedAt: time.Now().UTC(),
+		}
+
+		if err := UpdatePayment(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating payment: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// complete records the payment captured by the provider and tries to
+// fulfill its order. It returns whether the order has been fulfilled,
+// otherwise the fulfillment will be retried in background.
+// Payments of orders that are not pending anymore are refunded
+// and ErrNotPending is returned.
+func complete(ctx context.Context, db *sqlx.DB, p PaymentProvider, pay Payment) (bool, error) {
+	f, err := capture(ctx, db, pay.ProviderID, pay.PaymentID)
+	if err != nil {
+		// The order expired before the payment: give the money back.
+		if errors.Is(err, ErrNotPending) {
+			ord := Order{Provider: p.Name(), ProviderID: pay.ProviderID, PaymentID: pay.PaymentID}
+			if _, rerr := p.Refund(ctx, ord, money.Money{}, "late-"+pay.ProviderID); rerr != nil {
+				return false, fmt.Errorf("refunding late payment[%s]: %v: %w", pay.PaymentID, err, rerr)
+			}
+		}
+		return false, err
+	}
+
+	if f.Status == FulfillmentDone {
+		return true, nil
+	}
+
+	return attempt(ctx, db, f) == nil, nil
+}
+
+// fulfill completes the order, granting access to the bought courses,
+// and issues its invoice. Commissions of the referring affiliate are accrued.
+// Orders already fulfilled are ignored, while orders that are not
+// pending anymore cannot be fulfilled and ErrNotPending is returned.
+func fulfill(ctx context.Context, db *sqlx.DB, orderID string) error {
+	err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		switch ord.Status {
+		case Success:
+			// Only the outbox record may be left behind.
+			return CompleteFulfillment(ctx, tx, ord.ID, now)
+		case Pending:
+		default:
+			return fmt.Errorf("status[%s]: %w", ord.Status, ErrNotPending)
+		}
+
+		up := StatusUp{
+			ID:        ord.ID,
+			Status:    Success,
+			UpdatedAt: now,
+		}
+
+		if err = UpdateStatus(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating status: %w", err)
+		}
+
+		if err = CompleteFulfillment(ctx, tx, ord.ID, now); err != nil {
+			return fmt.Errorf("completing fulfillment: %w", err)
+		}
+
+		ok, err := invoiceable(ctx, tx, ord)
+		if err != nil {
+			return fmt.Errorf("checking invoice: %w", err)
+		}
+
+		if ok {
+			if _, err = issue(ctx, tx, ord.ID, now); err != nil {
+				return fmt.Errorf("issuing invoice: %w", err)
+			}
+		}
+
+		if err = accrue(ctx, tx, ord, now); err != nil {
+			return fmt.Errorf("accruing commissions: %w", err)
+		}
+
+		// Finally flush the cart as a last step.
+		// Seats of organizations are not bought through the cart.
+		if ord.OrgID == "" {
+			if err = cart.Delete(ctx, tx, ord.UserID); err != nil {
+				return fmt.Errorf("flushing cart: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("fulfilling the order[%s]: %w", orderID, err)
+	}
+	return nil
+}
+
+// accrue records the commissions earned on the items of the order
+// by the affiliate who referred it, if any.
+func accrue(ctx context.Context, tx sqlx.ExtContext, ord Order, now time.Time) error {
+	if ord.AffiliateID == "" {
+		return nil
+	}
+
+	items, err := FetchItems(ctx, tx, ord.ID)
+	if err != nil {
+		return fmt.Errorf("fetching items: %w", err)
+	}
+
+	sales := make([]affiliate.Sale, 0, len(items))
+	for _, it := range items {
+		sales = append(sales, affiliate.Sale{
+			CourseID: it.CourseID,
+			Price:    it.Price,
+			Tax:      it.Tax,
+			Currency: ord.Currency,
+		})
+	}
+
+	return affiliate.Accrue(ctx, tx, ord.AffiliateID, ord.ID, ord.UserID, sales, now)
+}
+
+// attempt tries to fulfill the order of the passed fulfillment.
+// On failure, the fulfillment is rescheduled with an exponential backoff.
+// It is marked as failed once attempts are exhausted or if the order
+// cannot be fulfilled anymore.
+func attempt(ctx context.Context, db *sqlx.DB, f Fulfillment) error {
+	ferr := fulfill(ctx, db, f.OrderID)
+	if ferr == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	f.Attempts++
+	f.LastError = ferr.Error()
+	f.UpdatedAt = now
+
+	backoff := fulfillBackoff << (f.Attempts - 1)
+	if backoff > fulfillMaxBackoff || backoff <= 0 {
+		backoff = fulfillMaxBackoff
+	}
+	f.NextAttemptAt = now.Add(backoff)
+
+	if f.Attempts >= fulfillMaxAttempts || errors.Is(ferr, ErrNotPending) {
+		f.Status = FulfillmentFailed
+	}
+
+	if err := UpdateFulfillment(ctx, db, f); err != nil {
+		return fmt.Errorf("rescheduling fulfillment[%s]: %v: %w", f.ID, err, ferr)
+	}
+
+	return ferr
+}
+
+// RetryFulfillments retries all the fulfillments whose next attempt is due.
+// It's meant to be periodically run in background.
+func RetryFulfillments(ctx context.Context, db *sqlx.DB) error {
+	fs, err := FetchFulfillmentsDue(ctx, db, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("fetching due fulfillments: %w", err)
+	}
+
+	var errs []error
+	for _, f := range fs {
+		if err := attempt(ctx, db, f); err != nil {
+			errs = append(errs, fmt.Errorf("retrying fulfillment[%s]: %w", f.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -2,79 +2,237 @@ package order
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/polldo/govod/api/web"
 	"github.com/polldo/govod/api/weberr"
 	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/affiliate"
+	"github.com/polldo/govod/core/bundle"
 	"github.com/polldo/govod/core/cart"
 	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/core/coupon"
 	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/gift"
+	"github.com/polldo/govod/core/org"
 	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/tax"
 	"github.com/polldo/govod/validate"
-	"github.com/stripe/stripe-go/v74"
-	stripecl "github.com/stripe/stripe-go/v74/client"
-	"github.com/stripe/stripe-go/v74/webhook"
-
-	"github.com/plutov/paypal/v4"
 )
 
-// checkout retrieves the latest details of the courses in the cart.
-func checkout(ctx context.Context, db *sqlx.DB, userID string) ([]course.Course, error) {
-	items, err := cart.FetchItems(ctx, db, userID)
+// checkout retrieves the latest details of the courses in the cart,
+// together with the price to be charged for each of them in the passed currency.
+// Prices are discounted by the coupon applied to the cart, if any, and
+// taxed according to the billing location of the user.
+//
+// Bundles in the cart are charged as a single line, credited of the
+// courses already owned by the user. Courses in the cart that are also
+// part of a bundle are bought within the bundle. Coupons only apply to courses.
+// Nothing is credited on gifts, since their courses are not bought for the user.
+func checkout(ctx context.Context, db *sqlx.DB, userID string, currency string, bill CheckoutNew) ([]Line, *coupon.Coupon, error) {
+	courses, err := cart.FetchCourses(ctx, db, userID, currency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching cart courses: %w", err)
+	}
+
+	quotes, err := quoteBundles(ctx, db, userID, currency, bill.Gift != nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	covered := make(map[string]bool)
+	for _, q := range quotes {
+		for _, c := range q.Courses {
+			covered[c.ID] = true
+		}
+	}
+
+	left := make([]course.Course, 0, len(courses))
+	for _, c := range courses {
+		if !covered[c.ID] {
+			left = append(left, c)
+		}
+	}
+	courses = left
+
+	// Users without a cart have no coupon applied.
+	crt, err := cart.Fetch(ctx, db, userID)
+	if err != nil && !errors.Is(err, database.ErrDBNotFound) {
+		return nil, nil, fmt.Errorf("fetching cart: %w", err)
+	}
+
+	cp, err := coupon.Resolve(ctx, db, crt.CouponCode, userID, courses)
 	if err != nil {
-		return nil, fmt.Errorf("fetching cart items: %w", err)
+		return nil, nil, fmt.Errorf("resolving coupon: %w", err)
+	}
+
+	rate := tax.Lookup(bill.Country, bill.Region)
+	q := coupon.Apply(cp, courses)
+	lines := make([]Line, 0, len(courses)+len(quotes))
+	for i, c := range courses {
+		b := rate.Apply(q.Lines[i].Total)
+		lines = append(lines, Line{
+			Course:   c,
+			Price:    money.New(b.Gross, c.Currency),
+			Discount: money.New(q.Lines[i].Discount, c.Currency),
+			Tax:      money.New(b.Tax, c.Currency),
+			Rate:     rate,
+		})
+	}
+
+	for i := range quotes {
+		qt := quotes[i]
+		b := rate.Apply(qt.Price.Amount)
+		lines = append(lines, Line{
+			Bundle:   &qt,
+			Price:    money.New(b.Gross, qt.Price.Currency),
+			Discount: money.New(0, qt.Price.Currency),
+			Tax:      money.New(b.Tax, qt.Price.Currency),
+			Rate:     rate,
+		})
+	}
+
+	return lines, cp, nil
+}
+
+// quoteBundles prices the bundles in the user's cart in the passed currency.
+// Courses already owned by the user, or granted by a previous bundle,
+// are credited on the price of the bundles, unless the bundles are a gift.
+// It returns bundle.ErrOwned if a bundle grants no new courses.
+func quoteBundles(ctx context.Context, db *sqlx.DB, userID string, currency string, gift bool) ([]bundle.Quote, error) {
+	items, err := cart.FetchBundles(ctx, db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching cart bundles: %w", err)
+	}
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	covered := make(map[string]bool)
+	if !gift {
+		owned, err := course.FetchByOwner(ctx, db, userID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching courses owned: %w", err)
+		}
+
+		for _, c := range owned {
+			covered[c.ID] = true
+		}
 	}
 
-	courses := make([]course.Course, 0, len(items))
+	quotes := make([]bundle.Quote, 0, len(items))
 	for _, it := range items {
-		c, err := course.Fetch(ctx, db, it.CourseID)
+		b, err := bundle.Fetch(ctx, db, it.BundleID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching bundle[%s]: %w", it.BundleID, err)
+		}
+
+		courses, err := bundle.FetchCourses(ctx, db, b)
 		if err != nil {
-			return nil, fmt.Errorf("fetching course[%s]: %w", it.CourseID, err)
+			return nil, err
 		}
 
-		courses = append(courses, c)
+		q, err := bundle.Credit(b, courses, covered, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range q.Courses {
+			covered[c.ID] = true
+		}
+		quotes = append(quotes, q)
 	}
 
-	return courses, nil
+	return quotes, nil
 }
 
 // prepare creates the order and its items in the database,
-// binding the order to the passed providerID.
-func prepare(ctx context.Context, db *sqlx.DB, userID string, providerID string, courses []course.Course) error {
+// binding the order to the passed provider and providerID.
+// The order is charged in the currency of the lines, which cannot be empty.
+// The coupon, if any, is redeemed by the order.
+// Orders bought as a gift are bound to the gift for the recipient, while
+// orders of seats are bound to the organization orgID.
+// Orders are attributed to the affiliate who referred the user, if any.
+func prepare(ctx context.Context, db *sqlx.DB, userID string, orgID string, provider string, providerID string, lines []Line, cp *coupon.Coupon, gf *gift.GiftNew) error {
 	err := database.Transaction(db, func(tx sqlx.ExtContext) error {
 		now := time.Now().UTC()
+		rate := lines[0].Rate
 		ord := Order{
-			ID:         validate.GenerateID(),
-			UserID:     userID,
-			ProviderID: providerID,
-			Status:     Pending,
-			CreatedAt:  now,
-			UpdatedAt:  now,
+			ID:           validate.GenerateID(),
+			UserID:       userID,
+			OrgID:        orgID,
+			AffiliateID:  affiliate.Referrer(ctx),
+			Provider:     provider,
+			ProviderID:   providerID,
+			Currency:     lines[0].Price.Currency,
+			Country:      rate.Country,
+			Region:       rate.Region,
+			TaxName:      rate.Name,
+			TaxInclusive: rate.Inclusive,
+			Status:       Pending,
+			CreatedAt:    now,
+			UpdatedAt:    now,
 		}
 
 		if err := Create(ctx, tx, ord); err != nil {
 			return fmt.Errorf("creating order: %w", err)
 		}
 
-		for _, c := range courses {
+		var discount int64
+		courses := make([]course.Course, 0, len(lines))
+		for _, l := range lines {
+			if l.Bundle != nil {
+				if err := createBundleItems(ctx, tx, ord.ID, l, now); err != nil {
+					return err
+				}
+				continue
+			}
+
 			it := Item{
 				OrderID:   ord.ID,
-				CourseID:  c.ID,
-				Price:     c.Price,
+				CourseID:  l.Course.ID,
+				Seats:     l.Seats,
+				Price:     l.Price.Amount,
+				Tax:       l.Tax.Amount,
+				TaxRate:   l.Rate.Value,
 				CreatedAt: now,
 			}
 
 			if err := CreateItem(ctx, tx, it); err != nil {
 				return fmt.Errorf("creating item: %w", err)
 			}
+
+			discount += l.Discount.Amount
+			courses = append(courses, l.Course)
+		}
+
+		if cp != nil {
+			if err := coupon.Redeem(ctx, tx, *cp, userID, ord.ID, courses, discount); err != nil {
+				return fmt.Errorf("redeeming coupon: %w", err)
+			}
+		}
+
+		if gf != nil {
+			g := gift.Gift{
+				ID:        validate.GenerateID(),
+				OrderID:   ord.ID,
+				UserID:    userID,
+				Email:     gf.Email,
+				Message:   gf.Message,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+
+			if err := gift.Create(ctx, tx, g); err != nil {
+				return fmt.Errorf("creating gift: %w", err)
+			}
 		}
 
 		return nil
@@ -86,245 +244,1139 @@ func prepare(ctx context.Context, db *sqlx.DB, userID string, providerID string,
 	return nil
 }
 
-func fulfill(ctx context.Context, db *sqlx.DB, providerID string) error {
-	ord, err := FetchByProviderID(ctx, db, providerID)
-	if err != nil {
-		return fmt.Errorf("fetching the order bound to payment[%s]: %w", providerID, err)
-	}
+// createBundleItems creates an item for each course granted by the bundle
+// of the line. The price and the tax of the line are split among the courses,
+// so that courses can be refunded on their own.
+func createBundleItems(ctx context.Context, tx sqlx.ExtContext, orderID string, l Line, now time.Time) error {
+	q := l.Bundle
+	prices := bundle.Split(l.Price.Amount, q.Shares)
+	taxes := bundle.Split(l.Tax.Amount, q.Shares)
 
-	err = database.Transaction(db, func(tx sqlx.ExtContext) error {
-		up := StatusUp{
-			ID:        ord.ID,
-			Status:    Success,
-			UpdatedAt: time.Now().UTC(),
+	for i, c := range q.Courses {
+		it := Item{
+			OrderID:   orderID,
+			CourseID:  c.ID,
+			BundleID:  q.Bundle.ID,
+			Price:     prices[i],
+			Tax:       taxes[i],
+			TaxRate:   l.Rate.Value,
+			CreatedAt: now,
 		}
 
-		if err = UpdateStatus(ctx, tx, up); err != nil {
-			return fmt.Errorf("updating status: %w", err)
+		if err := CreateItem(ctx, tx, it); err != nil {
+			return fmt.Errorf("creating item of bundle[%s]: %w", q.Bundle.ID, err)
 		}
+	}
+
+	return nil
+}
 
-		// Finally flush the cart as a last step.
-		if err = cart.Delete(ctx, tx, ord.UserID); err != nil {
-			return fmt.Errorf("flushing cart: %w", err)
+// checkoutSeats retrieves the latest details of the courses whose seats
+// are bought by an organization, together with the price to be charged
+// for all the seats of each course in the passed currency.
+// Seats are taxed according to the billing location of the organization.
+func checkoutSeats(ctx context.Context, db *sqlx.DB, currency string, bill SeatsCheckoutNew) ([]Line, error) {
+	rate := tax.Lookup(bill.Country, bill.Region)
+	lines := make([]Line, 0, len(bill.Seats))
+	for _, sn := range bill.Seats {
+		c, err := course.Fetch(ctx, db, sn.CourseID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching course[%s]: %w", sn.CourseID, err)
 		}
 
-		return nil
-	})
+		if c, err = c.In(currency); err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return fmt.Errorf("fulfilling the order[%s] bound to payment[%s]: %w", ord.ID, providerID, err)
+		b := rate.Apply(c.Price * int64(sn.Seats))
+		lines = append(lines, Line{
+			Course:   c,
+			Seats:    sn.Seats,
+			Price:    money.New(b.Gross, c.Currency),
+			Discount: money.New(0, c.Currency),
+			Tax:      money.New(b.Tax, c.Currency),
+			Rate:     rate,
+		})
 	}
-	return nil
+
+	return lines, nil
+}
+
+// paymentProvider extracts the payment provider specified in the request.
+func paymentProvider(r *http.Request, providers map[string]PaymentProvider) (PaymentProvider, error) {
+	name := web.Param(r, "provider")
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, weberr.NotFound(fmt.Errorf("payment provider %s not found", name))
+	}
+
+	return p, nil
 }
 
-// HandlePaypalCheckout starts the purchase flow with paypal.
-func HandlePaypalCheckout(db *sqlx.DB, pp *paypal.Client) web.Handler {
+// HandleCheckout starts the purchase flow with the requested provider.
+// The billing location of the user determines the taxes to be charged.
+// Users can buy the cart for someone else by passing the recipient of the gift.
+// The response of the provider is returned to let the user pay.
+// Carts with nothing to be paid, like carts of free courses or fully
+// discounted ones, skip the provider: the order is fulfilled right away
+// and its receipt is returned with status 201.
+func HandleCheckout(db *sqlx.DB, providers map[string]PaymentProvider) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p, err := paymentProvider(r, providers)
+		if err != nil {
+			return err
+		}
+
+		var bill CheckoutNew
+		if err := web.Decode(w, r, &bill); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		bill.Country = strings.ToUpper(bill.Country)
+		bill.Region = strings.ToUpper(bill.Region)
+		if err := validate.Check(bill); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
 		clm, err := claims.Get(ctx)
 		if err != nil {
 			return weberr.NotAuthorized(errors.New("user not authenticated"))
 		}
 
-		courses, err := checkout(ctx, db, clm.UserID)
+		currency, err := cart.Currency(ctx, db, r, clm.UserID)
+		if err != nil {
+			return err
+		}
+
+		lines, cp, err := checkout(ctx, db, clm.UserID, currency, bill)
 		if err != nil {
+			if errors.Is(err, coupon.ErrNotApplicable) ||
+				errors.Is(err, course.ErrNoPrice) ||
+				errors.Is(err, bundle.ErrOwned) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
 			return fmt.Errorf("fetching details of cart items: %w", err)
 		}
 
-		if len(courses) == 0 {
+		if len(lines) == 0 {
 			err := errors.New("no items to checkout")
 			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
 		}
 
-		var tot int
-		items := make([]paypal.Item, 0, len(courses))
-		for _, c := range courses {
-			items = append(items, paypal.Item{
-				Quantity:    "1",
-				Name:        c.Name,
-				Description: c.Description,
+		if total(lines) == 0 {
+			rc, err := enroll(ctx, db, clm.UserID, lines, cp, bill.Gift)
+			if err != nil {
+				if errors.Is(err, coupon.ErrNotApplicable) {
+					return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+				}
+				return fmt.Errorf("creating the free order: %w", err)
+			}
 
-				UnitAmount: &paypal.Money{
-					Currency: "USD",
-					Value:    strconv.Itoa(c.Price),
-				},
-			})
+			return web.Respond(ctx, w, rc, http.StatusCreated)
+		}
+
+		chk, err := p.Checkout(ctx, lines)
+		if err != nil {
+			return fmt.Errorf("starting %s checkout: %w", p.Name(), err)
+		}
+
+		if err := prepare(ctx, db, clm.UserID, "", p.Name(), chk.ProviderID, lines, cp, bill.Gift); err != nil {
+			if errors.Is(err, coupon.ErrNotApplicable) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("creating the order on the database: %w", err)
+		}
 
-			tot += c.Price
+		return web.Respond(ctx, w, chk.Response, http.StatusOK)
+	}
+}
+
+// HandleEnroll allows users to enroll in free courses without going
+// through a payment provider. The enrollment is recorded as an order with
+// nothing to be paid, which is fulfilled right away.
+func HandleEnroll(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		courseID := web.Param(r, "id")
+
+		if err := validate.CheckID(courseID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		c, err := course.Fetch(ctx, db, courseID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return fmt.Errorf("fetching course[%s]: %w", courseID, err)
+		}
+
+		if !c.Free() {
+			err := fmt.Errorf("course[%s] is not free", c.ID)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
 		}
 
-		units := []paypal.PurchaseUnitRequest{{
-			Items: items,
+		owned, err := course.FetchByOwner(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("checking if course[%s] is already owned by user[%s]: %w", c.ID, clm.UserID, err)
+		}
 
-			Amount: &paypal.PurchaseUnitAmount{
-				Currency: "USD",
-				Value:    strconv.Itoa(tot),
+		for _, o := range owned {
+			if o.ID == c.ID {
+				err := errors.New("course already owned")
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+		}
 
-				Breakdown: &paypal.PurchaseUnitAmountBreakdown{ItemTotal: &paypal.Money{
-					Currency: "USD",
-					Value:    strconv.Itoa(tot),
-				}},
-			},
+		lines := []Line{{
+			Course:   c,
+			Price:    money.New(0, c.Currency),
+			Discount: money.New(0, c.Currency),
+			Tax:      money.New(0, c.Currency),
 		}}
 
-		// TODO: Extract these params from the configuration.
-		app := &paypal.ApplicationContext{
-			// ReturnURL: "/success.html",
-			// CancelURL: "/canceled.html",
+		rc, err := enroll(ctx, db, clm.UserID, lines, nil, nil)
+		if err != nil {
+			return fmt.Errorf("enrolling user[%s] in course[%s]: %w", clm.UserID, c.ID, err)
+		}
+
+		return web.Respond(ctx, w, rc, http.StatusCreated)
+	}
+}
+
+// HandleCheckoutSeats starts the purchase of seats of courses for an
+// organization with the requested provider. Only owners and managers
+// of the organization can buy seats, which are then assigned to its members.
+func HandleCheckoutSeats(db *sqlx.DB, providers map[string]PaymentProvider) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+
+		p, err := paymentProvider(r, providers)
+		if err != nil {
+			return err
+		}
+
+		var bill SeatsCheckoutNew
+		if err := web.Decode(w, r, &bill); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		bill.Country = strings.ToUpper(bill.Country)
+		bill.Region = strings.ToUpper(bill.Region)
+		if err := validate.Check(bill); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := org.Authorize(ctx, db, orgID, clm.UserID, org.Owner, org.Manager); err != nil {
+			return err
+		}
+
+		currency, err := cart.Currency(ctx, db, r, clm.UserID)
+		if err != nil {
+			return err
+		}
+
+		lines, err := checkoutSeats(ctx, db, currency, bill)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) || errors.Is(err, course.ErrNoPrice) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching details of seats: %w", err)
 		}
 
-		ord, err := pp.CreateOrder(ctx, "CAPTURE", units, nil, app)
+		chk, err := p.Checkout(ctx, lines)
 		if err != nil {
-			return fmt.Errorf("creating paypal order: %w", err)
+			return fmt.Errorf("starting %s checkout: %w", p.Name(), err)
 		}
 
-		if err := prepare(ctx, db, clm.UserID, ord.ID, courses); err != nil {
+		if err := prepare(ctx, db, clm.UserID, orgID, p.Name(), chk.ProviderID, lines, nil, nil); err != nil {
 			return fmt.Errorf("creating the order on the database: %w", err)
 		}
 
-		return web.Respond(ctx, w, ord, http.StatusOK)
+		return web.Respond(ctx, w, chk.Response, http.StatusOK)
 	}
 }
 
-// HandlePaypalCapture checks if the user's purchase has been
+// HandleCapture checks if the user's purchase has been
 // successfully completed. After the capture, the money of the user
-// will be transferred to our paypal account.
-func HandlePaypalCapture(db *sqlx.DB, pp *paypal.Client) web.Handler {
+// will be transferred to our account.
+// Payments captured on orders that are already expired are refunded.
+func HandleCapture(db *sqlx.DB, providers map[string]PaymentProvider) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p, err := paymentProvider(r, providers)
+		if err != nil {
+			return err
+		}
+
 		providerID := web.Param(r, "id")
 
-		resp, err := pp.CaptureOrder(ctx, providerID, paypal.CaptureOrderRequest{})
+		// Never capture the money of orders that cannot be fulfilled.
+		ord, err := FetchByProviderID(ctx, db, providerID)
 		if err != nil {
-			return fmt.Errorf("capturing paypal order[%s]: %w", providerID, err)
+			err := fmt.Errorf("fetching the order bound to payment[%s]: %w", providerID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if ord.Provider != p.Name() {
+			return weberr.NotFound(fmt.Errorf("order[%s] not bound to provider[%s]", ord.ID, p.Name()))
 		}
 
-		if resp.Status != "COMPLETED" {
-			return fmt.Errorf("captured order[%s] with status[%s] different from 'COMPLETED'", providerID, resp.Status)
+		switch ord.Status {
+		case Pending:
+		case Success, PartiallyRefunded, Refunded:
+			// Already completed, for instance through webhooks.
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		default:
+			err := fmt.Errorf("order[%s] with status[%s]: %w", ord.ID, ord.Status, ErrNotPending)
+			return weberr.NewError(err, ErrNotPending.Error(), http.StatusUnprocessableEntity)
+		}
+
+		pay, err := p.Capture(ctx, providerID)
+		if err != nil {
+			if errors.Is(err, ErrNotPaid) {
+				return weberr.NewError(err, ErrNotPaid.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("capturing %s order[%s]: %w", p.Name(), providerID, err)
 		}
 
-		// WARNING: This is a critical error. The user has payed but he won't have the bought courses.
-		// Take this issue in mind, manual recovery is needed at the moment.
-		//
-		// Putting an alarm here is a good compromise; so we don't need to use an external service
-		// like redis or google pub-sub to enqueue the fulfillment request.
-		// Then if this issue happens regularly we're going to solve it in a proper way.
-		if err := fulfill(ctx, db, providerID); err != nil {
-			// Try to refund the capture.
-			// pp.RefundCapture(ctx, providerID, paypal.RefundCaptureRequest{})
+		done, err := complete(ctx, db, p, pay)
+		if err != nil {
+			if errors.Is(err, ErrNotPending) {
+				return weberr.NewError(err, ErrNotPending.Error(), http.StatusUnprocessableEntity)
+			}
 
-			// Alternative: Use webhooks even for paypal capture.
-			// https://developer.paypal.com/api/rest/webhooks/
-			// PAYMENT.CAPTURE.COMPLETED https://developer.paypal.com/beta/apm-beta/additional-information/subscribe-to-webhooks/
-			// However, this is in BETA...
-			//
-			// We could also try order webhooks but it says: `Orders webhooks are for use by Partners only`
+			// WARNING: This is a critical error. The user has payed but the payment
+			// could not be recorded. Manual recovery is needed.
+			return fmt.Errorf("the order was payed but its capture could not be recorded: %w", err)
+		}
 
-			return fmt.Errorf("the order was payed but its fulfillment failed: %w", err)
+		// The fulfillment will be retried in background.
+		if !done {
+			return web.Respond(ctx, w, nil, http.StatusAccepted)
 		}
 
 		return web.Respond(ctx, w, nil, http.StatusNoContent)
 	}
 }
 
-// HandleStripeCheckout starts the purchase flow with stripe.
-func HandleStripeCheckout(db *sqlx.DB, strp *stripecl.API, cfg config.Stripe) web.Handler {
+// HandleWebhook processes the events notified by the requested provider.
+// Payments completed on orders that are already expired are refunded.
+// Events are recorded once processed, so that duplicate deliveries are ignored.
+func HandleWebhook(db *sqlx.DB, providers map[string]PaymentProvider) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-		clm, err := claims.Get(ctx)
+		p, err := paymentProvider(r, providers)
 		if err != nil {
-			return weberr.NotAuthorized(errors.New("user not authenticated"))
+			return err
 		}
 
-		courses, err := checkout(ctx, db, clm.UserID)
+		ev, err := p.ParseWebhook(r)
 		if err != nil {
-			return fmt.Errorf("fetching details of cart items: %w", err)
+			if errors.Is(err, ErrNotSupported) {
+				return weberr.NotFound(fmt.Errorf("provider[%s] has no webhooks", p.Name()))
+			}
+			return weberr.BadRequest(fmt.Errorf("parsing %s event: %w", p.Name(), err))
 		}
 
-		if len(courses) == 0 {
-			err := errors.New("no items to checkout")
-			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		if ev.Type == EventIgnored || ev.ID == "" {
+			if err := process(ctx, db, p, ev); err != nil {
+				return err
+			}
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
 		}
 
-		li := make([]*stripe.CheckoutSessionLineItemParams, 0, len(courses))
-		for _, c := range courses {
-			li = append(li, &stripe.CheckoutSessionLineItemParams{
-				Quantity: stripe.Int64(1),
+		// Record the event before processing it, so that concurrent
+		// deliveries of the same event are processed only once.
+		we := WebhookEvent{
+			Provider:  p.Name(),
+			ID:        ev.ID,
+			Type:      ev.Type,
+			CreatedAt: time.Now().UTC(),
+		}
 
-				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency:    stripe.String("usd"),
-					TaxBehavior: stripe.String("inclusive"),
-					UnitAmount:  stripe.Int64(int64(c.Price) * 100),
+		if err := CreateWebhookEvent(ctx, db, we); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return web.Respond(ctx, w, nil, http.StatusNoContent)
+			}
+			return err
+		}
 
-					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name:        stripe.String(c.Name),
-						Description: stripe.String(c.Description),
-					},
-				},
-			})
+		// Forget the event on failure: the provider will notify it again.
+		if err := process(ctx, db, p, ev); err != nil {
+			if derr := DeleteWebhookEvent(ctx, db, p.Name(), ev.ID); derr != nil {
+				return fmt.Errorf("%v: %w", derr, err)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// process applies the event notified by the provider to its order.
+func process(ctx context.Context, db *sqlx.DB, p PaymentProvider, ev Event) error {
+	switch ev.Type {
+	case EventProcessing:
+		if err := await(ctx, db, ev.Payment); err != nil {
+			return fmt.Errorf("binding payment[%s] in progress: %w", ev.PaymentID, err)
+		}
+
+	case EventCompleted:
+		// No need to fail if the fulfillment is still in progress,
+		// it will be retried in background.
+		// Otherwise the provider will notify the event again.
+		if _, err := complete(ctx, db, p, ev.Payment); err != nil && !errors.Is(err, ErrNotPending) {
+			return fmt.Errorf("the order was payed but its capture could not be recorded: %w", err)
 		}
 
-		params := &stripe.CheckoutSessionParams{
-			SuccessURL: stripe.String(cfg.SuccessURL),
-			CancelURL:  stripe.String(cfg.CancelURL),
-			Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
-			LineItems:  li,
+	case EventFailed:
+		if err := fail(ctx, db, ev.ProviderID); err != nil {
+			return fmt.Errorf("failing the order bound to payment[%s]: %w", ev.ProviderID, err)
 		}
 
-		// Create a new stripe checkout with the courses to be bought.
-		s, err := strp.CheckoutSessions.New(params)
+	case EventExpired:
+		ord, err := FetchByProviderID(ctx, db, ev.ProviderID)
 		if err != nil {
-			return fmt.Errorf("creating stripe session: %w", err)
+			return fmt.Errorf("fetching the order bound to payment[%s]: %w", ev.ProviderID, err)
 		}
 
-		if err := prepare(ctx, db, clm.UserID, s.ID, courses); err != nil {
-			return fmt.Errorf("creating the order on the database: %w", err)
+		if err := expire(ctx, db, ord.ID, nil); err != nil {
+			return fmt.Errorf("expiring order[%s]: %w", ord.ID, err)
+		}
+
+	case EventRefunded, EventReversed:
+		if err := revoke(ctx, db, ev, ev.Type == EventReversed); err != nil {
+			return fmt.Errorf("revoking refund[%s] of payment[%s]: %w", ev.RefundID, ev.PaymentID, err)
 		}
 
-		return web.Respond(ctx, w, s.URL, http.StatusOK)
+	case EventDisputed:
+		if err := suspend(ctx, db, ev); err != nil {
+			return fmt.Errorf("opening dispute[%s] of payment[%s]: %w", ev.DisputeID, ev.PaymentID, err)
+		}
+
+	case EventDisputeWon, EventDisputeLost:
+		if err := settle(ctx, db, ev, ev.Type == EventDisputeWon); err != nil {
+			return fmt.Errorf("closing dispute[%s] of payment[%s]: %w", ev.DisputeID, ev.PaymentID, err)
+		}
 	}
+
+	return nil
 }
 
-// HandleStripeCapture completes the user's purchase.
-// Stripe webhooks must be configured to call this endpoint when a
-// checkout is completed.
+// HandleRefund allows administrators to refund a whole order or only
+// some of its items. The refund is performed through the same provider
+// that was used to pay the order.
+// Refunded items no longer grant access to their courses,
+// and the commissions earned on them are reversed.
 //
-// TODO: Remember to disable async payments.
-// https://stripe.com/docs/payments/checkout/fulfill-orders#delayed-notification .
-// TODO: rename in HandleStripeWebhooks.
-func HandleStripeCapture(db *sqlx.DB, cfg config.Stripe) web.Handler {
+// Refunds are recorded as pending before being requested to the provider,
+// so that the order is not locked meanwhile, and completed afterwards.
+// Refunds left pending, as when the provider fails, are retried by the
+// next request for the order, which cannot select other items.
+func HandleRefund(db *sqlx.DB, providers map[string]PaymentProvider) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-		b, err := ioutil.ReadAll(r.Body)
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		var rn RefundNew
+		if err := web.Decode(w, r, &rn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(rn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		refunds, err := refund(ctx, db, providers, orderID, rn)
+		if err != nil {
+			err := fmt.Errorf("refunding order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			if errors.Is(err, ErrNotRefundable) {
+				return weberr.NewError(err, ErrNotRefundable.Error(), http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, refunds, http.StatusOK)
+	}
+}
+
+// refund reserves the items of the order to refund, asks the provider to
+// refund them and completes their refunds.
+// The provider is passed an idempotency key bound to the pending refunds,
+// so that retries don't give back the money twice.
+func refund(ctx context.Context, db *sqlx.DB, providers map[string]PaymentProvider, orderID string, rn RefundNew) ([]Refund, error) {
+	ord, pending, err := reserveRefund(ctx, db, orderID, rn)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := lookup(providers, ord.Provider)
+	if !ok {
+		return nil, fmt.Errorf("payment provider[%s] not available", ord.Provider)
+	}
+
+	var tot int64
+	for _, ref := range pending {
+		tot += ref.Amount
+	}
+
+	provRefundID, err := p.Refund(ctx, ord, money.New(tot, ord.Currency), "refund-"+pending[0].ID)
+	if err != nil {
+		return nil, fmt.Errorf("refunding %s order[%s]: %w", p.Name(), ord.ID, err)
+	}
+
+	return completeRefund(ctx, db, ord.ID, pending, provRefundID)
+}
+
+// reserveRefund records the pending refunds of the items selected in the
+// passed request, all the remaining ones by default, and returns them.
+// The refunds already pending are returned instead, if the request
+// doesn't select other items.
+func reserveRefund(ctx context.Context, db *sqlx.DB, orderID string, rn RefundNew) (Order, []Refund, error) {
+	var ord Order
+	var pending []Refund
+
+	// Lock the order to avoid refunding the same items twice.
+	err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+		var err error
+		ord, err = Lock(ctx, tx, orderID)
 		if err != nil {
-			return weberr.BadRequest(fmt.Errorf("cannot read the request body: %w", err))
+			return err
 		}
 
-		sig := r.Header.Get("Stripe-Signature")
-		if sig == "" {
-			return weberr.BadRequest(errors.New("received stripe event is not signed"))
+		if ord.Status != Success && ord.Status != PartiallyRefunded {
+			return fmt.Errorf("status[%s]: %w", ord.Status, ErrNotRefundable)
 		}
 
-		event, err := webhook.ConstructEvent(b, sig, cfg.WebhookSecret)
+		items, err := FetchItems(ctx, tx, ord.ID)
 		if err != nil {
-			return weberr.BadRequest(fmt.Errorf("cannot construct stripe event: %w", err))
+			return err
 		}
 
-		// Filter all the events but the checkout completion one.
-		if event.Type != "checkout.session.completed" {
-			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		done, err := FetchRefunds(ctx, tx, ord.ID)
+		if err != nil {
+			return err
 		}
 
-		var session stripe.CheckoutSession
-		if err = json.Unmarshal(event.Data.Raw, &session); err != nil {
-			return weberr.BadRequest(fmt.Errorf("unable to decode stripe event: %w", err))
+		refunded := make(map[string]bool, len(done))
+		for _, d := range done {
+			refunded[d.CourseID] = true
+			if d.Status == RefundPending {
+				pending = append(pending, d)
+			}
 		}
 
-		// Filter out checkouts that are not for one-time payments.
-		if session.Mode != stripe.CheckoutSessionModePayment {
-			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		requested := make(map[string]bool, len(rn.CourseIDs))
+		for _, id := range rn.CourseIDs {
+			requested[id] = true
 		}
 
-		if err := fulfill(ctx, db, session.ID); err != nil {
-			return fmt.Errorf("the order was payed but its fulfillment failed: %w", err)
+		// Retry the refunds left pending.
+		if len(pending) > 0 {
+			for _, ref := range pending {
+				delete(requested, ref.CourseID)
+			}
+			if len(requested) > 0 {
+				return fmt.Errorf("refund of other items in progress: %w", ErrNotRefundable)
+			}
+			return nil
 		}
 
-		return web.Respond(ctx, w, nil, http.StatusNoContent)
+		// Select the items to refund, all the remaining ones by default.
+		all := len(requested) == 0
+		now := time.Now().UTC()
+		for _, it := range items {
+			if !all && !requested[it.CourseID] {
+				continue
+			}
+			delete(requested, it.CourseID)
+
+			if refunded[it.CourseID] && all {
+				continue
+			}
+			if refunded[it.CourseID] {
+				return fmt.Errorf("course[%s] already refunded: %w", it.CourseID, ErrNotRefundable)
+			}
+
+			pending = append(pending, Refund{
+				ID:        validate.GenerateID(),
+				OrderID:   ord.ID,
+				CourseID:  it.CourseID,
+				Amount:    it.Price,
+				Status:    RefundPending,
+				CreatedAt: now,
+			})
+		}
+
+		if len(requested) > 0 || len(pending) == 0 {
+			return fmt.Errorf("no valid items to refund: %w", ErrNotRefundable)
+		}
+
+		for _, ref := range pending {
+			if err := CreateRefund(ctx, tx, ref); err != nil {
+				return fmt.Errorf("creating refund for course[%s]: %w", ref.CourseID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Order{}, nil, err
+	}
+
+	return ord, pending, nil
+}
+
+// completeRefund completes the pending refunds, once performed by the
+// provider, and updates the status of the order accordingly.
+func completeRefund(ctx context.Context, db *sqlx.DB, orderID string, pending []Refund, provRefundID string) ([]Refund, error) {
+	refunds := make([]Refund, 0, len(pending))
+
+	err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, ref := range pending {
+			if err := CompleteRefund(ctx, tx, ref.ID, provRefundID); err != nil {
+				return err
+			}
+
+			if err := affiliate.ReverseCommission(ctx, tx, ord.ID, ref.CourseID, now); err != nil {
+				return err
+			}
+
+			ref.ProviderRefundID = provRefundID
+			ref.Status = RefundCompleted
+			refunds = append(refunds, ref)
+		}
+
+		items, err := FetchItems(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		done, err := FetchRefunds(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		up := StatusUp{
+			ID:        ord.ID,
+			Status:    PartiallyRefunded,
+			UpdatedAt: now,
+		}
+		if len(done) == len(items) {
+			up.Status = Refunded
+		}
+
+		if err := UpdateStatus(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating status: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// receipt collects the details of the passed order.
+func receipt(ctx context.Context, db *sqlx.DB, ord Order) (Receipt, error) {
+	items, err := FetchItems(ctx, db, ord.ID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	refunds, err := FetchRefunds(ctx, db, ord.ID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	disputes, err := FetchDisputes(ctx, db, ord.ID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	rc := Receipt{Order: ord, Items: items, Refunds: refunds, Disputes: disputes}
+	for _, it := range items {
+		rc.Total += it.Price
+		rc.Tax += it.Tax
+	}
+	for _, ref := range refunds {
+		rc.Total -= ref.Amount
+	}
+
+	return rc, nil
+}
+
+// receipts collects the details of the orders matching the passed filter.
+func receipts(ctx context.Context, db *sqlx.DB, filter Filter) ([]Receipt, error) {
+	ords, err := FetchAll(ctx, db, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rcs := make([]Receipt, 0, len(ords))
+	for _, o := range ords {
+		rc, err := receipt(ctx, db, o)
+		if err != nil {
+			return nil, fmt.Errorf("collecting details of order[%s]: %w", o.ID, err)
+		}
+		rcs = append(rcs, rc)
+	}
+
+	return rcs, nil
+}
+
+// parseFilter extracts the order filter from the query parameters.
+// Dates must be expressed in RFC3339 format.
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+
+	filter := Filter{
+		UserID:   q.Get("userId"),
+		CourseID: q.Get("courseId"),
+		Status:   q.Get("status"),
+	}
+
+	for _, d := range []struct {
+		key string
+		dst **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		v := q.Get(d.key)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing %s date: %w", d.key, err)
+		}
+
+		t = t.UTC()
+		*d.dst = &t
+	}
+
+	if err := validate.Check(filter); err != nil {
+		return Filter{}, err
+	}
+
+	return filter, nil
+}
+
+// HandleList allows users to fetch the orders they made.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		rcs, err := receipts(ctx, db, Filter{UserID: clm.UserID})
+		if err != nil {
+			return fmt.Errorf("fetching orders of user[%s]: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, rcs, http.StatusOK)
+	}
+}
+
+// HandleShow allows users to fetch the details of one of their orders.
+func HandleShow(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		ord, err := Fetch(ctx, db, orderID)
+		if err != nil {
+			err := fmt.Errorf("fetching order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		// Users cannot know about orders of other users.
+		if ord.UserID != clm.UserID {
+			return weberr.NotFound(fmt.Errorf("order[%s] not owned by user[%s]", ord.ID, clm.UserID))
+		}
+
+		rc, err := receipt(ctx, db, ord)
+		if err != nil {
+			return fmt.Errorf("collecting details of order[%s]: %w", ord.ID, err)
+		}
+
+		return web.Respond(ctx, w, rc, http.StatusOK)
+	}
+}
+
+// HandleListAll allows administrators to fetch all the orders.
+// Orders can be filtered by user, course, status and creation date.
+func HandleListAll(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		filter, err := parseFilter(r)
+		if err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		rcs, err := receipts(ctx, db, filter)
+		if err != nil {
+			return fmt.Errorf("fetching orders: %w", err)
+		}
+
+		return web.Respond(ctx, w, rcs, http.StatusOK)
+	}
+}
+
+// HandleShowAny allows administrators to fetch the details of any order.
+func HandleShowAny(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		ord, err := Fetch(ctx, db, orderID)
+		if err != nil {
+			err := fmt.Errorf("fetching order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		rc, err := receipt(ctx, db, ord)
+		if err != nil {
+			return fmt.Errorf("collecting details of order[%s]: %w", ord.ID, err)
+		}
+
+		return web.Respond(ctx, w, rc, http.StatusOK)
+	}
+}
+
+// HandleListFulfillments allows administrators to inspect fulfillments,
+// optionally filtered by status.
+func HandleListFulfillments(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		filter := FulfillmentFilter{
+			Status: r.URL.Query().Get("status"),
+		}
+
+		if err := validate.Check(filter); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		fs, err := FetchFulfillments(ctx, db, filter)
+		if err != nil {
+			return fmt.Errorf("fetching fulfillments: %w", err)
+		}
+
+		return web.Respond(ctx, w, fs, http.StatusOK)
+	}
+}
+
+// HandleRetryFulfillment allows administrators to immediately retry
+// a fulfillment. Failed fulfillments are rescheduled as well.
+func HandleRetryFulfillment(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		fulfillmentID := web.Param(r, "id")
+
+		if err := validate.CheckID(fulfillmentID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		f, err := FetchFulfillment(ctx, db, fulfillmentID)
+		if err != nil {
+			err := fmt.Errorf("fetching fulfillment[%s]: %w", fulfillmentID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if f.Status != FulfillmentPending && f.Status != FulfillmentFailed {
+			err := fmt.Errorf("fulfillment[%s] with status[%s] cannot be retried", f.ID, f.Status)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		// Give the fulfillment a fresh set of attempts.
+		f.Status = FulfillmentPending
+		f.Attempts = 0
+
+		// Errors are recorded in the fulfillment itself.
+		attempt(ctx, db, f)
+
+		if f, err = FetchFulfillment(ctx, db, fulfillmentID); err != nil {
+			return fmt.Errorf("fetching fulfillment[%s]: %w", fulfillmentID, err)
+		}
+
+		return web.Respond(ctx, w, f, http.StatusOK)
+	}
+}
+
+// HandleResolveFulfillment allows administrators to mark a fulfillment
+// as resolved after having handled it manually. Resolved fulfillments
+// are not retried anymore.
+func HandleResolveFulfillment(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		fulfillmentID := web.Param(r, "id")
+
+		if err := validate.CheckID(fulfillmentID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		f, err := FetchFulfillment(ctx, db, fulfillmentID)
+		if err != nil {
+			err := fmt.Errorf("fetching fulfillment[%s]: %w", fulfillmentID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if f.Status == FulfillmentDone {
+			err := fmt.Errorf("fulfillment[%s] is already done", f.ID)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		f.Status = FulfillmentResolved
+		f.UpdatedAt = time.Now().UTC()
+
+		if err := UpdateFulfillment(ctx, db, f); err != nil {
+			return fmt.Errorf("resolving fulfillment[%s]: %w", f.ID, err)
+		}
+
+		return web.Respond(ctx, w, f, http.StatusOK)
+	}
+}
+
+// HandleListReconciliations allows administrators to list the
+// reconciliations performed, from the latest one.
+func HandleListReconciliations(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		recs, err := FetchReconciliations(ctx, db)
+		if err != nil {
+			return fmt.Errorf("fetching reconciliations: %w", err)
+		}
+
+		return web.Respond(ctx, w, recs, http.StatusOK)
+	}
+}
+
+// HandleShowReconciliation allows administrators to read the report
+// of a reconciliation, with all the discrepancies it found.
+func HandleShowReconciliation(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		recID := web.Param(r, "id")
+
+		if err := validate.CheckID(recID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		rec, err := FetchReconciliation(ctx, db, recID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		ds, err := FetchDiscrepancies(ctx, db, rec.ID)
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, Report{Reconciliation: rec, Discrepancies: ds}, http.StatusOK)
+	}
+}
+
+// HandleShowInvoice allows users to download the invoice of one of their
+// orders as a PDF document. Administrators can download any invoice.
+func HandleShowInvoice(db *sqlx.DB, seller config.Invoice) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		ord, err := Fetch(ctx, db, orderID)
+		if err != nil {
+			err := fmt.Errorf("fetching order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		// Users cannot know about orders of other users.
+		if ord.UserID != clm.UserID && !claims.IsAdmin(ctx) {
+			return weberr.NotFound(fmt.Errorf("order[%s] not owned by user[%s]", ord.ID, clm.UserID))
+		}
+
+		inv, err := FetchInvoiceByOrder(ctx, db, ord.ID)
+		if err != nil {
+			err := fmt.Errorf("fetching invoice of order[%s]: %w", ord.ID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		// The invoice may not have been rendered in background yet.
+		if inv.PDF == nil {
+			rendered, err := render(ctx, db, seller, inv)
+			if err != nil {
+				return fmt.Errorf("rendering invoice[%s]: %w", inv.Number, err)
+			}
+			inv = rendered
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%s.pdf\"", inv.Number))
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := w.Write(inv.PDF); err != nil {
+			return fmt.Errorf("writing invoice[%s]: %w", inv.Number, err)
+		}
+
+		return nil
+	}
+}
+
+// HandleRegenerateInvoice allows administrators to render again the
+// invoice of an order, keeping its number. If the invoice has been voided,
+// or the order has never been invoiced, a new invoice is issued.
+// Orders with nothing paid are never invoiced.
+func HandleRegenerateInvoice(db *sqlx.DB, seller config.Invoice) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		var inv Invoice
+		err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+			ord, err := Lock(ctx, tx, orderID)
+			if err != nil {
+				return err
+			}
+
+			if ord.Status != Success && ord.Status != PartiallyRefunded && ord.Status != Refunded {
+				return fmt.Errorf("status[%s]: %w", ord.Status, ErrNotInvoiced)
+			}
+
+			ok, err := invoiceable(ctx, tx, ord)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("nothing paid: %w", ErrNotInvoiced)
+			}
+
+			inv, err = FetchInvoiceByOrder(ctx, tx, ord.ID)
+			if err != nil {
+				if !errors.Is(err, database.ErrDBNotFound) {
+					return err
+				}
+				if inv, err = issue(ctx, tx, ord.ID, time.Now().UTC()); err != nil {
+					return fmt.Errorf("issuing invoice: %w", err)
+				}
+			}
+
+			rendered, err := render(ctx, tx, seller, inv)
+			if err != nil {
+				return fmt.Errorf("rendering invoice[%s]: %w", inv.Number, err)
+			}
+			inv = rendered
+
+			return nil
+		})
+
+		if err != nil {
+			err := fmt.Errorf("regenerating invoice of order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			if errors.Is(err, ErrNotInvoiced) {
+				return weberr.NewError(err, ErrNotInvoiced.Error(), http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, inv, http.StatusOK)
+	}
+}
+
+// HandleVoidInvoice allows administrators to void the invoice of an order.
+// Voided invoices keep their number, so that numbering stays gap-free.
+func HandleVoidInvoice(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orderID := web.Param(r, "id")
+
+		if err := validate.CheckID(orderID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		inv, err := FetchInvoiceByOrder(ctx, db, orderID)
+		if err != nil {
+			err := fmt.Errorf("fetching invoice of order[%s]: %w", orderID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		now := time.Now().UTC()
+		inv.Status = InvoiceVoid
+		inv.VoidedAt = &now
+		inv.UpdatedAt = now
+
+		if err := UpdateInvoice(ctx, db, inv); err != nil {
+			return fmt.Errorf("voiding invoice[%s]: %w", inv.Number, err)
+		}
+
+		return web.Respond(ctx, w, inv, http.StatusOK)
 	}
 }
@@ -0,0 +1,214 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/user"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/pdf"
+	"github.com/polldo/govod/tax"
+	"github.com/polldo/govod/validate"
+)
+
+// issue numbers a new invoice for the passed order.
+// It must be called within the transaction that makes the order
+// successful, so that numbers are assigned without gaps.
+// The document is rendered later on, see RenderInvoices.
+func issue(ctx context.Context, tx sqlx.ExtContext, orderID string, now time.Time) (Invoice, error) {
+	seq, err := NextInvoiceSeq(ctx, tx, now.Year())
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	inv := Invoice{
+		ID:        validate.GenerateID(),
+		OrderID:   orderID,
+		Year:      now.Year(),
+		Seq:       seq,
+		Number:    fmt.Sprintf("%d-%06d", now.Year(), seq),
+		Status:    InvoiceIssued,
+		IssuedAt:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := CreateInvoice(ctx, tx, inv); err != nil {
+		return Invoice{}, err
+	}
+
+	return inv, nil
+}
+
+// invoiceable reports whether the order is a sale to be invoiced.
+// Orders with nothing paid, like the enrollments to free courses,
+// must not use up the numbers of invoices.
+func invoiceable(ctx context.Context, tx sqlx.ExtContext, ord Order) (bool, error) {
+	if ord.Provider == ProviderFree {
+		return false, nil
+	}
+
+	items, err := FetchItems(ctx, tx, ord.ID)
+	if err != nil {
+		return false, err
+	}
+
+	var tot int64
+	for _, it := range items {
+		tot += it.Price
+	}
+
+	return tot > 0, nil
+}
+
+// render generates and stores the document of the passed invoice.
+func render(ctx context.Context, db sqlx.ExtContext, seller config.Invoice, inv Invoice) (Invoice, error) {
+	ord, err := Fetch(ctx, db, inv.OrderID)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	buyer, err := user.Fetch(ctx, db, ord.UserID)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("fetching buyer[%s]: %w", ord.UserID, err)
+	}
+
+	items, err := FetchItems(ctx, db, ord.ID)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	names := make(map[string]string, len(items))
+	for _, it := range items {
+		crs, err := course.Fetch(ctx, db, it.CourseID)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("fetching course[%s]: %w", it.CourseID, err)
+		}
+		names[it.CourseID] = crs.Name
+	}
+
+	inv.PDF = document(seller, inv, ord, buyer, items, names)
+	inv.UpdatedAt = time.Now().UTC()
+
+	if err := UpdateInvoice(ctx, db, inv); err != nil {
+		return Invoice{}, err
+	}
+
+	return inv, nil
+}
+
+// document lays out the invoice of the passed order.
+// Prices include taxes, which are split according to the rate applied to each item.
+// Items overflowing the page continue on new ones, each repeating the table header.
+func document(seller config.Invoice, inv Invoice, ord Order, buyer user.User, items []Item, names map[string]string) []byte {
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50.0
+		top    = 70.0
+		bottom = pdf.PageHeight - 60.0
+	)
+
+	amount := func(a int64) string {
+		return money.New(a, ord.Currency).String()
+	}
+
+	doc := pdf.New()
+	p := doc.AddPage()
+
+	p.Text(left, 70, 20, true, "Invoice "+inv.Number)
+	p.Text(left, 92, 10, false, "Issue date: "+inv.IssuedAt.Format("2006-01-02"))
+	p.Text(left, 106, 10, false, "Order: "+ord.ID)
+
+	// Seller and buyer details side by side.
+	p.Text(left, 140, 10, true, "Seller")
+	p.Text(300, 140, 10, true, "Buyer")
+	p.Text(left, 156, 10, false, seller.SellerName)
+	p.Text(300, 156, 10, false, buyer.Name)
+	p.Text(left, 170, 10, false, seller.SellerAddress)
+	p.Text(300, 170, 10, false, buyer.Email)
+	if seller.SellerTaxID != "" {
+		p.Text(left, 184, 10, false, "Tax ID: "+seller.SellerTaxID)
+	}
+	if ord.Country != "" {
+		p.Text(300, 184, 10, false, "Country: "+strings.Trim(ord.Country+"-"+ord.Region, "-"))
+	}
+
+	taxName := ord.TaxName
+	if taxName == "" {
+		taxName = "Tax"
+	}
+
+	header := func(y float64) {
+		p.Text(left, y, 10, true, "Course")
+		p.Text(260, y, 10, true, "Net")
+		p.Text(340, y, 10, true, "Rate")
+		p.Text(400, y, 10, true, taxName)
+		p.Text(470, y, 10, true, "Total")
+		p.Line(left, y+6, right, y+6)
+	}
+
+	// Line items with their tax breakdown.
+	y := 230.0
+	header(y)
+
+	var net, taxes, tot int64
+	for _, it := range items {
+		y += 20
+		if y > bottom {
+			p = doc.AddPage()
+			y = top
+			header(y)
+			y += 20
+		}
+
+		p.Text(left, y, 10, false, names[it.CourseID])
+		p.Text(260, y, 10, false, amount(it.Price-it.Tax))
+		p.Text(340, y, 10, false, tax.FormatPercent(it.TaxRate))
+		p.Text(400, y, 10, false, amount(it.Tax))
+		p.Text(470, y, 10, false, amount(it.Price))
+
+		net += it.Price - it.Tax
+		taxes += it.Tax
+		tot += it.Price
+	}
+
+	// Totals are kept together, below the last item.
+	y += 12
+	if y+56 > bottom {
+		p = doc.AddPage()
+		y = top
+	}
+	p.Line(left, y, right, y)
+	p.Text(300, y+20, 10, false, "Net total")
+	p.Text(470, y+20, 10, false, amount(net))
+	p.Text(300, y+36, 10, false, taxName)
+	p.Text(470, y+36, 10, false, amount(taxes))
+	p.Text(300, y+56, 12, true, "Total")
+	p.Text(470, y+56, 12, true, amount(tot))
+
+	return doc.Bytes()
+}
+
+// RenderInvoices renders the documents of all the issued invoices
+// that don't have one yet. It's meant to be periodically run in background.
+func RenderInvoices(ctx context.Context, db *sqlx.DB, seller config.Invoice) error {
+	invs, err := FetchInvoicesUnrendered(ctx, db)
+	if err != nil {
+		return fmt.Errorf("fetching unrendered invoices: %w", err)
+	}
+
+	var errs []error
+	for _, inv := range invs {
+		if _, err := render(ctx, db, seller, inv); err != nil {
+			errs = append(errs, fmt.Errorf("rendering invoice[%s]: %w", inv.Number, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,56 @@
+package order
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/user"
+)
+
+func TestDocumentPages(t *testing.T) {
+	tests := []struct {
+		items int
+		pages int
+	}{
+		{1, 1},
+		{24, 1},
+		{40, 2},
+		{80, 3},
+	}
+
+	seller := config.Invoice{SellerName: "Govod", SellerAddress: "Main street"}
+	inv := Invoice{Number: "2023-000001", IssuedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	ord := Order{ID: "order", Currency: "USD"}
+	buyer := user.User{Name: "Buyer", Email: "buyer@test.com"}
+
+	for _, tt := range tests {
+		items := make([]Item, 0, tt.items)
+		names := make(map[string]string, tt.items)
+		for i := 0; i < tt.items; i++ {
+			id := fmt.Sprintf("course-%d", i)
+			items = append(items, Item{CourseID: id, Price: 1000})
+			names[id] = fmt.Sprintf("Course number %d", i)
+		}
+
+		b := document(seller, inv, ord, buyer, items, names)
+
+		if !bytes.Contains(b, []byte(fmt.Sprintf("/Count %d", tt.pages))) {
+			t.Fatalf("%d items: expected %d pages", tt.items, tt.pages)
+		}
+
+		// Every page repeats the header of the table.
+		if n := bytes.Count(b, []byte("(Course) Tj")); n != tt.pages {
+			t.Fatalf("%d items: expected %d table headers, got %d", tt.items, tt.pages, n)
+		}
+
+		// Every item is listed once.
+		for i := 0; i < tt.items; i++ {
+			if n := bytes.Count(b, []byte(fmt.Sprintf("(Course number %d) Tj", i))); n != 1 {
+				t.Fatalf("%d items: item %d listed %d times", tt.items, i, n)
+			}
+		}
+	}
+}
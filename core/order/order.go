@@ -1,25 +1,107 @@
 package order
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/polldo/govod/core/gift"
+)
+
+var (
+	ErrNotPending    = errors.New("order is not pending")
+	ErrNotRefundable = errors.New("order cannot be refunded")
+	ErrNotInvoiced   = errors.New("order cannot be invoiced")
+)
 
 // Status models the possible states of an order.
 type Status string
 
 const (
-	Pending Status = "pending"
-	Success Status = "success"
-	Expired Status = "expired"
+	Pending           Status = "pending"
+	Success           Status = "success"
+	Expired           Status = "expired"
+	Refunded          Status = "refunded"
+	PartiallyRefunded Status = "partially_refunded"
+	Failed            Status = "failed"
+)
+
+// Payment providers that can be bound to an order.
+const (
+	ProviderPaypal = "paypal"
+	ProviderStripe = "stripe"
+	ProviderFake   = "fake"
+	ProviderFree   = "free"
 )
 
 // Order models orders.
 // Orders have a one-to-many relationship with items.
+// Prices of items and refunds are expressed in the minor unit of Currency.
+// Items are taxed according to the billing country and region of the user.
+// Orders of seats are bought by a manager on behalf of the organization OrgID.
+// Orders placed by users referred by an affiliate are bound to AffiliateID.
 type Order struct {
-	ID         string    `json:"id" db:"order_id"`
-	UserID     string    `json:"userId" db:"user_id"`
-	ProviderID string    `json:"providerId" db:"provider_id"`
-	Status     Status    `json:"status" db:"status"`
-	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
+	ID           string    `json:"id" db:"order_id"`
+	UserID       string    `json:"userId" db:"user_id"`
+	OrgID        string    `json:"orgId" db:"org_id"`
+	AffiliateID  string    `json:"affiliateId" db:"affiliate_id"`
+	Provider     string    `json:"provider" db:"provider"`
+	ProviderID   string    `json:"providerId" db:"provider_id"`
+	PaymentID    string    `json:"paymentId" db:"payment_id"`
+	Currency     string    `json:"currency" db:"currency"`
+	Country      string    `json:"country" db:"country"`
+	Region       string    `json:"region" db:"region"`
+	TaxName      string    `json:"taxName" db:"tax_name"`
+	TaxInclusive bool      `json:"taxInclusive" db:"tax_inclusive"`
+	Status       Status    `json:"status" db:"status"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// Receipt contains the details of an order, as shown to its owner.
+// Total is the amount paid for the order, refunds excluded.
+// Tax is the amount of taxes charged on the items, regardless of refunds.
+type Receipt struct {
+	Order
+	Items    []Item    `json:"items"`
+	Refunds  []Refund  `json:"refunds"`
+	Disputes []Dispute `json:"disputes"`
+	Total    int64     `json:"total"`
+	Tax      int64     `json:"tax"`
+}
+
+// CheckoutNew contains the billing information needed to checkout the cart.
+// Country is an ISO 3166-1 alpha-2 code, while Region is the
+// code of the subdivision of the country, like a state or a province.
+// The cart is bought for someone else if Gift is passed.
+type CheckoutNew struct {
+	Country string        `json:"country" validate:"required,iso3166_1_alpha2"`
+	Region  string        `json:"region" validate:"omitempty,alphanum,max=3"`
+	Gift    *gift.GiftNew `json:"gift"`
+}
+
+// SeatsCheckoutNew contains the seats of courses to be bought
+// by an organization, together with its billing information.
+// Each course can be listed only once.
+type SeatsCheckoutNew struct {
+	Country string     `json:"country" validate:"required,iso3166_1_alpha2"`
+	Region  string     `json:"region" validate:"omitempty,alphanum,max=3"`
+	Seats   []SeatsNew `json:"seats" validate:"required,min=1,unique=CourseID,dive"`
+}
+
+// SeatsNew contains the number of seats of a course to be bought.
+type SeatsNew struct {
+	CourseID string `json:"courseId" validate:"required,uuid"`
+	Seats    int    `json:"seats" validate:"required,gte=1,lte=10000"`
+}
+
+// Filter contains the parameters to filter orders.
+// Orders are filtered by creation date within [From, To).
+type Filter struct {
+	UserID   string     `db:"user_id" validate:"omitempty,uuid"`
+	CourseID string     `db:"course_id" validate:"omitempty,uuid"`
+	Status   string     `db:"status" validate:"omitempty,oneof=pending success expired refunded partially_refunded failed"`
+	From     *time.Time `db:"from"`
+	To       *time.Time `db:"to"`
 }
 
 // StatusUp contains the information needed to update an order.
@@ -29,12 +111,198 @@ type StatusUp struct {
 	UpdatedAt time.Time `db:"updated_at"`
 }
 
+// PaymentUp contains the information needed to bind
+// a captured payment to an order.
+type PaymentUp struct {
+	ID        string    `db:"order_id"`
+	PaymentID string    `db:"payment_id"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
 // Item models the item of an order.
 // An item can only belong to one order.
 // An order can have many items.
+// Price is the amount charged for the item, Tax included.
+// TaxRate is expressed in thousandths of a percent.
+// Courses bought within a bundle keep the id of the bundle, while
+// items bought by organizations contain the number of Seats.
 type Item struct {
 	OrderID   string    `json:"orderId" db:"order_id"`
 	CourseID  string    `json:"courseId" db:"course_id"`
-	Price     int       `json:"price" db:"price"`
+	BundleID  string    `json:"bundleId" db:"bundle_id"`
+	Seats     int       `json:"seats" db:"seats"`
+	Price     int64     `json:"price" db:"price"`
+	Tax       int64     `json:"tax" db:"tax"`
+	TaxRate   int       `json:"taxRate" db:"tax_rate"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// RefundStatus models the possible states of a refund.
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundCompleted RefundStatus = "completed"
+)
+
+// Refund models the refund of a single order item.
+// An item can be refunded only once.
+// Refunds requested to the provider are pending until it confirms them,
+// and don't have a provider refund id meanwhile: items keep granting
+// access to their courses until their refund is completed.
+type Refund struct {
+	ID               string       `json:"id" db:"refund_id"`
+	OrderID          string       `json:"orderId" db:"order_id"`
+	CourseID         string       `json:"courseId" db:"course_id"`
+	ProviderRefundID string       `json:"providerRefundId" db:"provider_refund_id"`
+	Amount           int64        `json:"amount" db:"amount"`
+	Status           RefundStatus `json:"status" db:"status"`
+	CreatedAt        time.Time    `json:"createdAt" db:"created_at"`
+}
+
+// DisputeStatus models the possible states of a dispute.
+type DisputeStatus string
+
+const (
+	DisputeOpen DisputeStatus = "open"
+	DisputeWon  DisputeStatus = "won"
+	DisputeLost DisputeStatus = "lost"
+)
+
+// Dispute models a payment disputed by the user with their bank.
+// While a dispute is open, the order doesn't grant access to its courses.
+// Lost disputes refund all the items of the order.
+type Dispute struct {
+	ID                string        `json:"id" db:"dispute_id"`
+	OrderID           string        `json:"orderId" db:"order_id"`
+	ProviderDisputeID string        `json:"providerDisputeId" db:"provider_dispute_id"`
+	Status            DisputeStatus `json:"status" db:"status"`
+	Reason            string        `json:"reason" db:"reason"`
+	Amount            int64         `json:"amount" db:"amount"`
+	CreatedAt         time.Time     `json:"createdAt" db:"created_at"`
+	UpdatedAt         time.Time     `json:"updatedAt" db:"updated_at"`
+}
+
+// WebhookEvent records an event notified by a provider,
+// so that duplicate deliveries of the same event are ignored.
+type WebhookEvent struct {
+	Provider  string    `db:"provider"`
+	ID        string    `db:"event_id"`
+	Type      EventType `db:"type"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// RefundNew contains the information needed to refund an order.
+// If no course is specified, all the items not yet refunded
+// are refunded.
+type RefundNew struct {
+	CourseIDs []string `json:"courseIds" validate:"omitempty,dive,uuid"`
+}
+
+// FulfillmentStatus models the possible states of a fulfillment.
+type FulfillmentStatus string
+
+const (
+	FulfillmentPending  FulfillmentStatus = "pending"
+	FulfillmentDone     FulfillmentStatus = "done"
+	FulfillmentFailed   FulfillmentStatus = "failed"
+	FulfillmentResolved FulfillmentStatus = "resolved"
+)
+
+// Fulfillment models the outbox record that tracks the fulfillment
+// of a paid order. It's created together with the payment capture,
+// so that fulfillments can be retried until they succeed.
+type Fulfillment struct {
+	ID            string            `json:"id" db:"fulfillment_id"`
+	OrderID       string            `json:"orderId" db:"order_id"`
+	Status        FulfillmentStatus `json:"status" db:"status"`
+	Attempts      int               `json:"attempts" db:"attempts"`
+	LastError     string            `json:"lastError" db:"last_error"`
+	NextAttemptAt time.Time         `json:"nextAttemptAt" db:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time         `json:"updatedAt" db:"updated_at"`
+}
+
+// FulfillmentFilter contains the parameters to filter fulfillments.
+type FulfillmentFilter struct {
+	Status string `validate:"omitempty,oneof=pending done failed resolved"`
+}
+
+// DiscrepancyKind models the mismatches between orders and
+// the payments recorded by providers.
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyPaidPending is an order still pending whose payment
+	// has been completed on the provider.
+	DiscrepancyPaidPending DiscrepancyKind = "paid_pending"
+
+	// DiscrepancyUnpaidSuccess is an order fulfilled without
+	// a completed payment on the provider.
+	DiscrepancyUnpaidSuccess DiscrepancyKind = "unpaid_success"
+
+	// DiscrepancyAmountMismatch is an order whose items don't
+	// sum up to the amount charged by the provider.
+	DiscrepancyAmountMismatch DiscrepancyKind = "amount_mismatch"
+)
+
+// Reconciliation models a comparison of the orders created within
+// [From, To) with the payments recorded by their providers.
+// Checked is the number of orders compared, while Failed is the
+// number of orders that could not be fetched from their provider.
+type Reconciliation struct {
+	ID        string    `json:"id" db:"reconciliation_id"`
+	From      time.Time `json:"from" db:"window_from"`
+	To        time.Time `json:"to" db:"window_to"`
+	Checked   int       `json:"checked" db:"checked"`
+	Failed    int       `json:"failed" db:"failed"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
+
+// Discrepancy models a mismatch found by a reconciliation.
+// Expected is the amount of the order, Actual the amount charged by the
+// provider, both in the minor unit of Currency. Fixed discrepancies
+// have been automatically solved by the reconciliation.
+type Discrepancy struct {
+	ID               string          `json:"id" db:"discrepancy_id"`
+	ReconciliationID string          `json:"reconciliationId" db:"reconciliation_id"`
+	OrderID          string          `json:"orderId" db:"order_id"`
+	Kind             DiscrepancyKind `json:"kind" db:"kind"`
+	Expected         int64           `json:"expected" db:"expected"`
+	Actual           int64           `json:"actual" db:"actual"`
+	Currency         string          `json:"currency" db:"currency"`
+	Detail           string          `json:"detail" db:"detail"`
+	Fixed            bool            `json:"fixed" db:"fixed"`
+	CreatedAt        time.Time       `json:"createdAt" db:"created_at"`
+}
+
+// Report contains the details of a reconciliation, as shown to administrators.
+type Report struct {
+	Reconciliation
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// InvoiceStatus models the possible states of an invoice.
+type InvoiceStatus string
+
+const (
+	InvoiceIssued InvoiceStatus = "issued"
+	InvoiceVoid   InvoiceStatus = "void"
+)
+
+// Invoice models the invoice of a successful order.
+// Invoices are numbered per year without gaps: voided invoices
+// keep their number and a new invoice must be issued to replace them.
+type Invoice struct {
+	ID        string        `json:"id" db:"invoice_id"`
+	OrderID   string        `json:"orderId" db:"order_id"`
+	Year      int           `json:"year" db:"year"`
+	Seq       int           `json:"seq" db:"seq"`
+	Number    string        `json:"number" db:"number"`
+	Status    InvoiceStatus `json:"status" db:"status"`
+	PDF       []byte        `json:"-" db:"pdf"`
+	IssuedAt  time.Time     `json:"issuedAt" db:"issued_at"`
+	VoidedAt  *time.Time    `json:"voidedAt" db:"voided_at"`
+	CreatedAt time.Time     `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time     `json:"updatedAt" db:"updated_at"`
+}
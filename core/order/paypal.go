@@ -0,0 +1,294 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/plutov/paypal/v4"
+	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/money"
+)
+
+// Paypal is the payment provider backed by paypal orders.
+// Checkouts are completed by clients, asking to capture the order
+// once the user has approved it. Webhooks notify captures as well,
+// in case clients never ask for them.
+type Paypal struct {
+	client *paypal.Client
+	cfg    config.Paypal
+}
+
+// NewPaypal constructs a paypal payment provider.
+func NewPaypal(client *paypal.Client, cfg config.Paypal) *Paypal {
+	return &Paypal{client: client, cfg: cfg}
+}
+
+// Name implements the PaymentProvider interface.
+func (p *Paypal) Name() string {
+	return ProviderPaypal
+}
+
+// Checkout creates a new paypal order with the courses to be bought.
+// Lines must be priced in the same currency.
+// Paypal requires item amounts to exclude taxes, which are passed apart.
+// The paypal order is returned to clients.
+func (p *Paypal) Checkout(ctx context.Context, lines []Line) (Checkout, error) {
+	if len(lines) == 0 {
+		return Checkout{}, errors.New("no lines to checkout")
+	}
+
+	net := money.New(0, lines[0].Price.Currency)
+	tax := net
+	items := make([]paypal.Item, 0, len(lines))
+	for _, l := range lines {
+		items = append(items, paypal.Item{
+			Quantity:    "1",
+			Name:        l.Name(),
+			Description: l.Description(),
+			UnitAmount:  paypalMoney(l.Net()),
+			Tax:         paypalMoney(l.Tax),
+		})
+
+		var err error
+		if net, err = net.Add(l.Net()); err != nil {
+			return Checkout{}, fmt.Errorf("computing paypal order total: %w", err)
+		}
+		if tax, err = tax.Add(l.Tax); err != nil {
+			return Checkout{}, fmt.Errorf("computing paypal order tax: %w", err)
+		}
+	}
+
+	tot, err := net.Add(tax)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("computing paypal order total: %w", err)
+	}
+
+	units := []paypal.PurchaseUnitRequest{{
+		Items: items,
+
+		Amount: &paypal.PurchaseUnitAmount{
+			Currency: tot.Currency,
+			Value:    tot.Decimal(),
+
+			Breakdown: &paypal.PurchaseUnitAmountBreakdown{
+				ItemTotal: paypalMoney(net),
+				TaxTotal:  paypalMoney(tax),
+			},
+		},
+	}}
+
+	// TODO: Extract these params from the configuration.
+	app := &paypal.ApplicationContext{
+		// ReturnURL: "/success.html",
+		// CancelURL: "/canceled.html",
+	}
+
+	ord, err := p.client.CreateOrder(ctx, "CAPTURE", units, nil, app)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("creating paypal order: %w", err)
+	}
+
+	return Checkout{ProviderID: ord.ID, Response: ord}, nil
+}
+
+// Capture captures the paypal order approved by the user.
+// After the capture, the money of the user is transferred to our paypal account.
+func (p *Paypal) Capture(ctx context.Context, providerID string) (Payment, error) {
+	resp, err := p.client.CaptureOrder(ctx, providerID, paypal.CaptureOrderRequest{})
+	if err != nil {
+		return Payment{}, fmt.Errorf("capturing paypal order[%s]: %w", providerID, err)
+	}
+
+	if resp.Status != "COMPLETED" {
+		return Payment{}, fmt.Errorf("captured order[%s] with status[%s]: %w", providerID, resp.Status, ErrNotPaid)
+	}
+
+	pay := Payment{ProviderID: providerID}
+	for _, pu := range resp.PurchaseUnits {
+		if pu.Payments == nil {
+			continue
+		}
+		for _, c := range pu.Payments.Captures {
+			pay.PaymentID = c.ID
+		}
+	}
+
+	return pay, nil
+}
+
+// paypalResource contains the fields of captures and refunds
+// notified by paypal webhooks that are relevant for orders.
+type paypalResource struct {
+	ID     string        `json:"id"`
+	Status string        `json:"status"`
+	Amount *paypal.Money `json:"amount"`
+	Links  []paypal.Link `json:"links"`
+
+	SupplementaryData struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data"`
+}
+
+// ParseWebhook asks paypal to verify the transmission signature of the
+// event and decodes it. Only completions, refunds and reversals of
+// captures are relevant.
+func (p *Paypal) ParseWebhook(r *http.Request) (Event, error) {
+	if p.cfg.WebhookID == "" {
+		return Event{}, ErrNotSupported
+	}
+
+	if r.Header.Get("PAYPAL-TRANSMISSION-SIG") == "" {
+		return Event{}, errors.New("received paypal event is not signed")
+	}
+
+	// The body is restored after the verification.
+	ver, err := p.client.VerifyWebhookSignature(r.Context(), r, p.cfg.WebhookID)
+	if err != nil {
+		return Event{}, fmt.Errorf("verifying paypal event signature: %w", err)
+	}
+
+	if ver.VerificationStatus != "SUCCESS" {
+		return Event{}, fmt.Errorf("paypal event signature verification status[%s]", ver.VerificationStatus)
+	}
+
+	var event paypal.AnyEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return Event{}, fmt.Errorf("unable to decode paypal event: %w", err)
+	}
+
+	var typ EventType
+	switch event.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED":
+		typ = EventCompleted
+	case "PAYMENT.CAPTURE.REFUNDED":
+		typ = EventRefunded
+	case "PAYMENT.CAPTURE.REVERSED":
+		typ = EventReversed
+	default:
+		return Event{Type: EventIgnored}, nil
+	}
+
+	var res paypalResource
+	if err := json.Unmarshal(event.Resource, &res); err != nil {
+		return Event{}, fmt.Errorf("unable to decode paypal %s resource: %w", event.ResourceType, err)
+	}
+
+	if typ == EventCompleted {
+		pay := Payment{ProviderID: res.SupplementaryData.RelatedIDs.OrderID, PaymentID: res.ID}
+		if pay.ProviderID == "" {
+			return Event{}, fmt.Errorf("paypal capture[%s] is not bound to an order", res.ID)
+		}
+		return Event{ID: event.ID, Type: typ, Payment: pay}, nil
+	}
+
+	// Refunds link the capture they belong to. Reversals may be notified
+	// on the capture itself, without any refund.
+	ev := Event{ID: event.ID, Type: typ, RefundID: res.ID, Payment: Payment{PaymentID: res.ID}}
+	for _, l := range res.Links {
+		if l.Rel == "up" {
+			ev.PaymentID = path.Base(l.Href)
+		}
+	}
+
+	if res.Amount != nil {
+		if ev.Amount, err = money.Parse(res.Amount.Value, res.Amount.Currency); err != nil {
+			return Event{}, fmt.Errorf("parsing paypal %s amount: %w", event.ResourceType, err)
+		}
+	}
+
+	return ev, nil
+}
+
+// Refund refunds the capture of the order's paypal order.
+func (p *Paypal) Refund(ctx context.Context, ord Order, amount money.Money, key string) (string, error) {
+	captureID := ord.PaymentID
+
+	// Orders paid before payments were recorded only know their paypal order.
+	if captureID == "" {
+		pord, err := p.client.GetOrder(ctx, ord.ProviderID)
+		if err != nil {
+			return "", fmt.Errorf("fetching paypal order[%s]: %w", ord.ProviderID, err)
+		}
+
+		for _, pu := range pord.PurchaseUnits {
+			if pu.Payments == nil {
+				continue
+			}
+			for _, c := range pu.Payments.Captures {
+				captureID = c.ID
+			}
+		}
+	}
+
+	if captureID == "" {
+		return "", fmt.Errorf("paypal order[%s] has no capture", ord.ProviderID)
+	}
+
+	var req paypal.RefundCaptureRequest
+	if !amount.IsZero() {
+		req.Amount = paypalMoney(amount)
+	}
+
+	ref, err := p.client.RefundCaptureWithPaypalRequestId(ctx, captureID, req, key)
+	if err != nil {
+		return "", fmt.Errorf("refunding paypal capture[%s]: %w", captureID, err)
+	}
+
+	return ref.ID, nil
+}
+
+// Cancel does nothing since paypal doesn't allow to cancel orders.
+// However, orders that are not pending are never captured.
+func (p *Paypal) Cancel(ctx context.Context, ord Order) error {
+	return nil
+}
+
+// Inspect fetches the paypal order. Orders are paid once their capture
+// is completed, even if it has been refunded afterwards.
+func (p *Paypal) Inspect(ctx context.Context, ord Order) (Record, error) {
+	pord, err := p.client.GetOrder(ctx, ord.ProviderID)
+	if err != nil {
+		return Record{}, fmt.Errorf("fetching paypal order[%s]: %w", ord.ProviderID, err)
+	}
+
+	rec := Record{Payment: Payment{ProviderID: ord.ProviderID}, Amount: money.New(0, ord.Currency)}
+	for _, pu := range pord.PurchaseUnits {
+		if pu.Payments == nil {
+			continue
+		}
+		for _, c := range pu.Payments.Captures {
+			switch c.Status {
+			case "COMPLETED", "PARTIALLY_REFUNDED", "REFUNDED":
+			default:
+				continue
+			}
+
+			rec.Paid = true
+			rec.PaymentID = c.ID
+			if c.Amount == nil {
+				continue
+			}
+
+			amount, err := money.Parse(c.Amount.Value, c.Amount.Currency)
+			if err != nil {
+				return Record{}, fmt.Errorf("parsing paypal capture[%s]: %w", c.ID, err)
+			}
+			if rec.Amount, err = rec.Amount.Add(amount); err != nil {
+				return Record{}, fmt.Errorf("summing paypal capture[%s]: %w", c.ID, err)
+			}
+		}
+	}
+
+	return rec, nil
+}
+
+// paypalMoney converts the amount in the decimal format required by paypal.
+func paypalMoney(m money.Money) *paypal.Money {
+	return &paypal.Money{Currency: m.Currency, Value: m.Decimal()}
+}
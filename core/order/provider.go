@@ -0,0 +1,151 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/polldo/govod/core/bundle"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/tax"
+)
+
+var (
+	ErrNotSupported = errors.New("operation not supported by the payment provider")
+	ErrNotPaid      = errors.New("order is not paid")
+)
+
+// PaymentProvider abstracts the payment gateways that can be used
+// to pay orders. Providers are identified by their name, which is
+// stored in the orders they are bound to.
+type PaymentProvider interface {
+	// Name returns the unique name of the provider.
+	Name() string
+
+	// Checkout starts the payment of the passed lines on the provider.
+	Checkout(ctx context.Context, lines []Line) (Checkout, error)
+
+	// Capture completes the payment of the checkout bound to providerID.
+	// It returns ErrNotPaid if the user has not completed the payment yet.
+	Capture(ctx context.Context, providerID string) (Payment, error)
+
+	// ParseWebhook verifies and decodes an event notified by the provider.
+	ParseWebhook(r *http.Request) (Event, error)
+
+	// Refund gives back the passed amount of the order's payment.
+	// The whole payment is refunded if amount is zero.
+	// Retries passing the same idempotency key refund only once.
+	// It returns the id of the refund generated by the provider.
+	Refund(ctx context.Context, ord Order, amount money.Money, key string) (string, error)
+
+	// Cancel closes the checkout of an order that has not been paid,
+	// so that it cannot be paid anymore.
+	Cancel(ctx context.Context, ord Order) error
+
+	// Inspect fetches the payment of the order as recorded by the provider,
+	// so that orders can be reconciled with it.
+	Inspect(ctx context.Context, ord Order) (Record, error)
+}
+
+// Line is a course being bought, together with the price charged for it.
+// Price is the gross amount charged, after the discount and including Tax,
+// which is computed on the discounted price according to Rate.
+//
+// Lines of bundles carry the quote of the bundle instead of the course,
+// and they are charged as a single product. Lines of seats bought by
+// organizations are charged for the number of Seats.
+type Line struct {
+	Course   course.Course
+	Bundle   *bundle.Quote
+	Seats    int
+	Price    money.Money
+	Discount money.Money
+	Tax      money.Money
+	Rate     tax.Rate
+}
+
+// Name returns the name of the product bought with the line.
+func (l Line) Name() string {
+	if l.Bundle != nil {
+		return l.Bundle.Bundle.Name
+	}
+	if l.Seats > 0 {
+		return fmt.Sprintf("%s (%d seats)", l.Course.Name, l.Seats)
+	}
+	return l.Course.Name
+}
+
+// Description returns the description of the product bought with the line.
+func (l Line) Description() string {
+	if l.Bundle != nil {
+		return l.Bundle.Bundle.Description
+	}
+	return l.Course.Description
+}
+
+// Net returns the amount charged for the line, tax excluded.
+func (l Line) Net() money.Money {
+	return money.New(l.Price.Amount-l.Tax.Amount, l.Price.Currency)
+}
+
+// Checkout is the result of starting a payment on a provider.
+type Checkout struct {
+	// ProviderID identifies the checkout on the provider.
+	ProviderID string
+
+	// Response is returned as is to clients, which need it
+	// to let the user complete the payment.
+	Response any
+}
+
+// Payment contains the details of a completed payment.
+type Payment struct {
+	ProviderID string
+	PaymentID  string
+}
+
+// Record is the payment of an order as recorded by a provider.
+// Amount is the total charged, refunds included, and it's only
+// meaningful for paid orders.
+type Record struct {
+	Payment
+	Paid   bool
+	Amount money.Money
+}
+
+// EventType models the events notified by providers that are relevant for orders.
+type EventType string
+
+const (
+	EventIgnored     EventType = "ignored"
+	EventProcessing  EventType = "processing"
+	EventCompleted   EventType = "completed"
+	EventFailed      EventType = "failed"
+	EventExpired     EventType = "expired"
+	EventRefunded    EventType = "refunded"
+	EventReversed    EventType = "reversed"
+	EventDisputed    EventType = "disputed"
+	EventDisputeWon  EventType = "dispute_won"
+	EventDisputeLost EventType = "dispute_lost"
+)
+
+// Event is an event notified by a provider through webhooks.
+// ID identifies the event on the provider, if available, and it's used
+// to ignore duplicate deliveries.
+//
+// Refunds and reversals carry the id of the refund on the provider
+// and the amount given back, while their ProviderID may be unknown.
+// Cumulative amounts are the total refunded for the payment so far.
+// Disputes carry the id of the dispute and the disputed amount.
+type Event struct {
+	ID   string
+	Type EventType
+	Payment
+	RefundID   string
+	DisputeID  string
+	Reason     string
+	Amount     money.Money
+	Cumulative bool
+}
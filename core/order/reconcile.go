@@ -0,0 +1,146 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// Reconcile compares the orders created within [from, to) with the payments
+// recorded by their providers, and stores the discrepancies found in a report.
+// It's meant to be periodically run in background.
+//
+// Pending orders already paid on the provider are fulfilled, as it's safe
+// to do so, while the other discrepancies require a manual action.
+// Expired and failed orders are not compared, as well as the orders of
+// providers that don't support it. Orders that cannot be fetched from
+// their provider are counted as failed and their errors returned,
+// but the report is stored anyway.
+func Reconcile(ctx context.Context, db *sqlx.DB, providers map[string]PaymentProvider, from time.Time, to time.Time) (Report, error) {
+	ords, err := FetchAll(ctx, db, Filter{From: &from, To: &to})
+	if err != nil {
+		return Report{}, fmt.Errorf("fetching orders: %w", err)
+	}
+
+	now := time.Now().UTC()
+	rep := Report{
+		Reconciliation: Reconciliation{
+			ID:        validate.GenerateID(),
+			From:      from,
+			To:        to,
+			CreatedAt: now,
+		},
+		Discrepancies: []Discrepancy{},
+	}
+
+	var errs []error
+	for _, o := range ords {
+		if o.Status == Expired || o.Status == Failed {
+			continue
+		}
+
+		p, ok := lookup(providers, o.Provider)
+		if !ok {
+			rep.Failed++
+			errs = append(errs, fmt.Errorf("payment provider[%s] of order[%s] not available", o.Provider, o.ID))
+			continue
+		}
+
+		rec, err := p.Inspect(ctx, o)
+		if err != nil {
+			if errors.Is(err, ErrNotSupported) {
+				continue
+			}
+			rep.Failed++
+			errs = append(errs, fmt.Errorf("inspecting order[%s]: %w", o.ID, err))
+			continue
+		}
+		rep.Checked++
+
+		d, err := reconcile(ctx, db, p, o, rec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reconciling order[%s]: %w", o.ID, err))
+		}
+		if d == nil {
+			continue
+		}
+
+		d.ReconciliationID = rep.ID
+		d.CreatedAt = now
+		rep.Discrepancies = append(rep.Discrepancies, *d)
+	}
+
+	err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+		if err := CreateReconciliation(ctx, tx, rep.Reconciliation); err != nil {
+			return err
+		}
+
+		for _, d := range rep.Discrepancies {
+			if err := CreateDiscrepancy(ctx, tx, d); err != nil {
+				return fmt.Errorf("order[%s]: %w", d.OrderID, err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return Report{}, fmt.Errorf("storing reconciliation[%s]: %w", rep.ID, err)
+	}
+
+	return rep, errors.Join(errs...)
+}
+
+// reconcile compares the order with its payment recorded by the provider.
+// It returns the discrepancy found, if any. Pending orders that have been
+// paid are captured and fulfilled: the discrepancy is fixed if the capture
+// succeeds, even if the fulfillment is left to be retried in background.
+func reconcile(ctx context.Context, db *sqlx.DB, p PaymentProvider, ord Order, rec Record) (*Discrepancy, error) {
+	items, err := FetchItems(ctx, db, ord.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := money.New(0, ord.Currency)
+	for _, it := range items {
+		exp.Amount += it.Price
+	}
+
+	d := &Discrepancy{
+		ID:       validate.GenerateID(),
+		OrderID:  ord.ID,
+		Expected: exp.Amount,
+		Actual:   rec.Amount.Amount,
+		Currency: ord.Currency,
+	}
+
+	switch {
+	case rec.Paid && rec.Amount != exp:
+		d.Kind = DiscrepancyAmountMismatch
+		d.Detail = fmt.Sprintf("order of %s charged %s", exp, rec.Amount)
+
+	case rec.Paid && ord.Status == Pending:
+		d.Kind = DiscrepancyPaidPending
+		d.Detail = fmt.Sprintf("payment[%s] not captured", rec.PaymentID)
+
+		if _, err := complete(ctx, db, p, rec.Payment); err != nil {
+			return d, fmt.Errorf("capturing payment[%s]: %w", rec.PaymentID, err)
+		}
+		d.Fixed = true
+
+	case !rec.Paid && ord.Status != Pending:
+		d.Kind = DiscrepancyUnpaidSuccess
+		d.Detail = fmt.Sprintf("order with status[%s] not paid", ord.Status)
+
+	default:
+		return nil, nil
+	}
+
+	return d, nil
+}
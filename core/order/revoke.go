@@ -0,0 +1,130 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/core/affiliate"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// revoke records a refund performed on the provider side, like the
+// refunds issued from the provider dashboard or the reversals of payments
+// due to disputes. Refunded items no longer grant access to their courses,
+// and the commissions earned on them are reversed.
+//
+// Refunds already recorded, like the ones requested through HandleRefund,
+// are ignored, as well as payments not bound to a successful order.
+// Cumulative amounts only refund the part not recorded yet.
+// Items are refunded in order until the amount is exhausted; an item
+// partially refunded is revoked anyway. When all is true, all the items
+// not yet refunded are revoked regardless of the amount.
+func revoke(ctx context.Context, db *sqlx.DB, ev Event, all bool) error {
+	if ev.PaymentID == "" || ev.RefundID == "" {
+		return errors.New("refund not bound to any payment")
+	}
+
+	ord, err := FetchByPaymentID(ctx, db, ev.PaymentID)
+	if err != nil {
+		// Late payments are refunded before being bound to their order.
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		ord, err := Lock(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		if ord.Status != Success && ord.Status != PartiallyRefunded {
+			return nil
+		}
+
+		if !all && ev.Amount.Currency != ord.Currency {
+			return fmt.Errorf("refund[%s] of %s: %w", ev.RefundID, ev.Amount, money.ErrCurrencyMismatch)
+		}
+
+		items, err := FetchItems(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		done, err := FetchRefunds(ctx, tx, ord.ID)
+		if err != nil {
+			return err
+		}
+
+		left := ev.Amount.Amount
+		refunded := make(map[string]bool, len(done))
+		for _, d := range done {
+			if d.ProviderRefundID == ev.RefundID {
+				return nil
+			}
+			if ev.Cumulative {
+				left -= d.Amount
+			}
+			refunded[d.CourseID] = true
+		}
+
+		now := time.Now().UTC()
+		revoked := 0
+		for _, it := range items {
+			if refunded[it.CourseID] {
+				continue
+			}
+			if !all && left <= 0 {
+				break
+			}
+
+			ref := Refund{
+				ID:               validate.GenerateID(),
+				OrderID:          ord.ID,
+				CourseID:         it.CourseID,
+				ProviderRefundID: ev.RefundID,
+				Amount:           it.Price,
+				Status:           RefundCompleted,
+				CreatedAt:        now,
+			}
+			if !all {
+				ref.Amount = min(it.Price, left)
+				left -= ref.Amount
+			}
+
+			if err := CreateRefund(ctx, tx, ref); err != nil {
+				return fmt.Errorf("creating refund for course[%s]: %w", it.CourseID, err)
+			}
+
+			if err := affiliate.ReverseCommission(ctx, tx, ord.ID, it.CourseID, now); err != nil {
+				return err
+			}
+			revoked++
+		}
+
+		if revoked == 0 {
+			return nil
+		}
+
+		up := StatusUp{
+			ID:        ord.ID,
+			Status:    PartiallyRefunded,
+			UpdatedAt: now,
+		}
+		if len(done)+revoked == len(items) {
+			up.Status = Refunded
+		}
+
+		if err := UpdateStatus(ctx, tx, up); err != nil {
+			return fmt.Errorf("updating status: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -3,6 +3,7 @@ package order
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/polldo/govod/database"
@@ -12,9 +13,9 @@ import (
 func Create(ctx context.Context, db sqlx.ExtContext, order Order) error {
 	const q = `
 	INSERT INTO orders
-		(order_id, user_id, provider_id, status, created_at, updated_at)
+		(order_id, user_id, org_id, affiliate_id, provider, provider_id, currency, country, region, tax_name, tax_inclusive, status, created_at, updated_at)
 	VALUES
-		(:order_id, :user_id, :provider_id, :status, :created_at, :updated_at)`
+		(:order_id, :user_id, :org_id, :affiliate_id, :provider, :provider_id, :currency, :country, :region, :tax_name, :tax_inclusive, :status, :created_at, :updated_at)`
 
 	if err := database.NamedExecContext(ctx, db, q, order); err != nil {
 		return fmt.Errorf("inserting order: %w", err)
@@ -40,6 +41,100 @@ func UpdateStatus(ctx context.Context, db sqlx.ExtContext, up StatusUp) error {
 	return nil
 }
 
+// UpdatePayment binds a captured payment to an order.
+func UpdatePayment(ctx context.Context, db sqlx.ExtContext, up PaymentUp) error {
+	const q = `
+	UPDATE orders
+	SET
+		payment_id = :payment_id,
+		updated_at = :updated_at
+	WHERE
+		order_id = :order_id`
+
+	if err := database.NamedExecContext(ctx, db, q, up); err != nil {
+		return fmt.Errorf("updating payment of order[%s]: %w", up.ID, err)
+	}
+
+	return nil
+}
+
+// Fetch retrieves the order with the specified id, if any.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Order, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		orders
+	WHERE
+		order_id = :order_id`
+
+	var order Order
+	if err := database.NamedQueryStruct(ctx, db, q, in, &order); err != nil {
+		return Order{}, fmt.Errorf("selecting order[%s]: %w", id, err)
+	}
+
+	return order, nil
+}
+
+// Lock retrieves the order with the specified id and locks it
+// until the end of the passed transaction.
+func Lock(ctx context.Context, tx sqlx.ExtContext, id string) (Order, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		orders
+	WHERE
+		order_id = :order_id
+	FOR UPDATE`
+
+	var order Order
+	if err := database.NamedQueryStruct(ctx, tx, q, in, &order); err != nil {
+		return Order{}, fmt.Errorf("locking order[%s]: %w", id, err)
+	}
+
+	return order, nil
+}
+
+// FetchAll returns the orders matching the passed filter, latest first.
+func FetchAll(ctx context.Context, db sqlx.ExtContext, filter Filter) ([]Order, error) {
+	const q = `
+	SELECT
+		o.*
+	FROM
+		orders AS o
+	WHERE
+		(:user_id = '' OR o.user_id = CAST(NULLIF(:user_id, '') AS UUID)) AND
+		(:status = '' OR o.status = :status) AND
+		(CAST(:from AS TIMESTAMP) IS NULL OR o.created_at >= :from) AND
+		(CAST(:to AS TIMESTAMP) IS NULL OR o.created_at < :to) AND
+		(:course_id = '' OR EXISTS (
+			SELECT 1 FROM order_items AS i
+			WHERE i.order_id = o.order_id AND i.course_id = CAST(NULLIF(:course_id, '') AS UUID)
+		))
+	ORDER BY
+		o.created_at DESC`
+
+	orders := []Order{}
+	if err := database.NamedQuerySlice(ctx, db, q, filter, &orders); err != nil {
+		return nil, fmt.Errorf("selecting orders: %w", err)
+	}
+
+	return orders, nil
+}
+
 // FetchByProviderID retrieves the order with the specified provider id, if any.
 func FetchByProviderID(ctx context.Context, db sqlx.ExtContext, provID string) (Order, error) {
 	in := struct {
@@ -64,13 +159,68 @@ func FetchByProviderID(ctx context.Context, db sqlx.ExtContext, provID string) (
 	return order, nil
 }
 
+// FetchByPaymentID retrieves the order bound to the specified payment, if any.
+func FetchByPaymentID(ctx context.Context, db sqlx.ExtContext, paymentID string) (Order, error) {
+	in := struct {
+		PaymentID string `db:"payment_id"`
+	}{
+		PaymentID: paymentID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		orders
+	WHERE
+		payment_id = :payment_id`
+
+	var order Order
+	if err := database.NamedQueryStruct(ctx, db, q, in, &order); err != nil {
+		return Order{}, fmt.Errorf("selecting order by payment_id[%s]: %w", paymentID, err)
+	}
+
+	return order, nil
+}
+
+// FetchPendingBefore returns all the orders still pending and not
+// paid yet that were created before the passed time.
+func FetchPendingBefore(ctx context.Context, db sqlx.ExtContext, before time.Time) ([]Order, error) {
+	in := struct {
+		Status Status    `db:"status"`
+		Before time.Time `db:"before"`
+	}{
+		Status: Pending,
+		Before: before,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		orders
+	WHERE
+		status = :status AND
+		payment_id = '' AND
+		created_at < :before
+	ORDER BY
+		created_at`
+
+	orders := []Order{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &orders); err != nil {
+		return nil, fmt.Errorf("selecting pending orders: %w", err)
+	}
+
+	return orders, nil
+}
+
 // CreateItem adds a new item in an order.
 func CreateItem(ctx context.Context, db sqlx.ExtContext, item Item) error {
 	const q = `
 	INSERT INTO order_items
-		(order_id, course_id, price, created_at)
+		(order_id, course_id, bundle_id, seats, price, tax, tax_rate, created_at)
 	VALUES
-	(:order_id, :course_id, :price, :created_at)`
+	(:order_id, :course_id, :bundle_id, :seats, :price, :tax, :tax_rate, :created_at)`
 
 	if err := database.NamedExecContext(ctx, db, q, item); err != nil {
 		return fmt.Errorf("inserting order item: %w", err)
@@ -78,3 +228,630 @@ func CreateItem(ctx context.Context, db sqlx.ExtContext, item Item) error {
 
 	return nil
 }
+
+// FetchItems returns all the items of an order.
+func FetchItems(ctx context.Context, db sqlx.ExtContext, orderID string) ([]Item, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: orderID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		order_items
+	WHERE
+		order_id = :order_id
+	ORDER BY
+		course_id`
+
+	items := []Item{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &items); err != nil {
+		return nil, fmt.Errorf("selecting items of order[%s]: %w", orderID, err)
+	}
+
+	return items, nil
+}
+
+// CreateRefund records the refund of an order item.
+func CreateRefund(ctx context.Context, db sqlx.ExtContext, refund Refund) error {
+	const q = `
+	INSERT INTO order_refunds
+		(refund_id, order_id, course_id, provider_refund_id, amount, status, created_at)
+	VALUES
+	(:refund_id, :order_id, :course_id, :provider_refund_id, :amount, :status, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, refund); err != nil {
+		return fmt.Errorf("inserting order refund: %w", err)
+	}
+
+	return nil
+}
+
+// CompleteRefund marks the pending refund as completed by the provider.
+func CompleteRefund(ctx context.Context, db sqlx.ExtContext, refundID string, providerRefundID string) error {
+	in := struct {
+		ID               string       `db:"refund_id"`
+		ProviderRefundID string       `db:"provider_refund_id"`
+		Status           RefundStatus `db:"status"`
+	}{
+		ID:               refundID,
+		ProviderRefundID: providerRefundID,
+		Status:           RefundCompleted,
+	}
+
+	const q = `
+	UPDATE
+		order_refunds
+	SET
+		provider_refund_id = :provider_refund_id,
+		status = :status
+	WHERE
+		refund_id = :refund_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("completing refund[%s]: %w", refundID, err)
+	}
+
+	return nil
+}
+
+// DeletePendingRefunds deletes the pending refunds of an order.
+func DeletePendingRefunds(ctx context.Context, db sqlx.ExtContext, orderID string) error {
+	in := struct {
+		ID     string       `db:"order_id"`
+		Status RefundStatus `db:"status"`
+	}{
+		ID:     orderID,
+		Status: RefundPending,
+	}
+
+	const q = `
+	DELETE FROM
+		order_refunds
+	WHERE
+		order_id = :order_id AND
+		status = :status`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting pending refunds of order[%s]: %w", orderID, err)
+	}
+
+	return nil
+}
+
+// FetchRefunds returns all the refunds of an order.
+func FetchRefunds(ctx context.Context, db sqlx.ExtContext, orderID string) ([]Refund, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: orderID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		order_refunds
+	WHERE
+		order_id = :order_id
+	ORDER BY
+		course_id`
+
+	refunds := []Refund{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &refunds); err != nil {
+		return nil, fmt.Errorf("selecting refunds of order[%s]: %w", orderID, err)
+	}
+
+	return refunds, nil
+}
+
+// CreateDispute records a dispute opened on the payment of an order.
+// Nothing happens if the dispute has already been recorded.
+func CreateDispute(ctx context.Context, db sqlx.ExtContext, d Dispute) error {
+	const q = `
+	INSERT INTO order_disputes
+		(dispute_id, order_id, provider_dispute_id, status, reason, amount, created_at, updated_at)
+	VALUES
+		(:dispute_id, :order_id, :provider_dispute_id, :status, :reason, :amount, :created_at, :updated_at)
+	ON CONFLICT
+		(provider_dispute_id)
+	DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, db, q, d); err != nil {
+		return fmt.Errorf("inserting dispute: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateDisputeStatus updates the status of the dispute with the passed provider id.
+func UpdateDisputeStatus(ctx context.Context, db sqlx.ExtContext, providerDisputeID string, status DisputeStatus, now time.Time) error {
+	in := struct {
+		ProviderDisputeID string        `db:"provider_dispute_id"`
+		Status            DisputeStatus `db:"status"`
+		UpdatedAt         time.Time     `db:"updated_at"`
+	}{
+		ProviderDisputeID: providerDisputeID,
+		Status:            status,
+		UpdatedAt:         now,
+	}
+
+	const q = `
+	UPDATE order_disputes
+	SET
+		status = :status,
+		updated_at = :updated_at
+	WHERE
+		provider_dispute_id = :provider_dispute_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("updating status of dispute[%s]: %w", providerDisputeID, err)
+	}
+
+	return nil
+}
+
+// FetchDisputes returns all the disputes of an order.
+func FetchDisputes(ctx context.Context, db sqlx.ExtContext, orderID string) ([]Dispute, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: orderID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		order_disputes
+	WHERE
+		order_id = :order_id
+	ORDER BY
+		created_at`
+
+	disputes := []Dispute{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &disputes); err != nil {
+		return nil, fmt.Errorf("selecting disputes of order[%s]: %w", orderID, err)
+	}
+
+	return disputes, nil
+}
+
+// CreateWebhookEvent records an event notified by a provider.
+// It returns ErrDBNotFound if the event has already been recorded.
+func CreateWebhookEvent(ctx context.Context, db sqlx.ExtContext, ev WebhookEvent) error {
+	const q = `
+	INSERT INTO webhook_events
+		(provider, event_id, type, created_at)
+	VALUES
+		(:provider, :event_id, :type, :created_at)
+	ON CONFLICT
+		(provider, event_id)
+	DO NOTHING
+	RETURNING event_id`
+
+	var out struct {
+		ID string `db:"event_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, db, q, ev, &out); err != nil {
+		return fmt.Errorf("inserting %s event[%s]: %w", ev.Provider, ev.ID, err)
+	}
+
+	return nil
+}
+
+// DeleteWebhookEvent forgets an event notified by a provider,
+// so that it's processed again when delivered another time.
+func DeleteWebhookEvent(ctx context.Context, db sqlx.ExtContext, provider string, id string) error {
+	in := struct {
+		Provider string `db:"provider"`
+		ID       string `db:"event_id"`
+	}{
+		Provider: provider,
+		ID:       id,
+	}
+
+	const q = `
+	DELETE FROM
+		webhook_events
+	WHERE
+		provider = :provider AND
+		event_id = :event_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting %s event[%s]: %w", provider, id, err)
+	}
+
+	return nil
+}
+
+// CreateFulfillment enqueues the fulfillment of an order.
+// Nothing happens if the order already has a fulfillment.
+func CreateFulfillment(ctx context.Context, db sqlx.ExtContext, f Fulfillment) error {
+	const q = `
+	INSERT INTO fulfillments
+		(fulfillment_id, order_id, status, attempts, last_error, next_attempt_at, created_at, updated_at)
+	VALUES
+		(:fulfillment_id, :order_id, :status, :attempts, :last_error, :next_attempt_at, :created_at, :updated_at)
+	ON CONFLICT
+		(order_id)
+	DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, db, q, f); err != nil {
+		return fmt.Errorf("inserting fulfillment: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateFulfillment updates the state of a fulfillment.
+func UpdateFulfillment(ctx context.Context, db sqlx.ExtContext, f Fulfillment) error {
+	const q = `
+	UPDATE fulfillments
+	SET
+		status = :status,
+		attempts = :attempts,
+		last_error = :last_error,
+		next_attempt_at = :next_attempt_at,
+		updated_at = :updated_at
+	WHERE
+		fulfillment_id = :fulfillment_id`
+
+	if err := database.NamedExecContext(ctx, db, q, f); err != nil {
+		return fmt.Errorf("updating fulfillment[%s]: %w", f.ID, err)
+	}
+
+	return nil
+}
+
+// CompleteFulfillment marks as done the fulfillment of the passed order.
+func CompleteFulfillment(ctx context.Context, db sqlx.ExtContext, orderID string, now time.Time) error {
+	in := struct {
+		OrderID   string            `db:"order_id"`
+		Status    FulfillmentStatus `db:"status"`
+		UpdatedAt time.Time         `db:"updated_at"`
+	}{
+		OrderID:   orderID,
+		Status:    FulfillmentDone,
+		UpdatedAt: now,
+	}
+
+	const q = `
+	UPDATE fulfillments
+	SET
+		status = :status,
+		updated_at = :updated_at
+	WHERE
+		order_id = :order_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("completing fulfillment of order[%s]: %w", orderID, err)
+	}
+
+	return nil
+}
+
+// FetchFulfillment retrieves the fulfillment with the specified id, if any.
+func FetchFulfillment(ctx context.Context, db sqlx.ExtContext, id string) (Fulfillment, error) {
+	in := struct {
+		ID string `db:"fulfillment_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		fulfillments
+	WHERE
+		fulfillment_id = :fulfillment_id`
+
+	var f Fulfillment
+	if err := database.NamedQueryStruct(ctx, db, q, in, &f); err != nil {
+		return Fulfillment{}, fmt.Errorf("selecting fulfillment[%s]: %w", id, err)
+	}
+
+	return f, nil
+}
+
+// FetchFulfillmentByOrder retrieves the fulfillment of the specified order, if any.
+func FetchFulfillmentByOrder(ctx context.Context, db sqlx.ExtContext, orderID string) (Fulfillment, error) {
+	in := struct {
+		ID string `db:"order_id"`
+	}{
+		ID: orderID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		fulfillments
+	WHERE
+		order_id = :order_id`
+
+	var f Fulfillment
+	if err := database.NamedQueryStruct(ctx, db, q, in, &f); err != nil {
+		return Fulfillment{}, fmt.Errorf("selecting fulfillment of order[%s]: %w", orderID, err)
+	}
+
+	return f, nil
+}
+
+// FetchFulfillmentsDue returns all the pending fulfillments
+// whose next attempt is due at the passed time.
+func FetchFulfillmentsDue(ctx context.Context, db sqlx.ExtContext, now time.Time) ([]Fulfillment, error) {
+	in := struct {
+		Status FulfillmentStatus `db:"status"`
+		Now    time.Time         `db:"now"`
+	}{
+		Status: FulfillmentPending,
+		Now:    now,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		fulfillments
+	WHERE
+		status = :status AND
+		next_attempt_at <= :now
+	ORDER BY
+		next_attempt_at`
+
+	fs := []Fulfillment{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &fs); err != nil {
+		return nil, fmt.Errorf("selecting due fulfillments: %w", err)
+	}
+
+	return fs, nil
+}
+
+// FetchFulfillments returns all the fulfillments matching the passed filter.
+func FetchFulfillments(ctx context.Context, db sqlx.ExtContext, filter FulfillmentFilter) ([]Fulfillment, error) {
+	in := struct {
+		Status string `db:"status"`
+	}{
+		Status: filter.Status,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		fulfillments
+	WHERE
+		(:status = '' OR status = :status)
+	ORDER BY
+		created_at DESC`
+
+	fs := []Fulfillment{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &fs); err != nil {
+		return nil, fmt.Errorf("selecting fulfillments: %w", err)
+	}
+
+	return fs, nil
+}
+
+// NextInvoiceSeq returns the next invoice number of the passed year.
+// The counter of the year stays locked until the end of the passed
+// transaction, so that numbers are not lost if the transaction fails.
+func NextInvoiceSeq(ctx context.Context, tx sqlx.ExtContext, year int) (int, error) {
+	in := struct {
+		Year int `db:"year"`
+	}{
+		Year: year,
+	}
+
+	const q = `
+	INSERT INTO invoice_counters
+		(year, last)
+	VALUES
+		(:year, 1)
+	ON CONFLICT
+		(year)
+	DO UPDATE SET
+		last = invoice_counters.last + 1
+	RETURNING last`
+
+	var out struct {
+		Last int `db:"last"`
+	}
+	if err := database.NamedQueryStruct(ctx, tx, q, in, &out); err != nil {
+		return 0, fmt.Errorf("incrementing invoice counter of year[%d]: %w", year, err)
+	}
+
+	return out.Last, nil
+}
+
+// CreateInvoice inserts a new invoice.
+func CreateInvoice(ctx context.Context, db sqlx.ExtContext, inv Invoice) error {
+	const q = `
+	INSERT INTO invoices
+		(invoice_id, order_id, year, seq, number, status, pdf, issued_at, voided_at, created_at, updated_at)
+	VALUES
+		(:invoice_id, :order_id, :year, :seq, :number, :status, :pdf, :issued_at, :voided_at, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, inv); err != nil {
+		return fmt.Errorf("inserting invoice: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateInvoice updates the status and the document of an invoice.
+func UpdateInvoice(ctx context.Context, db sqlx.ExtContext, inv Invoice) error {
+	const q = `
+	UPDATE invoices
+	SET
+		status = :status,
+		pdf = :pdf,
+		voided_at = :voided_at,
+		updated_at = :updated_at
+	WHERE
+		invoice_id = :invoice_id`
+
+	if err := database.NamedExecContext(ctx, db, q, inv); err != nil {
+		return fmt.Errorf("updating invoice[%s]: %w", inv.ID, err)
+	}
+
+	return nil
+}
+
+// FetchInvoiceByOrder retrieves the issued invoice of the specified order, if any.
+func FetchInvoiceByOrder(ctx context.Context, db sqlx.ExtContext, orderID string) (Invoice, error) {
+	in := struct {
+		OrderID string        `db:"order_id"`
+		Status  InvoiceStatus `db:"status"`
+	}{
+		OrderID: orderID,
+		Status:  InvoiceIssued,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		invoices
+	WHERE
+		order_id = :order_id AND
+		status = :status`
+
+	var inv Invoice
+	if err := database.NamedQueryStruct(ctx, db, q, in, &inv); err != nil {
+		return Invoice{}, fmt.Errorf("selecting invoice of order[%s]: %w", orderID, err)
+	}
+
+	return inv, nil
+}
+
+// FetchInvoicesUnrendered returns all the issued invoices
+// whose document has not been rendered yet.
+func FetchInvoicesUnrendered(ctx context.Context, db sqlx.ExtContext) ([]Invoice, error) {
+	in := struct {
+		Status InvoiceStatus `db:"status"`
+	}{
+		Status: InvoiceIssued,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		invoices
+	WHERE
+		status = :status AND
+		pdf IS NULL
+	ORDER BY
+		year, seq`
+
+	invs := []Invoice{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &invs); err != nil {
+		return nil, fmt.Errorf("selecting unrendered invoices: %w", err)
+	}
+
+	return invs, nil
+}
+
+// CreateReconciliation records a reconciliation.
+func CreateReconciliation(ctx context.Context, db sqlx.ExtContext, rec Reconciliation) error {
+	const q = `
+	INSERT INTO reconciliations
+		(reconciliation_id, window_from, window_to, checked, failed, created_at)
+	VALUES
+	(:reconciliation_id, :window_from, :window_to, :checked, :failed, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, rec); err != nil {
+		return fmt.Errorf("inserting reconciliation: %w", err)
+	}
+
+	return nil
+}
+
+// FetchReconciliation returns the reconciliation with the passed id.
+func FetchReconciliation(ctx context.Context, db sqlx.ExtContext, id string) (Reconciliation, error) {
+	in := struct {
+		ID string `db:"reconciliation_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		reconciliations
+	WHERE
+		reconciliation_id = :reconciliation_id`
+
+	var rec Reconciliation
+	if err := database.NamedQueryStruct(ctx, db, q, in, &rec); err != nil {
+		return Reconciliation{}, fmt.Errorf("selecting reconciliation[%s]: %w", id, err)
+	}
+
+	return rec, nil
+}
+
+// FetchReconciliations returns all the reconciliations, from the latest one.
+func FetchReconciliations(ctx context.Context, db sqlx.ExtContext) ([]Reconciliation, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		reconciliations
+	ORDER BY
+		created_at DESC`
+
+	recs := []Reconciliation{}
+	if err := database.NamedQuerySlice(ctx, db, q, struct{}{}, &recs); err != nil {
+		return nil, fmt.Errorf("selecting reconciliations: %w", err)
+	}
+
+	return recs, nil
+}
+
+// CreateDiscrepancy records a discrepancy found by a reconciliation.
+func CreateDiscrepancy(ctx context.Context, db sqlx.ExtContext, d Discrepancy) error {
+	const q = `
+	INSERT INTO reconciliation_discrepancies
+		(discrepancy_id, reconciliation_id, order_id, kind, expected, actual, currency, detail, fixed, created_at)
+	VALUES
+	(:discrepancy_id, :reconciliation_id, :order_id, :kind, :expected, :actual, :currency, :detail, :fixed, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, d); err != nil {
+		return fmt.Errorf("inserting discrepancy: %w", err)
+	}
+
+	return nil
+}
+
+// FetchDiscrepancies returns all the discrepancies found by a reconciliation.
+func FetchDiscrepancies(ctx context.Context, db sqlx.ExtContext, reconciliationID string) ([]Discrepancy, error) {
+	in := struct {
+		ID string `db:"reconciliation_id"`
+	}{
+		ID: reconciliationID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		reconciliation_discrepancies
+	WHERE
+		reconciliation_id = :reconciliation_id
+	ORDER BY
+		created_at, order_id`
+
+	ds := []Discrepancy{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ds); err != nil {
+		return nil, fmt.Errorf("selecting discrepancies of reconciliation[%s]: %w", reconciliationID, err)
+	}
+
+	return ds, nil
+}
@@ -0,0 +1,433 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/tax"
+	"github.com/stripe/stripe-go/v74"
+	stripecl "github.com/stripe/stripe-go/v74/client"
+	"github.com/stripe/stripe-go/v74/webhook"
+)
+
+// Stripe is the payment provider backed by stripe checkout sessions.
+// Checkouts are completed through webhooks.
+type Stripe struct {
+	api *stripecl.API
+	cfg config.Stripe
+
+	mu    sync.Mutex
+	rates map[tax.Rate]string
+	calls map[tax.Rate]*rateCall
+}
+
+// NewStripe constructs a stripe payment provider.
+func NewStripe(api *stripecl.API, cfg config.Stripe) *Stripe {
+	return &Stripe{api: api, cfg: cfg, rates: make(map[tax.Rate]string), calls: make(map[tax.Rate]*rateCall)}
+}
+
+// Name implements the PaymentProvider interface.
+func (s *Stripe) Name() string {
+	return ProviderStripe
+}
+
+// Checkout creates a new stripe checkout session with the courses to be bought.
+// Unit amounts include taxes, which are broken down by attaching the tax rate
+// to each line. The URL of the session is returned to clients.
+func (s *Stripe) Checkout(ctx context.Context, lines []Line) (Checkout, error) {
+	li := make([]*stripe.CheckoutSessionLineItemParams, 0, len(lines))
+	for _, l := range lines {
+		var rates []*string
+		if l.Rate.Value > 0 {
+			id, err := s.taxRate(ctx, l.Rate)
+			if err != nil {
+				return Checkout{}, err
+			}
+			rates = append(rates, stripe.String(id))
+		}
+
+		li = append(li, &stripe.CheckoutSessionLineItemParams{
+			Quantity: stripe.Int64(1),
+			TaxRates: rates,
+
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:    stripe.String(strings.ToLower(l.Price.Currency)),
+				TaxBehavior: stripe.String("inclusive"),
+				UnitAmount:  stripe.Int64(l.Price.Amount),
+
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name:        stripe.String(l.Name()),
+					Description: stripe.String(l.Description()),
+				},
+			},
+		})
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(s.cfg.SuccessURL),
+		CancelURL:  stripe.String(s.cfg.CancelURL),
+		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems:  li,
+	}
+	params.Context = ctx
+
+	sess, err := s.api.CheckoutSessions.New(params)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("creating stripe session: %w", err)
+	}
+
+	return Checkout{ProviderID: sess.ID, Response: sess.URL}, nil
+}
+
+// Capture checks whether the stripe session has been paid.
+// Stripe captures payments on its own, so nothing is actually captured.
+func (s *Stripe) Capture(ctx context.Context, providerID string) (Payment, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+
+	sess, err := s.api.CheckoutSessions.Get(providerID, params)
+	if err != nil {
+		return Payment{}, fmt.Errorf("fetching stripe session[%s]: %w", providerID, err)
+	}
+
+	if sess.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		return Payment{}, fmt.Errorf("stripe session[%s] with payment status[%s]: %w", providerID, sess.PaymentStatus, ErrNotPaid)
+	}
+
+	return stripePayment(sess), nil
+}
+
+// ParseWebhook verifies the signature of the stripe event and decodes it.
+// Relevant events are the ones about checkouts of one-time payments,
+// including delayed payment methods, refunds and disputes of charges.
+func (s *Stripe) ParseWebhook(r *http.Request) (Event, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Event{}, fmt.Errorf("cannot read the request body: %w", err)
+	}
+
+	sig := r.Header.Get("Stripe-Signature")
+	if sig == "" {
+		return Event{}, errors.New("received stripe event is not signed")
+	}
+
+	event, err := webhook.ConstructEvent(b, sig, s.cfg.WebhookSecret)
+	if err != nil {
+		return Event{}, fmt.Errorf("cannot construct stripe event: %w", err)
+	}
+
+	var ev Event
+	switch event.Type {
+	case "checkout.session.completed",
+		"checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed",
+		"checkout.session.expired":
+		ev, err = stripeSessionEvent(event)
+
+	case "charge.refunded":
+		ev, err = stripeRefundEvent(event)
+
+	case "charge.dispute.created", "charge.dispute.closed":
+		ev, err = stripeDisputeEvent(event)
+
+	default:
+		return Event{Type: EventIgnored}, nil
+	}
+
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to decode stripe event[%s]: %w", event.Type, err)
+	}
+
+	ev.ID = event.ID
+	return ev, nil
+}
+
+// stripeSessionEvent decodes the events about checkout sessions.
+// Sessions paid with delayed methods complete without being paid:
+// their payment succeeds or fails later on.
+func stripeSessionEvent(event stripe.Event) (Event, error) {
+	var sess stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
+		return Event{}, err
+	}
+
+	// Filter out checkouts that are not for one-time payments.
+	if sess.Mode != stripe.CheckoutSessionModePayment {
+		return Event{Type: EventIgnored}, nil
+	}
+
+	ev := Event{Payment: stripePayment(&sess)}
+	switch event.Type {
+	case "checkout.session.completed":
+		ev.Type = EventCompleted
+		if sess.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+			ev.Type = EventProcessing
+		}
+	case "checkout.session.async_payment_succeeded":
+		ev.Type = EventCompleted
+	case "checkout.session.async_payment_failed":
+		ev.Type = EventFailed
+	case "checkout.session.expired":
+		ev.Type = EventExpired
+	}
+
+	return ev, nil
+}
+
+// stripeRefundEvent decodes the refunds of charges. Stripe notifies
+// the total refunded for the charge so far, together with its refunds
+// when they are included.
+func stripeRefundEvent(event stripe.Event) (Event, error) {
+	var ch stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+		return Event{}, err
+	}
+
+	if ch.PaymentIntent == nil {
+		return Event{Type: EventIgnored}, nil
+	}
+
+	ev := Event{
+		Type:       EventRefunded,
+		Payment:    Payment{PaymentID: ch.PaymentIntent.ID},
+		RefundID:   event.ID,
+		Amount:     money.New(ch.AmountRefunded, string(ch.Currency)),
+		Cumulative: true,
+	}
+
+	// Refunds are listed from the latest one.
+	if ch.Refunds != nil && len(ch.Refunds.Data) > 0 {
+		ev.RefundID = ch.Refunds.Data[0].ID
+	}
+
+	return ev, nil
+}
+
+// stripeDisputeEvent decodes the disputes of charges.
+// Disputes closed because the charge was refunded are considered lost.
+func stripeDisputeEvent(event stripe.Event) (Event, error) {
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return Event{}, err
+	}
+
+	if d.PaymentIntent == nil {
+		return Event{Type: EventIgnored}, nil
+	}
+
+	ev := Event{
+		Type:      EventDisputed,
+		Payment:   Payment{PaymentID: d.PaymentIntent.ID},
+		DisputeID: d.ID,
+		Reason:    string(d.Reason),
+		Amount:    money.New(d.Amount, string(d.Currency)),
+	}
+
+	if event.Type == "charge.dispute.closed" {
+		switch d.Status {
+		case stripe.DisputeStatusWon, stripe.DisputeStatusWarningClosed:
+			ev.Type = EventDisputeWon
+		case stripe.DisputeStatusLost, stripe.DisputeStatusChargeRefunded:
+			ev.Type = EventDisputeLost
+		default:
+			return Event{Type: EventIgnored}, nil
+		}
+	}
+
+	return ev, nil
+}
+
+// Refund refunds the payment intent of the order's stripe session.
+// Stripe amounts are already expressed in the minor unit of the currency.
+func (s *Stripe) Refund(ctx context.Context, ord Order, amount money.Money, key string) (string, error) {
+	paymentID := ord.PaymentID
+
+	// Orders paid before payments were recorded only know their session.
+	if paymentID == "" {
+		pay, err := s.Capture(ctx, ord.ProviderID)
+		if err != nil {
+			return "", err
+		}
+		paymentID = pay.PaymentID
+	}
+
+	if paymentID == "" {
+		return "", fmt.Errorf("stripe session[%s] has no payment intent", ord.ProviderID)
+	}
+
+	params := &stripe.RefundParams{PaymentIntent: stripe.String(paymentID)}
+	params.Context = ctx
+	params.SetIdempotencyKey(key)
+	if !amount.IsZero() {
+		params.Amount = stripe.Int64(amount.Amount)
+	}
+
+	ref, err := s.api.Refunds.New(params)
+	if err != nil {
+		return "", fmt.Errorf("refunding stripe payment[%s]: %w", paymentID, err)
+	}
+
+	return ref.ID, nil
+}
+
+// Cancel expires the stripe session of the order. Sessions already
+// expired are ignored.
+func (s *Stripe) Cancel(ctx context.Context, ord Order) error {
+	params := &stripe.CheckoutSessionExpireParams{}
+	params.Context = ctx
+
+	_, err := s.api.CheckoutSessions.Expire(ord.ProviderID, params)
+	if err == nil {
+		return nil
+	}
+
+	// Only open sessions can be expired. Check if the session
+	// was already expired, the order can be expired as well then.
+	gparams := &stripe.CheckoutSessionParams{}
+	gparams.Context = ctx
+
+	sess, gerr := s.api.CheckoutSessions.Get(ord.ProviderID, gparams)
+	if gerr != nil {
+		return fmt.Errorf("fetching stripe session[%s]: %v: %w", ord.ProviderID, gerr, err)
+	}
+
+	if sess.Status != stripe.CheckoutSessionStatusExpired {
+		return fmt.Errorf("expiring stripe session[%s] with status[%s]: %w", ord.ProviderID, sess.Status, err)
+	}
+
+	return nil
+}
+
+// Inspect fetches the stripe session of the order. Sessions are paid
+// once their payment succeeds, including the ones of delayed methods.
+func (s *Stripe) Inspect(ctx context.Context, ord Order) (Record, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+
+	sess, err := s.api.CheckoutSessions.Get(ord.ProviderID, params)
+	if err != nil {
+		return Record{}, fmt.Errorf("fetching stripe session[%s]: %w", ord.ProviderID, err)
+	}
+
+	rec := Record{
+		Payment: stripePayment(sess),
+		Paid:    sess.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid,
+		Amount:  money.New(sess.AmountTotal, string(sess.Currency)),
+	}
+
+	return rec, nil
+}
+
+// rateMetadata is the metadata key identifying the rates of govod on
+// stripe, so that the rates created by previous runs are reused.
+const rateMetadata = "govod_rate"
+
+// rateCall is the lookup of a tax rate in progress, shared by the
+// checkouts needing the same rate meanwhile.
+type rateCall struct {
+	done chan struct{}
+	id   string
+	err  error
+}
+
+// taxRate returns the id of the stripe tax rate matching the passed rate.
+// Stripe rates are looked up, or created, the first time they are needed
+// and cached afterwards. Concurrent checkouts wait for the same lookup,
+// without blocking the checkouts needing other rates.
+func (s *Stripe) taxRate(ctx context.Context, rate tax.Rate) (string, error) {
+	s.mu.Lock()
+	if id, ok := s.rates[rate]; ok {
+		s.mu.Unlock()
+		return id, nil
+	}
+
+	if call, ok := s.calls[rate]; ok {
+		s.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.id, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	call := &rateCall{done: make(chan struct{})}
+	s.calls[rate] = call
+	s.mu.Unlock()
+
+	call.id, call.err = s.lookupRate(ctx, rate)
+
+	s.mu.Lock()
+	if call.err == nil {
+		s.rates[rate] = call.id
+	}
+	delete(s.calls, rate)
+	s.mu.Unlock()
+	close(call.done)
+
+	return call.id, call.err
+}
+
+// lookupRate returns the id of the stripe tax rate created for the passed
+// rate, identified by its metadata, creating it if missing.
+// Rates are always inclusive, since unit amounts already include taxes.
+func (s *Stripe) lookupRate(ctx context.Context, rate tax.Rate) (string, error) {
+	key := fmt.Sprintf("%s-%s-%s-%d", rate.Country, rate.Region, rate.Name, rate.Value)
+
+	lp := &stripe.TaxRateListParams{
+		Active:    stripe.Bool(true),
+		Inclusive: stripe.Bool(true),
+	}
+	lp.Context = ctx
+
+	it := s.api.TaxRates.List(lp)
+	for it.Next() {
+		if tr := it.TaxRate(); tr.Metadata[rateMetadata] == key {
+			return tr.ID, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return "", fmt.Errorf("listing stripe tax rates: %w", err)
+	}
+
+	jur := strings.Trim(rate.Country+"-"+rate.Region, "-")
+	params := &stripe.TaxRateParams{
+		DisplayName:  stripe.String(rate.Name),
+		Percentage:   stripe.Float64(float64(rate.Value) / 1000),
+		Inclusive:    stripe.Bool(true),
+		Country:      stripe.String(rate.Country),
+		Jurisdiction: stripe.String(jur),
+	}
+	if rate.Region != "" {
+		params.State = stripe.String(rate.Region)
+	}
+	params.Context = ctx
+	params.AddMetadata(rateMetadata, key)
+
+	// Processes creating the same rate at once are deduplicated by stripe.
+	params.SetIdempotencyKey("tax-rate-" + key)
+
+	tr, err := s.api.TaxRates.New(params)
+	if err != nil {
+		return "", fmt.Errorf("creating stripe tax rate[%s %s]: %w", jur, rate.Percent(), err)
+	}
+
+	return tr.ID, nil
+}
+
+// stripePayment extracts the payment details from a stripe session.
+func stripePayment(sess *stripe.CheckoutSession) Payment {
+	pay := Payment{ProviderID: sess.ID}
+	if sess.PaymentIntent != nil {
+		pay.PaymentID = sess.PaymentIntent.ID
+	}
+	return pay
+}
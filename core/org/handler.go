@@ -0,0 +1,511 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/background"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/validate"
+)
+
+// Authorize checks that the user is a member of the organization
+// with one of the passed roles. Any member is authorized if no role is passed.
+func Authorize(ctx context.Context, db sqlx.ExtContext, orgID string, userID string, roles ...Role) (Membership, error) {
+	if err := validate.CheckID(orgID); err != nil {
+		return Membership{}, weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	m, err := FetchMember(ctx, db, orgID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Membership{}, weberr.NewError(err, "access forbidden", http.StatusForbidden)
+		}
+		return Membership{}, err
+	}
+
+	if len(roles) == 0 {
+		return m, nil
+	}
+
+	for _, r := range roles {
+		if m.Role == r {
+			return m, nil
+		}
+	}
+
+	err = fmt.Errorf("user[%s] is %s of organization[%s]", userID, m.Role, orgID)
+	return Membership{}, weberr.NewError(err, "access forbidden", http.StatusForbidden)
+}
+
+// HandleCreate allows users to create an organization, becoming its owner.
+func HandleCreate(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var on OrgNew
+		if err := web.Decode(w, r, &on); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(on); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		now := time.Now().UTC()
+		org := Org{
+			ID:        validate.GenerateID(),
+			Name:      on.Name,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		owner := Membership{
+			OrgID:     org.ID,
+			UserID:    clm.UserID,
+			Role:      Owner,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			if err := Create(ctx, tx, org); err != nil {
+				return err
+			}
+			return CreateMember(ctx, tx, owner)
+		})
+		if err != nil {
+			return fmt.Errorf("creating organization for user[%s]: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, org, http.StatusCreated)
+	}
+}
+
+// HandleList allows users to fetch the organizations they are member of.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		orgs, err := FetchByMember(ctx, db, clm.UserID)
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, orgs, http.StatusOK)
+	}
+}
+
+// HandleShow allows members to fetch the details of their organization,
+// with its members and seats.
+func HandleShow(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID); err != nil {
+			return err
+		}
+
+		org, err := Fetch(ctx, db, orgID)
+		if err != nil {
+			return err
+		}
+
+		members, err := FetchMembers(ctx, db, orgID)
+		if err != nil {
+			return err
+		}
+
+		seats, err := FetchSeats(ctx, db, orgID, "", time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, Details{Org: org, Members: members, Seats: seats}, http.StatusOK)
+	}
+}
+
+// HandleUpdateMember allows owners to promote members to managers and back.
+func HandleUpdateMember(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+		userID := web.Param(r, "user_id")
+
+		if err := validate.CheckID(userID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		var rup RoleUp
+		if err := web.Decode(w, r, &rup); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(rup); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID, Owner); err != nil {
+			return err
+		}
+
+		m, err := FetchMember(ctx, db, orgID, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if m.Role == Owner {
+			err := errors.New("the role of the owner cannot be changed")
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		m.Role = rup.Role
+		m.UpdatedAt = time.Now().UTC()
+		if err := UpdateRole(ctx, db, m); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, m, http.StatusOK)
+	}
+}
+
+// HandleDeleteMember allows managers to remove members from the organization,
+// reclaiming their seats. Only owners can remove managers, while owners
+// cannot be removed.
+func HandleDeleteMember(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+		userID := web.Param(r, "user_id")
+
+		if err := validate.CheckID(userID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		mgr, err := Authorize(ctx, db, orgID, clm.UserID, Owner, Manager)
+		if err != nil {
+			return err
+		}
+
+		m, err := FetchMember(ctx, db, orgID, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return web.Respond(ctx, w, nil, http.StatusNoContent)
+			}
+			return err
+		}
+
+		if m.Role == Owner || (m.Role == Manager && mgr.Role != Owner) {
+			err := fmt.Errorf("%s cannot remove %s", mgr.Role, m.Role)
+			return weberr.NewError(err, "access forbidden", http.StatusForbidden)
+		}
+
+		if err := DeleteMember(ctx, db, orgID, userID); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleInvite allows managers to invite users by email to take a seat
+// of a course. The seat is reserved until the invitation expires.
+func HandleInvite(db *sqlx.DB, mailer Mailer, bg *background.Background) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+
+		var in InvitationNew
+		if err := web.Decode(w, r, &in); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(in); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID, Owner, Manager); err != nil {
+			return err
+		}
+
+		text, h, err := genToken()
+		if err != nil {
+			return fmt.Errorf("generating invitation token: %w", err)
+		}
+
+		now := time.Now().UTC()
+		inv := Invitation{
+			ID:        validate.GenerateID(),
+			OrgID:     orgID,
+			CourseID:  in.CourseID,
+			Email:     in.Email,
+			TokenHash: h,
+			ExpiresAt: now.Add(invitationTTL),
+			CreatedAt: now,
+		}
+
+		var org Org
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			if org, err = Lock(ctx, tx, orgID); err != nil {
+				return err
+			}
+
+			seats, err := FetchSeatsOf(ctx, tx, orgID, in.CourseID, now)
+			if err != nil {
+				return err
+			}
+
+			if seats.Available() <= 0 {
+				return fmt.Errorf("course[%s]: %w", in.CourseID, ErrNoSeats)
+			}
+
+			return CreateInvitation(ctx, tx, inv)
+		})
+		if err != nil {
+			if errors.Is(err, ErrNoSeats) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("inviting %s to organization[%s]: %w", in.Email, orgID, err)
+		}
+
+		// Send the email in background.
+		bg.Add(func() error {
+			if err := mailer.SendInvitation(text, inv.Email, org.Name); err != nil {
+				return fmt.Errorf("failed to send invitation to %s: %w", inv.Email, err)
+			}
+			return nil
+		})
+
+		return web.Respond(ctx, w, inv, http.StatusCreated)
+	}
+}
+
+// HandleAccept allows users to accept an invitation, joining its
+// organization and taking the seat reserved for them.
+func HandleAccept(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var in Token
+		if err := web.Decode(w, r, &in); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(in); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		now := time.Now().UTC()
+
+		inv, err := FetchInvitation(ctx, db, hash(in.Token), now)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.BadRequest(errors.New("invitation is not valid"))
+			}
+			return err
+		}
+
+		seat := Seat{
+			OrgID:     inv.OrgID,
+			CourseID:  inv.CourseID,
+			UserID:    clm.UserID,
+			CreatedAt: now,
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			if _, err := Lock(ctx, tx, inv.OrgID); err != nil {
+				return err
+			}
+
+			m := Membership{
+				OrgID:     inv.OrgID,
+				UserID:    clm.UserID,
+				Role:      Member,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+
+			if err := CreateMember(ctx, tx, m); err != nil {
+				return err
+			}
+
+			if err := CreateSeat(ctx, tx, seat); err != nil {
+				return err
+			}
+
+			return DeleteInvitation(ctx, tx, inv.ID)
+		})
+		if err != nil {
+			if !errors.Is(err, ErrSeatHeld) {
+				return fmt.Errorf("accepting invitation[%s]: %w", inv.ID, err)
+			}
+
+			// The user already holds a seat: release the reserved one.
+			if err := DeleteInvitation(ctx, db, inv.ID); err != nil {
+				return err
+			}
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		return web.Respond(ctx, w, seat, http.StatusOK)
+	}
+}
+
+// HandleAssign allows managers to assign seats of courses to members.
+func HandleAssign(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+
+		var sn SeatNew
+		if err := web.Decode(w, r, &sn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(sn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID, Owner, Manager); err != nil {
+			return err
+		}
+
+		if _, err := FetchMember(ctx, db, orgID, sn.UserID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				err := fmt.Errorf("user[%s] is not a member of the organization", sn.UserID)
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		now := time.Now().UTC()
+		seat := Seat{
+			OrgID:     orgID,
+			CourseID:  sn.CourseID,
+			UserID:    sn.UserID,
+			CreatedAt: now,
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			if _, err := Lock(ctx, tx, orgID); err != nil {
+				return err
+			}
+
+			seats, err := FetchSeatsOf(ctx, tx, orgID, sn.CourseID, now)
+			if err != nil {
+				return err
+			}
+
+			if seats.Available() <= 0 {
+				return fmt.Errorf("course[%s]: %w", sn.CourseID, ErrNoSeats)
+			}
+
+			return CreateSeat(ctx, tx, seat)
+		})
+		if err != nil {
+			if errors.Is(err, ErrNoSeats) || errors.Is(err, ErrSeatHeld) {
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("assigning seat in organization[%s]: %w", orgID, err)
+		}
+
+		return web.Respond(ctx, w, seat, http.StatusCreated)
+	}
+}
+
+// HandleReclaim allows managers to take back the seat assigned to a member,
+// so that it can be assigned to someone else.
+func HandleReclaim(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+		courseID := web.Param(r, "course_id")
+		userID := web.Param(r, "user_id")
+
+		if err := validate.CheckID(courseID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		if err := validate.CheckID(userID); err != nil {
+			return weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID, Owner, Manager); err != nil {
+			return err
+		}
+
+		if err := DeleteSeat(ctx, db, orgID, courseID, userID); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleListProgress allows managers to follow the progress of each member
+// on the courses of their seats.
+func HandleListProgress(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		orgID := web.Param(r, "id")
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		if _, err := Authorize(ctx, db, orgID, clm.UserID, Owner, Manager); err != nil {
+			return err
+		}
+
+		ps, err := FetchProgress(ctx, db, orgID)
+		if err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, ps, http.StatusOK)
+	}
+}
@@ -0,0 +1,160 @@
+package org
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoSeats  = errors.New("no seats left for the course")
+	ErrSeatHeld = errors.New("user already holds a seat of the course")
+)
+
+// Role models the roles of the members of an organization.
+type Role string
+
+const (
+	// Owners manage the organization and its managers.
+	Owner Role = "owner"
+	// Managers buy seats and assign them to members.
+	Manager Role = "manager"
+	// Members watch the courses of the seats assigned to them.
+	Member Role = "member"
+)
+
+// invitationTTL is the time after which invitations expire,
+// releasing the seat they reserved.
+const invitationTTL = 7 * 24 * time.Hour
+
+// Mailer should be able to send invitations to join organizations.
+type Mailer interface {
+	SendInvitation(token string, to string, org string) error
+}
+
+// Org models organizations buying seats of courses for their staff.
+type Org struct {
+	ID        string    `json:"id" db:"org_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// OrgNew contains the information needed to create an organization.
+type OrgNew struct {
+	Name string `json:"name" validate:"required,max=200"`
+}
+
+// Details contains an organization together with its members
+// and the seats it bought.
+type Details struct {
+	Org
+	Members []MemberInfo `json:"members"`
+	Seats   []Seats      `json:"seats"`
+}
+
+// Membership binds users to organizations with a role.
+type Membership struct {
+	OrgID     string    `json:"orgId" db:"org_id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	Role      Role      `json:"role" db:"role"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// MemberInfo is a membership together with the details of the user.
+type MemberInfo struct {
+	Membership
+	Name  string `json:"name" db:"name"`
+	Email string `json:"email" db:"email"`
+}
+
+// RoleUp contains the role to be given to a member.
+// Ownership cannot be transferred.
+type RoleUp struct {
+	Role Role `json:"role" validate:"required,oneof=manager member"`
+}
+
+// Seat is the seat of a course assigned to a member.
+type Seat struct {
+	OrgID     string    `json:"orgId" db:"org_id"`
+	CourseID  string    `json:"courseId" db:"course_id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// SeatNew contains the information needed to assign a seat to a member.
+type SeatNew struct {
+	CourseID string `json:"courseId" validate:"required,uuid"`
+	UserID   string `json:"userId" validate:"required,uuid"`
+}
+
+// Seats summarizes the seats of a course bought by an organization.
+// Seats reserved by pending invitations are not available.
+type Seats struct {
+	CourseID  string `json:"courseId" db:"course_id"`
+	Purchased int    `json:"purchased" db:"purchased"`
+	Assigned  int    `json:"assigned" db:"assigned"`
+	Invited   int    `json:"invited" db:"invited"`
+}
+
+// Available returns the number of seats that can still be assigned.
+func (s Seats) Available() int {
+	return s.Purchased - s.Assigned - s.Invited
+}
+
+// Invitation models invitations to join an organization,
+// taking a seat of a course.
+type Invitation struct {
+	ID        string    `json:"id" db:"invitation_id"`
+	OrgID     string    `json:"orgId" db:"org_id"`
+	CourseID  string    `json:"courseId" db:"course_id"`
+	Email     string    `json:"email" db:"email"`
+	TokenHash []byte    `json:"-" db:"token_hash"`
+	ExpiresAt time.Time `json:"expiresAt" db:"expires_at"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// InvitationNew contains the information needed to invite a user.
+type InvitationNew struct {
+	CourseID string `json:"courseId" validate:"required,uuid"`
+	Email    string `json:"email" validate:"required,email"`
+}
+
+// Token contains the token of an invitation to be accepted.
+type Token struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// Progress is the progress of a member on the course of their seat.
+// Progress is the average progress on the videos of the course, while
+// Completed is the number of videos fully watched.
+type Progress struct {
+	UserID    string `json:"userId" db:"user_id"`
+	Name      string `json:"name" db:"name"`
+	Email     string `json:"email" db:"email"`
+	CourseID  string `json:"courseId" db:"course_id"`
+	Videos    int    `json:"videos" db:"videos"`
+	Completed int    `json:"completed" db:"completed"`
+	Progress  int    `json:"progress" db:"progress"`
+}
+
+// genToken generates a new random invitation token, returning it
+// together with its hash.
+func genToken() (string, []byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	return token, hash(token), nil
+}
+
+// hash returns the hash of the passed token, as stored.
+func hash(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
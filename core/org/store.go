@@ -0,0 +1,504 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// Create inserts a new organization.
+func Create(ctx context.Context, db sqlx.ExtContext, org Org) error {
+	const q = `
+	INSERT INTO organizations
+		(org_id, name, created_at, updated_at)
+	VALUES
+	(:org_id, :name, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, org); err != nil {
+		return fmt.Errorf("inserting organization: %w", err)
+	}
+
+	return nil
+}
+
+// Fetch returns a specific organization.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Org, error) {
+	in := struct {
+		ID string `db:"org_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		organizations
+	WHERE
+		org_id = :org_id`
+
+	var org Org
+	if err := database.NamedQueryStruct(ctx, db, q, in, &org); err != nil {
+		return Org{}, fmt.Errorf("selecting organization[%s]: %w", id, err)
+	}
+
+	return org, nil
+}
+
+// Lock fetches an organization, locking it until the end of the transaction,
+// so that its seats can be safely assigned.
+func Lock(ctx context.Context, tx sqlx.ExtContext, id string) (Org, error) {
+	in := struct {
+		ID string `db:"org_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		organizations
+	WHERE
+		org_id = :org_id
+	FOR UPDATE`
+
+	var org Org
+	if err := database.NamedQueryStruct(ctx, tx, q, in, &org); err != nil {
+		return Org{}, fmt.Errorf("locking organization[%s]: %w", id, err)
+	}
+
+	return org, nil
+}
+
+// FetchByMember returns all the organizations the passed user is member of.
+func FetchByMember(ctx context.Context, db sqlx.ExtContext, userID string) ([]Org, error) {
+	in := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		o.*
+	FROM
+		organizations AS o
+	INNER JOIN
+		org_members AS m ON m.org_id = o.org_id
+	WHERE
+		m.user_id = :user_id
+	ORDER BY
+		o.name`
+
+	orgs := []Org{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &orgs); err != nil {
+		return nil, fmt.Errorf("selecting organizations of user[%s]: %w", userID, err)
+	}
+
+	return orgs, nil
+}
+
+// CreateMember inserts a new member in the organization.
+// Users already members keep their role.
+func CreateMember(ctx context.Context, db sqlx.ExtContext, m Membership) error {
+	const q = `
+	INSERT INTO org_members
+		(org_id, user_id, role, created_at, updated_at)
+	VALUES
+	(:org_id, :user_id, :role, :created_at, :updated_at)
+	ON CONFLICT DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, db, q, m); err != nil {
+		return fmt.Errorf("inserting member[%s] of organization[%s]: %w", m.UserID, m.OrgID, err)
+	}
+
+	return nil
+}
+
+// FetchMember returns the membership of the user in the organization.
+func FetchMember(ctx context.Context, db sqlx.ExtContext, orgID string, userID string) (Membership, error) {
+	in := struct {
+		OrgID  string `db:"org_id"`
+		UserID string `db:"user_id"`
+	}{
+		OrgID:  orgID,
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		org_members
+	WHERE
+		org_id = :org_id AND
+		user_id = :user_id`
+
+	var m Membership
+	if err := database.NamedQueryStruct(ctx, db, q, in, &m); err != nil {
+		return Membership{}, fmt.Errorf("selecting member[%s] of organization[%s]: %w", userID, orgID, err)
+	}
+
+	return m, nil
+}
+
+// FetchMembers returns all the members of the organization.
+func FetchMembers(ctx context.Context, db sqlx.ExtContext, orgID string) ([]MemberInfo, error) {
+	in := struct {
+		OrgID string `db:"org_id"`
+	}{
+		OrgID: orgID,
+	}
+
+	const q = `
+	SELECT
+		m.*, u.name, u.email
+	FROM
+		org_members AS m
+	INNER JOIN
+		users AS u ON u.user_id = m.user_id
+	WHERE
+		m.org_id = :org_id
+	ORDER BY
+		u.email`
+
+	ms := []MemberInfo{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ms); err != nil {
+		return nil, fmt.Errorf("selecting members of organization[%s]: %w", orgID, err)
+	}
+
+	return ms, nil
+}
+
+// UpdateRole updates the role of a member of the organization.
+func UpdateRole(ctx context.Context, db sqlx.ExtContext, m Membership) error {
+	const q = `
+	UPDATE org_members
+	SET
+		role = :role,
+		updated_at = :updated_at
+	WHERE
+		org_id = :org_id AND
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, db, q, m); err != nil {
+		return fmt.Errorf("updating role of member[%s] of organization[%s]: %w", m.UserID, m.OrgID, err)
+	}
+
+	return nil
+}
+
+// DeleteMember removes a member from the organization.
+// The seats of the member are reclaimed in cascade.
+func DeleteMember(ctx context.Context, db sqlx.ExtContext, orgID string, userID string) error {
+	in := struct {
+		OrgID  string `db:"org_id"`
+		UserID string `db:"user_id"`
+	}{
+		OrgID:  orgID,
+		UserID: userID,
+	}
+
+	const q = `
+	DELETE FROM
+		org_members
+	WHERE
+		org_id = :org_id AND
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting member[%s] of organization[%s]: %w", userID, orgID, err)
+	}
+
+	return nil
+}
+
+// FetchSeats returns the seats bought by the organization for each course,
+// together with the seats already assigned or reserved by invitations.
+// Seats whose order item has been refunded or whose order payment is
+// disputed are not counted. Results are limited to the passed course, if any.
+func FetchSeats(ctx context.Context, db sqlx.ExtContext, orgID string, courseID string, now time.Time) ([]Seats, error) {
+	in := struct {
+		OrgID           string    `db:"org_id"`
+		CourseID        string    `db:"course_id"`
+		Now             time.Time `db:"now"`
+		Status          string    `db:"status"`
+		StatusRefunding string    `db:"status_refunding"`
+		DisputeOpen     string    `db:"dispute_open"`
+	}{
+		OrgID:           orgID,
+		CourseID:        courseID,
+		Now:             now,
+		Status:          "success",
+		StatusRefunding: "partially_refunded",
+		DisputeOpen:     "open",
+	}
+
+	const q = `
+	WITH purchased AS (
+		SELECT
+			i.course_id, SUM(i.seats) AS seats
+		FROM
+			orders AS o
+		INNER JOIN
+			order_items AS i ON i.order_id = o.order_id
+		WHERE
+			o.org_id = :org_id AND
+			o.status IN (:status, :status_refunding) AND
+			(:course_id = '' OR i.course_id = CAST(NULLIF(:course_id, '') AS UUID)) AND
+			NOT EXISTS (
+				SELECT 1 FROM order_refunds AS r
+				WHERE r.order_id = i.order_id AND r.course_id = i.course_id AND r.status = 'completed'
+			) AND
+			NOT EXISTS (
+				SELECT 1 FROM order_disputes AS d
+				WHERE d.order_id = o.order_id AND d.status = :dispute_open
+			)
+		GROUP BY
+			i.course_id
+	)
+	SELECT
+		p.course_id,
+		p.seats AS purchased,
+		(
+			SELECT COUNT(*) FROM org_seats AS s
+			WHERE s.org_id = CAST(:org_id AS UUID) AND s.course_id = p.course_id
+		) AS assigned,
+		(
+			SELECT COUNT(*) FROM org_invitations AS n
+			WHERE n.org_id = CAST(:org_id AS UUID) AND n.course_id = p.course_id AND n.expires_at > :now
+		) AS invited
+	FROM
+		purchased AS p
+	ORDER BY
+		p.course_id`
+
+	ss := []Seats{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ss); err != nil {
+		return nil, fmt.Errorf("selecting seats of organization[%s]: %w", orgID, err)
+	}
+
+	return ss, nil
+}
+
+// FetchSeatsOf returns the seats bought by the organization for the course.
+func FetchSeatsOf(ctx context.Context, db sqlx.ExtContext, orgID string, courseID string, now time.Time) (Seats, error) {
+	ss, err := FetchSeats(ctx, db, orgID, courseID, now)
+	if err != nil {
+		return Seats{}, err
+	}
+
+	if len(ss) == 0 {
+		return Seats{CourseID: courseID}, nil
+	}
+
+	return ss[0], nil
+}
+
+// CreateSeat assigns the seat of a course to a member of the organization.
+// It returns ErrSeatHeld if the member already holds a seat of the course.
+func CreateSeat(ctx context.Context, db sqlx.ExtContext, seat Seat) error {
+	const q = `
+	INSERT INTO org_seats
+		(org_id, course_id, user_id, created_at)
+	VALUES
+	(:org_id, :course_id, :user_id, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, seat); err != nil {
+		if errors.Is(err, database.ErrDBDuplicatedEntry) {
+			err = ErrSeatHeld
+		}
+		return fmt.Errorf("inserting seat of course[%s] for user[%s]: %w", seat.CourseID, seat.UserID, err)
+	}
+
+	return nil
+}
+
+// DeleteSeat reclaims the seat of a course assigned to a member.
+func DeleteSeat(ctx context.Context, db sqlx.ExtContext, orgID string, courseID string, userID string) error {
+	in := struct {
+		OrgID    string `db:"org_id"`
+		CourseID string `db:"course_id"`
+		UserID   string `db:"user_id"`
+	}{
+		OrgID:    orgID,
+		CourseID: courseID,
+		UserID:   userID,
+	}
+
+	const q = `
+	DELETE FROM
+		org_seats
+	WHERE
+		org_id = :org_id AND
+		course_id = :course_id AND
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting seat of course[%s] for user[%s]: %w", courseID, userID, err)
+	}
+
+	return nil
+}
+
+// FetchSeat returns a seat of the course assigned to the user, if any.
+// Seats are valid as long as their organization holds seats of the course.
+func FetchSeat(ctx context.Context, db sqlx.ExtContext, courseID string, userID string) (Seat, error) {
+	in := struct {
+		CourseID        string `db:"course_id"`
+		UserID          string `db:"user_id"`
+		Status          string `db:"status"`
+		StatusRefunding string `db:"status_refunding"`
+		DisputeOpen     string `db:"dispute_open"`
+	}{
+		CourseID:        courseID,
+		UserID:          userID,
+		Status:          "success",
+		StatusRefunding: "partially_refunded",
+		DisputeOpen:     "open",
+	}
+
+	const q = `
+	SELECT
+		s.*
+	FROM
+		org_seats AS s
+	WHERE
+		s.course_id = :course_id AND
+		s.user_id = :user_id AND
+		EXISTS (
+			SELECT 1 FROM orders AS o
+			INNER JOIN order_items AS i ON i.order_id = o.order_id
+			WHERE
+				o.org_id = CAST(s.org_id AS TEXT) AND
+				i.course_id = s.course_id AND
+				i.seats > 0 AND
+				o.status IN (:status, :status_refunding) AND
+				NOT EXISTS (
+					SELECT 1 FROM order_refunds AS r
+					WHERE r.order_id = i.order_id AND r.course_id = i.course_id AND r.status = 'completed'
+				) AND
+				NOT EXISTS (
+					SELECT 1 FROM order_disputes AS d
+					WHERE d.order_id = o.order_id AND d.status = :dispute_open
+				)
+		)
+	LIMIT 1`
+
+	var s Seat
+	if err := database.NamedQueryStruct(ctx, db, q, in, &s); err != nil {
+		return Seat{}, fmt.Errorf("selecting seat of course[%s] for user[%s]: %w", courseID, userID, err)
+	}
+
+	return s, nil
+}
+
+// CreateInvitation inserts a new invitation.
+func CreateInvitation(ctx context.Context, db sqlx.ExtContext, inv Invitation) error {
+	const q = `
+	INSERT INTO org_invitations
+		(invitation_id, org_id, course_id, email, token_hash, expires_at, created_at)
+	VALUES
+	(:invitation_id, :org_id, :course_id, :email, :token_hash, :expires_at, :created_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, inv); err != nil {
+		return fmt.Errorf("inserting invitation: %w", err)
+	}
+
+	return nil
+}
+
+// FetchInvitation returns the invitation whose token has the passed hash,
+// if not expired.
+func FetchInvitation(ctx context.Context, db sqlx.ExtContext, tokenHash []byte, now time.Time) (Invitation, error) {
+	in := struct {
+		TokenHash []byte    `db:"token_hash"`
+		Now       time.Time `db:"now"`
+	}{
+		TokenHash: tokenHash,
+		Now:       now,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		org_invitations
+	WHERE
+		token_hash = :token_hash AND
+		expires_at > :now`
+
+	var inv Invitation
+	if err := database.NamedQueryStruct(ctx, db, q, in, &inv); err != nil {
+		return Invitation{}, fmt.Errorf("selecting invitation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// DeleteInvitation deletes an invitation, releasing the seat it reserved.
+func DeleteInvitation(ctx context.Context, db sqlx.ExtContext, id string) error {
+	in := struct {
+		ID string `db:"invitation_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	DELETE FROM
+		org_invitations
+	WHERE
+		invitation_id = :invitation_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting invitation[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// FetchProgress returns the progress of each member of the organization
+// on the courses of the seats assigned to them.
+func FetchProgress(ctx context.Context, db sqlx.ExtContext, orgID string) ([]Progress, error) {
+	in := struct {
+		OrgID string `db:"org_id"`
+	}{
+		OrgID: orgID,
+	}
+
+	const q = `
+	SELECT
+		s.user_id,
+		u.name,
+		u.email,
+		s.course_id,
+		COUNT(v.video_id) AS videos,
+		COUNT(p.video_id) FILTER (WHERE p.progress = 100) AS completed,
+		COALESCE(SUM(p.progress), 0) / GREATEST(COUNT(v.video_id), 1) AS progress
+	FROM
+		org_seats AS s
+	INNER JOIN
+		users AS u ON u.user_id = s.user_id
+	LEFT JOIN
+		videos AS v ON v.course_id = s.course_id
+	LEFT JOIN
+		videos_progress AS p ON p.video_id = v.video_id AND p.user_id = s.user_id
+	WHERE
+		s.org_id = :org_id
+	GROUP BY
+		s.user_id, u.name, u.email, s.course_id
+	ORDER BY
+		u.email, s.course_id`
+
+	ps := []Progress{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ps); err != nil {
+		return nil, fmt.Errorf("selecting progress of members of organization[%s]: %w", orgID, err)
+	}
+
+	return ps, nil
+}
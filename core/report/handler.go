@@ -0,0 +1,132 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleRevenue allows administrators to fetch the revenue of the
+// orders, grouped by period, course, provider or currency.
+func HandleRevenue(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		filter, err := parseFilter(r)
+		if err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		rows := []Row{}
+		f := func(row Row) error {
+			rows = append(rows, row)
+			return nil
+		}
+
+		if err := EachRevenue(ctx, db, filter, f); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, rows, http.StatusOK)
+	}
+}
+
+// HandleExportRevenue allows administrators to export the revenue of the
+// orders as a CSV file. Rows are written as soon as they are aggregated.
+// Amounts are expressed in the major unit of their currency.
+func HandleExportRevenue(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		filter, err := parseFilter(r)
+		if err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"revenue-%s.csv\"", filter.Group))
+		w.WriteHeader(http.StatusOK)
+
+		cw := csv.NewWriter(w)
+		cw.Write([]string{string(filter.Group), "name", "currency", "orders", "gross", "tax", "refunds", "net", "average_order"})
+
+		f := func(row Row) error {
+			dec := func(amount int64) string {
+				return money.New(amount, row.Currency).Decimal()
+			}
+
+			cw.Write([]string{
+				row.Key,
+				row.Name,
+				row.Currency,
+				strconv.Itoa(row.Orders),
+				dec(row.Gross),
+				dec(row.Tax),
+				dec(row.Refunds),
+				dec(row.Net),
+				dec(row.AverageOrder),
+			})
+			return cw.Error()
+		}
+
+		if err := EachRevenue(ctx, db, filter, f); err != nil {
+			return err
+		}
+		cw.Flush()
+
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("writing revenue: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// parseFilter extracts the report filter from the query parameters.
+// Revenue is grouped by month by default.
+// Dates must be expressed in RFC3339 format.
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+
+	filter := Filter{
+		Group:    Group(q.Get("group")),
+		Currency: strings.ToUpper(q.Get("currency")),
+	}
+
+	if filter.Group == "" {
+		filter.Group = Month
+	}
+
+	for _, d := range []struct {
+		key string
+		dst **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		v := q.Get(d.key)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing %s date: %w", d.key, err)
+		}
+
+		t = t.UTC()
+		*d.dst = &t
+	}
+
+	if err := validate.Check(filter); err != nil {
+		return Filter{}, err
+	}
+
+	return filter, nil
+}
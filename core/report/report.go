@@ -0,0 +1,58 @@
+// Package report aggregates the orders into revenue reports for administrators.
+package report
+
+import (
+	"time"
+)
+
+// Group is the dimension the revenue is aggregated by.
+type Group string
+
+// Set of possible groups of revenue reports.
+const (
+	Day      Group = "day"
+	Week     Group = "week"
+	Month    Group = "month"
+	Course   Group = "course"
+	Provider Group = "provider"
+	Currency Group = "currency"
+)
+
+// Filter selects the orders aggregated by a report.
+// Orders are assigned to periods by their creation date, in UTC,
+// and weeks start on Monday.
+type Filter struct {
+	Group    Group      `db:"group" validate:"required,oneof=day week month course provider currency"`
+	Currency string     `db:"currency" validate:"omitempty,iso4217"`
+	From     *time.Time `db:"from"`
+	To       *time.Time `db:"to"`
+}
+
+// Row is the revenue of a group in a currency.
+// Key identifies the group: the first day of the period, the id of the
+// course, the provider or the currency itself. Name is the name of the
+// course, when grouping by course.
+//
+// Gross is the amount charged for the items, taxes included, while
+// Refunds is the amount refunded for them, regardless of when the refund
+// was issued. Amounts are expressed in the minor unit of the currency.
+type Row struct {
+	Key          string `json:"key" db:"key"`
+	Name         string `json:"name" db:"name"`
+	Currency     string `json:"currency" db:"currency"`
+	Orders       int    `json:"orders" db:"orders"`
+	Gross        int64  `json:"gross" db:"gross"`
+	Tax          int64  `json:"tax" db:"tax"`
+	Refunds      int64  `json:"refunds" db:"refunds"`
+	Net          int64  `json:"net" db:"-"`
+	AverageOrder int64  `json:"averageOrder" db:"-"`
+}
+
+// complete computes the figures derived from the aggregated ones.
+func (r Row) complete() Row {
+	r.Net = r.Gross - r.Refunds
+	if r.Orders > 0 {
+		r.AverageOrder = r.Gross / int64(r.Orders)
+	}
+	return r
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// EachRevenue aggregates the paid orders matching the filter and passes
+// the revenue of each group to fn, ordered by group and currency.
+// Orders refunded afterwards are included, together with their refunds,
+// while orders with nothing paid, like free enrollments, are not sales.
+func EachRevenue(ctx context.Context, db sqlx.ExtContext, filter Filter, fn func(Row) error) error {
+	const q = `
+	SELECT
+		CASE :group
+			WHEN 'day' THEN to_char(date_trunc('day', o.created_at), 'YYYY-MM-DD')
+			WHEN 'week' THEN to_char(date_trunc('week', o.created_at), 'YYYY-MM-DD')
+			WHEN 'month' THEN to_char(date_trunc('month', o.created_at), 'YYYY-MM-DD')
+			WHEN 'course' THEN CAST(i.course_id AS TEXT)
+			WHEN 'provider' THEN o.provider
+			ELSE o.currency
+		END AS key,
+		CASE WHEN :group = 'course' THEN c.name ELSE '' END AS name,
+		o.currency,
+		COUNT(DISTINCT o.order_id) AS orders,
+		CAST(COALESCE(SUM(i.price), 0) AS BIGINT) AS gross,
+		CAST(COALESCE(SUM(i.tax), 0) AS BIGINT) AS tax,
+		CAST(COALESCE(SUM(r.amount), 0) AS BIGINT) AS refunds
+	FROM
+		orders AS o
+	JOIN
+		order_items AS i ON i.order_id = o.order_id
+	JOIN
+		courses AS c ON c.course_id = i.course_id
+	LEFT JOIN
+		order_refunds AS r ON r.order_id = i.order_id AND r.course_id = i.course_id AND r.status = 'completed'
+	WHERE
+		o.status IN ('success', 'partially_refunded', 'refunded') AND
+		o.provider <> 'free' AND
+		o.order_id IN (SELECT order_id FROM order_items GROUP BY order_id HAVING SUM(price) > 0) AND
+		(:currency = '' OR o.currency = :currency) AND
+		(CAST(:from AS TIMESTAMP) IS NULL OR o.created_at >= :from) AND
+		(CAST(:to AS TIMESTAMP) IS NULL OR o.created_at < :to)
+	GROUP BY
+		1, 2, 3
+	ORDER BY
+		1, 3`
+
+	f := func(r Row) error {
+		return fn(r.complete())
+	}
+
+	if err := database.NamedQueryEach(ctx, db, q, filter, f); err != nil {
+		return fmt.Errorf("selecting revenue by %s: %w", filter.Group, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,400 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/money"
+	"github.com/polldo/govod/validate"
+)
+
+// HandleCreatePlan allows administrators to add new subscription plans.
+func HandleCreatePlan(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var p PlanNew
+		if err := web.Decode(w, r, &p); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(p); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+
+		plan := Plan{
+			ID:          validate.GenerateID(),
+			Name:        p.Name,
+			Description: p.Description,
+			Interval:    p.Interval,
+			Price:       p.Price,
+			Currency:    p.Currency,
+			AllCourses:  p.AllCourses,
+			CourseIDs:   p.CourseIDs,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if plan.Currency == "" {
+			plan.Currency = money.Default
+		}
+		if plan.CourseIDs == nil {
+			plan.CourseIDs = []string{}
+		}
+
+		if err := check(ctx, db, plan); err != nil {
+			return err
+		}
+
+		err := database.Transaction(db, func(tx sqlx.ExtContext) error {
+			return CreatePlan(ctx, tx, plan)
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrDBDuplicatedEntry) {
+				return weberr.NewError(err, "passed plan already exists", http.StatusUnprocessableEntity)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, plan, http.StatusCreated)
+	}
+}
+
+// HandleUpdatePlan allows administrators to update existing plans.
+// Changes of price and interval apply to new subscriptions only.
+func HandleUpdatePlan(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		planID := web.Param(r, "id")
+
+		if err := validate.CheckID(planID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		var pup PlanUp
+		if err := web.Decode(w, r, &pup); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(pup); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		plan, err := FetchPlan(ctx, db, planID)
+		if err != nil {
+			err := fmt.Errorf("fetching passed plan[%s]: %w", planID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if pup.Name != nil {
+			plan.Name = *pup.Name
+		}
+		if pup.Description != nil {
+			plan.Description = *pup.Description
+		}
+		if pup.Interval != nil {
+			plan.Interval = *pup.Interval
+		}
+		if pup.Price != nil {
+			plan.Price = *pup.Price
+		}
+		if pup.Currency != nil {
+			plan.Currency = *pup.Currency
+		}
+		if pup.AllCourses != nil {
+			plan.AllCourses = *pup.AllCourses
+		}
+		if pup.CourseIDs != nil {
+			plan.CourseIDs = *pup.CourseIDs
+		}
+		plan.UpdatedAt = time.Now().UTC()
+
+		if err := check(ctx, db, plan); err != nil {
+			return err
+		}
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			plan, err = UpdatePlan(ctx, tx, plan)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("updating plan[%s]: %w", planID, err)
+		}
+
+		return web.Respond(ctx, w, plan, http.StatusOK)
+	}
+}
+
+// HandleDeletePlan allows administrators to archive plans, so that they
+// cannot be subscribed anymore. Existing subscriptions are kept.
+func HandleDeletePlan(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		planID := web.Param(r, "id")
+
+		if err := validate.CheckID(planID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		plan, err := FetchPlan(ctx, db, planID)
+		if err != nil {
+			err := fmt.Errorf("fetching passed plan[%s]: %w", planID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		plan.Archived = true
+		plan.UpdatedAt = time.Now().UTC()
+
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			_, err := UpdatePlan(ctx, tx, plan)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("archiving plan[%s]: %w", planID, err)
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// HandleListPlans allows users to fetch all the plans they can subscribe.
+func HandleListPlans(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		plans, err := FetchPlans(ctx, db)
+		if err != nil {
+			return fmt.Errorf("fetching all plans: %w", err)
+		}
+
+		return web.Respond(ctx, w, plans, http.StatusOK)
+	}
+}
+
+// HandleShowPlan allows users to fetch the information of a specific plan.
+func HandleShowPlan(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		planID := web.Param(r, "id")
+
+		if err := validate.CheckID(planID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		plan, err := FetchPlan(ctx, db, planID)
+		if err != nil {
+			err := fmt.Errorf("fetching plan[%s]: %w", planID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		return web.Respond(ctx, w, plan, http.StatusOK)
+	}
+}
+
+// HandleCheckout starts the subscription of users to a plan with the
+// billing provider. The subscription is pending until its first payment.
+// Users whose subscription still grants access cannot subscribe again.
+func HandleCheckout(db *sqlx.DB, billing Billing) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if billing == nil {
+			return weberr.NotFound(errors.New("subscriptions are not available"))
+		}
+
+		var sn SubscriptionNew
+		if err := web.Decode(w, r, &sn); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(sn); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		plan, err := FetchPlan(ctx, db, sn.PlanID)
+		if err != nil {
+			err := fmt.Errorf("fetching plan[%s]: %w", sn.PlanID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if plan.Archived {
+			err := fmt.Errorf("subscribing plan[%s]: %w", plan.ID, ErrArchived)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+
+		_, err = FetchAccessible(ctx, db, clm.UserID, now)
+		switch {
+		case err == nil:
+			return weberr.NewError(ErrSubscribed, ErrSubscribed.Error(), http.StatusUnprocessableEntity)
+		case !errors.Is(err, database.ErrDBNotFound):
+			return err
+		}
+
+		// Providers date their events to the second.
+		sub := Subscription{
+			ID:        validate.GenerateID(),
+			UserID:    clm.UserID,
+			PlanID:    plan.ID,
+			Status:    Pending,
+			SyncedAt:  now.Truncate(time.Second),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		chk, err := billing.Checkout(ctx, plan, sub)
+		if err != nil {
+			return fmt.Errorf("starting subscription checkout: %w", err)
+		}
+		sub.ProviderID = chk.ProviderID
+
+		if err := Create(ctx, db, sub); err != nil {
+			return fmt.Errorf("creating the subscription on the database: %w", err)
+		}
+
+		return web.Respond(ctx, w, chk.Response, http.StatusOK)
+	}
+}
+
+// HandleList allows users to fetch their subscriptions.
+func HandleList(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		subs, err := FetchByUser(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("fetching subscriptions of user[%s]: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, subs, http.StatusOK)
+	}
+}
+
+// HandleCancel allows users to cancel their subscription. Subscriptions
+// are canceled at the end of the paid period, when access is revoked.
+func HandleCancel(db *sqlx.DB, billing Billing) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if billing == nil {
+			return weberr.NotFound(errors.New("subscriptions are not available"))
+		}
+
+		subID := web.Param(r, "id")
+
+		if err := validate.CheckID(subID); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		sub, err := Fetch(ctx, db, subID)
+		if err != nil {
+			err := fmt.Errorf("fetching subscription[%s]: %w", subID, err)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return weberr.NotFound(err)
+			}
+			return err
+		}
+
+		if sub.UserID != clm.UserID {
+			return weberr.NotFound(fmt.Errorf("subscription[%s] of user[%s] not found", subID, clm.UserID))
+		}
+
+		if sub.Status != Active && sub.Status != PastDue {
+			err := fmt.Errorf("subscription[%s] with status[%s] cannot be canceled", sub.ID, sub.Status)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if err := billing.Cancel(ctx, sub); err != nil {
+			return err
+		}
+
+		// The provider will confirm the cancellation through webhooks.
+		err = database.Transaction(db, func(tx sqlx.ExtContext) error {
+			if sub, err = Lock(ctx, tx, sub.ID); err != nil {
+				return err
+			}
+
+			sub.CancelAtPeriodEnd = true
+			sub.UpdatedAt = time.Now().UTC()
+			return Update(ctx, tx, sub)
+		})
+		if err != nil {
+			return fmt.Errorf("canceling subscription[%s]: %w", sub.ID, err)
+		}
+
+		return web.Respond(ctx, w, sub, http.StatusOK)
+	}
+}
+
+// HandleWebhook applies the events notified by the billing provider to
+// subscriptions. Events that cannot be applied yet are refused, so that
+// the provider notifies them again later.
+func HandleWebhook(db *sqlx.DB, billing Billing, grace time.Duration) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if billing == nil {
+			return weberr.NotFound(errors.New("subscriptions are not available"))
+		}
+
+		ev, err := billing.ParseWebhook(r)
+		if err != nil {
+			return weberr.BadRequest(fmt.Errorf("parsing billing event: %w", err))
+		}
+
+		if ev.Type == EventIgnored {
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+
+		if err := process(ctx, db, ev, grace); err != nil {
+			return fmt.Errorf("processing billing event[%s]: %w", ev.ID, err)
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// check verifies that the plan grants access to some courses
+// and that all its courses exist.
+func check(ctx context.Context, db sqlx.ExtContext, p Plan) error {
+	if !p.AllCourses && len(p.CourseIDs) == 0 {
+		err := errors.New("plan must include all the courses or some of them")
+		return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	for _, id := range p.CourseIDs {
+		if _, err := course.Fetch(ctx, db, id); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				err := fmt.Errorf("course[%s] of plan not found", id)
+				return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+			}
+			return fmt.Errorf("fetching course[%s] of plan: %w", id, err)
+		}
+	}
+
+	return nil
+}
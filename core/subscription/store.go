@@ -0,0 +1,387 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// CreatePlan inserts a new plan together with its courses.
+func CreatePlan(ctx context.Context, db sqlx.ExtContext, plan Plan) error {
+	const q = `
+	INSERT INTO plans
+		(plan_id, name, description, interval, price, currency, all_courses, created_at, updated_at)
+	VALUES
+	(:plan_id, :name, :description, :interval, :price, :currency, :all_courses, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, plan); err != nil {
+		return fmt.Errorf("inserting plan: %w", err)
+	}
+
+	return createCourses(ctx, db, plan)
+}
+
+// UpdatePlan updates the details of a specific plan, replacing its
+// courses. It relies on optimistic lock to deal with data races.
+func UpdatePlan(ctx context.Context, db sqlx.ExtContext, plan Plan) (Plan, error) {
+	const q = `
+	UPDATE plans
+	SET
+		name = :name,
+		description = :description,
+		interval = :interval,
+		price = :price,
+		currency = :currency,
+		all_courses = :all_courses,
+		archived = :archived,
+		updated_at = :updated_at,
+		version = version + 1
+	WHERE
+		plan_id = :plan_id AND
+		version = :version
+	RETURNING version`
+
+	v := struct {
+		Version int `db:"version"`
+	}{}
+
+	if err := database.NamedQueryStruct(ctx, db, q, plan, &v); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Plan{}, fmt.Errorf("updating plan[%s]: version conflict", plan.ID)
+		}
+		return Plan{}, fmt.Errorf("updating plan[%s]: %w", plan.ID, err)
+	}
+
+	plan.Version = v.Version
+
+	in := struct {
+		ID string `db:"plan_id"`
+	}{
+		ID: plan.ID,
+	}
+
+	const qc = `
+	DELETE FROM
+		plan_courses
+	WHERE
+		plan_id = :plan_id`
+
+	if err := database.NamedExecContext(ctx, db, qc, in); err != nil {
+		return Plan{}, fmt.Errorf("deleting courses of plan[%s]: %w", plan.ID, err)
+	}
+
+	if err := createCourses(ctx, db, plan); err != nil {
+		return Plan{}, err
+	}
+
+	return plan, nil
+}
+
+// FetchPlan returns information of a specific plan, with its courses.
+func FetchPlan(ctx context.Context, db sqlx.ExtContext, id string) (Plan, error) {
+	in := struct {
+		ID string `db:"plan_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		plans
+	WHERE
+		plan_id = :plan_id`
+
+	var plan Plan
+	if err := database.NamedQueryStruct(ctx, db, q, in, &plan); err != nil {
+		return Plan{}, fmt.Errorf("selecting plan[%s]: %w", id, err)
+	}
+
+	return withCourses(ctx, db, plan)
+}
+
+// FetchPlans returns all the plans that can be subscribed, with their courses.
+func FetchPlans(ctx context.Context, db sqlx.ExtContext) ([]Plan, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		plans
+	WHERE
+		archived = FALSE
+	ORDER BY
+		plan_id`
+
+	ps := []Plan{}
+	if err := database.NamedQuerySlice(ctx, db, q, struct{}{}, &ps); err != nil {
+		return nil, fmt.Errorf("selecting all plans: %w", err)
+	}
+
+	for i := range ps {
+		p, err := withCourses(ctx, db, ps[i])
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = p
+	}
+
+	return ps, nil
+}
+
+// Create inserts a new subscription.
+func Create(ctx context.Context, db sqlx.ExtContext, sub Subscription) error {
+	const q = `
+	INSERT INTO subscriptions
+		(subscription_id, user_id, plan_id, provider_id, status, synced_at, created_at, updated_at)
+	VALUES
+	(:subscription_id, :user_id, :plan_id, :provider_id, :status, :synced_at, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, sub); err != nil {
+		return fmt.Errorf("inserting subscription: %w", err)
+	}
+
+	return nil
+}
+
+// Update updates the status of the passed subscription
+// as synced with the billing provider.
+func Update(ctx context.Context, db sqlx.ExtContext, sub Subscription) error {
+	const q = `
+	UPDATE subscriptions
+	SET
+		billing_id = :billing_id,
+		status = :status,
+		cancel_at_period_end = :cancel_at_period_end,
+		current_period_end = :current_period_end,
+		access_until = :access_until,
+		synced_at = :synced_at,
+		updated_at = :updated_at
+	WHERE
+		subscription_id = :subscription_id`
+
+	if err := database.NamedExecContext(ctx, db, q, sub); err != nil {
+		return fmt.Errorf("updating subscription[%s]: %w", sub.ID, err)
+	}
+
+	return nil
+}
+
+// Fetch returns a specific subscription.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Subscription, error) {
+	in := struct {
+		ID string `db:"subscription_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		subscription_id = :subscription_id`
+
+	var sub Subscription
+	if err := database.NamedQueryStruct(ctx, db, q, in, &sub); err != nil {
+		return Subscription{}, fmt.Errorf("selecting subscription[%s]: %w", id, err)
+	}
+
+	return sub, nil
+}
+
+// Lock selects a specific subscription, locking it until
+// the end of the transaction.
+func Lock(ctx context.Context, tx sqlx.ExtContext, id string) (Subscription, error) {
+	in := struct {
+		ID string `db:"subscription_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		subscription_id = :subscription_id
+	FOR UPDATE`
+
+	var sub Subscription
+	if err := database.NamedQueryStruct(ctx, tx, q, in, &sub); err != nil {
+		return Subscription{}, fmt.Errorf("locking subscription[%s]: %w", id, err)
+	}
+
+	return sub, nil
+}
+
+// FetchByProviderID returns the subscription started by the passed checkout.
+func FetchByProviderID(ctx context.Context, db sqlx.ExtContext, providerID string) (Subscription, error) {
+	in := struct {
+		ProviderID string `db:"provider_id"`
+	}{
+		ProviderID: providerID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		provider_id = :provider_id`
+
+	var sub Subscription
+	if err := database.NamedQueryStruct(ctx, db, q, in, &sub); err != nil {
+		return Subscription{}, fmt.Errorf("selecting subscription of checkout[%s]: %w", providerID, err)
+	}
+
+	return sub, nil
+}
+
+// FetchByBillingID returns the subscription bound to the passed
+// subscription of the billing provider.
+func FetchByBillingID(ctx context.Context, db sqlx.ExtContext, billingID string) (Subscription, error) {
+	in := struct {
+		BillingID string `db:"billing_id"`
+	}{
+		BillingID: billingID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		billing_id = :billing_id`
+
+	var sub Subscription
+	if err := database.NamedQueryStruct(ctx, db, q, in, &sub); err != nil {
+		return Subscription{}, fmt.Errorf("selecting subscription with billing[%s]: %w", billingID, err)
+	}
+
+	return sub, nil
+}
+
+// FetchByUser returns all the subscriptions started by the passed user,
+// including the ones whose checkout has not been completed.
+func FetchByUser(ctx context.Context, db sqlx.ExtContext, userID string) ([]Subscription, error) {
+	in := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		created_at DESC`
+
+	subs := []Subscription{}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &subs); err != nil {
+		return nil, fmt.Errorf("selecting subscriptions of user[%s]: %w", userID, err)
+	}
+
+	return subs, nil
+}
+
+// FetchAccessible returns the subscription of the passed user that
+// grants access at the passed time, if any.
+func FetchAccessible(ctx context.Context, db sqlx.ExtContext, userID string, now time.Time) (Subscription, error) {
+	in := struct {
+		UserID string    `db:"user_id"`
+		Now    time.Time `db:"now"`
+	}{
+		UserID: userID,
+		Now:    now,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		subscriptions
+	WHERE
+		user_id = :user_id AND
+		access_until > :now
+	ORDER BY
+		access_until DESC
+	LIMIT 1`
+
+	var sub Subscription
+	if err := database.NamedQueryStruct(ctx, db, q, in, &sub); err != nil {
+		return Subscription{}, fmt.Errorf("selecting accessible subscription of user[%s]: %w", userID, err)
+	}
+
+	return sub, nil
+}
+
+// createCourses inserts the courses of the plan.
+func createCourses(ctx context.Context, db sqlx.ExtContext, plan Plan) error {
+	for _, id := range plan.CourseIDs {
+		in := struct {
+			PlanID   string `db:"plan_id"`
+			CourseID string `db:"course_id"`
+		}{
+			PlanID:   plan.ID,
+			CourseID: id,
+		}
+
+		const q = `
+		INSERT INTO plan_courses
+			(plan_id, course_id)
+		VALUES
+			(:plan_id, :course_id)`
+
+		if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+			return fmt.Errorf("inserting course[%s] of plan[%s]: %w", id, plan.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// withCourses fills the passed plan with its courses.
+func withCourses(ctx context.Context, db sqlx.ExtContext, plan Plan) (Plan, error) {
+	in := struct {
+		ID string `db:"plan_id"`
+	}{
+		ID: plan.ID,
+	}
+
+	const q = `
+	SELECT
+		course_id
+	FROM
+		plan_courses
+	WHERE
+		plan_id = :plan_id
+	ORDER BY
+		course_id`
+
+	var rows []struct {
+		CourseID string `db:"course_id"`
+	}
+	if err := database.NamedQuerySlice(ctx, db, q, in, &rows); err != nil {
+		return Plan{}, fmt.Errorf("selecting courses of plan[%s]: %w", plan.ID, err)
+	}
+
+	plan.CourseIDs = make([]string, 0, len(rows))
+	for _, r := range rows {
+		plan.CourseIDs = append(plan.CourseIDs, r.CourseID)
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,236 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/polldo/govod/config"
+	"github.com/stripe/stripe-go/v74"
+	stripecl "github.com/stripe/stripe-go/v74/client"
+	"github.com/stripe/stripe-go/v74/webhook"
+)
+
+// metadataKey is the key of the stripe metadata holding the id
+// of the subscription bound to a stripe subscription.
+const metadataKey = "subscription_id"
+
+// Stripe is the billing provider backed by stripe billing.
+// Subscriptions start with checkout sessions in subscription mode
+// and their lifecycle is notified through webhooks.
+type Stripe struct {
+	api *stripecl.API
+	cfg config.Stripe
+}
+
+// NewStripe constructs a stripe billing provider.
+func NewStripe(api *stripecl.API, cfg config.Stripe) *Stripe {
+	return &Stripe{api: api, cfg: cfg}
+}
+
+// Checkout creates a new stripe checkout session subscribing to the plan.
+// The id of the subscription is attached to the stripe subscription,
+// so that its events can be bound to it. The URL of the session is
+// returned to clients.
+func (s *Stripe) Checkout(ctx context.Context, plan Plan, sub Subscription) (Checkout, error) {
+	li := &stripe.CheckoutSessionLineItemParams{
+		Quantity: stripe.Int64(1),
+
+		PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+			Currency:   stripe.String(strings.ToLower(plan.Currency)),
+			UnitAmount: stripe.Int64(plan.Price),
+
+			Recurring: &stripe.CheckoutSessionLineItemPriceDataRecurringParams{
+				Interval: stripe.String(string(plan.Interval)),
+			},
+
+			ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+				Name:        stripe.String(plan.Name),
+				Description: stripe.String(plan.Description),
+			},
+		},
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL:        stripe.String(s.cfg.SuccessURL),
+		CancelURL:         stripe.String(s.cfg.CancelURL),
+		Mode:              stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		ClientReferenceID: stripe.String(sub.ID),
+		LineItems:         []*stripe.CheckoutSessionLineItemParams{li},
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{metadataKey: sub.ID},
+		},
+	}
+	params.Context = ctx
+
+	sess, err := s.api.CheckoutSessions.New(params)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("creating stripe session: %w", err)
+	}
+
+	return Checkout{ProviderID: sess.ID, Response: sess.URL}, nil
+}
+
+// Cancel cancels the stripe subscription at the end of the current period.
+func (s *Stripe) Cancel(ctx context.Context, sub Subscription) error {
+	if sub.BillingID == "" {
+		return fmt.Errorf("subscription[%s] is not bound to a stripe subscription", sub.ID)
+	}
+
+	params := &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)}
+	params.Context = ctx
+
+	if _, err := s.api.Subscriptions.Update(sub.BillingID, params); err != nil {
+		return fmt.Errorf("canceling stripe subscription[%s]: %w", sub.BillingID, err)
+	}
+
+	return nil
+}
+
+// ParseWebhook verifies the signature of the stripe event and decodes it.
+// Relevant events are the ones about checkouts in subscription mode,
+// subscriptions and their invoices.
+func (s *Stripe) ParseWebhook(r *http.Request) (Event, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Event{}, fmt.Errorf("cannot read the request body: %w", err)
+	}
+
+	sig := r.Header.Get("Stripe-Signature")
+	if sig == "" {
+		return Event{}, errors.New("received stripe event is not signed")
+	}
+
+	event, err := webhook.ConstructEvent(b, sig, s.cfg.BillingWebhookSecret)
+	if err != nil {
+		return Event{}, fmt.Errorf("cannot construct stripe event: %w", err)
+	}
+
+	var ev Event
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.expired":
+		ev, err = stripeSessionEvent(event)
+
+	case "customer.subscription.created",
+		"customer.subscription.updated",
+		"customer.subscription.deleted":
+		ev, err = stripeSubscriptionEvent(event)
+
+	case "invoice.paid", "invoice.payment_failed":
+		ev, err = stripeInvoiceEvent(event)
+
+	default:
+		return Event{Type: EventIgnored}, nil
+	}
+
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to decode stripe event[%s]: %w", event.Type, err)
+	}
+
+	ev.ID = event.ID
+	ev.CreatedAt = time.Unix(event.Created, 0).UTC()
+	return ev, nil
+}
+
+// stripeSessionEvent decodes the events about checkout sessions.
+func stripeSessionEvent(event stripe.Event) (Event, error) {
+	var sess stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
+		return Event{}, err
+	}
+
+	// Filter out checkouts that are not for subscriptions.
+	if sess.Mode != stripe.CheckoutSessionModeSubscription {
+		return Event{Type: EventIgnored}, nil
+	}
+
+	ev := Event{Type: EventStarted, ProviderID: sess.ID}
+	if event.Type == "checkout.session.expired" {
+		ev.Type = EventExpired
+	}
+
+	if sess.Subscription != nil {
+		ev.BillingID = sess.Subscription.ID
+	}
+
+	return ev, nil
+}
+
+// stripeSubscriptionEvent decodes the events about subscriptions.
+func stripeSubscriptionEvent(event stripe.Event) (Event, error) {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return Event{}, err
+	}
+
+	ev := Event{
+		Type:              EventUpdated,
+		BillingID:         sub.ID,
+		SubscriptionID:    sub.Metadata[metadataKey],
+		Status:            stripeStatus(sub.Status),
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+	}
+
+	if sub.CurrentPeriodEnd > 0 {
+		ev.PeriodEnd = time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+	}
+
+	if event.Type == "customer.subscription.deleted" {
+		ev.Type = EventDeleted
+		ev.Status = Canceled
+	}
+
+	return ev, nil
+}
+
+// stripeInvoiceEvent decodes the payments of the invoices of subscriptions.
+// Invoices of renewals are issued at the end of the period they follow,
+// so the paid period is the one of their lines.
+func stripeInvoiceEvent(event stripe.Event) (Event, error) {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return Event{}, err
+	}
+
+	if inv.Subscription == nil {
+		return Event{Type: EventIgnored}, nil
+	}
+
+	ev := Event{Type: EventPaid, BillingID: inv.Subscription.ID}
+	if event.Type == "invoice.payment_failed" {
+		ev.Type = EventPaymentFailed
+		return ev, nil
+	}
+
+	end := inv.PeriodEnd
+	if inv.Lines != nil {
+		for _, l := range inv.Lines.Data {
+			if l.Period != nil && l.Period.End > end {
+				end = l.Period.End
+			}
+		}
+	}
+	ev.PeriodEnd = time.Unix(end, 0).UTC()
+
+	return ev, nil
+}
+
+// stripeStatus converts the status of a stripe subscription.
+// Subscriptions whose first payment is not completed are still pending.
+func stripeStatus(s stripe.SubscriptionStatus) Status {
+	switch s {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+		return Active
+	case stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		return PastDue
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return Canceled
+	default:
+		return Pending
+	}
+}
@@ -0,0 +1,159 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrSubscribed is returned when users subscribe while they
+	// already have a subscription granting access.
+	ErrSubscribed = errors.New("user already has an active subscription")
+
+	// ErrArchived is returned when subscribing to an archived plan.
+	ErrArchived = errors.New("plan is archived")
+)
+
+// Interval models how often subscriptions of a plan are renewed.
+type Interval string
+
+const (
+	Monthly Interval = "month"
+	Yearly  Interval = "year"
+)
+
+// Status models the status of a subscription.
+type Status string
+
+const (
+	Pending  Status = "pending"
+	Active   Status = "active"
+	PastDue  Status = "past_due"
+	Canceled Status = "canceled"
+)
+
+// Plan models a subscription plan. Subscribers can access all the
+// courses of the catalog if AllCourses is set, otherwise they can only
+// access the courses of the plan.
+//
+// Price is expressed in the minor unit of Currency and it's charged
+// at each Interval.
+type Plan struct {
+	ID          string    `json:"id" db:"plan_id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Interval    Interval  `json:"interval" db:"interval"`
+	Price       int64     `json:"price" db:"price"`
+	Currency    string    `json:"currency" db:"currency"`
+	AllCourses  bool      `json:"allCourses" db:"all_courses"`
+	CourseIDs   []string  `json:"courseIds" db:"-"`
+	Archived    bool      `json:"archived" db:"archived"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+	Version     int       `json:"-" db:"version"`
+}
+
+// PlanNew contains the information needed to create a new plan.
+// Plans not including all the courses must include at least one course.
+// Currency defaults to money.Default.
+type PlanNew struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description" validate:"required"`
+	Interval    Interval `json:"interval" validate:"required,oneof=month year"`
+	Price       int64    `json:"price" validate:"required,gt=0,lte=1000000"`
+	Currency    string   `json:"currency" validate:"omitempty,iso4217"`
+	AllCourses  bool     `json:"allCourses"`
+	CourseIDs   []string `json:"courseIds" validate:"required_without=AllCourses,unique,dive,uuid"`
+}
+
+// PlanUp contains the information of a plan that can be updated.
+// Prices and intervals apply to the subscriptions started afterwards.
+type PlanUp struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Interval    *Interval `json:"interval" validate:"omitempty,oneof=month year"`
+	Price       *int64    `json:"price" validate:"omitempty,gt=0,lte=1000000"`
+	Currency    *string   `json:"currency" validate:"omitempty,iso4217"`
+	AllCourses  *bool     `json:"allCourses"`
+	CourseIDs   *[]string `json:"courseIds" validate:"omitempty,unique,dive,uuid"`
+}
+
+// Subscription models the subscription of a user to a plan.
+//
+// ProviderID identifies the checkout that started the subscription,
+// while BillingID identifies the subscription on the provider once created.
+// Subscribers can access the courses of the plan until AccessUntil,
+// which is the end of the paid period plus a grace period to let
+// failed renewals be retried.
+type Subscription struct {
+	ID                string     `json:"id" db:"subscription_id"`
+	UserID            string     `json:"userId" db:"user_id"`
+	PlanID            string     `json:"planId" db:"plan_id"`
+	ProviderID        string     `json:"-" db:"provider_id"`
+	BillingID         string     `json:"-" db:"billing_id"`
+	Status            Status     `json:"status" db:"status"`
+	CancelAtPeriodEnd bool       `json:"cancelAtPeriodEnd" db:"cancel_at_period_end"`
+	CurrentPeriodEnd  *time.Time `json:"currentPeriodEnd" db:"current_period_end"`
+	AccessUntil       *time.Time `json:"accessUntil" db:"access_until"`
+	SyncedAt          time.Time  `json:"-" db:"synced_at"`
+	CreatedAt         time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// SubscriptionNew contains the information needed to subscribe to a plan.
+type SubscriptionNew struct {
+	PlanID string `json:"planId" validate:"required,uuid"`
+}
+
+// Accessible returns whether the subscription grants access at the passed time.
+func (s Subscription) Accessible(now time.Time) bool {
+	return s.AccessUntil != nil && s.AccessUntil.After(now)
+}
+
+// Checkout is a checkout of a subscription started on the provider.
+// Response is returned to clients to let them complete the checkout.
+type Checkout struct {
+	ProviderID string
+	Response   any
+}
+
+// EventType models the events notified by billing providers that are
+// relevant for subscriptions.
+type EventType string
+
+const (
+	EventIgnored       EventType = "ignored"
+	EventStarted       EventType = "started"
+	EventExpired       EventType = "expired"
+	EventUpdated       EventType = "updated"
+	EventDeleted       EventType = "deleted"
+	EventPaid          EventType = "paid"
+	EventPaymentFailed EventType = "payment_failed"
+)
+
+// Event is an event notified by a billing provider through webhooks.
+// Events about checkouts carry their ProviderID, while the other ones
+// carry the BillingID of the subscription. SubscriptionID is known
+// when the provider reports it back.
+// Events created before the last one applied to a subscription are
+// stale: only payments are applied regardless of their order.
+type Event struct {
+	ID                string
+	Type              EventType
+	CreatedAt         time.Time
+	ProviderID        string
+	BillingID         string
+	SubscriptionID    string
+	Status            Status
+	CancelAtPeriodEnd bool
+	PeriodEnd         time.Time
+}
+
+// Billing is implemented by providers of recurring payments.
+type Billing interface {
+	Checkout(ctx context.Context, plan Plan, sub Subscription) (Checkout, error)
+	Cancel(ctx context.Context, sub Subscription) error
+	ParseWebhook(r *http.Request) (Event, error)
+}
@@ -0,0 +1,109 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// process applies the event notified by the billing provider to its
+// subscription. Events of subscriptions not bound yet fail, so that
+// the provider notifies them again once the checkout is completed.
+func process(ctx context.Context, db *sqlx.DB, ev Event, grace time.Duration) error {
+	id := ev.SubscriptionID
+	if id == "" {
+		var sub Subscription
+		var err error
+		if ev.ProviderID != "" {
+			sub, err = FetchByProviderID(ctx, db, ev.ProviderID)
+		} else {
+			sub, err = FetchByBillingID(ctx, db, ev.BillingID)
+		}
+		if err != nil {
+			return err
+		}
+		id = sub.ID
+	}
+
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		sub, err := Lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := Update(ctx, tx, apply(sub, ev, grace, time.Now().UTC())); err != nil {
+			return fmt.Errorf("applying event[%s] of type[%s]: %w", ev.ID, ev.Type, err)
+		}
+
+		return nil
+	})
+}
+
+// apply returns the subscription updated by the passed event.
+//
+// Payments extend the access to the end of the paid period plus the
+// grace period, regardless of the order they are notified.
+// Other events are applied only if they are not older than the last
+// one applied, and they revoke the access once the subscription is canceled.
+func apply(sub Subscription, ev Event, grace time.Duration, now time.Time) Subscription {
+	if ev.BillingID != "" {
+		sub.BillingID = ev.BillingID
+	}
+	sub.UpdatedAt = now
+
+	if ev.Type == EventPaid {
+		if sub.Status == Canceled {
+			return sub
+		}
+
+		end := ev.PeriodEnd
+		if sub.CurrentPeriodEnd == nil || end.After(*sub.CurrentPeriodEnd) {
+			sub.CurrentPeriodEnd = &end
+		}
+
+		until := end.Add(grace)
+		if sub.AccessUntil == nil || until.After(*sub.AccessUntil) {
+			sub.AccessUntil = &until
+		}
+
+		sub.Status = Active
+		return sub
+	}
+
+	if ev.CreatedAt.Before(sub.SyncedAt) {
+		return sub
+	}
+	sub.SyncedAt = ev.CreatedAt
+
+	switch ev.Type {
+	case EventExpired:
+		if sub.Status == Pending {
+			sub.Status = Canceled
+		}
+
+	case EventUpdated, EventDeleted:
+		// Subscriptions are created before their first payment.
+		if ev.Status != Pending {
+			sub.Status = ev.Status
+		}
+		sub.CancelAtPeriodEnd = ev.CancelAtPeriodEnd
+		if !ev.PeriodEnd.IsZero() {
+			end := ev.PeriodEnd
+			sub.CurrentPeriodEnd = &end
+		}
+
+	case EventPaymentFailed:
+		if sub.Status != Canceled {
+			sub.Status = PastDue
+		}
+	}
+
+	if sub.Status == Canceled && sub.AccessUntil != nil && sub.AccessUntil.After(now) {
+		sub.AccessUntil = &now
+	}
+
+	return sub
+}
@@ -0,0 +1,116 @@
+package upload
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/storage"
+)
+
+// ErrChecksum is returned when the file received does not match its checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// commit verifies the checksum of the whole file received and stores it,
+// joining its parts, under the key of the upload. Parts are then discarded.
+// Parts are composed by the storage, so that files larger than the limit
+// of single writes, like the 5 GiB of S3, can be stored without being read back.
+// Uploads not matching their checksum are marked as failed.
+func commit(ctx context.Context, db *sqlx.DB, store storage.Store, up Upload, h hash.Hash) (Upload, error) {
+	now := time.Now().UTC()
+
+	if hex.EncodeToString(h.Sum(nil)) != up.Checksum {
+		up.Status = Failed
+		up.UpdatedAt = now
+
+		if err := UpdateStatus(ctx, db, up); err != nil {
+			return Upload{}, err
+		}
+
+		if err := discard(ctx, db, store, up.ID); err != nil {
+			return Upload{}, err
+		}
+
+		return up, fmt.Errorf("upload[%s]: %w", up.ID, ErrChecksum)
+	}
+
+	parts, err := FetchParts(ctx, db, up.ID)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	keys := make([]string, 0, len(parts))
+	for _, p := range parts {
+		keys = append(keys, p.Key)
+	}
+
+	if _, err := store.Compose(ctx, up.Key, keys, up.ContentType); err != nil {
+		return Upload{}, fmt.Errorf("storing upload[%s]: %w", up.ID, err)
+	}
+
+	up.Status = Completed
+	up.UpdatedAt = now
+
+	if err := UpdateStatus(ctx, db, up); err != nil {
+		return Upload{}, err
+	}
+
+	if err := discard(ctx, db, store, up.ID); err != nil {
+		return Upload{}, err
+	}
+
+	return up, nil
+}
+
+// discard deletes the parts of an upload from both the storage and the db.
+func discard(ctx context.Context, db *sqlx.DB, store storage.Store, uploadID string) error {
+	parts, err := FetchParts(ctx, db, uploadID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+		if err := store.Delete(ctx, p.Key); err != nil {
+			return fmt.Errorf("deleting part at offset %d of upload[%s]: %w", p.Offset, uploadID, err)
+		}
+	}
+
+	return DeleteParts(ctx, db, uploadID)
+}
+
+// Purge deletes the uploads that have not been completed within ttl,
+// together with their parts. It's meant to be periodically run in background.
+func Purge(ctx context.Context, db *sqlx.DB, store storage.Store, ttl time.Duration) error {
+	ups, err := FetchExpired(ctx, db, time.Now().UTC().Add(-ttl))
+	if err != nil {
+		return err
+	}
+
+	for _, up := range ups {
+		if err := discard(ctx, db, store, up.ID); err != nil {
+			return err
+		}
+
+		if err := Delete(ctx, db, up.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// accept stores the part received and advances the upload,
+// failing with ErrDBNotFound if another chunk has been accepted first.
+func accept(ctx context.Context, db *sqlx.DB, up Upload, p Part) error {
+	return database.Transaction(db, func(tx sqlx.ExtContext) error {
+		if err := CreatePart(ctx, tx, p); err != nil {
+			return err
+		}
+		return Advance(ctx, tx, up, p.Offset)
+	})
+}
@@ -0,0 +1,250 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/config"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/storage"
+	"github.com/polldo/govod/validate"
+)
+
+// sniffLen is the number of bytes used to detect the content type of files.
+const sniffLen = 512
+
+// HandleCreate allows administrators to start the upload of a file,
+// whose chunks are then sent to HandleWrite.
+func HandleCreate(db *sqlx.DB, cfg config.Uploads) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		var un UploadNew
+		if err := web.Decode(w, r, &un); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(un); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		ct, ext, err := format(un.Kind, un.Filename)
+		if err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnsupportedMediaType)
+		}
+
+		limit := cfg.MaxImageSize
+		if un.Kind == Video {
+			limit = cfg.MaxVideoSize
+		}
+
+		if un.Size > limit {
+			err := fmt.Errorf("%s of %d bytes exceeds the limit of %d bytes", un.Kind, un.Size, limit)
+			return weberr.NewError(err, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+
+		state, err := newHashState()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		id := validate.GenerateID()
+
+		up := Upload{
+			ID:          id,
+			UserID:      clm.UserID,
+			Kind:        un.Kind,
+			Filename:    un.Filename,
+			Key:         objectKey(un.Kind, id, ext),
+			ContentType: ct,
+			Size:        un.Size,
+			Checksum:    un.Checksum,
+			HashState:   state,
+			Status:      Pending,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if err := Create(ctx, db, up); err != nil {
+			return fmt.Errorf("creating upload: %w", err)
+		}
+
+		w.Header().Set(OffsetHeader, "0")
+		return web.Respond(ctx, w, up, http.StatusCreated)
+	}
+}
+
+// HandleShow returns an upload. Clients resume interrupted uploads
+// by sending the chunks following its offset.
+func HandleShow(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		up, err := fetch(ctx, db, web.Param(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set(OffsetHeader, strconv.FormatInt(up.Offset, 10))
+		return web.Respond(ctx, w, up, http.StatusOK)
+	}
+}
+
+// HandleWrite receives a chunk of an upload, starting at the offset
+// passed in the Upload-Offset header, which must match the offset of the
+// upload. The content type of files is detected from their first chunk.
+// The upload is completed with its last chunk: once its checksum is
+// verified, the file is stored under the key of the upload.
+// Completions failed after receiving the whole file are retried by
+// sending an empty chunk at the end of the upload.
+func HandleWrite(db *sqlx.DB, store storage.Store, cfg config.Uploads) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		up, err := fetch(ctx, db, web.Param(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if up.Status != Pending {
+			err := fmt.Errorf("upload[%s] is %s", up.ID, up.Status)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get(OffsetHeader), 10, 64)
+		if err != nil {
+			return weberr.BadRequest(fmt.Errorf("invalid upload offset: %w", err))
+		}
+
+		if offset != up.Offset {
+			w.Header().Set(OffsetHeader, strconv.FormatInt(up.Offset, 10))
+			err := fmt.Errorf("upload[%s] is at offset %d, got %d", up.ID, up.Offset, offset)
+			return weberr.NewError(err, err.Error(), http.StatusConflict)
+		}
+
+		size := r.ContentLength
+		switch {
+		case size < 0:
+			return weberr.NewError(errors.New("length of chunk required"), "length of chunk required", http.StatusLengthRequired)
+		case size == 0 && offset != up.Size:
+			return weberr.BadRequest(errors.New("empty chunk"))
+		case size > cfg.MaxChunkSize || offset+size > up.Size:
+			err := fmt.Errorf("chunk of %d bytes at offset %d exceeds the limits of upload[%s]", size, offset, up.ID)
+			return weberr.NewError(err, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+
+		h, err := restoreHash(up.HashState)
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			body := bufio.NewReaderSize(http.MaxBytesReader(w, r.Body, size), sniffLen)
+
+			if offset == 0 {
+				head, err := body.Peek(sniffLen)
+				if err != nil && !errors.Is(err, io.EOF) {
+					return weberr.BadRequest(fmt.Errorf("reading chunk: %w", err))
+				}
+
+				if ct := http.DetectContentType(head); ct != up.ContentType {
+					err := fmt.Errorf("file of type[%s] uploaded as %s", ct, up.ContentType)
+					return weberr.NewError(err, err.Error(), http.StatusUnsupportedMediaType)
+				}
+			}
+
+			part := Part{
+				UploadID: up.ID,
+				Offset:   offset,
+				Key:      partKey(up.ID, offset, validate.GenerateID()),
+				Size:     size,
+			}
+
+			if _, err := store.Put(ctx, part.Key, io.TeeReader(body, h), size, ""); err != nil {
+				return fmt.Errorf("storing part at offset %d of upload[%s]: %w", offset, up.ID, err)
+			}
+
+			if up.HashState, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+				return fmt.Errorf("saving checksum of upload[%s]: %w", up.ID, err)
+			}
+			up.Offset += size
+			up.UpdatedAt = time.Now().UTC()
+
+			if err := accept(ctx, db, up, part); err != nil {
+				if derr := store.Delete(ctx, part.Key); derr != nil {
+					err = errors.Join(err, derr)
+				}
+				if errors.Is(err, database.ErrDBNotFound) || errors.Is(err, database.ErrDBDuplicatedEntry) {
+					return weberr.NewError(err, "chunk already received", http.StatusConflict)
+				}
+				return err
+			}
+		}
+
+		if up.Offset == up.Size {
+			if up, err = commit(ctx, db, store, up, h); err != nil {
+				if errors.Is(err, ErrChecksum) {
+					return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+				}
+				return err
+			}
+		}
+
+		w.Header().Set(OffsetHeader, strconv.FormatInt(up.Offset, 10))
+		return web.Respond(ctx, w, up, http.StatusOK)
+	}
+}
+
+// HandleCancel allows administrators to abort uploads not completed.
+func HandleCancel(db *sqlx.DB, store storage.Store) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		up, err := fetch(ctx, db, web.Param(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if up.Status == Completed {
+			err := fmt.Errorf("upload[%s] already completed", up.ID)
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		if err := discard(ctx, db, store, up.ID); err != nil {
+			return err
+		}
+
+		if err := Delete(ctx, db, up.ID); err != nil {
+			return err
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+}
+
+// fetch returns the upload with the passed id, failing with web errors.
+func fetch(ctx context.Context, db *sqlx.DB, id string) (Upload, error) {
+	if err := validate.CheckID(id); err != nil {
+		return Upload{}, weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+	}
+
+	up, err := Fetch(ctx, db, id)
+	if err != nil {
+		err := fmt.Errorf("fetching upload[%s]: %w", id, err)
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Upload{}, weberr.NotFound(err)
+		}
+		return Upload{}, err
+	}
+
+	return up, nil
+}
@@ -0,0 +1,221 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/database"
+)
+
+// Create inserts a new upload.
+func Create(ctx context.Context, db sqlx.ExtContext, up Upload) error {
+	const q = `
+	INSERT INTO uploads
+		(upload_id, user_id, kind, filename, object_key, content_type, size,
+		upload_offset, checksum, hash_state, status, created_at, updated_at)
+	VALUES
+		(:upload_id, :user_id, :kind, :filename, :object_key, :content_type, :size,
+		:upload_offset, :checksum, :hash_state, :status, :created_at, :updated_at)`
+
+	if err := database.NamedExecContext(ctx, db, q, up); err != nil {
+		return fmt.Errorf("inserting upload: %w", err)
+	}
+
+	return nil
+}
+
+// Fetch returns a specific upload.
+func Fetch(ctx context.Context, db sqlx.ExtContext, id string) (Upload, error) {
+	in := struct {
+		ID string `db:"upload_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		uploads
+	WHERE
+		upload_id = :upload_id`
+
+	var up Upload
+	if err := database.NamedQueryStruct(ctx, db, q, in, &up); err != nil {
+		return Upload{}, fmt.Errorf("selecting upload[%s]: %w", id, err)
+	}
+
+	return up, nil
+}
+
+// FetchExpired returns the uploads not completed that have not been
+// updated since the passed time.
+func FetchExpired(ctx context.Context, db sqlx.ExtContext, before time.Time) ([]Upload, error) {
+	in := struct {
+		Before    time.Time `db:"before"`
+		Completed Status    `db:"completed"`
+	}{
+		Before:    before,
+		Completed: Completed,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		uploads
+	WHERE
+		status != :completed AND
+		updated_at < :before`
+
+	var ups []Upload
+	if err := database.NamedQuerySlice(ctx, db, q, in, &ups); err != nil {
+		return nil, fmt.Errorf("selecting expired uploads: %w", err)
+	}
+
+	return ups, nil
+}
+
+// Advance moves the offset of a pending upload, storing the state of its
+// checksum. The upload must still be at the passed offset: when another
+// chunk has been accepted in the meantime, ErrDBNotFound is returned.
+func Advance(ctx context.Context, db sqlx.ExtContext, up Upload, from int64) error {
+	in := struct {
+		ID        string    `db:"upload_id"`
+		From      int64     `db:"from"`
+		Offset    int64     `db:"upload_offset"`
+		HashState []byte    `db:"hash_state"`
+		Pending   Status    `db:"pending"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}{
+		ID:        up.ID,
+		From:      from,
+		Offset:    up.Offset,
+		HashState: up.HashState,
+		Pending:   Pending,
+		UpdatedAt: up.UpdatedAt,
+	}
+
+	const q = `
+	UPDATE uploads
+	SET
+		upload_offset = :upload_offset,
+		hash_state = :hash_state,
+		updated_at = :updated_at
+	WHERE
+		upload_id = :upload_id AND
+		upload_offset = :from AND
+		status = :pending
+	RETURNING upload_id`
+
+	var out struct {
+		ID string `db:"upload_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, db, q, in, &out); err != nil {
+		return fmt.Errorf("advancing upload[%s] from offset %d: %w", up.ID, from, err)
+	}
+
+	return nil
+}
+
+// UpdateStatus sets the status of an upload.
+func UpdateStatus(ctx context.Context, db sqlx.ExtContext, up Upload) error {
+	const q = `
+	UPDATE uploads
+	SET
+		status = :status,
+		updated_at = :updated_at
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, db, q, up); err != nil {
+		return fmt.Errorf("updating status of upload[%s]: %w", up.ID, err)
+	}
+
+	return nil
+}
+
+// Delete removes an upload together with its parts.
+func Delete(ctx context.Context, db sqlx.ExtContext, id string) error {
+	in := struct {
+		ID string `db:"upload_id"`
+	}{
+		ID: id,
+	}
+
+	const q = `
+	DELETE FROM
+		uploads
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting upload[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// CreatePart inserts a part of an upload.
+func CreatePart(ctx context.Context, db sqlx.ExtContext, p Part) error {
+	const q = `
+	INSERT INTO upload_parts
+		(upload_id, part_offset, object_key, size)
+	VALUES
+		(:upload_id, :part_offset, :object_key, :size)`
+
+	if err := database.NamedExecContext(ctx, db, q, p); err != nil {
+		return fmt.Errorf("inserting part at offset %d of upload[%s]: %w", p.Offset, p.UploadID, err)
+	}
+
+	return nil
+}
+
+// FetchParts returns the parts of an upload, sorted by offset.
+func FetchParts(ctx context.Context, db sqlx.ExtContext, uploadID string) ([]Part, error) {
+	in := struct {
+		ID string `db:"upload_id"`
+	}{
+		ID: uploadID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		upload_parts
+	WHERE
+		upload_id = :upload_id
+	ORDER BY
+		part_offset`
+
+	var parts []Part
+	if err := database.NamedQuerySlice(ctx, db, q, in, &parts); err != nil {
+		return nil, fmt.Errorf("selecting parts of upload[%s]: %w", uploadID, err)
+	}
+
+	return parts, nil
+}
+
+// DeleteParts removes all the parts of an upload.
+func DeleteParts(ctx context.Context, db sqlx.ExtContext, uploadID string) error {
+	in := struct {
+		ID string `db:"upload_id"`
+	}{
+		ID: uploadID,
+	}
+
+	const q = `
+	DELETE FROM
+		upload_parts
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, db, q, in); err != nil {
+		return fmt.Errorf("deleting parts of upload[%s]: %w", uploadID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package upload receives media files from administrators in chunks,
+// so that large files can be uploaded across several requests and
+// resumed after disconnects. Chunks are stored as parts, which are
+// joined in the final object once the whole file has been received.
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"hash"
+	"path"
+	"strings"
+	"time"
+)
+
+// Kind models the kind of media being uploaded.
+type Kind string
+
+const (
+	// Video uploads are attached to videos.
+	Video Kind = "video"
+	// Image uploads are attached to courses.
+	Image Kind = "image"
+)
+
+// Status models the status of an upload.
+type Status string
+
+const (
+	// Pending uploads are receiving chunks.
+	Pending Status = "pending"
+	// Completed uploads have been stored under their key.
+	Completed Status = "completed"
+	// Failed uploads did not match their checksum: they must be restarted.
+	Failed Status = "failed"
+)
+
+// OffsetHeader contains the offset of uploads: clients pass the
+// offset of the chunks they send and are returned the new offset.
+const OffsetHeader = "Upload-Offset"
+
+// formats contains the content types accepted for each kind of upload,
+// by the extension of the file.
+var formats = map[Kind]map[string]string{
+	Video: {
+		".mp4":  "video/mp4",
+		".webm": "video/webm",
+	},
+	Image: {
+		".png":  "image/png",
+		".jpg":  "image/jpeg",
+		".jpeg": "image/jpeg",
+		".gif":  "image/gif",
+		".webp": "image/webp",
+	},
+}
+
+// prefixes contains the prefix of the keys of each kind of upload.
+var prefixes = map[Kind]string{
+	Video: "videos/",
+	Image: "images/",
+}
+
+// Upload models the uploads of media files.
+// Offset is the number of bytes received so far, out of Size.
+// Checksum is the hex encoded SHA-256 of the whole file, verified
+// once it has been received. Key is the storage key the file is
+// stored under once completed: it can then be attached to videos
+// or courses through their update.
+type Upload struct {
+	ID          string    `json:"id" db:"upload_id"`
+	UserID      string    `json:"userId" db:"user_id"`
+	Kind        Kind      `json:"kind" db:"kind"`
+	Filename    string    `json:"filename" db:"filename"`
+	Key         string    `json:"key" db:"object_key"`
+	ContentType string    `json:"contentType" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	Offset      int64     `json:"offset" db:"upload_offset"`
+	Checksum    string    `json:"checksum" db:"checksum"`
+	HashState   []byte    `json:"-" db:"hash_state"`
+	Status      Status    `json:"status" db:"status"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// UploadNew contains the information needed to start an upload.
+type UploadNew struct {
+	Kind     Kind   `json:"kind" validate:"required,oneof=video image"`
+	Filename string `json:"filename" validate:"required,max=255"`
+	Size     int64  `json:"size" validate:"required,gt=0"`
+	Checksum string `json:"checksum" validate:"required,len=64,hexadecimal"`
+}
+
+// Part models the chunks received, stored under their own key.
+type Part struct {
+	UploadID string `db:"upload_id"`
+	Offset   int64  `db:"part_offset"`
+	Key      string `db:"object_key"`
+	Size     int64  `db:"size"`
+}
+
+// format returns the content type and the extension of the file
+// uploaded, if they are accepted for the passed kind.
+func format(kind Kind, filename string) (string, string, error) {
+	ext := strings.ToLower(path.Ext(filename))
+
+	ct, ok := formats[kind][ext]
+	if !ok {
+		return "", "", fmt.Errorf("files of type[%s] cannot be uploaded as %s", ext, kind)
+	}
+
+	return ct, ext, nil
+}
+
+// objectKey returns the key the upload is stored under once completed.
+func objectKey(kind Kind, id string, ext string) string {
+	return prefixes[kind] + id + ext
+}
+
+// partKey returns the key of a part. Parts received concurrently at the
+// same offset get different keys, so that only the accepted one is kept.
+func partKey(uploadID string, offset int64, nonce string) string {
+	return fmt.Sprintf("uploads/%s/%020d-%s", uploadID, offset, nonce)
+}
+
+// newHashState returns the state of the checksum of an empty upload.
+func newHashState() ([]byte, error) {
+	return sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// restoreHash returns the checksum of the bytes received, resumed from its state.
+func restoreHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("restoring checksum: %w", err)
+	}
+	return h, nil
+}
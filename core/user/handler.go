@@ -99,3 +99,37 @@ func HandleShowCurrent(db *sqlx.DB) web.Handler {
 		return web.Respond(ctx, w, user, http.StatusOK)
 	}
 }
+
+// HandleUpdatePrefs allows the current user to update their preferences,
+// like the currency prices are shown and charged in.
+func HandleUpdatePrefs(db *sqlx.DB) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var prefs UserPrefs
+		if err := web.Decode(w, r, &prefs); err != nil {
+			return weberr.BadRequest(fmt.Errorf("unable to decode payload: %w", err))
+		}
+
+		if err := validate.Check(prefs); err != nil {
+			return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+		}
+
+		clm, err := claims.Get(ctx)
+		if err != nil {
+			return weberr.NotAuthorized(errors.New("user not authenticated"))
+		}
+
+		user, err := Fetch(ctx, db, clm.UserID)
+		if err != nil {
+			return fmt.Errorf("fetching user[%s]: %w", clm.UserID, err)
+		}
+
+		user.Currency = prefs.Currency
+		user.UpdatedAt = time.Now().UTC()
+
+		if user, err = Update(ctx, db, user); err != nil {
+			return fmt.Errorf("updating user[%s] preferences: %w", clm.UserID, err)
+		}
+
+		return web.Respond(ctx, w, user, http.StatusOK)
+	}
+}
@@ -43,6 +43,7 @@ func Update(ctx context.Context, db sqlx.ExtContext, user User) (User, error) {
 		role = :role,
 		active = :active,
 		password_hash = :password_hash,
+		currency = :currency,
 		updated_at = :updated_at,
 		version = version + 1
 	WHERE
@@ -12,6 +12,7 @@ type User struct {
 	Role         string    `json:"role" db:"role"`
 	Active       bool      `json:"active" db:"active"`
 	PasswordHash []byte    `json:"-" db:"password_hash"`
+	Currency     string    `json:"currency" db:"currency"`
 	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
 	Version      int       `json:"-" db:"version"`
@@ -43,3 +44,9 @@ type UserUp struct {
 	Password        *string `json:"password"`
 	PasswordConfirm *string `json:"passwordConfirm" validate:"omitempty,eqfield=Password"`
 }
+
+// UserPrefs contains the preferences a user can set.
+// An empty currency resets it to the default one.
+type UserPrefs struct {
+	Currency string `json:"currency" validate:"omitempty,iso4217"`
+}
@@ -0,0 +1,188 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/playlist"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
+)
+
+// maxPlaylistSize is the maximum size of the playlists rewritten by the gateway.
+const maxPlaylistSize = 4 << 20
+
+// dashIdentifier matches the identifiers of DASH segment templates,
+// such as $Number%05d$, which are not valid in URIs.
+var dashIdentifier = regexp.MustCompile(`\$[^$]*\$`)
+
+// isPlaylist reports whether the key references an HLS playlist
+// or a DASH manifest, served by the gateway.
+func isPlaylist(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8", ".mpd":
+		return true
+	}
+	return false
+}
+
+// mediaPath returns the escaped path the gateway serves the file of the
+// video at. The name is relative to the directory of the video playlist.
+func mediaPath(videoID string, name string) string {
+	u := url.URL{Path: "/videos/" + videoID + "/media/" + name}
+	return u.EscapedPath()
+}
+
+// HandleMedia is the gateway of the videos packaged for adaptive streaming,
+// whose key references an HLS playlist or a DASH manifest. It serves the
+// files in the directory of the playlist, checking at every request that
+// the user owns the course of the video, as HandleStream does.
+// Playlists are rewritten so that the URIs they reference, such as
+// variants, segments and keys, are located by the gateway with links
+// signed for the user. Segments of DASH templates are built by players,
+// so they are signed by the prefix of the gateway path of the video.
+func HandleMedia(db *sqlx.DB, store storage.Store, sg *signer.Signer) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		video, userID, err := access(ctx, db, web.Param(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if !isPlaylist(video.Key) {
+			return weberr.NotFound(fmt.Errorf("video[%s] not packaged for adaptive streaming", video.ID))
+		}
+
+		name := web.Param(r, "path")
+		key := path.Join(path.Dir(video.Key), name)
+		if err := storage.CheckKey(key); err != nil || !within(video.Key, key) {
+			return weberr.NotFound(fmt.Errorf("file[%s] out of video[%s]", name, video.ID))
+		}
+
+		obj, err := stat(ctx, store, key)
+		if err != nil {
+			return err
+		}
+
+		var rewrite func([]byte, playlist.RewriteFunc) ([]byte, error)
+		var fn playlist.RewriteFunc
+		var contentType string
+
+		switch strings.ToLower(path.Ext(key)) {
+		case ".m3u8":
+			rewrite, fn, contentType = playlist.RewriteHLS, hlsLinker(sg, video, key, userID), "application/vnd.apple.mpegurl"
+		case ".mpd":
+			rewrite, fn, contentType = playlist.RewriteDASH, dashLinker(sg, video, userID), "application/dash+xml"
+		default:
+			serve(ctx, w, r, store, obj)
+			return nil
+		}
+
+		if obj.Size > maxPlaylistSize {
+			return fmt.Errorf("playlist[%s] of %d bytes is too large", key, obj.Size)
+		}
+
+		rc, _, err := store.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reading playlist[%s]: %w", key, err)
+		}
+		defer rc.Close()
+
+		src, err := io.ReadAll(io.LimitReader(rc, maxPlaylistSize))
+		if err != nil {
+			return fmt.Errorf("reading playlist[%s]: %w", key, err)
+		}
+
+		out, err := rewrite(src, fn)
+		if err != nil {
+			return fmt.Errorf("rewriting playlist[%s]: %w", key, err)
+		}
+
+		// Playlists reference links that expire, so they must not be cached.
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := w.Write(out); err != nil {
+			return fmt.Errorf("writing playlist[%s]: %w", key, err)
+		}
+
+		return nil
+	}
+}
+
+// hlsLinker rewrites the URIs of the HLS playlist of the key into links
+// of the gateway, each signed for the user.
+// URIs of other hosts, such as key servers, are left untouched.
+func hlsLinker(sg *signer.Signer, video Video, key string, userID string) playlist.RewriteFunc {
+	return func(uri string) (string, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", fmt.Errorf("parsing uri[%s]: %w", uri, err)
+		}
+
+		if u.Scheme != "" || u.Host != "" {
+			return uri, nil
+		}
+
+		ref := path.Join(path.Dir(key), u.Path)
+		if path.IsAbs(u.Path) || !within(video.Key, ref) {
+			return "", fmt.Errorf("uri[%s] out of video[%s]", uri, video.ID)
+		}
+
+		return sg.Sign(mediaPath(video.ID, relative(video.Key, ref)), userID), nil
+	}
+}
+
+// dashLinker signs the relative URIs of the DASH manifest of the video
+// by the prefix of its gateway path, since the URIs of segment templates
+// are resolved by players. URIs of other hosts are left untouched.
+func dashLinker(sg *signer.Signer, video Video, userID string) playlist.RewriteFunc {
+	query := sg.SignPrefix(mediaPath(video.ID, ""), userID)
+
+	return func(uri string) (string, error) {
+		u, err := url.Parse(dashIdentifier.ReplaceAllString(uri, "0"))
+		if err != nil {
+			return "", fmt.Errorf("parsing uri[%s]: %w", uri, err)
+		}
+
+		if u.Scheme != "" || u.Host != "" {
+			return uri, nil
+		}
+
+		if path.IsAbs(u.Path) {
+			return "", fmt.Errorf("uri[%s] out of video[%s]", uri, video.ID)
+		}
+
+		if strings.Contains(uri, "?") {
+			return uri + "&" + query, nil
+		}
+		return uri + "?" + query, nil
+	}
+}
+
+// within reports whether the key is in the directory of the root playlist.
+func within(root string, key string) bool {
+	dir := path.Dir(root)
+	if dir == "." {
+		return key != ".." && !strings.HasPrefix(key, "../")
+	}
+	return strings.HasPrefix(key, dir+"/")
+}
+
+// relative returns the key relative to the directory of the root playlist.
+func relative(root string, key string) string {
+	dir := path.Dir(root)
+	if dir == "." {
+		return key
+	}
+	return strings.TrimPrefix(key, dir+"/")
+}
@@ -13,11 +13,14 @@ import (
 	"github.com/polldo/govod/core/claims"
 	"github.com/polldo/govod/core/course"
 	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
 	"github.com/polldo/govod/validate"
 )
 
 // HandleCreate allows administrators to insert a new video in a course.
-func HandleCreate(db *sqlx.DB) web.Handler {
+// Videos referenced by key must have been uploaded to the media storage.
+func HandleCreate(db *sqlx.DB, store storage.Store) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		var v VideoNew
 		if err := web.Decode(w, r, &v); err != nil {
@@ -38,11 +41,16 @@ func HandleCreate(db *sqlx.DB) web.Handler {
 			Description: v.Description,
 			Free:        v.Free,
 			URL:         v.URL,
+			Key:         v.Key,
 			ImageURL:    v.ImageURL,
 			CreatedAt:   now,
 			UpdatedAt:   now,
 		}
 
+		if err := checkObject(ctx, store, video); err != nil {
+			return err
+		}
+
 		if err := Create(ctx, db, video); err != nil {
 			err := fmt.Errorf("creating video: %w", err)
 			if errors.Is(err, database.ErrDBDuplicatedEntry) {
@@ -56,7 +64,8 @@ func HandleCreate(db *sqlx.DB) web.Handler {
 }
 
 // HandleUpdate allows administrators to update videos' information.
-func HandleUpdate(db *sqlx.DB) web.Handler {
+// Passing an empty key unlinks the video uploaded, falling back to its URL.
+func HandleUpdate(db *sqlx.DB, store storage.Store) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		videoID := web.Param(r, "id")
 
@@ -100,11 +109,18 @@ func HandleUpdate(db *sqlx.DB) web.Handler {
 		if vup.URL != nil {
 			video.URL = *vup.URL
 		}
+		if vup.Key != nil {
+			video.Key = *vup.Key
+		}
 		if vup.ImageURL != nil {
 			video.ImageURL = *vup.ImageURL
 		}
 		video.UpdatedAt = time.Now().UTC()
 
+		if err := checkObject(ctx, store, video); err != nil {
+			return err
+		}
+
 		if video, err = Update(ctx, db, video); err != nil {
 			return fmt.Errorf("updating video[%s]: %w", videoID, err)
 		}
@@ -169,8 +185,11 @@ func HandleShow(db *sqlx.DB) web.Handler {
 }
 
 // HandleShowFull returns all data useful for presenting the video to users.
-// This returns the URL also, so only owners of a video are allowed to call this.
-func HandleShowFull(db *sqlx.DB) web.Handler {
+// This returns the URL also, so only owners of a video, or members of an
+// organization holding a seat of its course, are allowed to call this.
+// Videos uploaded to the media storage are returned with a signed link
+// of the endpoint streaming them, so that their location is never exposed.
+func HandleShowFull(db *sqlx.DB, sg *signer.Signer) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		videoID := web.Param(r, "id")
 
@@ -192,21 +211,9 @@ func HandleShowFull(db *sqlx.DB) web.Handler {
 			return err
 		}
 
-		var crs course.Course
-		if video.Free {
-			crs, err = course.Fetch(ctx, db, video.CourseID)
-			if err != nil {
-				return fmt.Errorf("fetching course of free video[%s]: %w", video.ID, err)
-			}
-		} else {
-			crs, err = course.FetchOwned(ctx, db, video.CourseID, clm.UserID)
-			if err != nil {
-				err := fmt.Errorf("fetching course[%s] owned by user[%s]: %w", video.CourseID, clm.UserID, err)
-				if errors.Is(err, database.ErrDBNotFound) {
-					return weberr.NewError(err, "access forbidden", http.StatusForbidden)
-				}
-				return err
-			}
+		crs, err := authorize(ctx, db, video, clm.UserID)
+		if err != nil {
+			return err
 		}
 
 		videos, err := FetchAllByCourse(ctx, db, video.CourseID)
@@ -234,7 +241,7 @@ func HandleShowFull(db *sqlx.DB) web.Handler {
 			Video:       video,
 			AllVideos:   videos,
 			AllProgress: progress,
-			URL:         video.URL,
+			URL:         locate(sg, video, clm.UserID),
 		}
 
 		return web.Respond(ctx, w, fullVideo, http.StatusOK)
@@ -244,7 +251,7 @@ func HandleShowFull(db *sqlx.DB) web.Handler {
 // HandleShowFree returns all information useful for presenting the video
 // to users. Only free videos can be retrieved with this function.
 // Thus, it can be safely exposed.
-func HandleShowFree(db *sqlx.DB) web.Handler {
+func HandleShowFree(db *sqlx.DB, sg *signer.Signer) web.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		videoID := web.Param(r, "id")
 
@@ -277,7 +284,7 @@ func HandleShowFree(db *sqlx.DB) web.Handler {
 		}{
 			Course: crs,
 			Video:  video,
-			URL:    video.URL,
+			URL:    locate(sg, video, ""),
 		}
 
 		return web.Respond(ctx, w, freeVideo, http.StatusOK)
@@ -0,0 +1,190 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/polldo/govod/api/web"
+	"github.com/polldo/govod/api/weberr"
+	"github.com/polldo/govod/core/claims"
+	"github.com/polldo/govod/core/course"
+	"github.com/polldo/govod/core/org"
+	"github.com/polldo/govod/database"
+	"github.com/polldo/govod/signer"
+	"github.com/polldo/govod/storage"
+	"github.com/polldo/govod/validate"
+)
+
+// checkObject checks that the object referenced by the key
+// of the video has been uploaded to the media storage.
+func checkObject(ctx context.Context, store storage.Store, video Video) error {
+	if video.Key == "" {
+		return nil
+	}
+
+	if err := storage.CheckKey(video.Key); err != nil {
+		return weberr.NewError(err, err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	if _, err := store.Stat(ctx, video.Key); err != nil {
+		err := fmt.Errorf("checking object[%s] of video[%s]: %w", video.Key, video.ID, err)
+		if errors.Is(err, storage.ErrNotFound) {
+			return weberr.NewError(err, "video not uploaded", http.StatusUnprocessableEntity)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// locate returns the URL the video can be watched at. Videos uploaded to
+// the media storage are located by the endpoint streaming them, or by the
+// gateway of their playlist, with a link signed for the user, which
+// expires shortly.
+func locate(sg *signer.Signer, video Video, userID string) string {
+	switch {
+	case video.Key == "":
+		return video.URL
+	case isPlaylist(video.Key):
+		return sg.Sign(mediaPath(video.ID, path.Base(video.Key)), userID)
+	default:
+		return sg.Sign("/videos/"+video.ID+"/stream", userID)
+	}
+}
+
+// authorize returns the course of the video if the user is allowed to
+// watch it. Free videos can be watched by anyone, while the others only
+// by the owners of the course, or by the members of an organization
+// holding a seat of it.
+func authorize(ctx context.Context, db sqlx.ExtContext, video Video, userID string) (course.Course, error) {
+	if video.Free {
+		crs, err := course.Fetch(ctx, db, video.CourseID)
+		if err != nil {
+			return course.Course{}, fmt.Errorf("fetching course of free video[%s]: %w", video.ID, err)
+		}
+		return crs, nil
+	}
+
+	crs, err := course.FetchOwned(ctx, db, video.CourseID, userID)
+	if errors.Is(err, database.ErrDBNotFound) {
+		// Members of organizations are granted access by their seats.
+		if _, err = org.FetchSeat(ctx, db, video.CourseID, userID); err == nil {
+			crs, err = course.Fetch(ctx, db, video.CourseID)
+		}
+	}
+	if err != nil {
+		err := fmt.Errorf("fetching course[%s] owned by user[%s]: %w", video.CourseID, userID, err)
+		if errors.Is(err, database.ErrDBNotFound) {
+			return course.Course{}, weberr.NewError(err, "access forbidden", http.StatusForbidden)
+		}
+		return course.Course{}, err
+	}
+
+	return crs, nil
+}
+
+// HandleStream streams the videos uploaded to the media storage, with
+// the same access rules of HandleShowFull and HandleShowFree: users must
+// be authenticated, by their session or by a signed link, to watch videos
+// that are not free.
+// Range and conditional requests are supported, so that players can
+// seek videos and revalidate the parts they cached.
+func HandleStream(db *sqlx.DB, store storage.Store) web.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		video, _, err := access(ctx, db, web.Param(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if video.Key == "" {
+			return weberr.NotFound(fmt.Errorf("video[%s] not uploaded", video.ID))
+		}
+
+		// Playlists are served by the gateway, which rewrites them.
+		if isPlaylist(video.Key) {
+			return weberr.NotFound(fmt.Errorf("video[%s] packaged for adaptive streaming", video.ID))
+		}
+
+		obj, err := stat(ctx, store, video.Key)
+		if err != nil {
+			return err
+		}
+
+		serve(ctx, w, r, store, obj)
+		return nil
+	}
+}
+
+// access fetches the video, checking that the user of the request is
+// allowed to watch it. The ID of the user is returned, if any.
+func access(ctx context.Context, db sqlx.ExtContext, videoID string) (Video, string, error) {
+	if err := validate.CheckID(videoID); err != nil {
+		return Video{}, "", weberr.BadRequest(fmt.Errorf("passed id is not valid: %w", err))
+	}
+
+	video, err := Fetch(ctx, db, videoID)
+	if err != nil {
+		err := fmt.Errorf("fetching video[%s]: %w", videoID, err)
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Video{}, "", weberr.NotFound(err)
+		}
+		return Video{}, "", err
+	}
+
+	var userID string
+	if clm, err := claims.Get(ctx); err == nil {
+		userID = clm.UserID
+	} else if !video.Free {
+		return Video{}, "", weberr.NotAuthorized(errors.New("user not authenticated"))
+	}
+
+	if _, err := authorize(ctx, db, video, userID); err != nil {
+		return Video{}, "", err
+	}
+
+	return video, userID, nil
+}
+
+// stat returns the info of the object of the key.
+func stat(ctx context.Context, store storage.Store, key string) (storage.Object, error) {
+	obj, err := store.Stat(ctx, key)
+	if err != nil {
+		err := fmt.Errorf("reading info of object[%s]: %w", key, err)
+		if errors.Is(err, storage.ErrNotFound) {
+			return storage.Object{}, weberr.NotFound(err)
+		}
+		return storage.Object{}, err
+	}
+	return obj, nil
+}
+
+// serve streams the object, supporting range and conditional requests.
+func serve(ctx context.Context, w http.ResponseWriter, r *http.Request, store storage.Store, obj storage.Object) {
+	rs := storage.NewReader(ctx, store, obj)
+	defer rs.Close()
+
+	// Responses of users allowed to watch videos must not be cached by proxies.
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("Cache-Control", "private")
+
+	http.ServeContent(streamWriter{w}, r, "", obj.ModTime, rs)
+}
+
+// streamWriter flushes the headers of responses as soon as they are written,
+// so that their bodies are streamed instead of buffered by middlewares.
+type streamWriter struct {
+	http.ResponseWriter
+}
+
+func (sw streamWriter) WriteHeader(code int) {
+	sw.ResponseWriter.WriteHeader(code)
+
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
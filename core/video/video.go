@@ -6,6 +6,8 @@ import "time"
 // A course can contain many videos.
 // A video can be contained by a course only.
 // URL is not marhsalled to JSON to avoid security issues.
+// Videos uploaded to the media storage are referenced by Key,
+// which takes precedence over URL.
 type Video struct {
 	ID          string    `json:"id" db:"video_id"`
 	CourseID    string    `json:"courseId" db:"course_id"`
@@ -14,6 +16,7 @@ type Video struct {
 	Description string    `json:"description" db:"description"`
 	Free        bool      `json:"free" db:"free"`
 	URL         string    `json:"-" db:"url"`
+	Key         string    `json:"-" db:"object_key"`
 	ImageURL    string    `json:"imageUrl" db:"image_url"`
 	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
@@ -28,6 +31,7 @@ type VideoNew struct {
 	Description string `json:"description" validate:"required"`
 	Free        bool   `json:"free" validate:"required"`
 	URL         string `json:"url" validate:"omitempty,url"`
+	Key         string `json:"key"`
 	ImageURL    string `json:"imageUrl" validate:"required"`
 }
 
@@ -39,6 +43,7 @@ type VideoUp struct {
 	Description *string `json:"description"`
 	Free        *bool   `json:"free"`
 	URL         *string `json:"url" validate:"omitempty,url"`
+	Key         *string `json:"key"`
 	ImageURL    *string `json:"imageUrl"`
 }
 
@@ -162,6 +162,29 @@ func NamedQuerySlice[T any](ctx context.Context, db sqlx.ExtContext, query strin
 	return nil
 }
 
+// NamedQueryEach is a helper function for executing queries that return a
+// collection of data, passing each row to fn as soon as it's unmarshalled,
+// so that large results can be processed without holding them in memory.
+func NamedQueryEach[T any](ctx context.Context, db sqlx.ExtContext, query string, data any, fn func(T) error) error {
+	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v T
+		if err := rows.StructScan(&v); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // NamedQueryStruct is a helper function for executing queries that return a
 // single value to be unmarshalled into a struct type.
 func NamedQueryStruct(ctx context.Context, db sqlx.ExtContext, query string, data any, dest any) error {
@@ -0,0 +1,15 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS order_refunds
+(
+	refund_id           UUID                        NOT NULL,
+	order_id            UUID                        NOT NULL,
+	course_id           UUID                        NOT NULL,
+	provider_refund_id  TEXT                        NOT NULL,
+	amount              INT                         NOT NULL,
+	created_at          TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (refund_id),
+	FOREIGN KEY (order_id, course_id) REFERENCES order_items(order_id, course_id) ON DELETE CASCADE,
+	UNIQUE(order_id, course_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS fulfillments;
+ALTER TABLE orders DROP COLUMN IF EXISTS payment_id;
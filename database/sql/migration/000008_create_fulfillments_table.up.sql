@@ -0,0 +1,16 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_id TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS fulfillments
+(
+	fulfillment_id    UUID                        NOT NULL,
+	order_id          UUID UNIQUE                 NOT NULL,
+	status            TEXT                        NOT NULL,
+	attempts          INT                         NOT NULL DEFAULT 0,
+	last_error        TEXT                        NOT NULL DEFAULT '',
+	next_attempt_at   TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	created_at        TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at        TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (fulfillment_id),
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
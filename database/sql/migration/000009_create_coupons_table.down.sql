@@ -0,0 +1,4 @@
+ALTER TABLE carts DROP COLUMN IF EXISTS coupon_code;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_courses;
+DROP TABLE IF EXISTS coupons;
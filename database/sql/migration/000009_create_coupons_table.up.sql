@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS coupons
+(
+	coupon_id         UUID                        NOT NULL,
+	code              TEXT UNIQUE                 NOT NULL,
+	kind              TEXT                        NOT NULL,
+	value             INT                         NOT NULL,
+	min_total         INT                         NOT NULL DEFAULT 0,
+	max_redemptions   INT                         NOT NULL DEFAULT 0,
+	max_per_user      INT                         NOT NULL DEFAULT 0,
+	starts_at         TIMESTAMP                   NULL,
+	ends_at           TIMESTAMP                   NULL,
+	created_at        TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at        TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (coupon_id)
+);
+
+CREATE TABLE IF NOT EXISTS coupon_courses
+(
+	coupon_id         UUID                        NOT NULL,
+	course_id         UUID                        NOT NULL,
+
+	PRIMARY KEY (coupon_id, course_id),
+	FOREIGN KEY (coupon_id) REFERENCES coupons(coupon_id) ON DELETE CASCADE,
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions
+(
+	coupon_id         UUID                        NOT NULL,
+	order_id          UUID                        NOT NULL,
+	user_id           UUID                        NOT NULL,
+	discount          INT                         NOT NULL,
+	created_at        TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (coupon_id, order_id),
+	FOREIGN KEY (coupon_id) REFERENCES coupons(coupon_id) ON DELETE CASCADE,
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS coupon_code TEXT NOT NULL DEFAULT '';
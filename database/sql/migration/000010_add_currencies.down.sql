@@ -0,0 +1,24 @@
+ALTER TABLE users DROP COLUMN IF EXISTS currency;
+
+UPDATE coupon_redemptions SET discount = discount / 100;
+ALTER TABLE coupon_redemptions ALTER COLUMN discount TYPE INT;
+
+UPDATE coupons SET min_total = min_total / 100;
+UPDATE coupons SET value = value / 100 WHERE kind = 'fixed';
+ALTER TABLE coupons DROP COLUMN IF EXISTS currency;
+ALTER TABLE coupons ALTER COLUMN min_total TYPE INT;
+ALTER TABLE coupons ALTER COLUMN value TYPE INT;
+
+UPDATE order_refunds SET amount = amount / 100;
+ALTER TABLE order_refunds ALTER COLUMN amount TYPE INT;
+
+UPDATE order_items SET price = price / 100;
+ALTER TABLE order_items ALTER COLUMN price TYPE INT;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
+
+DROP TABLE IF EXISTS course_prices;
+
+ALTER TABLE courses DROP COLUMN IF EXISTS currency;
+UPDATE courses SET price = price / 100;
+ALTER TABLE courses ALTER COLUMN price TYPE INT;
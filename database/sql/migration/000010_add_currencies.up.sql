@@ -0,0 +1,33 @@
+/* Amounts are stored in the minor unit of their currency from now on. */
+ALTER TABLE courses ALTER COLUMN price TYPE BIGINT;
+UPDATE courses SET price = price * 100;
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+
+CREATE TABLE IF NOT EXISTS course_prices
+(
+	course_id     UUID                        NOT NULL,
+	currency      TEXT                        NOT NULL,
+	amount        BIGINT                      NOT NULL,
+
+	PRIMARY KEY (course_id, currency),
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+
+ALTER TABLE order_items ALTER COLUMN price TYPE BIGINT;
+UPDATE order_items SET price = price * 100;
+
+ALTER TABLE order_refunds ALTER COLUMN amount TYPE BIGINT;
+UPDATE order_refunds SET amount = amount * 100;
+
+ALTER TABLE coupons ALTER COLUMN value TYPE BIGINT;
+ALTER TABLE coupons ALTER COLUMN min_total TYPE BIGINT;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT '';
+UPDATE coupons SET value = value * 100 WHERE kind = 'fixed';
+UPDATE coupons SET min_total = min_total * 100, currency = 'USD' WHERE kind = 'fixed' OR min_total > 0;
+
+ALTER TABLE coupon_redemptions ALTER COLUMN discount TYPE BIGINT;
+UPDATE coupon_redemptions SET discount = discount * 100;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT '';
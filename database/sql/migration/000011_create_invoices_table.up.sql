@@ -0,0 +1,30 @@
+/* Counters are locked while numbering an invoice, so that numbers are gap-free. */
+CREATE TABLE IF NOT EXISTS invoice_counters
+(
+	year          INT                         NOT NULL,
+	last          INT                         NOT NULL,
+
+	PRIMARY KEY (year)
+);
+
+CREATE TABLE IF NOT EXISTS invoices
+(
+	invoice_id    UUID                        NOT NULL,
+	order_id      UUID                        NOT NULL,
+	year          INT                         NOT NULL,
+	seq           INT                         NOT NULL,
+	number        TEXT UNIQUE                 NOT NULL,
+	status        TEXT                        NOT NULL,
+	pdf           BYTEA                       NULL,
+	issued_at     TIMESTAMP                   NOT NULL,
+	voided_at     TIMESTAMP                   NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (invoice_id),
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE,
+	UNIQUE (year, seq)
+);
+
+/* An order can have only one valid invoice at a time. */
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_order_issued_idx ON invoices(order_id) WHERE status = 'issued';
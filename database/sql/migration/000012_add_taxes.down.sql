@@ -0,0 +1,7 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_inclusive;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_name;
+ALTER TABLE orders DROP COLUMN IF EXISTS region;
+ALTER TABLE orders DROP COLUMN IF EXISTS country;
@@ -0,0 +1,9 @@
+/* Orders record the billing location of the customer, which determines the tax rate. */
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT TRUE;
+
+/* Item prices include the tax, whose rate is expressed in thousandths of a percent. */
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_rate INT NOT NULL DEFAULT 0;
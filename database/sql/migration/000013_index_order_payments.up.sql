@@ -0,0 +1,2 @@
+/* Refunds notified by providers only reference the payment of their order. */
+CREATE INDEX IF NOT EXISTS orders_payment_id_idx ON orders(payment_id) WHERE payment_id <> '';
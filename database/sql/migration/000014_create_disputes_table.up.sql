@@ -0,0 +1,15 @@
+/* Orders with an open dispute don't grant access to their courses. */
+CREATE TABLE IF NOT EXISTS order_disputes
+(
+	dispute_id           UUID                        NOT NULL,
+	order_id             UUID                        NOT NULL,
+	provider_dispute_id  TEXT UNIQUE                 NOT NULL,
+	status               TEXT                        NOT NULL,
+	reason               TEXT                        NOT NULL DEFAULT '',
+	amount               BIGINT                      NOT NULL,
+	created_at           TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at           TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (dispute_id),
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
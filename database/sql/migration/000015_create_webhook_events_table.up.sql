@@ -0,0 +1,10 @@
+/* Events notified by providers are recorded to ignore duplicate deliveries. */
+CREATE TABLE IF NOT EXISTS webhook_events
+(
+	provider      TEXT                        NOT NULL,
+	event_id      TEXT                        NOT NULL,
+	type          TEXT                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (provider, event_id)
+);
@@ -0,0 +1,30 @@
+/* Reconciliations compare orders with the payments recorded by providers. */
+CREATE TABLE IF NOT EXISTS reconciliations
+(
+	reconciliation_id  UUID                        NOT NULL,
+	window_from        TIMESTAMP                   NOT NULL,
+	window_to          TIMESTAMP                   NOT NULL,
+	checked            INT                         NOT NULL DEFAULT 0,
+	failed             INT                         NOT NULL DEFAULT 0,
+	created_at         TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (reconciliation_id)
+);
+
+CREATE TABLE IF NOT EXISTS reconciliation_discrepancies
+(
+	discrepancy_id     UUID                        NOT NULL,
+	reconciliation_id  UUID                        NOT NULL,
+	order_id           UUID                        NOT NULL,
+	kind               TEXT                        NOT NULL,
+	expected           BIGINT                      NOT NULL,
+	actual             BIGINT                      NOT NULL,
+	currency           TEXT                        NOT NULL,
+	detail             TEXT                        NOT NULL DEFAULT '',
+	fixed              BOOLEAN                     NOT NULL DEFAULT FALSE,
+	created_at         TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (discrepancy_id),
+	FOREIGN KEY (reconciliation_id) REFERENCES reconciliations(reconciliation_id) ON DELETE CASCADE,
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
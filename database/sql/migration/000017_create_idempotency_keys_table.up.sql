@@ -0,0 +1,15 @@
+/* Responses are recorded once completed: status is zero while the request is in progress. */
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+	user_id          UUID                        NOT NULL,
+	idempotency_key  TEXT                        NOT NULL,
+	fingerprint      TEXT                        NOT NULL,
+	status           INT                         NOT NULL DEFAULT 0,
+	content_type     TEXT                        NOT NULL DEFAULT '',
+	body             BYTEA,
+	created_at       TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at       TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (user_id, idempotency_key),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
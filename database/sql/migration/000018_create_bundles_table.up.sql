@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS bundles
+(
+	bundle_id     UUID                        NOT NULL,
+	name          TEXT                        NOT NULL,
+	description   TEXT                        NOT NULL,
+	image_url     TEXT                        NOT NULL,
+	price         BIGINT                      NOT NULL,
+	currency      TEXT                        NOT NULL DEFAULT 'USD',
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	version       INT                         NOT NULL DEFAULT 1,
+
+	PRIMARY KEY (bundle_id)
+);
+
+CREATE TABLE IF NOT EXISTS bundle_prices
+(
+	bundle_id     UUID                        NOT NULL,
+	currency      TEXT                        NOT NULL,
+	amount        BIGINT                      NOT NULL,
+
+	PRIMARY KEY (bundle_id, currency),
+	FOREIGN KEY (bundle_id) REFERENCES bundles(bundle_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS bundle_courses
+(
+	bundle_id     UUID                        NOT NULL,
+	course_id     UUID                        NOT NULL,
+
+	PRIMARY KEY (bundle_id, course_id),
+	FOREIGN KEY (bundle_id) REFERENCES bundles(bundle_id) ON DELETE CASCADE,
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cart_bundles
+(
+	user_id       UUID                        NOT NULL,
+	bundle_id     UUID                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (user_id, bundle_id),
+	FOREIGN KEY (user_id) REFERENCES carts(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (bundle_id) REFERENCES bundles(bundle_id) ON DELETE CASCADE
+);
+
+/* Courses bought within a bundle keep track of it. Bundles can be deleted afterwards. */
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS bundle_id TEXT NOT NULL DEFAULT '';
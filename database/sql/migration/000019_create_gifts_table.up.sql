@@ -0,0 +1,21 @@
+/* Codes are generated once the order is fulfilled: code_hash is empty until the gift is sent. */
+CREATE TABLE IF NOT EXISTS gifts
+(
+	gift_id          UUID                        NOT NULL,
+	order_id         UUID UNIQUE                 NOT NULL,
+	user_id          UUID                        NOT NULL,
+	recipient_email  TEXT                        NOT NULL,
+	message          TEXT                        NOT NULL DEFAULT '',
+	code_hash        BYTEA,
+	sent_at          TIMESTAMP,
+	redeemed_by      TEXT                        NOT NULL DEFAULT '',
+	redeemed_at      TIMESTAMP,
+	created_at       TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at       TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (gift_id),
+	FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS gifts_code_hash_idx ON gifts (code_hash);
@@ -0,0 +1,6 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS seats;
+ALTER TABLE orders DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_seats;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS organizations
+(
+	org_id        UUID                        NOT NULL,
+	name          TEXT                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS org_members
+(
+	org_id        UUID                        NOT NULL,
+	user_id       UUID                        NOT NULL,
+	role          TEXT                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY (org_id) REFERENCES organizations(org_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+/* Seats of members are reclaimed when they leave the organization. */
+CREATE TABLE IF NOT EXISTS org_seats
+(
+	org_id        UUID                        NOT NULL,
+	course_id     UUID                        NOT NULL,
+	user_id       UUID                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (org_id, course_id, user_id),
+	FOREIGN KEY (org_id, user_id) REFERENCES org_members(org_id, user_id) ON DELETE CASCADE,
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+/* Pending invitations reserve a seat of the course until they expire. */
+CREATE TABLE IF NOT EXISTS org_invitations
+(
+	invitation_id UUID                        NOT NULL,
+	org_id        UUID                        NOT NULL,
+	course_id     UUID                        NOT NULL,
+	email         TEXT                        NOT NULL,
+	token_hash    BYTEA UNIQUE                NOT NULL,
+	expires_at    TIMESTAMP                   NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (invitation_id),
+	FOREIGN KEY (org_id) REFERENCES organizations(org_id) ON DELETE CASCADE,
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+/* Seats are bought by organizations through orders. */
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS org_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS seats INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS plan_courses;
+DROP TABLE IF EXISTS plans;
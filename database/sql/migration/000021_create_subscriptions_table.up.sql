@@ -0,0 +1,53 @@
+/* Archived plans cannot be subscribed anymore, while their subscriptions go on. */
+CREATE TABLE IF NOT EXISTS plans
+(
+	plan_id       UUID                        NOT NULL,
+	name          TEXT                        NOT NULL,
+	description   TEXT                        NOT NULL,
+	interval      TEXT                        NOT NULL,
+	price         BIGINT                      NOT NULL,
+	currency      TEXT                        NOT NULL DEFAULT 'USD',
+	all_courses   BOOLEAN                     NOT NULL DEFAULT FALSE,
+	archived      BOOLEAN                     NOT NULL DEFAULT FALSE,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	version       INT                         NOT NULL DEFAULT 1,
+
+	PRIMARY KEY (plan_id)
+);
+
+CREATE TABLE IF NOT EXISTS plan_courses
+(
+	plan_id       UUID                        NOT NULL,
+	course_id     UUID                        NOT NULL,
+
+	PRIMARY KEY (plan_id, course_id),
+	FOREIGN KEY (plan_id) REFERENCES plans(plan_id) ON DELETE CASCADE,
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+/* Provider ids identify checkouts, while billing ids identify subscriptions on the provider. */
+/* Subscribers can access the courses of the plan until access_until. */
+CREATE TABLE IF NOT EXISTS subscriptions
+(
+	subscription_id       UUID                        NOT NULL,
+	user_id               UUID                        NOT NULL,
+	plan_id               UUID                        NOT NULL,
+	provider_id           TEXT                        NOT NULL,
+	billing_id            TEXT                        NOT NULL DEFAULT '',
+	status                TEXT                        NOT NULL,
+	cancel_at_period_end  BOOLEAN                     NOT NULL DEFAULT FALSE,
+	current_period_end    TIMESTAMP,
+	access_until          TIMESTAMP,
+	synced_at             TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	created_at            TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at            TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (subscription_id),
+	UNIQUE (provider_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (plan_id) REFERENCES plans(plan_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_user_idx ON subscriptions (user_id, access_until);
+CREATE INDEX IF NOT EXISTS subscriptions_billing_idx ON subscriptions (billing_id);
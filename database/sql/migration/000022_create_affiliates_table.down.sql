@@ -0,0 +1,5 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS affiliate_id;
+DROP TABLE IF EXISTS commissions;
+DROP TABLE IF EXISTS affiliate_clicks;
+DROP TABLE IF EXISTS commission_rates;
+DROP TABLE IF EXISTS affiliates;
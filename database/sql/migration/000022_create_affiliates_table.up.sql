@@ -0,0 +1,59 @@
+/* Rates are expressed in basis points of the price of items, taxes excluded. */
+CREATE TABLE IF NOT EXISTS affiliates
+(
+	affiliate_id  UUID                        NOT NULL,
+	user_id       UUID                        NOT NULL,
+	code          TEXT                        NOT NULL,
+	rate          INT                         NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (affiliate_id),
+	UNIQUE (user_id),
+	UNIQUE (code),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+/* Rates of courses apply to all affiliates when affiliate_id is empty. */
+CREATE TABLE IF NOT EXISTS commission_rates
+(
+	affiliate_id  TEXT                        NOT NULL DEFAULT '',
+	course_id     UUID                        NOT NULL,
+	rate          INT                         NOT NULL,
+
+	PRIMARY KEY (affiliate_id, course_id),
+	FOREIGN KEY (course_id) REFERENCES courses(course_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS affiliate_clicks
+(
+	click_id      UUID                        NOT NULL,
+	affiliate_id  UUID                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (click_id),
+	FOREIGN KEY (affiliate_id) REFERENCES affiliates(affiliate_id) ON DELETE CASCADE
+);
+
+/* Commissions reversed after being paid are clawed back from the next payout. */
+CREATE TABLE IF NOT EXISTS commissions
+(
+	commission_id UUID                        NOT NULL,
+	affiliate_id  UUID                        NOT NULL,
+	order_id      UUID                        NOT NULL,
+	course_id     UUID                        NOT NULL,
+	amount        BIGINT                      NOT NULL,
+	currency      TEXT                        NOT NULL,
+	rate          INT                         NOT NULL,
+	status        TEXT                        NOT NULL,
+	paid_at       TIMESTAMP,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (commission_id),
+	UNIQUE (order_id, course_id),
+	FOREIGN KEY (affiliate_id) REFERENCES affiliates(affiliate_id),
+	FOREIGN KEY (order_id) REFERENCES orders(order_id)
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS affiliate_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE courses DROP COLUMN IF EXISTS image_key;
+ALTER TABLE videos DROP COLUMN IF EXISTS object_key;
@@ -0,0 +1,2 @@
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS object_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS image_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,33 @@
+/* Uploads are received in chunks, stored as parts until the upload is completed. */
+/* Hash state is the serialized state of the checksum of the received bytes. */
+CREATE TABLE IF NOT EXISTS uploads
+(
+	upload_id     UUID                        NOT NULL,
+	user_id       UUID                        NOT NULL,
+	kind          TEXT                        NOT NULL,
+	filename      TEXT                        NOT NULL,
+	object_key    TEXT                        NOT NULL,
+	content_type  TEXT                        NOT NULL,
+	size          BIGINT                      NOT NULL,
+	upload_offset BIGINT                      NOT NULL DEFAULT 0,
+	checksum      TEXT                        NOT NULL,
+	hash_state    BYTEA                       NOT NULL,
+	status        TEXT                        NOT NULL,
+	created_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+	updated_at    TIMESTAMP                   NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (upload_id),
+	UNIQUE (object_key),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS upload_parts
+(
+	upload_id     UUID                        NOT NULL,
+	part_offset   BIGINT                      NOT NULL,
+	object_key    TEXT                        NOT NULL,
+	size          BIGINT                      NOT NULL,
+
+	PRIMARY KEY (upload_id, part_offset),
+	FOREIGN KEY (upload_id) REFERENCES uploads(upload_id) ON DELETE CASCADE
+);
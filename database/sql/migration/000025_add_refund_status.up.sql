@@ -0,0 +1 @@
+ALTER TABLE order_refunds ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'completed';
@@ -24,6 +24,8 @@ type Emailer struct {
 type Links struct {
 	RecoveryURL   string
 	ActivationURL string
+	GiftURL       string
+	InvitationURL string
 }
 
 // New builds and returns a ready-to-use Emailer.
@@ -85,3 +87,67 @@ func (e *Emailer) SendRecoveryToken(token string, to string) error {
 
 	return smtp.SendMail(e.host, e.auth, e.from, []string{to}, bytes)
 }
+
+// SendGiftCode attempts to send the code of a gift to its recipient,
+// together with the name of the sender and their message.
+func (e *Emailer) SendGiftCode(code string, to string, sender string, message string) error {
+	t, err := template.New("email").ParseFS(templates, "templates/gift.tmpl")
+	if err != nil {
+		return fmt.Errorf("parsing email template: %w", err)
+	}
+
+	var data struct {
+		Link    string
+		Code    string
+		Sender  string
+		Message string
+	}
+	data.Link = e.links.GiftURL + code
+	data.Code = code
+	data.Sender = sender
+	data.Message = message
+
+	var body bytes.Buffer
+	err = t.ExecuteTemplate(&body, "html", data)
+	if err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+
+	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	subject := "Subject: You received a gift!\n"
+	src := fmt.Sprintf("From: %s\r\n", e.from)
+	dst := fmt.Sprintf("To: %s\r\n", to)
+	bytes := append([]byte(src+dst+subject+mime), body.Bytes()...)
+
+	return smtp.SendMail(e.host, e.auth, e.from, []string{to}, bytes)
+}
+
+// SendInvitation attempts to send the token of an invitation to join
+// the passed organization to the specified user.
+func (e *Emailer) SendInvitation(token string, to string, org string) error {
+	t, err := template.New("email").ParseFS(templates, "templates/invitation.tmpl")
+	if err != nil {
+		return fmt.Errorf("parsing email template: %w", err)
+	}
+
+	var data struct {
+		Link string
+		Org  string
+	}
+	data.Link = e.links.InvitationURL + token
+	data.Org = org
+
+	var body bytes.Buffer
+	err = t.ExecuteTemplate(&body, "html", data)
+	if err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+
+	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	subject := fmt.Sprintf("Subject: Join %s on Govod\n", org)
+	src := fmt.Sprintf("From: %s\r\n", e.from)
+	dst := fmt.Sprintf("To: %s\r\n", to)
+	bytes := append([]byte(src+dst+subject+mime), body.Bytes()...)
+
+	return smtp.SendMail(e.host, e.auth, e.from, []string{to}, bytes)
+}
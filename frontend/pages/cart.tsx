@@ -3,7 +3,9 @@ import Head from 'next/head'
 import { useRouter } from 'next/router'
 import { useSession } from '@/session/context'
 import { fetcher } from '@/services/fetch'
+import { formatMoney } from '@/services/money'
 import Image from 'next/image'
+import { useRef, useState } from 'react'
 import { toast } from 'react-hot-toast'
 import { PayPalButtons, usePayPalScriptReducer } from '@paypal/react-paypal-js'
 import useSWR from 'swr'
@@ -30,7 +32,7 @@ function CartCourse(props: CartCourseProps) {
             </button>
             <Image className="mx-auto h-20 w-20" alt={course.name} src={course.imageUrl} width={80} height={32} />
             <div className="mx-auto ">{course.name}</div>
-            <div className="mx-auto font-bold">${course.price}</div>
+            <div className="mx-auto font-bold">{formatMoney({ amount: course.price, currency: course.currency })}</div>
         </div>
     )
 }
@@ -41,6 +43,12 @@ export default function CartPage() {
     const [{ isPending, isResolved }] = usePayPalScriptReducer()
 
     const { data: cart, mutate } = useSWR<Cart>(isLoggedIn ? '/cart' : null)
+    const [country, setCountry] = useState('')
+    const [region, setRegion] = useState('')
+
+    // Repeated clicks on the same checkout share the idempotency key,
+    // so that a single order is created.
+    const checkoutKey = useRef(crypto.randomUUID())
 
     if (isLoading) {
         return null
@@ -63,24 +71,35 @@ export default function CartPage() {
         }
     }
 
+    // Taxes are computed on the billing location of the user.
+    const billing = () => ({
+        method: 'POST',
+        headers: { 'Idempotency-Key': checkoutKey.current },
+        body: JSON.stringify({ country: country.toUpperCase(), region: region.toUpperCase() }),
+    })
+
     const handleStripeCheckout = async (e: React.MouseEvent<HTMLButtonElement, MouseEvent>) => {
         e.preventDefault()
         try {
-            const res = await fetcher.fetch(`/orders/stripe`, { method: 'POST' })
+            const res = await fetcher.fetch(`/orders/stripe`, billing())
             const data = await res.json()
             window.location.href = data
         } catch (err) {
             toast.error('Something went wrong')
+        } finally {
+            checkoutKey.current = crypto.randomUUID()
         }
     }
 
     const handlePaypalCheckout = async () => {
         try {
-            const res = await fetcher.fetch(`/orders/paypal`, { method: 'POST' })
+            const res = await fetcher.fetch(`/orders/paypal`, billing())
             const data = await res.json()
             return data.id
         } catch (err) {
             toast.error('Something went wrong')
+        } finally {
+            checkoutKey.current = crypto.randomUUID()
         }
     }
 
@@ -118,6 +137,23 @@ export default function CartPage() {
                         {cart?.items.length === 0 && <p className="p-5 text-center">Nothing in the cart..</p>}
 
                         <div className="flex w-full flex-col items-center gap-1 p-2">
+                            <div className="flex w-full flex-row gap-1 md:w-1/2">
+                                <input
+                                    className="w-1/2 rounded border p-2"
+                                    placeholder="Country (e.g. IT)"
+                                    maxLength={2}
+                                    value={country}
+                                    onChange={(e) => setCountry(e.target.value)}
+                                />
+                                <input
+                                    className="w-1/2 rounded border p-2"
+                                    placeholder="Region (optional)"
+                                    maxLength={3}
+                                    value={region}
+                                    onChange={(e) => setRegion(e.target.value)}
+                                />
+                            </div>
+
                             <button
                                 onClick={handleStripeCheckout}
                                 className={`flex w-full flex-row justify-center rounded p-2 text-white md:w-1/2 ${
@@ -0,0 +1,8 @@
+import { Money } from '@/services/types'
+
+// Amounts are expressed in the minor unit of their currency.
+export function formatMoney(m: Money): string {
+    const format = new Intl.NumberFormat(undefined, { style: 'currency', currency: m.currency })
+    const digits = format.resolvedOptions().maximumFractionDigits ?? 2
+    return format.format(m.amount / Math.pow(10, digits))
+}
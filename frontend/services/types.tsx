@@ -4,6 +4,13 @@ export type Course = {
     description: string
     imageUrl: string
     price: number
+    currency: string
+    prices: Money[]
+}
+
+export type Money = {
+    amount: number
+    currency: string
 }
 
 export type Video = {
@@ -0,0 +1,115 @@
+// Package money models amounts of money in a given currency.
+// Amounts are always expressed in the minor unit of their currency,
+// e.g. cents for USD, to avoid rounding errors.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default is the currency used when no other currency is specified.
+const Default = "USD"
+
+// ErrCurrencyMismatch is returned when operating on amounts of different currencies.
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// exponents lists the ISO 4217 currencies whose minor unit is not
+// the hundredth of the major unit.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Money is an amount of money in a currency.
+// Amount is expressed in the minor unit of the ISO 4217 currency.
+type Money struct {
+	Amount   int64  `json:"amount" db:"amount" validate:"gte=0"`
+	Currency string `json:"currency" db:"currency" validate:"required,iso4217"`
+}
+
+// New constructs a Money. The currency is normalized in upper case.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Exponent returns the number of decimal digits of the minor unit of the currency.
+func Exponent(currency string) int {
+	if e, ok := exponents[strings.ToUpper(currency)]; ok {
+		return e
+	}
+	return 2
+}
+
+// Parse converts a decimal string in the major unit, like "12.30",
+// into a Money of the passed currency.
+func Parse(value string, currency string) (Money, error) {
+	exp := Exponent(currency)
+
+	whole, frac, _ := strings.Cut(value, ".")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %s has too many decimal digits for %s", value, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("parsing amount %s: %w", value, err)
+	}
+
+	return New(amount, currency), nil
+}
+
+// Decimal formats the amount as a decimal string in the major unit,
+// like "12.30", as required by some payment providers.
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	s := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + s
+	}
+
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// String formats the money in a human readable way, like "12.30 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns the sum of the two amounts, which must have the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("adding %s to %s: %w", o.Currency, m.Currency, ErrCurrencyMismatch)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference of the two amounts, which must have the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("subtracting %s from %s: %w", o.Currency, m.Currency, ErrCurrencyMismatch)
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
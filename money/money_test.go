@@ -0,0 +1,76 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		exp   string
+	}{
+		{New(1230, "USD"), "12.30"},
+		{New(5, "eur"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-150, "USD"), "-1.50"},
+		{New(1200, "JPY"), "1200"},
+		{New(1234, "KWD"), "1.234"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.exp {
+			t.Fatalf("formatting %d %s: expected %s, got %s", tt.money.Amount, tt.money.Currency, tt.exp, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		exp      Money
+	}{
+		{"12.30", "USD", New(1230, "USD")},
+		{"12.3", "USD", New(1230, "USD")},
+		{"12", "USD", New(1200, "USD")},
+		{"0.05", "EUR", New(5, "EUR")},
+		{"1200", "JPY", New(1200, "JPY")},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value, tt.currency)
+		if err != nil {
+			t.Fatalf("parsing %s %s: %v", tt.value, tt.currency, err)
+		}
+		if got != tt.exp {
+			t.Fatalf("parsing %s %s: expected %v, got %v", tt.value, tt.currency, tt.exp, got)
+		}
+	}
+
+	if _, err := Parse("1.234", "USD"); err == nil {
+		t.Fatal("amounts with too many decimals should not be parsed")
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	sum, err := New(150, "USD").Add(New(250, "USD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != New(400, "USD") {
+		t.Fatalf("expected 4.00 USD, got %s", sum)
+	}
+
+	diff, err := sum.Sub(New(100, "USD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != New(300, "USD") {
+		t.Fatalf("expected 3.00 USD, got %s", diff)
+	}
+
+	if _, err := sum.Add(New(100, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expected currency mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"Invoice 2023-000001", "Invoice 2023-000001"},
+		{"Total (VAT incl.)", `Total \(VAT incl.\)`},
+		{`C:\path`, `C:\\path`},
+		{"12,00 €", `12,00 \200`},
+		{"Città", `Citt\340`},
+		{"日本", "??"},
+	}
+
+	for _, tt := range tests {
+		if got := escape(tt.s); got != tt.exp {
+			t.Fatalf("escaping %q: expected %q, got %q", tt.s, tt.exp, got)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	d := New()
+	for i := 0; i < 2; i++ {
+		p := d.AddPage()
+		p.Text(50, 50, 12, i == 0, fmt.Sprintf("Page %d", i+1))
+		p.Line(50, 60, 500, 60)
+	}
+
+	b := d.Bytes()
+
+	if !bytes.HasPrefix(b, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+		t.Fatal("document is not delimited by the PDF header and trailer")
+	}
+
+	if !bytes.Contains(b, []byte("/Count 2")) {
+		t.Fatal("document should have 2 pages")
+	}
+
+	// Every entry of the cross-reference table must point to its object.
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+	if start == nil {
+		t.Fatal("missing startxref")
+	}
+
+	xref, err := strconv.Atoi(string(start[1]))
+	if err != nil || !bytes.HasPrefix(b[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref does not point to the cross-reference table: %v", err)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("expected 8 objects, got %d", len(entries))
+	}
+
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if exp := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(b[off:], []byte(exp)) {
+			t.Fatalf("object %d not found at offset %d", i+1, off)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package playlist rewrites the URIs referenced by the playlists of adaptive
+// streaming: HLS master and media playlists, and DASH manifests.
+package playlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalid is returned when rewriting files that are not playlists.
+var ErrInvalid = errors.New("invalid playlist")
+
+// RewriteFunc returns the URI replacing the passed one.
+type RewriteFunc func(uri string) (string, error)
+
+var (
+	// hlsURIAttr matches the URI attribute of HLS tags, such as
+	// EXT-X-KEY, EXT-X-MAP, EXT-X-MEDIA and EXT-X-I-FRAME-STREAM-INF.
+	hlsURIAttr = regexp.MustCompile(`([:,]\s*URI=")([^"]*)(")`)
+
+	// dashURIAttr matches the attributes of DASH elements holding URIs,
+	// such as the templates of segments.
+	dashURIAttr = regexp.MustCompile(`(\s(?:media|initialization|index|sourceURL)\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+	// dashBaseURL matches the BaseURL elements of DASH manifests.
+	dashBaseURL = regexp.MustCompile(`(<BaseURL(?:\s[^>]*)?>)([^<]*)(</BaseURL>)`)
+)
+
+// RewriteHLS rewrites the URIs of variants, renditions, segments, keys and
+// maps of the HLS playlist. Byte ranges of segments are left untouched,
+// since they apply to the rewritten URIs as well.
+func RewriteHLS(src []byte, fn RewriteFunc) ([]byte, error) {
+	lines := strings.Split(string(bytes.TrimPrefix(src, []byte("\ufeff"))), "\n")
+	if strings.TrimSpace(lines[0]) != "#EXTM3U" {
+		return nil, fmt.Errorf("missing EXTM3U header: %w", ErrInvalid)
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+
+		switch {
+		case strings.HasPrefix(line, "#EXT"):
+			var err error
+			line, err = replace(hlsURIAttr, line, func(m []string) (string, error) {
+				uri, err := fn(m[2])
+				return m[1] + uri + m[3], err
+			})
+			if err != nil {
+				return nil, err
+			}
+
+		case line != "" && !strings.HasPrefix(line, "#"):
+			uri, err := fn(strings.TrimSpace(line))
+			if err != nil {
+				return nil, err
+			}
+			line = uri
+		}
+
+		b.WriteString(line)
+		if i < len(lines)-1 {
+			b.WriteByte('\n')
+		}
+	}
+
+	return []byte(b.String()), nil
+}
+
+// RewriteDASH rewrites the base URLs of the DASH manifest, and the URIs of
+// its segments, including their templates.
+func RewriteDASH(src []byte, fn RewriteFunc) ([]byte, error) {
+	s := string(src)
+	if !strings.Contains(s, "<MPD") {
+		return nil, fmt.Errorf("missing MPD element: %w", ErrInvalid)
+	}
+
+	s, err := replace(dashURIAttr, s, func(m []string) (string, error) {
+		uri := m[2]
+		if m[3] != "" {
+			uri = m[3]
+		}
+		uri, err := fn(html.UnescapeString(uri))
+		return m[1] + `"` + escapeXML(uri) + `"`, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s, err = replace(dashBaseURL, s, func(m []string) (string, error) {
+		uri, err := fn(strings.TrimSpace(html.UnescapeString(m[2])))
+		return m[1] + escapeXML(uri) + m[3], err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(s), nil
+}
+
+// replace replaces the matches of re in s with the result of fn,
+// which is passed the submatches, stopping at the first error.
+func replace(re *regexp.Regexp, s string, fn func(m []string) (string, error)) (string, error) {
+	var err error
+	s = re.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		var r string
+		r, err = fn(re.FindStringSubmatch(match))
+		return r
+	})
+	return s, err
+}
+
+var xmlEscaper = strings.NewReplacer(`&`, "&amp;", `<`, "&lt;", `>`, "&gt;", `"`, "&quot;", `'`, "&apos;")
+
+func escapeXML(s string) string {
+	return xmlEscaper.Replace(s)
+}
@@ -0,0 +1,140 @@
+package playlist
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// sign prefixes the URIs, as if they were signed.
+func sign(uri string) (string, error) {
+	return "/signed/" + uri + "?sig=1&uid=2", nil
+}
+
+func TestRewriteHLSMaster(t *testing.T) {
+	src := "#EXTM3U\r\n" +
+		"#EXT-X-VERSION:6\r\n" +
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="audio/en.m3u8"` + "\r\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,AUDIO=\"aac\"\r\n" +
+		"720p/index.m3u8\r\n" +
+		`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=200000,URI="720p/iframes.m3u8"` + "\r\n"
+
+	exp := "#EXTM3U\n" +
+		"#EXT-X-VERSION:6\n" +
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="/signed/audio/en.m3u8?sig=1&uid=2"` + "\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,AUDIO=\"aac\"\n" +
+		"/signed/720p/index.m3u8?sig=1&uid=2\n" +
+		`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=200000,URI="/signed/720p/iframes.m3u8?sig=1&uid=2"` + "\n"
+
+	rewriteOK(t, RewriteHLS, src, exp)
+}
+
+func TestRewriteHLSMedia(t *testing.T) {
+	src := `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x1
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+# A comment with URI="comment.ts" is kept.
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:1000@720
+segments.mp4
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:1000
+segments.mp4
+#EXT-X-ENDLIST
+`
+
+	exp := `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-KEY:METHOD=AES-128,URI="/signed/key.bin?sig=1&uid=2",IV=0x1
+#EXT-X-MAP:URI="/signed/init.mp4?sig=1&uid=2",BYTERANGE="720@0"
+# A comment with URI="comment.ts" is kept.
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:1000@720
+/signed/segments.mp4?sig=1&uid=2
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:1000
+/signed/segments.mp4?sig=1&uid=2
+#EXT-X-ENDLIST
+`
+
+	rewriteOK(t, RewriteHLS, src, exp)
+}
+
+func TestRewriteDASH(t *testing.T) {
+	src := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static">
+  <BaseURL>https://cdn.example.com/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <BaseURL serviceLocation="a">video/</BaseURL>
+      <SegmentTemplate initialization='$RepresentationID$/init.mp4' media="$RepresentationID$/seg-$Number%05d$.m4s?v=1&amp;x=2" startNumber="1"/>
+      <Representation id="720p" bandwidth="2000000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <SegmentList>
+        <Initialization sourceURL="audio/init.mp4"/>
+        <SegmentURL media="audio/seg-1.m4s" mediaRange="0-999"/>
+      </SegmentList>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	exp := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static">
+  <BaseURL>/signed/https://cdn.example.com/?sig=1&amp;uid=2</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <BaseURL serviceLocation="a">/signed/video/?sig=1&amp;uid=2</BaseURL>
+      <SegmentTemplate initialization="/signed/$RepresentationID$/init.mp4?sig=1&amp;uid=2" media="/signed/$RepresentationID$/seg-$Number%05d$.m4s?v=1&amp;x=2?sig=1&amp;uid=2" startNumber="1"/>
+      <Representation id="720p" bandwidth="2000000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <SegmentList>
+        <Initialization sourceURL="/signed/audio/init.mp4?sig=1&amp;uid=2"/>
+        <SegmentURL media="/signed/audio/seg-1.m4s?sig=1&amp;uid=2" mediaRange="0-999"/>
+      </SegmentList>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	rewriteOK(t, RewriteDASH, src, exp)
+}
+
+func TestRewriteInvalid(t *testing.T) {
+	if _, err := RewriteHLS([]byte("<MPD></MPD>"), sign); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected manifest not to be an HLS playlist, got %v", err)
+	}
+
+	if _, err := RewriteDASH([]byte("#EXTM3U\nindex.m3u8"), sign); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected playlist not to be a DASH manifest, got %v", err)
+	}
+
+	// Errors of the rewrite are returned.
+	errURI := errors.New("uri out of the playlist")
+	fail := func(uri string) (string, error) {
+		if strings.HasPrefix(uri, "../") {
+			return "", errURI
+		}
+		return uri, nil
+	}
+
+	if _, err := RewriteHLS([]byte("#EXTM3U\nseg-1.ts\n../seg-2.ts\n"), fail); !errors.Is(err, errURI) {
+		t.Fatalf("expected error of the rewrite, got %v", err)
+	}
+
+	if _, err := RewriteDASH([]byte(`<MPD><SegmentURL media="../seg-1.m4s"/></MPD>`), fail); !errors.Is(err, errURI) {
+		t.Fatalf("expected error of the rewrite, got %v", err)
+	}
+}
+
+func rewriteOK(t *testing.T, rewrite func([]byte, RewriteFunc) ([]byte, error), src string, exp string) {
+	got, err := rewrite([]byte(src), sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != exp {
+		t.Fatalf("expected playlist:\n%s\ngot:\n%s", exp, got)
+	}
+}
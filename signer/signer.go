@@ -0,0 +1,186 @@
+// Package signer signs the links of media files with short-lived HMACs,
+// so that they can be fetched without further authentication, and only
+// until they expire.
+//
+// Signed links carry their signature in the query:
+//
+//	/videos/1/stream?expires=1700000000&uid=2&kid=k1&sig=...
+//
+// where sig is the HMAC-SHA256, encoded in URL-safe base64, of the path,
+// the user ID and the expiry, separated by newlines, computed with the
+// key identified by kid. Several keys can be active at once, so that
+// links keep working while the keys are rotated.
+//
+// Links built by clients, such as the segments of DASH templates, can
+// be signed by a prefix instead: the signature, computed over the prefix
+// followed by an asterisk, is valid for all the paths starting with it.
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the query parameters of signed links.
+const (
+	ExpiresParam = "expires"
+	UserParam    = "uid"
+	KeyParam     = "kid"
+	PrefixParam  = "prefix"
+	SigParam     = "sig"
+)
+
+// minSecretLen is the minimum length of the secrets of keys, in bytes.
+const minSecretLen = 32
+
+var (
+	// ErrInvalid is returned when verifying links that are not signed,
+	// have been tampered with or have been signed by unknown keys.
+	ErrInvalid = errors.New("invalid signature")
+
+	// ErrExpired is returned when verifying links that have expired.
+	ErrExpired = errors.New("link expired")
+)
+
+// Key is a secret used to sign links, identified by its ID.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses keys formatted as id:secret.
+func ParseKeys(specs []string) ([]Key, error) {
+	keys := make([]Key, 0, len(specs))
+	for i, spec := range specs {
+		id, secret, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("key at position %d not formatted as id:secret", i)
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Signer signs links valid for its TTL.
+// Links are signed with the first key, and verified with any of them.
+type Signer struct {
+	ttl  time.Duration
+	keys []Key
+	now  func() time.Time
+}
+
+// New constructs a signer of links valid for ttl.
+// New keys are rotated in by placing them first, while the old
+// ones should be kept until the links they signed have expired.
+func New(ttl time.Duration, keys ...Key) (*Signer, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl[%s] of links must be positive", ttl)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("at least a key is required to sign links")
+	}
+
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("keys must have an id")
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("key[%s] is duplicated", k.ID)
+		}
+		if len(k.Secret) < minSecretLen {
+			return nil, fmt.Errorf("secret of key[%s] must be at least %d bytes long", k.ID, minSecretLen)
+		}
+		ids[k.ID] = true
+	}
+
+	return &Signer{ttl: ttl, keys: keys, now: time.Now}, nil
+}
+
+// Sign returns the passed path, escaped and without query,
+// signed for the user: the link can be verified until the TTL
+// of the signer elapses.
+func (s *Signer) Sign(path string, userID string) string {
+	return path + "?" + s.sign(path, userID).Encode()
+}
+
+// SignPrefix returns the query signing for the user all the paths
+// starting with the passed prefix, escaped and ending with a slash.
+func (s *Signer) SignPrefix(prefix string, userID string) string {
+	q := s.sign(prefix+"*", userID)
+	q.Set(PrefixParam, prefix)
+	return q.Encode()
+}
+
+func (s *Signer) sign(path string, userID string) url.Values {
+	key := s.keys[0]
+	expires := strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+
+	q := url.Values{}
+	q.Set(ExpiresParam, expires)
+	q.Set(UserParam, userID)
+	q.Set(KeyParam, key.ID)
+	q.Set(SigParam, mac(key.Secret, path, userID, expires))
+
+	return q
+}
+
+// Verify checks the signature of the link of the passed escaped
+// path and query, returning the user the link has been signed for.
+func (s *Signer) Verify(path string, q url.Values) (string, error) {
+	expires, userID, kid, sig := q.Get(ExpiresParam), q.Get(UserParam), q.Get(KeyParam), q.Get(SigParam)
+
+	var key *Key
+	for i := range s.keys {
+		if s.keys[i].ID == kid {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("unknown key[%s]: %w", kid, ErrInvalid)
+	}
+
+	if q.Has(PrefixParam) {
+		prefix := q.Get(PrefixParam)
+		if !strings.HasSuffix(prefix, "/") || !strings.HasPrefix(path, prefix) {
+			return "", fmt.Errorf("path[%s] out of prefix[%s]: %w", path, prefix, ErrInvalid)
+		}
+		path = prefix + "*"
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(mac(key.Secret, path, userID, expires))) {
+		return "", fmt.Errorf("link of path[%s] signed by key[%s]: %w", path, kid, ErrInvalid)
+	}
+
+	// The expiry can be trusted only once the signature is verified.
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parsing expiry[%s]: %w", expires, ErrInvalid)
+	}
+
+	if s.now().Unix() > exp {
+		return "", fmt.Errorf("link of path[%s] expired at %s: %w", path, time.Unix(exp, 0).UTC(), ErrExpired)
+	}
+
+	return userID, nil
+}
+
+// IsSigned reports whether the query carries a signature.
+func IsSigned(q url.Values) bool {
+	return q.Has(SigParam)
+}
+
+func mac(secret []byte, path string, userID string, expires string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(path + "\n" + userID + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
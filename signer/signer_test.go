@@ -0,0 +1,180 @@
+package signer
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = Key{ID: "k1", Secret: []byte(strings.Repeat("a", minSecretLen))}
+	newKey = Key{ID: "k2", Secret: []byte(strings.Repeat("b", minSecretLen))}
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		keys []Key
+	}{
+		{"no keys", time.Hour, nil},
+		{"no ttl", 0, []Key{oldKey}},
+		{"no id", time.Hour, []Key{{Secret: oldKey.Secret}}},
+		{"short secret", time.Hour, []Key{{ID: "k1", Secret: []byte("secret")}}},
+		{"duplicated id", time.Hour, []Key{oldKey, {ID: "k1", Secret: newKey.Secret}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.ttl, tt.keys...); err == nil {
+			t.Fatalf("expected signer with %s to be rejected", tt.name)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys([]string{"k2:" + string(newKey.Secret), "k1:" + string(oldKey.Secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != "k2" || string(keys[1].Secret) != string(oldKey.Secret) {
+		t.Fatalf("unexpected keys parsed: %+v", keys)
+	}
+
+	if _, err := ParseKeys([]string{"secret"}); err == nil {
+		t.Fatal("expected key without id to be rejected")
+	}
+}
+
+func TestSign(t *testing.T) {
+	s, err := New(time.Hour, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	link := s.Sign("/videos/1/stream", "user")
+	path, q := parse(t, link)
+
+	if path != "/videos/1/stream" || q.Get(ExpiresParam) != "1672534800" || q.Get(KeyParam) != "k1" || !IsSigned(q) {
+		t.Fatalf("unexpected signed link %s", link)
+	}
+
+	if uid, err := s.Verify(path, q); err != nil || uid != "user" {
+		t.Fatalf("expected link signed for user, got %q: %v", uid, err)
+	}
+
+	// Links are bound to their path, user and expiry.
+	tampered := map[string]func(q url.Values){
+		"user":    func(q url.Values) { q.Set(UserParam, "admin") },
+		"expiry":  func(q url.Values) { q.Set(ExpiresParam, "1672538400") },
+		"key":     func(q url.Values) { q.Set(KeyParam, "k2") },
+		"sig":     func(q url.Values) { q.Set(SigParam, q.Get(SigParam)[1:]) },
+		"missing": func(q url.Values) { q.Del(SigParam) },
+	}
+
+	for name, tamper := range tampered {
+		_, q := parse(t, link)
+		tamper(q)
+		if _, err := s.Verify(path, q); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected link with tampered %s to be invalid, got %v", name, err)
+		}
+	}
+
+	if _, err := s.Verify("/videos/2/stream", q); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected link of another path to be invalid, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := s.Verify(path, q); err != nil {
+		t.Fatalf("expected link valid until its expiry, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := s.Verify(path, q); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected link expired, got %v", err)
+	}
+}
+
+func TestSignPrefix(t *testing.T) {
+	s, err := New(time.Hour, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := url.ParseQuery(s.SignPrefix("/videos/1/media/", "user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/videos/1/media/", "/videos/1/media/manifest.mpd", "/videos/1/media/720p/seg-1.m4s"} {
+		if uid, err := s.Verify(path, q); err != nil || uid != "user" {
+			t.Fatalf("expected path %s signed for user, got %q: %v", path, uid, err)
+		}
+	}
+
+	for _, path := range []string{"/videos/1/stream", "/videos/12/media/seg-1.m4s", "/videos/1/media"} {
+		if _, err := s.Verify(path, q); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected path %s out of prefix to be invalid, got %v", path, err)
+		}
+	}
+
+	// Prefixes are bound to their signature.
+	q.Set(PrefixParam, "/videos/")
+	if _, err := s.Verify("/videos/2/media/seg-1.m4s", q); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected tampered prefix to be invalid, got %v", err)
+	}
+
+	// Links of a path can't be used as prefixes.
+	_, q = parse(t, s.Sign("/videos/1/media/", "user"))
+	q.Set(PrefixParam, "/videos/1/media/")
+	if _, err := s.Verify("/videos/1/media/seg-1.m4s", q); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected link used as prefix to be invalid, got %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old, err := New(time.Hour, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := New(time.Hour, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retired, err := New(time.Hour, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Links signed before the rotation keep working until the old key is retired.
+	path, q := parse(t, old.Sign("/videos/1/stream", "user"))
+	if _, err := rotated.Verify(path, q); err != nil {
+		t.Fatalf("expected link of old key to be valid during rotation, got %v", err)
+	}
+	if _, err := retired.Verify(path, q); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected link of retired key to be invalid, got %v", err)
+	}
+
+	path, q = parse(t, rotated.Sign("/videos/1/stream", "user"))
+	if q.Get(KeyParam) != newKey.ID {
+		t.Fatalf("expected link signed with the new key, got %s", q.Get(KeyParam))
+	}
+	if _, err := retired.Verify(path, q); err != nil {
+		t.Fatalf("expected link of new key to be valid, got %v", err)
+	}
+}
+
+func parse(t *testing.T, link string) (string, url.Values) {
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.EscapedPath(), u.Query()
+}
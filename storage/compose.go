@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// section is a range of a stored object.
+type section struct {
+	key    string
+	offset int64
+	length int64
+}
+
+// stat returns the metadata of the objects stored under the passed
+// keys, together with their total size.
+func stat(ctx context.Context, store Store, keys []string) ([]Object, int64, error) {
+	objs := make([]Object, 0, len(keys))
+	var size int64
+	for _, k := range keys {
+		obj, err := store.Stat(ctx, k)
+		if err != nil {
+			return nil, 0, err
+		}
+		objs = append(objs, obj)
+		size += obj.Size
+	}
+	return objs, size, nil
+}
+
+// wholes returns the sections covering the passed objects entirely.
+func wholes(objs []Object) []section {
+	secs := make([]section, 0, len(objs))
+	for _, obj := range objs {
+		if obj.Size > 0 {
+			secs = append(secs, section{key: obj.Key, length: obj.Size})
+		}
+	}
+	return secs
+}
+
+// planParts splits the concatenation of the objects into the parts of a
+// multipart upload, all at least minSize bytes long but the last one,
+// and at most maxSize bytes long.
+// Parts made of a single section at least minSize bytes long can be copied
+// from their object, while the others must be read and uploaded.
+func planParts(objs []Object, minSize int64, maxSize int64) [][]section {
+	var parts [][]section
+	var group []section
+	var n int64
+
+	for _, obj := range objs {
+		for off := int64(0); off < obj.Size; {
+			left := obj.Size - off
+
+			if len(group) == 0 && left >= minSize {
+				l := min(left, maxSize)
+				parts = append(parts, []section{{key: obj.Key, offset: off, length: l}})
+				off += l
+				continue
+			}
+
+			l := min(left, minSize-n)
+			group = append(group, section{key: obj.Key, offset: off, length: l})
+			off += l
+			n += l
+
+			if n == minSize {
+				parts = append(parts, group)
+				group, n = nil, 0
+			}
+		}
+	}
+
+	if len(group) > 0 {
+		parts = append(parts, group)
+	}
+
+	return parts
+}
+
+// sectionsReader reads sections of objects one after the other,
+// opening each of them only when the previous one has been read.
+type sectionsReader struct {
+	ctx   context.Context
+	store Store
+	secs  []section
+	cur   io.ReadCloser
+}
+
+func (r *sectionsReader) Read(b []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.secs) == 0 {
+				return 0, io.EOF
+			}
+
+			sec := r.secs[0]
+			rc, _, err := r.store.GetRange(r.ctx, sec.key, sec.offset, sec.length)
+			if err != nil {
+				return 0, fmt.Errorf("reading object[%s] at offset %d: %w", sec.key, sec.offset, err)
+			}
+
+			r.cur = rc
+			r.secs = r.secs[1:]
+		}
+
+		n, err := r.cur.Read(b)
+		if errors.Is(err, io.EOF) {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Close closes the section being read, if any.
+func (r *sectionsReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files under a root directory of the local disk.
+// Objects can be presigned only if the directory is publicly served, for
+// instance by a reverse proxy, under BaseURL: presigned URLs never expire.
+// Content types are not stored: they are guessed from the extension of keys.
+type Local struct {
+	root    string
+	baseURL string
+}
+
+// NewLocal constructs a local storage rooted at the passed directory,
+// which is created if it does not exist.
+func NewLocal(root string, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("creating storage root[%s]: %w", root, err)
+	}
+
+	return &Local{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// path returns the path of the file of the passed key.
+func (l *Local) path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object in a temporary file, which is then renamed,
+// so that readers never see partially written objects.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return Object{}, fmt.Errorf("creating directory of object[%s]: %w", key, err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return Object{}, fmt.Errorf("creating file of object[%s]: %w", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Object{}, fmt.Errorf("writing object[%s]: %w", key, err)
+	}
+
+	if n != size {
+		return Object{}, fmt.Errorf("object[%s] of %d bytes, expected %d", key, n, size)
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return Object{}, fmt.Errorf("storing object[%s]: %w", key, err)
+	}
+
+	return l.Stat(ctx, key)
+}
+
+// Get opens the file of the object.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	return l.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange opens the file of the object, reading only the requested range.
+func (l *Local) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, Object{}, fmt.Errorf("opening object[%s]: %w", key, ErrNotFound)
+		}
+		return nil, Object{}, fmt.Errorf("opening object[%s]: %w", key, err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Object{}, fmt.Errorf("reading info of object[%s]: %w", key, err)
+	}
+
+	obj := l.object(key, fi)
+	length, err = checkRange(obj.Size, offset, length)
+	if err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+
+	rc := struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.NewSectionReader(f, offset, length),
+		Closer: f,
+	}
+
+	return rc, obj, nil
+}
+
+// Stat returns the metadata of the file of the object.
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Object{}, fmt.Errorf("reading info of object[%s]: %w", key, ErrNotFound)
+		}
+		return Object{}, fmt.Errorf("reading info of object[%s]: %w", key, err)
+	}
+
+	return l.object(key, fi), nil
+}
+
+// Compose writes the objects one after the other in the file of the new one.
+func (l *Local) Compose(ctx context.Context, key string, keys []string, contentType string) (Object, error) {
+	objs, size, err := stat(ctx, l, keys)
+	if err != nil {
+		return Object{}, err
+	}
+
+	r := &sectionsReader{ctx: ctx, store: l, secs: wholes(objs)}
+	defer r.Close()
+
+	return l.Put(ctx, key, r, size, contentType)
+}
+
+// Delete removes the file of the object.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("deleting object[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// Presign returns the public URL of the object, if the storage is served.
+func (l *Local) Presign(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+
+	if l.baseURL == "" {
+		return "", fmt.Errorf("presigning object[%s] without a base URL: %w", key, ErrNotSupported)
+	}
+
+	return l.baseURL + "/" + (&url.URL{Path: key}).EscapedPath(), nil
+}
+
+// object builds the metadata of the object from the info of its file.
+// Local files have no checksum stored: their ETag changes on every write.
+func (l *Local) object(key string, fi fs.FileInfo) Object {
+	return Object{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentType(key),
+		ETag:        fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size()),
+		ModTime:     fi.ModTime().UTC(),
+	}
+}
+
+// mediaTypes are the content types of media files, which are not
+// always known to the system tables used by the mime package.
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".vtt":  "text/vtt",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+}
+
+// contentType guesses the content type of the object from the extension of its key.
+func contentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if ct, ok := mediaTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
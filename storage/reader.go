@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader reads an object as an io.ReadSeeker, as needed to serve it with
+// http.ServeContent. The object is read from the storage only when needed,
+// starting from the current offset, so that seeking does not download the
+// bytes skipped.
+type Reader struct {
+	ctx    context.Context
+	store  Store
+	obj    Object
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReader constructs a reader of the passed object.
+func NewReader(ctx context.Context, store Store, obj Object) *Reader {
+	return &Reader{ctx: ctx, store: store, obj: obj}
+}
+
+// Read reads the object from the current offset.
+func (r *Reader) Read(b []byte) (int, error) {
+	if r.offset >= r.obj.Size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		body, _, err := r.store.GetRange(r.ctx, r.obj.Key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(b)
+	r.offset += int64(n)
+
+	if errors.Is(err, io.EOF) && r.offset < r.obj.Size {
+		err = fmt.Errorf("object[%s] truncated at %d bytes: %w", r.obj.Key, r.offset, io.ErrUnexpectedEOF)
+	}
+
+	return n, err
+}
+
+// Seek moves the offset the object is read from.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.obj.Size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("seeking object[%s] at %d: %w", r.obj.Key, offset, ErrInvalidRange)
+	}
+
+	if offset != r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		r.offset = offset
+	}
+
+	return offset, nil
+}
+
+// Close releases the object being read, if any.
+func (r *Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,629 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// emptyHash is the hash of the empty payload of bodiless requests.
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// unsignedPayload marks requests whose payload is not hashed,
+	// so that objects can be uploaded without being read twice.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// maxPresignExpiry is the maximum validity of presigned URLs.
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	// Limits of the parts of multipart uploads: all the parts but
+	// the last must be at least minPartSize bytes long.
+	minPartSize = 5 << 20
+	maxPartSize = 5 << 30
+	maxParts    = 10000
+
+	amzDateFormat = "20060102T150405Z"
+)
+
+// S3Config contains the parameters to connect to an S3-compatible service.
+// Endpoint is the base URL of the service, like https://s3.amazonaws.com or
+// the URL of a MinIO server. Buckets are addressed as part of the path when
+// PathStyle is set, as needed by MinIO, otherwise as part of the host.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+// S3 stores objects on a bucket of an S3-compatible service.
+// Requests are authenticated with AWS Signature Version 4.
+type S3 struct {
+	client   *http.Client
+	endpoint *url.URL
+	cfg      S3Config
+	now      func() time.Time
+	minPart  int64
+}
+
+// NewS3 constructs an S3 storage.
+func NewS3(client *http.Client, cfg S3Config) (*S3, error) {
+	u, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing s3 endpoint: %w", err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint[%s] is not an absolute URL", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, fmt.Errorf("s3 bucket and region are required")
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3{client: client, endpoint: u, cfg: cfg, now: time.Now, minPart: minPartSize}, nil
+}
+
+// objectURL returns the URL of the object stored under the passed key.
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = u.Path + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+	}
+	return &u
+}
+
+// Put uploads the object with a single request.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (Object, error) {
+	if err := CheckKey(key); err != nil {
+		return Object{}, err
+	}
+
+	// Requests with a body and no length would be sent chunked.
+	body := io.NopCloser(r)
+	if size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), body)
+	if err != nil {
+		return Object{}, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return Object{}, fmt.Errorf("putting object[%s]: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return s.Stat(ctx, key)
+}
+
+// Get downloads the whole object.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange downloads the requested range of the object.
+func (s *S3) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, Object, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, Object{}, err
+	}
+
+	if offset < 0 || length == 0 {
+		// The size of the object is needed to validate these ranges.
+		obj, err := s.Stat(ctx, key)
+		if err != nil {
+			return nil, Object{}, err
+		}
+		if _, err := checkRange(obj.Size, offset, length); err != nil {
+			return nil, Object{}, err
+		}
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), obj, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, Object{}, err
+	}
+
+	if offset > 0 || length > 0 {
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			rng += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", rng)
+	}
+
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("getting object[%s]: %w", key, err)
+	}
+
+	obj, err := s3Object(key, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, Object{}, err
+	}
+
+	return resp.Body, obj, nil
+}
+
+// Stat fetches the metadata of the object.
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	if err := CheckKey(key); err != nil {
+		return Object{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return Object{}, err
+	}
+
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return Object{}, fmt.Errorf("reading info of object[%s]: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return s3Object(key, resp)
+}
+
+// Compose joins the objects with a multipart upload, so that objects larger
+// than the limit of single requests can be composed. Objects large enough
+// to be parts are copied by S3, while the smaller ones are read and
+// uploaded together. Objects smaller than a part are simply put.
+func (s *S3) Compose(ctx context.Context, key string, keys []string, contentType string) (Object, error) {
+	if err := CheckKey(key); err != nil {
+		return Object{}, err
+	}
+
+	objs, size, err := stat(ctx, s, keys)
+	if err != nil {
+		return Object{}, err
+	}
+
+	if size < s.minPart {
+		r := &sectionsReader{ctx: ctx, store: s, secs: wholes(objs)}
+		defer r.Close()
+		return s.Put(ctx, key, r, size, contentType)
+	}
+
+	parts := planParts(objs, s.minPart, maxPartSize)
+	if len(parts) > maxParts {
+		return Object{}, fmt.Errorf("composing object[%s] of %d parts, at most %d allowed", key, len(parts), maxParts)
+	}
+
+	uploadID, err := s.createMultipart(ctx, key, contentType)
+	if err != nil {
+		return Object{}, fmt.Errorf("composing object[%s]: %w", key, err)
+	}
+
+	if err := s.uploadParts(ctx, key, uploadID, parts); err != nil {
+		// Parts of uploads left incomplete are kept, and billed, until aborted.
+		if aerr := s.abortMultipart(context.WithoutCancel(ctx), key, uploadID); aerr != nil {
+			err = fmt.Errorf("%w: aborting upload: %v", err, aerr)
+		}
+		return Object{}, fmt.Errorf("composing object[%s]: %w", key, err)
+	}
+
+	return s.Stat(ctx, key)
+}
+
+// multipartPart is a part of a multipart upload, identified by its ETag.
+type multipartPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// multipartResult contains the results of the requests of multipart uploads.
+// S3 can report errors of part copies and completions with status 200,
+// in which case the root element is named Error.
+type multipartResult struct {
+	XMLName  xml.Name
+	UploadID string `xml:"UploadId"`
+	ETag     string `xml:"ETag"`
+	Code     string `xml:"Code"`
+	Message  string `xml:"Message"`
+}
+
+// uploadParts uploads the parts and completes the multipart upload.
+func (s *S3) uploadParts(ctx context.Context, key string, uploadID string, parts [][]section) error {
+	done := make([]multipartPart, 0, len(parts))
+	for i, secs := range parts {
+		var etag string
+		var err error
+		if len(secs) == 1 && secs[0].length >= s.minPart {
+			etag, err = s.copyPart(ctx, key, uploadID, i+1, secs[0])
+		} else {
+			etag, err = s.uploadPart(ctx, key, uploadID, i+1, secs)
+		}
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i+1, err)
+		}
+
+		done = append(done, multipartPart{PartNumber: i + 1, ETag: etag})
+	}
+
+	return s.completeMultipart(ctx, key, uploadID, done)
+}
+
+// multipartURL returns the URL of the requests of the multipart upload.
+func (s *S3) multipartURL(key string, q url.Values) string {
+	u := s.objectURL(key)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// createMultipart starts a multipart upload, returning its id.
+func (s *S3) createMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.multipartURL(key, url.Values{"uploads": {""}}), nil)
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.doMultipart(req, emptyHash)
+	if err != nil {
+		return "", fmt.Errorf("starting multipart upload: %w", err)
+	}
+
+	if res.UploadID == "" {
+		return "", errors.New("starting multipart upload: missing upload id")
+	}
+
+	return res.UploadID, nil
+}
+
+// uploadPart uploads the sections, read one after the other, as a part.
+func (s *S3) uploadPart(ctx context.Context, key string, uploadID string, n int, secs []section) (string, error) {
+	var size int64
+	for _, sec := range secs {
+		size += sec.length
+	}
+
+	r := &sectionsReader{ctx: ctx, store: s, secs: secs}
+	defer r.Close()
+
+	q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.multipartURL(key, q), io.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return "", fmt.Errorf("uploading: %w", err)
+	}
+	resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// copyPart copies the section of an object stored in the bucket as a part.
+func (s *S3) copyPart(ctx context.Context, key string, uploadID string, n int, sec section) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.multipartURL(key, q), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Amz-Copy-Source", "/"+s.cfg.Bucket+"/"+uriEncode(sec.key, false))
+	req.Header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", sec.offset, sec.offset+sec.length-1))
+
+	res, err := s.doMultipart(req, emptyHash)
+	if err != nil {
+		return "", fmt.Errorf("copying object[%s]: %w", sec.key, err)
+	}
+
+	return res.ETag, nil
+}
+
+// completeMultipart joins the uploaded parts in the object.
+func (s *S3) completeMultipart(ctx context.Context, key string, uploadID string, parts []multipartPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []multipartPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.multipartURL(key, url.Values{"uploadId": {uploadID}}), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	if _, err := s.doMultipart(req, hex.EncodeToString(sum[:])); err != nil {
+		return fmt.Errorf("completing multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// abortMultipart discards the multipart upload and its parts.
+func (s *S3) abortMultipart(ctx context.Context, key string, uploadID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.multipartURL(key, url.Values{"uploadId": {uploadID}}), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// doMultipart sends the request of a multipart upload, decoding its result.
+func (s *S3) doMultipart(req *http.Request, payloadHash string) (multipartResult, error) {
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return multipartResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var res multipartResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return multipartResult{}, fmt.Errorf("decoding s3 response: %w", err)
+	}
+
+	if res.XMLName.Local == "Error" {
+		return multipartResult{}, fmt.Errorf("s3 responded with error %s: %s", res.Code, res.Message)
+	}
+
+	return res, nil
+}
+
+// Delete removes the object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("deleting object[%s]: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Presign returns a URL that lets anyone download the object until expiry.
+// Expiries longer than seven days are not accepted by S3.
+func (s *S3) Presign(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return "", fmt.Errorf("presign expiry must be between 1s and %s", maxPresignExpiry)
+	}
+
+	u := s.objectURL(key)
+	now := s.now().UTC()
+	scope := s.scope(now)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", now.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(q)
+
+	h := http.Header{}
+	h.Set("Host", u.Host)
+
+	sig := s.signature(http.MethodGet, u, h, []string{"host"}, unsignedPayload, now)
+	u.RawQuery += "&X-Amz-Signature=" + sig
+
+	return u.String(), nil
+}
+
+// do signs and sends the request, returning an error for failed responses.
+// Responses with status 404 are reported as ErrNotFound.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, ErrInvalidRange
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("s3 responded with status %s: %s", resp.Status, b)
+}
+
+// sign adds the authorization headers to the request.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Signed headers must be sorted, and include all the x-amz ones.
+	signed := []string{"host"}
+	for _, name := range []string{"range", "x-amz-content-sha256", "x-amz-copy-source", "x-amz-copy-source-range", "x-amz-date"} {
+		if req.Header.Get(name) != "" {
+			signed = append(signed, name)
+		}
+	}
+
+	sig := s.signature(req.Method, req.URL, req.Header, signed, payloadHash, now)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, s.scope(now), strings.Join(signed, ";"), sig,
+	))
+
+	// The Host header is set by the client from the URL.
+	req.Header.Del("Host")
+}
+
+// scope returns the credential scope of the requests signed at the passed time.
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature computes the signature of a request with the passed headers,
+// which must be sorted and lower case.
+func (s *S3) signature(method string, u *url.URL, h http.Header, signed []string, payloadHash string, now time.Time) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		headers.WriteString(name + ":" + strings.TrimSpace(h.Get(name)) + "\n")
+	}
+
+	creq := strings.Join([]string{
+		method,
+		uriEncode(u.Path, false),
+		canonicalQuery(u.Query()),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	sum := sha256.Sum256([]byte(creq))
+	sts := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(amzDateFormat),
+		s.scope(now),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, sts))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by key, as required by signatures.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes all the characters except the unreserved ones.
+// Slashes are kept in paths.
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Object builds the metadata of the object from the headers of the response.
+func s3Object(key string, resp *http.Response) (Object, error) {
+	obj := Object{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+
+	// Partial responses carry the size of the whole object in the content range.
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		_, total, ok := strings.Cut(cr, "/")
+		size, err := strconv.ParseInt(total, 10, 64)
+		if !ok || err != nil {
+			return Object{}, fmt.Errorf("invalid content range[%s] of object[%s]", cr, key)
+		}
+		obj.Size = size
+	}
+
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		t, err := http.ParseTime(lm)
+		if err != nil {
+			return Object{}, fmt.Errorf("invalid modification time[%s] of object[%s]: %w", lm, key, err)
+		}
+		obj.ModTime = t.UTC()
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,98 @@
+// Package storage stores media objects, like videos and images,
+// on a pluggable backend. Objects are identified by keys, which are
+// slash separated paths relative to the root of the backend.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested object does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrNotSupported is returned when the backend cannot perform an operation.
+	ErrNotSupported = errors.New("operation not supported by the storage")
+	// ErrInvalidRange is returned when the requested range is outside the object.
+	ErrInvalidRange = errors.New("invalid range")
+)
+
+// maxKeyLen is the maximum length of the keys of objects.
+const maxKeyLen = 1024
+
+// Object contains the metadata of a stored object.
+// Size is the size of the whole object, even when a range of it is read.
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// Store abstracts the backends objects can be stored on.
+type Store interface {
+	// Put stores the object read from r under the passed key, replacing
+	// the existing one, if any. Size is the number of bytes to be read.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (Object, error)
+	// Get reads the whole object stored under the passed key.
+	// The returned reader must be closed by the caller.
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// GetRange reads length bytes of the object starting from offset.
+	// The rest of the object is read if length is negative.
+	GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, Object, error)
+	// Stat returns the metadata of the object stored under the passed key.
+	Stat(ctx context.Context, key string) (Object, error)
+	// Compose stores under the passed key the concatenation of the objects
+	// stored under keys, in order, replacing the existing one, if any.
+	Compose(ctx context.Context, key string, keys []string, contentType string) (Object, error)
+	// Delete removes the object stored under the passed key.
+	// Deleting an object that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that grants access to the object until expiry.
+	Presign(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// CheckKey validates the passed key of an object. Keys are slash separated
+// paths that cannot escape the root of the backend.
+func CheckKey(key string) error {
+	if key == "" || len(key) > maxKeyLen {
+		return fmt.Errorf("key must be between 1 and %d characters", maxKeyLen)
+	}
+
+	if strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("key[%s] is not a relative path", key)
+	}
+
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("key[%s] contains an invalid segment", key)
+		}
+	}
+
+	for _, c := range key {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("key[%s] contains control characters", key)
+		}
+	}
+
+	return nil
+}
+
+// checkRange validates the range of an object of the passed size,
+// returning the length of the range.
+func checkRange(size int64, offset int64, length int64) (int64, error) {
+	if offset < 0 || offset > size || (offset == size && size > 0) {
+		return 0, fmt.Errorf("offset %d of object of %d bytes: %w", offset, size, ErrInvalidRange)
+	}
+
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+
+	return length, nil
+}
@@ -0,0 +1,61 @@
+package tax
+
+// rates contains the standard rates applied to digital services, by
+// country code or by country and region codes joined by a dash.
+// Keep it up to date with the rates published by the tax authorities.
+var rates = map[string]Rate{
+	// European Union: VAT is due at the rate of the customer's country.
+	"AT": {Name: "VAT", Value: 20000, Inclusive: true},
+	"BE": {Name: "VAT", Value: 21000, Inclusive: true},
+	"BG": {Name: "VAT", Value: 20000, Inclusive: true},
+	"CY": {Name: "VAT", Value: 19000, Inclusive: true},
+	"CZ": {Name: "VAT", Value: 21000, Inclusive: true},
+	"DE": {Name: "VAT", Value: 19000, Inclusive: true},
+	"DK": {Name: "VAT", Value: 25000, Inclusive: true},
+	"EE": {Name: "VAT", Value: 24000, Inclusive: true},
+	"ES": {Name: "VAT", Value: 21000, Inclusive: true},
+	"FI": {Name: "VAT", Value: 25500, Inclusive: true},
+	"FR": {Name: "VAT", Value: 20000, Inclusive: true},
+	"GR": {Name: "VAT", Value: 24000, Inclusive: true},
+	"HR": {Name: "VAT", Value: 25000, Inclusive: true},
+	"HU": {Name: "VAT", Value: 27000, Inclusive: true},
+	"IE": {Name: "VAT", Value: 23000, Inclusive: true},
+	"IT": {Name: "VAT", Value: 22000, Inclusive: true},
+	"LT": {Name: "VAT", Value: 21000, Inclusive: true},
+	"LU": {Name: "VAT", Value: 17000, Inclusive: true},
+	"LV": {Name: "VAT", Value: 21000, Inclusive: true},
+	"MT": {Name: "VAT", Value: 18000, Inclusive: true},
+	"NL": {Name: "VAT", Value: 21000, Inclusive: true},
+	"PL": {Name: "VAT", Value: 23000, Inclusive: true},
+	"PT": {Name: "VAT", Value: 23000, Inclusive: true},
+	"RO": {Name: "VAT", Value: 21000, Inclusive: true},
+	"SE": {Name: "VAT", Value: 25000, Inclusive: true},
+	"SI": {Name: "VAT", Value: 22000, Inclusive: true},
+	"SK": {Name: "VAT", Value: 23000, Inclusive: true},
+
+	// Rest of Europe.
+	"CH": {Name: "VAT", Value: 8100, Inclusive: true},
+	"GB": {Name: "VAT", Value: 20000, Inclusive: true},
+	"NO": {Name: "VAT", Value: 25000, Inclusive: true},
+
+	// Oceania.
+	"AU": {Name: "GST", Value: 10000, Inclusive: true},
+	"NZ": {Name: "GST", Value: 15000, Inclusive: true},
+
+	// Canada: GST, or HST in participating provinces, plus QST in Quebec.
+	"CA":    {Name: "GST", Value: 5000},
+	"CA-NB": {Name: "HST", Value: 15000},
+	"CA-NL": {Name: "HST", Value: 15000},
+	"CA-NS": {Name: "HST", Value: 14000},
+	"CA-ON": {Name: "HST", Value: 13000},
+	"CA-PE": {Name: "HST", Value: 15000},
+	"CA-QC": {Name: "GST+QST", Value: 14975},
+
+	// United States: only states taxing digital products, at the state rate.
+	"US":    {Name: "Sales tax"},
+	"US-CT": {Name: "Sales tax", Value: 6350},
+	"US-NY": {Name: "Sales tax", Value: 4000},
+	"US-PA": {Name: "Sales tax", Value: 6000},
+	"US-TX": {Name: "Sales tax", Value: 6250},
+	"US-WA": {Name: "Sales tax", Value: 6500},
+}
@@ -0,0 +1,83 @@
+// Package tax computes the taxes due on sales, like VAT or sales taxes,
+// according to the country and region of the customer.
+// Rates are maintained locally, see rates.go.
+package tax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scale is the value of a 100% rate.
+const scale = 100000
+
+// Rate is the tax rate applied to sales in a country or in a region of it.
+// Value is expressed in thousandths of a percent: 22% is 22000.
+// Inclusive rates are already included in prices, while exclusive
+// rates are added on top of them.
+type Rate struct {
+	Country   string `json:"country"`
+	Region    string `json:"region"`
+	Name      string `json:"name"`
+	Value     int    `json:"value"`
+	Inclusive bool   `json:"inclusive"`
+}
+
+// Breakdown splits an amount in its net and tax parts.
+type Breakdown struct {
+	Net   int64 `json:"net"`
+	Tax   int64 `json:"tax"`
+	Gross int64 `json:"gross"`
+}
+
+// Lookup returns the tax rate of the passed country and region.
+// Regions without a specific rate use the rate of their country.
+// Countries without a known rate are not taxed.
+func Lookup(country string, region string) Rate {
+	country = strings.ToUpper(country)
+	region = strings.ToUpper(region)
+
+	if region != "" {
+		if r, ok := rates[country+"-"+region]; ok {
+			r.Country, r.Region = country, region
+			return r
+		}
+	}
+
+	r, ok := rates[country]
+	if !ok {
+		r = Rate{Name: "Tax"}
+	}
+	r.Country, r.Region = country, region
+
+	return r
+}
+
+// Apply computes the tax due on the passed amount, expressed in
+// the minor unit of a currency. The amount includes the tax if the
+// rate is inclusive, it's the net amount otherwise.
+// Taxes are rounded half up to the minor unit.
+func (r Rate) Apply(amount int64) Breakdown {
+	v := int64(r.Value)
+
+	if r.Inclusive {
+		tax := (amount*v + (scale+v)/2) / (scale + v)
+		return Breakdown{Net: amount - tax, Tax: tax, Gross: amount}
+	}
+
+	tax := (amount*v + scale/2) / scale
+	return Breakdown{Net: amount, Tax: tax, Gross: amount + tax}
+}
+
+// Percent formats the rate as a percentage, like "22%" or "14.975%".
+func (r Rate) Percent() string {
+	return FormatPercent(r.Value)
+}
+
+// FormatPercent formats a rate value as a percentage.
+func FormatPercent(value int) string {
+	s := fmt.Sprintf("%d.%03d", value/1000, value%1000)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return s + "%"
+}
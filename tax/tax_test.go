@@ -0,0 +1,62 @@
+package tax
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		country string
+		region  string
+		exp     Rate
+	}{
+		{"IT", "", Rate{Country: "IT", Name: "VAT", Value: 22000, Inclusive: true}},
+		{"it", "MI", Rate{Country: "IT", Region: "MI", Name: "VAT", Value: 22000, Inclusive: true}},
+		{"CA", "QC", Rate{Country: "CA", Region: "QC", Name: "GST+QST", Value: 14975}},
+		{"CA", "YT", Rate{Country: "CA", Region: "YT", Name: "GST", Value: 5000}},
+		{"US", "", Rate{Country: "US", Name: "Sales tax"}},
+		{"JP", "", Rate{Country: "JP", Name: "Tax"}},
+	}
+
+	for _, tt := range tests {
+		if got := Lookup(tt.country, tt.region); got != tt.exp {
+			t.Fatalf("looking up %s-%s: expected %+v, got %+v", tt.country, tt.region, tt.exp, got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		rate   Rate
+		amount int64
+		exp    Breakdown
+	}{
+		{Rate{Value: 22000, Inclusive: true}, 1220, Breakdown{Net: 1000, Tax: 220, Gross: 1220}},
+		{Rate{Value: 22000, Inclusive: true}, 999, Breakdown{Net: 819, Tax: 180, Gross: 999}},
+		{Rate{Value: 13000}, 1000, Breakdown{Net: 1000, Tax: 130, Gross: 1130}},
+		{Rate{Value: 14975}, 999, Breakdown{Net: 999, Tax: 150, Gross: 1149}},
+		{Rate{}, 999, Breakdown{Net: 999, Tax: 0, Gross: 999}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rate.Apply(tt.amount); got != tt.exp {
+			t.Fatalf("applying %s to %d: expected %+v, got %+v", tt.rate.Percent(), tt.amount, tt.exp, got)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		value int
+		exp   string
+	}{
+		{22000, "22%"},
+		{25500, "25.5%"},
+		{14975, "14.975%"},
+		{0, "0%"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatPercent(tt.value); got != tt.exp {
+			t.Fatalf("formatting %d: expected %s, got %s", tt.value, tt.exp, got)
+		}
+	}
+}